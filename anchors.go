@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"golang.org/x/xerrors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// Name of the symbol to anchor map written to the root of the build directory.
+const anchorIndexName = "anchors.json"
+
+// AnchorIndex maps the exported symbols of the module to where they are documented,
+// so other documentation can deep link to them across rebuilds.
+type AnchorIndex struct {
+	Module string `json:"module"`
+	// Packages with a page, by import path.
+	Packages map[string]*PackageAnchor `json:"packages"`
+	// Anchors by symbol, importPath.Name, with Type.Method as the name of methods.
+	Symbols map[string]*SymbolAnchor `json:"symbols"`
+}
+
+// PackageAnchor is the page of a package.
+type PackageAnchor struct {
+	// Name of the package in its package clause.
+	Name string `json:"name"`
+	// Page of the package, relative to the build directory.
+	Page string `json:"page"`
+}
+
+// SymbolAnchor is where a symbol is documented.
+type SymbolAnchor struct {
+	// Import path of the symbol's package.
+	Package string `json:"package"`
+	// Name of the symbol, Type.Method for methods.
+	Name string `json:"name"`
+	// func, method, type, const or var.
+	Kind string `json:"kind"`
+	// Page of the symbol's package, relative to the build directory.
+	Page string `json:"page"`
+	// Id of the element documenting the symbol: its name, Type.Method for methods, or
+	// the section of its kind if the page has no element with that id.
+	Fragment string `json:"fragment"`
+}
+
+// Ids of the sections of a package page listing the symbols of a kind, shared by
+// godoc and pkgsite.
+var kindSectionIds = map[string]string{
+	"const": "pkg-constants",
+	"var":   "pkg-variables",
+}
+
+// Regex for the ids of the elements of a page.
+var elementIdRegex = regexp.MustCompile(`\sid="([^"]+)"`)
+
+// Returns the ids of the elements of a page.
+func elementIds(data []byte) map[string]bool {
+	ids := make(map[string]bool)
+	for _, match := range elementIdRegex.FindAllSubmatch(data, -1) {
+		ids[string(match[1])] = true
+	}
+	return ids
+}
+
+// Returns the anchor of a symbol on a page with the element ids.
+func symbolFragment(symbol *ModelSymbol, ids map[string]bool) string {
+	if ids[symbol.Name] {
+		return symbol.Name
+	}
+	if section, ok := kindSectionIds[symbol.Kind]; ok && ids[section] {
+		return section
+	}
+	return "pkg-index"
+}
+
+// Returns the processor normalizing the anchors of every package page to the scheme
+// of godoc and pkgsite, the name of the symbol with Type.Method for methods. The
+// constants and variables the backend left without an element with their name as id
+// get one, before the first declaration naming them.
+func newAnchorsProcessor(ctx context.Context, runInfo *RunInfo) Processor {
+	settings := runInfo.Settings
+	if !settings.Anchors {
+		return nil
+	}
+	model, err := loadBuildDocModel(settings)
+	if err != nil {
+		log.Panicf("error loading doc model: %v", err)
+	}
+	symbols := make(map[string][]*ModelSymbol)
+	for _, pkg := range model.Packages {
+		symbols[pkg.ImportPath] = pkg.Symbols
+	}
+
+	return ProcessorFunc(func(page *Page) error {
+		ids := elementIds(page.Data)
+		for _, symbol := range symbols[page.ImportPath()] {
+			if _, ok := kindSectionIds[symbol.Kind]; !ok || ids[symbol.Name] {
+				continue
+			}
+			nameRegex := regexp.MustCompile(`\b` + regexp.QuoteMeta(symbol.Name) + `\b`)
+			for _, block := range codeBlockRegex.FindAllIndex(page.Data, -1) {
+				if !nameRegex.MatchString(htmlText(page.Data[block[0]:block[1]])) {
+					continue
+				}
+				anchor := []byte(`<span id="` + symbol.Name + `"></span>`)
+				data := append([]byte{}, page.Data[:block[0]]...)
+				data = append(data, anchor...)
+				page.Data = append(data, page.Data[block[0]:]...)
+				ids[symbol.Name] = true
+				break
+			}
+		}
+		return nil
+	})
+}
+
+// Writes anchors.json, mapping the symbols of the module to their page and anchor,
+// if --anchors is set.
+func writeAnchorIndex(runInfo *RunInfo) {
+	settings := runInfo.Settings
+	if !settings.Anchors {
+		return
+	}
+	index, err := buildAnchorIndex(runInfo)
+	if err != nil {
+		log.Panic(err)
+	}
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		log.Panicf("error encoding anchor index: %v", err)
+	}
+	indexPath := filepath.Join(settings.BuildDir, anchorIndexName)
+	if err := ioutil.WriteFile(indexPath, data, os.ModePerm); err != nil {
+		log.Panicf("error writing anchor index: %v", err)
+	}
+	log.Printf("wrote the anchors of %v symbols to %v.", len(index.Symbols), anchorIndexName)
+}
+
+// Returns the anchors of the symbols of the module's packages with a page in the
+// build, read from the pages as they were written.
+func buildAnchorIndex(runInfo *RunInfo) (*AnchorIndex, error) {
+	settings := runInfo.Settings
+	model, err := loadBuildDocModel(settings)
+	if err != nil {
+		return nil, xerrors.Errorf("error loading doc model: %w", err)
+	}
+	pages := packagePages(runInfo)
+	index := &AnchorIndex{
+		Module:   settings.ModName,
+		Packages: make(map[string]*PackageAnchor),
+		Symbols:  make(map[string]*SymbolAnchor),
+	}
+	for _, pkg := range model.Packages {
+		page, ok := pages[pkg.ImportPath]
+		if !ok {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(settings.BuildDir, filepath.FromSlash(page)))
+		if err != nil {
+			return nil, xerrors.Errorf("error reading page of %v: %w", pkg.ImportPath, err)
+		}
+		ids := elementIds(data)
+		index.Packages[pkg.ImportPath] = &PackageAnchor{Name: pkg.Name, Page: page}
+		for _, symbol := range pkg.Symbols {
+			index.Symbols[pkg.ImportPath+"."+symbol.Name] = &SymbolAnchor{
+				Package:  pkg.ImportPath,
+				Name:     symbol.Name,
+				Kind:     symbol.Kind,
+				Page:     page,
+				Fragment: symbolFragment(symbol, ids),
+			}
+		}
+	}
+	return index, nil
+}
+
+// Returns the pages of the packages of the model in the build, by import path, and the
+// anchors of their symbols on them, by importPath.Name. Both are empty if the build
+// has no HTML site.
+func modelPageAnchors(
+	settings *Settings, model *DocModel,
+) (map[string]string, map[string]string, error) {
+	pages := make(map[string]string)
+	if pageIndex, err := readPageIndex(settings.BuildDir); err == nil {
+		for page, importPath := range pageIndex {
+			pages[importPath] = page
+		}
+	}
+	anchors := make(map[string]string)
+	for _, pkg := range model.Packages {
+		page, ok := pages[pkg.ImportPath]
+		if !ok {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(settings.BuildDir, filepath.FromSlash(page)))
+		if err != nil {
+			return nil, nil, xerrors.Errorf("error reading page of %v: %w", pkg.ImportPath, err)
+		}
+		ids := elementIds(data)
+		for _, symbol := range pkg.Symbols {
+			anchors[symbol.key()] = symbolFragment(symbol, ids)
+		}
+	}
+	return pages, anchors, nil
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"golang.org/x/xerrors"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	textTemplate "text/template"
+)
+
+// DiffSettings configure `docmodule diff`.
+type DiffSettings struct {
+	// Git refs to compare.
+	From string
+	To   string
+	// Report format, md or html.
+	Format string
+	// File to write the report to, "-" for stdout.
+	Output string
+}
+
+func parseDiffArgs(args []string) *DiffSettings {
+	flags := newCommandFlagSet("diff")
+	from := flags.String(
+		"from",
+		"",
+		"Git ref of the old API, e.g. v1.2.0, or a model.json written by a build.",
+	)
+	to := flags.String(
+		"to",
+		"HEAD",
+		"Git ref of the new API, or a model.json written by a build.",
+	)
+	format := flags.String(
+		"format",
+		"md",
+		"Report format: 'md' or 'html'.",
+	)
+	output := flags.String(
+		"output",
+		"-",
+		"File to write the report to, '-' for stdout.",
+	)
+	_ = flags.Parse(args)
+
+	if *from == "" {
+		log.Fatal("--from is required")
+	}
+	if *format != "md" && *format != "html" {
+		log.Fatalf("unknown report format %q, expected md or html", *format)
+	}
+
+	return &DiffSettings{From: *from, To: *to, Format: *format, Output: *output}
+}
+
+// A symbol whose declaration differs between the refs.
+type apiChange struct {
+	Old *ModelSymbol
+	New *ModelSymbol
+}
+
+// Added, removed and changed symbols between two refs.
+type apiDiff struct {
+	From    string
+	To      string
+	Added   []*ModelSymbol
+	Removed []*ModelSymbol
+	Changed []*apiChange
+}
+
+// Extracts the tree of the repository at ref into dir with `git archive`.
+func extractGitRef(repoRoot string, ref string, dir string) error {
+	command := exec.Command("git", "archive", "--format=tar", ref)
+	command.Dir = repoRoot
+	output, err := command.Output()
+	if err != nil {
+		return xerrors.Errorf("error archiving %v: %w", ref, err)
+	}
+	return extractTar(bytes.NewReader(output), dir)
+}
+
+// Extracts the regular files and directories of a tar stream into dir.
+func extractTar(reader io.Reader, dir string) error {
+	archive := tar.NewReader(reader)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return xerrors.Errorf("error reading archive: %w", err)
+		}
+
+		name := path.Clean("/" + header.Name)
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, os.ModePerm); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+				return err
+			}
+			data, err := ioutil.ReadAll(archive)
+			if err != nil {
+				return xerrors.Errorf("error reading archive: %w", err)
+			}
+			if err := ioutil.WriteFile(target, data, os.ModePerm); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Compares the symbols of two refs.
+func diffSymbols(
+	from string, to string, oldSymbols map[string]*ModelSymbol, newSymbols map[string]*ModelSymbol,
+) *apiDiff {
+	diff := &apiDiff{From: from, To: to}
+	for key, newSymbol := range newSymbols {
+		oldSymbol, ok := oldSymbols[key]
+		if !ok {
+			diff.Added = append(diff.Added, newSymbol)
+		} else if oldSymbol.Signature != newSymbol.Signature {
+			diff.Changed = append(diff.Changed, &apiChange{Old: oldSymbol, New: newSymbol})
+		}
+	}
+	for key, oldSymbol := range oldSymbols {
+		if _, ok := newSymbols[key]; !ok {
+			diff.Removed = append(diff.Removed, oldSymbol)
+		}
+	}
+
+	sort.Slice(diff.Added, func(i, j int) bool {
+		return diff.Added[i].key() < diff.Added[j].key()
+	})
+	sort.Slice(diff.Removed, func(i, j int) bool {
+		return diff.Removed[i].key() < diff.Removed[j].key()
+	})
+	sort.Slice(diff.Changed, func(i, j int) bool {
+		return diff.Changed[i].New.key() < diff.Changed[j].New.key()
+	})
+	return diff
+}
+
+var diffMarkdownTemplate = textTemplate.Must(textTemplate.New("diff-md").Parse(
+	`# API changes from {{.From}} to {{.To}}
+{{define "symbol"}}
+### {{.ImportPath}}.{{.Name}} ({{.Kind}})
+
+` + "```go\n{{.Signature}}\n```" + `
+{{end}}
+## Added
+{{range .Added}}{{template "symbol" .}}{{else}}
+None.
+{{end}}
+## Removed
+{{range .Removed}}{{template "symbol" .}}{{else}}
+None.
+{{end}}
+## Changed
+{{range .Changed}}
+### {{.New.ImportPath}}.{{.New.Name}} ({{.New.Kind}})
+
+` + "```go\n// {{$.From}}\n{{.Old.Signature}}\n\n// {{$.To}}\n{{.New.Signature}}\n```" + `
+{{else}}
+None.
+{{end}}`))
+
+var diffHTMLTemplate = template.Must(template.New("diff-html").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta http-equiv="Content-Type" content="text/html; charset=utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>API changes from {{.From}} to {{.To}}</title>
+</head>
+<body>
+<h1>API changes from {{.From}} to {{.To}}</h1>
+{{define "symbol"}}
+<h3>{{.ImportPath}}.{{.Name}} ({{.Kind}})</h3>
+<pre>{{.Signature}}</pre>
+{{end}}
+<h2>Added</h2>
+{{range .Added}}{{template "symbol" .}}{{else}}<p>None.</p>{{end}}
+<h2>Removed</h2>
+{{range .Removed}}{{template "symbol" .}}{{else}}<p>None.</p>{{end}}
+<h2>Changed</h2>
+{{range .Changed}}
+<h3>{{.New.ImportPath}}.{{.New.Name}} ({{.New.Kind}})</h3>
+<pre>// {{$.From}}
+{{.Old.Signature}}
+
+// {{$.To}}
+{{.New.Signature}}</pre>
+{{else}}<p>None.</p>{{end}}
+</body>
+</html>
+`))
+
+// Returns the doc model of a side of the diff: a model.json written by a build, or
+// else the module at a git ref of the repository at repoRoot.
+func resolveDocModel(ref string, repoRoot string, moduleRelDir string) *DocModel {
+	if strings.HasSuffix(ref, ".json") {
+		if exists, _ := fileExists(ref); exists {
+			model, err := readDocModel(ref)
+			if err != nil {
+				log.Fatal(err)
+			}
+			return model
+		}
+	}
+	if repoRoot == "" {
+		log.Fatalf("%v is not a doc model and we are not in a git repository", ref)
+	}
+	return modelAtRef(repoRoot, moduleRelDir, ref)
+}
+
+// Returns the doc model of the module at ref, with the module at moduleRelDir in
+// the repo.
+func modelAtRef(repoRoot string, moduleRelDir string, ref string) *DocModel {
+	dir, err := ioutil.TempDir("", "docmodule-diff-")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := extractGitRef(repoRoot, ref, dir); err != nil {
+		log.Fatal(err)
+	}
+	model, err := loadDocModel(filepath.Join(dir, moduleRelDir))
+	if err != nil {
+		log.Fatal(xerrors.Errorf("error reading API at %v: %w", ref, err))
+	}
+	model.Version = ref
+	return model
+}
+
+// Compares the API of the module in the working directory at two refs, each a git
+// ref or a model.json. Internal packages are not part of the API.
+func diffRefs(from string, to string) *apiDiff {
+	settings := new(Settings)
+	getEnvSettings(settings)
+
+	command := exec.Command("git", "rev-parse", "--show-toplevel")
+	command.Dir = settings.ModuleRootPath
+	output, err := command.Output()
+	repoRoot, moduleRelDir := "", ""
+	if err == nil {
+		repoRoot = strings.TrimSpace(string(output))
+		moduleRelDir, err = filepath.Rel(repoRoot, settings.ModuleRootPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	return diffSymbols(
+		from,
+		to,
+		resolveDocModel(from, repoRoot, moduleRelDir).apiSymbols(),
+		resolveDocModel(to, repoRoot, moduleRelDir).apiSymbols(),
+	)
+}
+
+// Writes a report of the exported symbols added, removed and changed between two
+// git refs of the module in the working directory.
+func runDiffCommand(args []string) {
+	diffSettings := parseDiffArgs(args)
+
+	diff := diffRefs(diffSettings.From, diffSettings.To)
+
+	var err error
+	buffer := new(bytes.Buffer)
+	if diffSettings.Format == "html" {
+		err = diffHTMLTemplate.Execute(buffer, diff)
+	} else {
+		err = diffMarkdownTemplate.Execute(buffer, diff)
+	}
+	if err != nil {
+		log.Fatal(xerrors.Errorf("error rendering API diff: %w", err))
+	}
+
+	if diffSettings.Output == "-" {
+		_, _ = os.Stdout.Write(buffer.Bytes())
+		return
+	}
+	if err := ioutil.WriteFile(diffSettings.Output, buffer.Bytes(), os.ModePerm); err != nil {
+		log.Fatal(xerrors.Errorf("error writing API diff: %w", err))
+	}
+}
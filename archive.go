@@ -0,0 +1,185 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"golang.org/x/xerrors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Returns the modification time recorded for every archive entry, so archives of
+// the same build are byte for byte identical: SOURCE_DATE_EPOCH if set, else the
+// earliest time zip can represent.
+func archiveModTime() time.Time {
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+		if seconds, err := strconv.ParseInt(epoch, 10, 64); err == nil {
+			return time.Unix(seconds, 0).UTC()
+		}
+	}
+	return time.Date(1980, time.January, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// Returns the files under buildDir, relative to it and slash-separated, in lexical
+// order.
+func archiveFiles(buildDir string) ([]string, error) {
+	files := make([]string, 0)
+	err := filepath.Walk(buildDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		relPath, err := filepath.Rel(buildDir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(relPath))
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+// Packages every file under buildDir into a gzipped tarball at destPath. Entries are
+// written in lexical order with normalized owners, modes and times.
+func writeTarGz(buildDir string, destPath string) error {
+	files, err := archiveFiles(buildDir)
+	if err != nil {
+		return xerrors.Errorf("error listing build files: %w", err)
+	}
+
+	dest, err := os.Create(destPath)
+	if err != nil {
+		return xerrors.Errorf("error creating archive: %w", err)
+	}
+	defer dest.Close()
+
+	gzipWriter := gzip.NewWriter(dest)
+	tarWriter := tar.NewWriter(gzipWriter)
+	modTime := archiveModTime()
+
+	for _, relPath := range files {
+		err := func() error {
+			file, err := os.Open(filepath.Join(buildDir, filepath.FromSlash(relPath)))
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			info, err := file.Stat()
+			if err != nil {
+				return err
+			}
+
+			header := &tar.Header{
+				Typeflag: tar.TypeReg,
+				Name:     relPath,
+				Size:     info.Size(),
+				Mode:     0644,
+				ModTime:  modTime,
+				Format:   tar.FormatPAX,
+			}
+			if err := tarWriter.WriteHeader(header); err != nil {
+				return err
+			}
+			_, err = io.Copy(tarWriter, file)
+			return err
+		}()
+		if err != nil {
+			return xerrors.Errorf("error writing archive: %w", err)
+		}
+	}
+
+	if err := tarWriter.Close(); err != nil {
+		return xerrors.Errorf("error writing archive: %w", err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return xerrors.Errorf("error writing archive: %w", err)
+	}
+	// The deferred Close only cleans up after errors, a failed final write here
+	// would leave a truncated archive.
+	if err := dest.Close(); err != nil {
+		return xerrors.Errorf("error writing archive: %w", err)
+	}
+	return nil
+}
+
+// Packages every file under buildDir into a zip archive at destPath. Entries are
+// written in lexical order with normalized modes and times.
+func writeZip(buildDir string, destPath string) error {
+	files, err := archiveFiles(buildDir)
+	if err != nil {
+		return xerrors.Errorf("error listing build files: %w", err)
+	}
+
+	dest, err := os.Create(destPath)
+	if err != nil {
+		return xerrors.Errorf("error creating archive: %w", err)
+	}
+	defer dest.Close()
+
+	zipWriter := zip.NewWriter(dest)
+	modTime := archiveModTime()
+
+	for _, relPath := range files {
+		err := func() error {
+			file, err := os.Open(filepath.Join(buildDir, filepath.FromSlash(relPath)))
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+
+			header := &zip.FileHeader{Name: relPath, Method: zip.Deflate}
+			header.Modified = modTime
+			header.SetMode(0644)
+			writer, err := zipWriter.CreateHeader(header)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(writer, file)
+			return err
+		}()
+		if err != nil {
+			return xerrors.Errorf("error writing archive: %w", err)
+		}
+	}
+
+	if err := zipWriter.Close(); err != nil {
+		return xerrors.Errorf("error writing archive: %w", err)
+	}
+	if err := dest.Close(); err != nil {
+		return xerrors.Errorf("error writing archive: %w", err)
+	}
+	return nil
+}
+
+// Reports whether destPath names an archive format docmodule can write.
+func isArchivePath(destPath string) bool {
+	return strings.HasSuffix(destPath, ".tar.gz") ||
+		strings.HasSuffix(destPath, ".tgz") ||
+		strings.HasSuffix(destPath, ".zip")
+}
+
+// Packages the finished build directory into the archive given by --archive, a
+// .tar.gz, .tgz or .zip file.
+func writeBuildArchive(settings *Settings) {
+	if settings.Archive == "" {
+		return
+	}
+
+	log.Println("archiving build to", settings.Archive+".")
+	var err error
+	if strings.HasSuffix(settings.Archive, ".zip") {
+		err = writeZip(settings.BuildDir, settings.Archive)
+	} else {
+		err = writeTarGz(settings.BuildDir, settings.Archive)
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Writes files, by slash-separated path, under a new temporary directory, which the
+// caller removes.
+func writeTestTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "docmodule-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for relPath, content := range files {
+		filePath := filepath.Join(dir, filepath.FromSlash(relPath))
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filePath, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+var testArchiveFiles = map[string]string{
+	"index.html":         "<html>index</html>",
+	"pkg/index.html":     "<html>pkg</html>",
+	"pkg/sub/index.html": "<html>sub</html>",
+	"style.css":          "body {}",
+}
+
+func TestWriteTarGz(t *testing.T) {
+	buildDir := writeTestTree(t, testArchiveFiles)
+	defer os.RemoveAll(buildDir)
+	outDir := writeTestTree(t, nil)
+	defer os.RemoveAll(outDir)
+
+	first := filepath.Join(outDir, "first.tar.gz")
+	if err := writeTarGz(buildDir, first); err != nil {
+		t.Fatal(err)
+	}
+	// Different times and modes on disk must not change the archive.
+	later := time.Now().Add(time.Hour)
+	for relPath := range testArchiveFiles {
+		filePath := filepath.Join(buildDir, filepath.FromSlash(relPath))
+		if err := os.Chtimes(filePath, later, later); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(filePath, 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	second := filepath.Join(outDir, "second.tar.gz")
+	if err := writeTarGz(buildDir, second); err != nil {
+		t.Fatal(err)
+	}
+	assertSameFiles(t, first, second)
+
+	data, err := ioutil.ReadFile(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gzipReader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tarReader := tar.NewReader(gzipReader)
+	names := make([]string, 0)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, header.Name)
+		if header.Mode != 0644 || !header.ModTime.Equal(archiveModTime()) {
+			t.Errorf("%v: mode %o, time %v not normalized", header.Name, header.Mode, header.ModTime)
+		}
+		content, _ := ioutil.ReadAll(tarReader)
+		if string(content) != testArchiveFiles[header.Name] {
+			t.Errorf("%v: content %q, want %q", header.Name, content, testArchiveFiles[header.Name])
+		}
+	}
+	want := []string{"index.html", "pkg/index.html", "pkg/sub/index.html", "style.css"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("entries %v, want %v", names, want)
+	}
+}
+
+func TestWriteZip(t *testing.T) {
+	buildDir := writeTestTree(t, testArchiveFiles)
+	defer os.RemoveAll(buildDir)
+	outDir := writeTestTree(t, nil)
+	defer os.RemoveAll(outDir)
+
+	first := filepath.Join(outDir, "first.zip")
+	if err := writeZip(buildDir, first); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Hour)
+	for relPath := range testArchiveFiles {
+		filePath := filepath.Join(buildDir, filepath.FromSlash(relPath))
+		if err := os.Chtimes(filePath, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+	second := filepath.Join(outDir, "second.zip")
+	if err := writeZip(buildDir, second); err != nil {
+		t.Fatal(err)
+	}
+	assertSameFiles(t, first, second)
+
+	reader, err := zip.OpenReader(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	names := make([]string, 0)
+	for _, file := range reader.File {
+		names = append(names, file.Name)
+		if file.Mode() != 0644 || !file.Modified.Equal(archiveModTime()) {
+			t.Errorf("%v: mode %v, time %v not normalized", file.Name, file.Mode(), file.Modified)
+		}
+	}
+	want := []string{"index.html", "pkg/index.html", "pkg/sub/index.html", "style.css"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("entries %v, want %v", names, want)
+	}
+}
+
+// Fails the test unless the files at both paths have the same content.
+func assertSameFiles(t *testing.T, first string, second string) {
+	t.Helper()
+	firstData, err := ioutil.ReadFile(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondData, err := ioutil.ReadFile(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(firstData, secondData) {
+		t.Errorf("%v and %v differ", first, second)
+	}
+}
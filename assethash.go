@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Extensions of the assets given content-hashed names.
+var hashedAssetExtensions = map[string]bool{".css": true, ".js": true}
+
+// Number of hex digits of the content hash in asset names.
+const assetHashLength = 8
+
+// Regex for links to local files, with the link in the second group. Query strings
+// and fragments are left out of the link.
+var localLinkRegex = regexp.MustCompile(`(href|src)="([^"#?:]+)`)
+
+// Renames the stylesheets and scripts of the build to names with a hash of their
+// content, like style.3fa9c2e1.css. Returns the new paths by old path, relative to
+// the build directory and slash-separated.
+func hashAssetNames(settings *Settings) map[string]string {
+	renamed := make(map[string]string)
+	err := filepath.Walk(settings.BuildDir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		extension := filepath.Ext(filePath)
+		if !hashedAssetExtensions[extension] {
+			return nil
+		}
+		data, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		hash := hex.EncodeToString(sum[:])[:assetHashLength]
+		hashedPath := strings.TrimSuffix(filePath, extension) + "." + hash + extension
+		if err := os.Rename(filePath, hashedPath); err != nil {
+			return err
+		}
+
+		relPath := pageRelPath(settings, filePath)
+		renamed[relPath] = path.Join(path.Dir(relPath), filepath.Base(hashedPath))
+		return nil
+	})
+	if err != nil {
+		log.Panicf("error hashing asset names: %v", err)
+	}
+	return renamed
+}
+
+// Returns the processor pointing the links of the pages to the assets renamed with
+// hashAssetNames, so hosts can cache them for good.
+func newAssetHashesProcessor(ctx context.Context, runInfo *RunInfo) Processor {
+	settings := runInfo.Settings
+	if !settings.AssetHashes {
+		return nil
+	}
+	renamed := hashAssetNames(settings)
+	if len(renamed) == 0 {
+		return nil
+	}
+	log.Printf("renamed %v assets to content-hashed names.", len(renamed))
+
+	return ProcessorFunc(func(page *Page) error {
+		page.Data = localLinkRegex.ReplaceAllFunc(page.Data, func(match []byte) []byte {
+			groups := localLinkRegex.FindSubmatch(match)
+			link := string(groups[2])
+			target := path.Join(path.Dir(page.RelPath), link)
+			if strings.HasPrefix(link, "/") {
+				target = strings.TrimPrefix(link, "/")
+			}
+			hashed, ok := renamed[target]
+			if !ok {
+				return match
+			}
+			return []byte(string(groups[1]) + `="` + path.Join(path.Dir(link), path.Base(hashed)))
+		})
+		return nil
+	})
+}
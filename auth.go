@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"golang.org/x/xerrors"
+	"log"
+	"net/http"
+	"sort"
+)
+
+// Principal is an authenticated viewer of the docs.
+type Principal struct {
+	// Stable identifier of the viewer, e.g. the OIDC subject.
+	Subject string
+	// Roles of the viewer, used by the visibility policy.
+	Roles []string
+}
+
+// Authenticator authenticates requests in serve mode. Implementations may take over
+// the response, for instance to redirect to a login page, by returning a nil
+// principal and a nil error after writing to writer.
+type Authenticator interface {
+	// Routes the authenticator needs to serve itself, like login callbacks, keyed
+	// by path. Requests to these paths are not authenticated.
+	Routes() map[string]http.Handler
+	// Authenticates request, returning an error if the request must be rejected.
+	Authenticate(writer http.ResponseWriter, request *http.Request) (*Principal, error)
+}
+
+// Constructors of the built-in authenticators by name, as passed to --auth.
+var authenticators = map[string]func(settings *ServeSettings) (Authenticator, error){
+	"oidc": NewOIDCAuthenticator,
+}
+
+// Returns the names of the built-in authenticators.
+func authenticatorNames() []string {
+	names := make([]string, 0, len(authenticators))
+	for name := range authenticators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Builds the authenticator selected by settings.Auth.
+func newAuthenticator(settings *ServeSettings) (Authenticator, error) {
+	constructor, ok := authenticators[settings.Auth]
+	if !ok {
+		return nil, xerrors.Errorf(
+			"unknown authenticator %q, expected one of %v",
+			settings.Auth,
+			authenticatorNames(),
+		)
+	}
+	return constructor(settings)
+}
+
+type principalKey struct{}
+
+// Returns the subject of the principal authMiddleware attached to the request, or an
+// empty string.
+func requestSubject(request *http.Request) string {
+	if principal := requestPrincipal(request); principal != nil {
+		return principal.Subject
+	}
+	return ""
+}
+
+type principalSlotKey struct{}
+
+// Receives the principal authMiddleware authenticates, for the middlewares wrapping
+// it which only see the request before authentication, like the access log.
+type principalSlot struct {
+	principal *Principal
+}
+
+// Attaches a slot to the request's context which authMiddleware fills with the
+// authenticated principal.
+func withPrincipalSlot(request *http.Request) (*http.Request, *principalSlot) {
+	slot := new(principalSlot)
+	ctx := context.WithValue(request.Context(), principalSlotKey{}, slot)
+	return request.WithContext(ctx), slot
+}
+
+// Returns the principal authMiddleware attached to the request, if any.
+func requestPrincipal(request *http.Request) *Principal {
+	principal, _ := request.Context().Value(principalKey{}).(*Principal)
+	return principal
+}
+
+// Wraps handler so every request must be authenticated by authenticator. The
+// authenticated principal is attached to the request's context, and handed to the
+// slot of withPrincipalSlot if the request has one.
+//
+// Requests for which unauthenticated reports true are passed to handler without
+// authentication, and without a principal. unauthenticated may be nil.
+func authMiddleware(
+	authenticator Authenticator,
+	handler http.Handler,
+	unauthenticated func(request *http.Request) bool,
+) http.Handler {
+	mux := http.NewServeMux()
+	for path, route := range authenticator.Routes() {
+		mux.Handle(path, route)
+	}
+
+	mux.Handle("/", http.HandlerFunc(
+		func(writer http.ResponseWriter, request *http.Request) {
+			if unauthenticated != nil && unauthenticated(request) {
+				handler.ServeHTTP(writer, request)
+				return
+			}
+			principal, err := authenticator.Authenticate(writer, request)
+			if err != nil {
+				log.Printf("rejected request for %v: %v", request.URL.Path, err)
+				writer.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(writer, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if principal == nil {
+				// The authenticator has responded itself.
+				return
+			}
+
+			if slot, ok := request.Context().Value(principalSlotKey{}).(*principalSlot); ok {
+				slot.principal = principal
+			}
+			ctx := context.WithValue(request.Context(), principalKey{}, principal)
+			handler.ServeHTTP(writer, request.WithContext(ctx))
+		},
+	))
+
+	return mux
+}
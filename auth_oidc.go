@@ -0,0 +1,438 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"golang.org/x/xerrors"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Environment variable holding the OIDC client secret.
+const oidcClientSecretEnv = "DOCMODULE_OIDC_CLIENT_SECRET"
+
+// Paths and cookies used by the OIDC login flow.
+const (
+	oidcCallbackPath = serveAPIPrefix + "oidc/callback"
+	oidcTokenCookie  = "docmodule_id_token"
+	oidcStateCookie  = "docmodule_oidc_state"
+)
+
+// Minimum time between JWKS refreshes triggered by unknown key ids.
+const jwksRefreshInterval = 5 * time.Minute
+
+// Time after which the key set is refreshed even for known key ids, so keys the
+// provider rotated out stop being trusted.
+const jwksMaxAge = time.Hour
+
+// OIDCAuthenticator protects serve mode with an OpenID Connect provider. Browsers
+// are sent through the authorization code flow and keep the resulting ID token in a
+// cookie; API clients may send the ID token as a bearer token instead. Tokens must
+// be signed with RS256.
+type OIDCAuthenticator struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// External URL of the callback route, registered with the provider.
+	RedirectURL string
+	// Claim holding the viewer's roles.
+	RolesClaim string
+	Client     *http.Client
+
+	authorizationEndpoint string
+	tokenEndpoint         string
+	jwksURI               string
+
+	keys        map[string]*rsa.PublicKey
+	keysFetched time.Time
+	// Time of the last refresh, successful or not, so an unreachable provider is
+	// not asked again on every request.
+	keysAttempted time.Time
+	keysLock      sync.Mutex
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type jsonWebKeySet struct {
+	Keys []struct {
+		KeyType string `json:"kty"`
+		KeyID   string `json:"kid"`
+		N       string `json:"n"`
+		E       string `json:"e"`
+	} `json:"keys"`
+}
+
+func NewOIDCAuthenticator(settings *ServeSettings) (Authenticator, error) {
+	if settings.OIDCIssuer == "" || settings.OIDCClientID == "" {
+		return nil, xerrors.New("oidc auth requires an issuer and client id")
+	}
+
+	rolesClaim := settings.RolesClaim
+	if rolesClaim == "" {
+		rolesClaim = "groups"
+	}
+
+	authenticator := &OIDCAuthenticator{
+		Issuer:       strings.TrimSuffix(settings.OIDCIssuer, "/"),
+		ClientID:     settings.OIDCClientID,
+		ClientSecret: os.Getenv(oidcClientSecretEnv),
+		RedirectURL:  settings.OIDCRedirectURL,
+		RolesClaim:   rolesClaim,
+		Client:       &http.Client{Timeout: 10 * time.Second},
+		keys:         make(map[string]*rsa.PublicKey),
+	}
+
+	if err := authenticator.discover(); err != nil {
+		return nil, err
+	}
+	return authenticator, nil
+}
+
+// Fetches the provider's endpoints from its discovery document.
+func (auth *OIDCAuthenticator) discover() error {
+	discovery := new(oidcDiscovery)
+	err := auth.getJSON(auth.Issuer+"/.well-known/openid-configuration", discovery)
+	if err != nil {
+		return xerrors.Errorf("error fetching oidc discovery document: %w", err)
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != auth.Issuer {
+		return xerrors.Errorf("oidc issuer mismatch: %q", discovery.Issuer)
+	}
+
+	auth.authorizationEndpoint = discovery.AuthorizationEndpoint
+	auth.tokenEndpoint = discovery.TokenEndpoint
+	auth.jwksURI = discovery.JWKSURI
+	return nil
+}
+
+func (auth *OIDCAuthenticator) getJSON(url string, value interface{}) error {
+	resp, err := auth.Client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return xerrors.Errorf("GET %v: unexpected status %v", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(value)
+}
+
+// Returns the signing key with the given id, refreshing the key set if it is
+// unknown or the set is older than jwksMaxAge. A refresh replaces the whole set.
+// Refreshes are at least jwksRefreshInterval apart, and while the provider can't be
+// reached the cached keys are kept.
+func (auth *OIDCAuthenticator) signingKey(keyID string) (*rsa.PublicKey, error) {
+	auth.keysLock.Lock()
+	defer auth.keysLock.Unlock()
+
+	cached, known := auth.keys[keyID]
+	if known && time.Since(auth.keysFetched) < jwksMaxAge {
+		return cached, nil
+	}
+	if time.Since(auth.keysAttempted) < jwksRefreshInterval {
+		if known {
+			return cached, nil
+		}
+		return nil, xerrors.Errorf("unknown signing key %q", keyID)
+	}
+
+	auth.keysAttempted = time.Now()
+	keySet := new(jsonWebKeySet)
+	if err := auth.getJSON(auth.jwksURI, keySet); err != nil {
+		if known {
+			return cached, nil
+		}
+		return nil, xerrors.Errorf("error fetching oidc signing keys: %w", err)
+	}
+	auth.keysFetched = time.Now()
+
+	keys := make(map[string]*rsa.PublicKey, len(keySet.Keys))
+	for _, webKey := range keySet.Keys {
+		if webKey.KeyType != "RSA" {
+			continue
+		}
+		modulus, err := base64.RawURLEncoding.DecodeString(webKey.N)
+		if err != nil {
+			continue
+		}
+		exponent, err := base64.RawURLEncoding.DecodeString(webKey.E)
+		if err != nil {
+			continue
+		}
+		keys[webKey.KeyID] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(modulus),
+			E: int(new(big.Int).SetBytes(exponent).Int64()),
+		}
+	}
+	auth.keys = keys
+
+	key, ok := auth.keys[keyID]
+	if !ok {
+		return nil, xerrors.Errorf("unknown signing key %q", keyID)
+	}
+	return key, nil
+}
+
+// Verifies an ID token's signature and standard claims, returning its claims.
+func (auth *OIDCAuthenticator) verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, xerrors.New("malformed token")
+	}
+
+	headerData, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, xerrors.Errorf("malformed token header: %w", err)
+	}
+	header := struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}{}
+	if err := json.Unmarshal(headerData, &header); err != nil {
+		return nil, xerrors.Errorf("malformed token header: %w", err)
+	}
+	if header.Algorithm != "RS256" {
+		return nil, xerrors.Errorf("unsupported token algorithm %q", header.Algorithm)
+	}
+
+	key, err := auth.signingKey(header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, xerrors.Errorf("malformed token signature: %w", err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, xerrors.Errorf("invalid token signature: %w", err)
+	}
+
+	claims := jwtClaims(token)
+	if claims == nil {
+		return nil, xerrors.New("malformed token claims")
+	}
+	if issuer, _ := claims["iss"].(string); strings.TrimSuffix(issuer, "/") != auth.Issuer {
+		return nil, xerrors.Errorf("unexpected token issuer %q", issuer)
+	}
+	if !auth.audienceMatches(claims["aud"]) {
+		return nil, xerrors.New("token audience does not include our client id")
+	}
+	now := float64(time.Now().Unix())
+	if expires, ok := claims["exp"].(float64); !ok || now > expires {
+		return nil, xerrors.New("token expired")
+	}
+	if notBefore, ok := claims["nbf"].(float64); ok && now < notBefore {
+		return nil, xerrors.New("token not yet valid")
+	}
+
+	return claims, nil
+}
+
+func (auth *OIDCAuthenticator) audienceMatches(audience interface{}) bool {
+	switch audience := audience.(type) {
+	case string:
+		return audience == auth.ClientID
+	case []interface{}:
+		for _, entry := range audience {
+			if entry == auth.ClientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (auth *OIDCAuthenticator) principal(claims map[string]interface{}) *Principal {
+	principal := new(Principal)
+	principal.Subject, _ = claims["sub"].(string)
+
+	switch roles := claims[auth.RolesClaim].(type) {
+	case string:
+		principal.Roles = strings.Fields(roles)
+	case []interface{}:
+		for _, role := range roles {
+			if roleString, ok := role.(string); ok {
+				principal.Roles = append(principal.Roles, roleString)
+			}
+		}
+	}
+	return principal
+}
+
+func (auth *OIDCAuthenticator) Authenticate(
+	writer http.ResponseWriter, request *http.Request,
+) (*Principal, error) {
+	if bearer := request.Header.Get("Authorization"); strings.HasPrefix(bearer, "Bearer ") {
+		claims, err := auth.verify(strings.TrimPrefix(bearer, "Bearer "))
+		if err != nil {
+			return nil, err
+		}
+		return auth.principal(claims), nil
+	}
+
+	if cookie, err := request.Cookie(oidcTokenCookie); err == nil {
+		if claims, err := auth.verify(cookie.Value); err == nil {
+			return auth.principal(claims), nil
+		}
+	}
+
+	// Only browsers navigating to pages are sent to the login page.
+	if request.Method != http.MethodGet || auth.RedirectURL == "" {
+		return nil, xerrors.New("missing or invalid token")
+	}
+	auth.startLogin(writer, request)
+	return nil, nil
+}
+
+// Redirects the browser to the provider, remembering the page it asked for.
+func (auth *OIDCAuthenticator) startLogin(writer http.ResponseWriter, request *http.Request) {
+	state, err := randomHex(16)
+	if err != nil {
+		http.Error(writer, "error starting login", http.StatusInternalServerError)
+		return
+	}
+	nonce, err := randomHex(16)
+	if err != nil {
+		http.Error(writer, "error starting login", http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(writer, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state + "|" + nonce + "|" + request.URL.RequestURI(),
+		Path:     oidcCallbackPath,
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(auth.RedirectURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	query := url.Values{
+		"response_type": {"code"},
+		"client_id":     {auth.ClientID},
+		"redirect_uri":  {auth.RedirectURL},
+		"scope":         {"openid profile"},
+		"state":         {state},
+		"nonce":         {nonce},
+	}
+	http.Redirect(
+		writer, request, auth.authorizationEndpoint+"?"+query.Encode(), http.StatusFound,
+	)
+}
+
+func (auth *OIDCAuthenticator) Routes() map[string]http.Handler {
+	return map[string]http.Handler{
+		oidcCallbackPath: http.HandlerFunc(auth.handleCallback),
+	}
+}
+
+// Exchanges the authorization code for an ID token and stores it in a cookie.
+func (auth *OIDCAuthenticator) handleCallback(
+	writer http.ResponseWriter, request *http.Request,
+) {
+	stateCookie, err := request.Cookie(oidcStateCookie)
+	if err != nil {
+		http.Error(writer, "missing login state", http.StatusBadRequest)
+		return
+	}
+	stateParts := strings.SplitN(stateCookie.Value, "|", 3)
+	if len(stateParts) != 3 || stateParts[0] != request.URL.Query().Get("state") {
+		http.Error(writer, "invalid login state", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := auth.Client.PostForm(auth.tokenEndpoint, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {request.URL.Query().Get("code")},
+		"redirect_uri":  {auth.RedirectURL},
+		"client_id":     {auth.ClientID},
+		"client_secret": {auth.ClientSecret},
+	})
+	if err != nil {
+		http.Error(writer, "error exchanging login code", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		http.Error(writer, "error exchanging login code", http.StatusBadGateway)
+		return
+	}
+
+	tokens := struct {
+		IDToken string `json:"id_token"`
+	}{}
+	if err := json.Unmarshal(body, &tokens); err != nil || tokens.IDToken == "" {
+		http.Error(writer, "provider returned no id token", http.StatusBadGateway)
+		return
+	}
+	claims, err := auth.verify(tokens.IDToken)
+	if err != nil {
+		http.Error(writer, "invalid id token", http.StatusUnauthorized)
+		return
+	}
+	// The nonce ties the token to this login, so a token issued for another one
+	// can't be replayed through the callback.
+	if nonce, _ := claims["nonce"].(string); nonce != stateParts[1] {
+		http.Error(writer, "invalid id token nonce", http.StatusUnauthorized)
+		return
+	}
+
+	expires, _ := claims["exp"].(float64)
+	http.SetCookie(writer, &http.Cookie{
+		Name:     oidcTokenCookie,
+		Value:    tokens.IDToken,
+		Path:     "/",
+		Expires:  time.Unix(int64(expires), 0),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(auth.RedirectURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(writer, request, localRedirectTarget(stateParts[2]), http.StatusFound)
+}
+
+// Returns target if it is a path on this server, and "/" otherwise. Browsers treat
+// backslashes like slashes and drop control characters, so targets like `/\host` are
+// rejected along with anything naming a scheme or host.
+func localRedirectTarget(target string) string {
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") {
+		return "/"
+	}
+	for _, char := range target {
+		if char == '\\' || char < 0x20 || char == 0x7f {
+			return "/"
+		}
+	}
+	parsed, err := url.Parse(target)
+	if err != nil || parsed.Scheme != "" || parsed.Host != "" {
+		return "/"
+	}
+	return target
+}
+
+// Returns size random bytes, hex encoded.
+func randomHex(size int) (string, error) {
+	value := make([]byte, size)
+	if _, err := rand.Read(value); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(value), nil
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestLocalRedirectTarget(t *testing.T) {
+	cases := map[string]string{
+		"/pkg/index.html?q=1#a":  "/pkg/index.html?q=1#a",
+		"/acme/latest/":          "/acme/latest/",
+		"":                       "/",
+		"pkg/":                   "/",
+		"https://evil.com/":      "/",
+		"//evil.com/":            "/",
+		"/\\evil.com":            "/",
+		"/%5Cevil.com":           "/%5Cevil.com",
+		"/\tevil.com":            "/",
+		"/pkg/\r\nSet-Cookie: a": "/",
+	}
+	for target, want := range cases {
+		if got := localRedirectTarget(target); got != want {
+			t.Errorf("localRedirectTarget(%q) = %q, want %q", target, got, want)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os/exec"
+	"regexp"
+)
+
+// Backend is a documentation server we can run locally and scrape.
+type Backend interface {
+	// Name of the backend, as passed to --backend.
+	Name() string
+	// Name of the server binary.
+	Binary() string
+	// Package passed to `go install` to install the server binary.
+	InstallPackage() string
+	// Command which starts the server on settings.ServerHost, running the binary
+	// found at settings.BackendBinary. The process is killed when ctx is done.
+	Command(ctx context.Context, settings *Settings) *exec.Cmd
+	// Path polled to check that the server is up.
+	ReadyPath(settings *Settings) string
+	// Path of the module's root documentation page.
+	ModulePath(settings *Settings) string
+	// Regex of the url paths wget should download.
+	AcceptRegex(settings *Settings) string
+	// Name of an asset whose presence in the build directory tells us the scrape
+	// worked at least partially.
+	SentinelAsset() string
+	// Regex with a single group extracting the import path from a package page.
+	ImportPathRegex() *regexp.Regexp
+}
+
+// Known backends by name.
+var backends = map[string]Backend{
+	"godoc":   new(GodocBackend),
+	"pkgsite": new(PkgsiteBackend),
+}
+
+// Returns the backend selected by settings.
+func selectBackend(settings *Settings) Backend {
+	backend, ok := backends[settings.Backend]
+	if !ok {
+		log.Panicf("unknown backend %q", settings.Backend)
+	}
+	return backend
+}
+
+// GodocBackend scrapes the legacy `godoc -http` server.
+type GodocBackend struct{}
+
+func (backend *GodocBackend) Name() string {
+	return "godoc"
+}
+
+func (backend *GodocBackend) Binary() string {
+	return "godoc"
+}
+
+func (backend *GodocBackend) InstallPackage() string {
+	return "golang.org/x/tools/cmd/godoc@latest"
+}
+
+func (backend *GodocBackend) Command(ctx context.Context, settings *Settings) *exec.Cmd {
+	args := []string{"-http=" + settings.ServerHost}
+	if settings.TemplatesDir != "" {
+		args = append(args, "-templates="+settings.TemplatesDir)
+	}
+	if len(settings.NoteMarkers) > 0 {
+		args = append(args, "-notes="+notesFlagValue(settings.NoteMarkers))
+	}
+	if settings.GoToolchain != "" {
+		args = append(args, "-goroot="+settings.GoRootPath)
+	}
+	command := exec.CommandContext(ctx, settings.BackendBinary, args...)
+	// godoc serves the module of its working directory.
+	command.Dir = settings.docSourceRoot()
+	return command
+}
+
+func (backend *GodocBackend) ReadyPath(settings *Settings) string {
+	return "/pkg/"
+}
+
+func (backend *GodocBackend) ModulePath(settings *Settings) string {
+	return "/pkg/" + settings.ModName
+}
+
+func (backend *GodocBackend) AcceptRegex(settings *Settings) string {
+	return regexp.QuoteMeta("/pkg/"+settings.ModName) + `|\.css|\.png|\.js` +
+		stdlibAcceptRegex(settings)
+}
+
+func (backend *GodocBackend) SentinelAsset() string {
+	return "style.css"
+}
+
+func (backend *GodocBackend) ImportPathRegex() *regexp.Regexp {
+	return importPathRegex
+}
+
+// PkgsiteBackend scrapes a local golang.org/x/pkgsite server, which renders with the
+// same templates as pkg.go.dev, including doc links and [Name] syntax.
+type PkgsiteBackend struct{}
+
+// Regex for extracting the import path from a pkgsite unit page's copy button.
+var pkgsiteImportPathRegex = regexp.MustCompile(`data-to-copy="([^"]+)"`)
+
+func (backend *PkgsiteBackend) Name() string {
+	return "pkgsite"
+}
+
+func (backend *PkgsiteBackend) Binary() string {
+	return "pkgsite"
+}
+
+func (backend *PkgsiteBackend) InstallPackage() string {
+	return "golang.org/x/pkgsite/cmd/pkgsite@latest"
+}
+
+func (backend *PkgsiteBackend) Command(ctx context.Context, settings *Settings) *exec.Cmd {
+	return exec.CommandContext(
+		ctx,
+		settings.BackendBinary,
+		"-http="+settings.ServerHost,
+		// serve the module from disk rather than the proxy
+		settings.docSourceRoot(),
+	)
+}
+
+func (backend *PkgsiteBackend) ReadyPath(settings *Settings) string {
+	return "/" + settings.ModName
+}
+
+func (backend *PkgsiteBackend) ModulePath(settings *Settings) string {
+	return "/" + settings.ModName
+}
+
+func (backend *PkgsiteBackend) AcceptRegex(settings *Settings) string {
+	return regexp.QuoteMeta("/"+settings.ModName) +
+		`|/static/|/third_party/|\.css|\.png|\.svg|\.js`
+}
+
+func (backend *PkgsiteBackend) SentinelAsset() string {
+	return "frontend.min.css"
+}
+
+func (backend *PkgsiteBackend) ImportPathRegex() *regexp.Regexp {
+	return pkgsiteImportPathRegex
+}
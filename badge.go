@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"html"
+)
+
+// Badge colors, matching the shields.io palette.
+const (
+	badgeColorGreen  = "#4c1"
+	badgeColorYellow = "#dfb317"
+	badgeColorRed    = "#e05d44"
+	badgeColorBlue   = "#007ec6"
+	badgeColorGrey   = "#555"
+)
+
+// Approximate width in pixels of text rendered in the 11px Verdana shields.io uses.
+func badgeTextWidth(text string) int {
+	return len(text)*7 + 10
+}
+
+// Renders a flat shields.io style badge with a grey label and colored message.
+func renderBadgeSVG(label string, message string, color string) []byte {
+	labelWidth := badgeTextWidth(label)
+	messageWidth := badgeTextWidth(message)
+	width := labelWidth + messageWidth
+
+	label = html.EscapeString(label)
+	message = html.EscapeString(message)
+
+	return []byte(fmt.Sprintf(
+		`<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="%[4]s: %[5]s">`+
+			`<title>%[4]s: %[5]s</title>`+
+			`<linearGradient id="s" x2="0" y2="100%%">`+
+			`<stop offset="0" stop-color="#bbb" stop-opacity=".1"/>`+
+			`<stop offset="1" stop-opacity=".1"/></linearGradient>`+
+			`<clipPath id="r"><rect width="%[1]d" height="20" rx="3" fill="#fff"/></clipPath>`+
+			`<g clip-path="url(#r)">`+
+			`<rect width="%[2]d" height="20" fill="%[7]s"/>`+
+			`<rect x="%[2]d" width="%[3]d" height="20" fill="%[6]s"/>`+
+			`<rect width="%[1]d" height="20" fill="url(#s)"/></g>`+
+			`<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">`+
+			`<text x="%[8]d" y="14">%[4]s</text>`+
+			`<text x="%[9]d" y="14">%[5]s</text></g></svg>`,
+		width,
+		labelWidth,
+		messageWidth,
+		label,
+		message,
+		color,
+		badgeColorGrey,
+		labelWidth/2,
+		labelWidth+messageWidth/2,
+	))
+}
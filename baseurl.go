@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Regex for root-relative links, e.g. href="/lib/style.css", but not protocol
+// relative ones like href="//example.com".
+var rootRelativeLinkRegex = regexp.MustCompile(`(href|src|action)="/([^/"][^"]*)?"`)
+
+// Returns the processor rewriting absolute links so the site works when hosted under
+// settings.BaseURL. Root-relative links are moved under the base path, and links back
+// to the doc server we scraped are pointed at the base url.
+func newBaseURLProcessor(ctx context.Context, runInfo *RunInfo) Processor {
+	settings := runInfo.Settings
+	if settings.BaseURL == "" {
+		return nil
+	}
+
+	baseURL := strings.TrimSuffix(settings.BaseURL, "/")
+	parsed, err := url.Parse(baseURL)
+	if err != nil {
+		log.Panicf("error parsing base url: %v", err)
+	}
+	basePath := strings.TrimSuffix(parsed.Path, "/")
+
+	serverPrefixes := [][]byte{
+		[]byte(`="http://` + settings.ServerHost + `/`),
+		[]byte(`="https://` + settings.ServerHost + `/`),
+	}
+
+	return ProcessorFunc(func(page *Page) error {
+		data := rootRelativeLinkRegex.ReplaceAll(page.Data, []byte(`$1="`+basePath+`/$2"`))
+		for _, prefix := range serverPrefixes {
+			data = bytes.Replace(data, prefix, []byte(`="`+baseURL+`/`), -1)
+		}
+		page.Data = data
+		return nil
+	})
+}
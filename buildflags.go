@@ -0,0 +1,204 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// The commands parse their flags with the standard flag package rather than a CLI
+// framework like cobra or urfave/cli, which would be the module's first dependency
+// tree beyond xerrors and godirwalk. The flag package already accepts -name and
+// --name; what it lacks, short names and grouped help, is added here.
+
+// Short names of the most used flags of the build command, like -o for --build-path.
+var shortFlagNames = map[string]string{
+	"C": "module-root",
+	"b": "backend",
+	"c": "config",
+	"f": "formats",
+	"o": "build-path",
+	"t": "theme",
+}
+
+// A category of the flags of the build command in its help.
+type flagGroup struct {
+	Title string
+	Flags []string
+}
+
+// Categories of the flags of the build command, in the order of the help. Flags in
+// none of them are listed last, under "other flags".
+var buildFlagGroups = []*flagGroup{
+	{
+		Title: "module flags",
+		Flags: []string{
+			"module-root", "module-name", "config", "submodules", "doc-version",
+			"go-toolchain", "include-unexported", "include-stdlib", "edition", "audience",
+		},
+	},
+	{
+		Title: "doc server flags",
+		Flags: []string{
+			"backend", "godoc-host", "server-timeout", "timeout", "poll-interval",
+			"auto-install", "remote-server", "remote-header", "remote-user",
+			"max-requests-per-second", "max-concurrent-fetches",
+			"fixtures", "record", "replay",
+		},
+	},
+	{
+		Title: "output flags",
+		Flags: []string{
+			"build-path", "html-file-name", "base-url", "layout", "site-name",
+			"summary-file", "no-progress", "strict", "verify-offline", "delta",
+			"precompress", "asset-hashes", "csp", "minify", "text-only", "archive", "model",
+			"formats", "search-index", "hugo-front-matter", "jekyll-layout",
+			"man-per-symbol", "metrics", "coverage-badge", "build-stats", "stats-textfile",
+			"anchors", "sphinx-inventory", "symbol-registry", "implementers-index",
+		},
+	},
+	{
+		Title: "page flags",
+		Flags: []string{
+			"theme", "templates", "inject-head-file", "sanitize", "sidebar", "toc", "meta-tags",
+			"type-popovers", "expand-controls", "notes", "note-callouts", "comment-tables",
+			"example-tabs", "example-order", "example-pages", "verify-examples",
+			"playground-links", "implementations", "graph", "graph-external",
+		},
+	},
+	{
+		Title: "publish flags",
+		Flags: []string{
+			"webdav-url", "webdav-user", "delete-extraneous", "artifact-repo",
+			"artifact-group", "artifact-name", "artifact-version", "artifact-user",
+			"artifact-unpacked", "ipfs", "version-archive", "confluence-url",
+			"confluence-space", "confluence-parent", "confluence-user", "search-endpoint",
+			"search-user", "algolia-app-id", "algolia-index", "audit-log",
+		},
+	},
+}
+
+// Replaces the short flags of args with the flags they stand for, so -o=docs and
+// -o docs become -build-path=docs and -build-path docs. Values of flags and the
+// arguments after "--" are left alone. Short flags can't be combined or followed
+// directly by their value, since -name is also the long form of every flag.
+func expandShortFlags(flags *flag.FlagSet, args []string) []string {
+	expanded := make([]string, 0, len(args))
+	takesValue := false
+	for i, arg := range args {
+		if takesValue {
+			takesValue = false
+			expanded = append(expanded, arg)
+			continue
+		}
+		if arg == "--" {
+			return append(expanded, args[i:]...)
+		}
+		if !strings.HasPrefix(arg, "-") {
+			expanded = append(expanded, arg)
+			continue
+		}
+
+		nameValue := strings.SplitN(strings.TrimLeft(arg, "-"), "=", 2)
+		name := nameValue[0]
+		if long, ok := shortFlagNames[name]; ok {
+			name = long
+			arg = "-" + strings.Join(append([]string{long}, nameValue[1:]...), "=")
+		}
+		expanded = append(expanded, arg)
+
+		// The next argument is the value of a flag given without "=", unless it is
+		// a boolean flag.
+		if setFlag := flags.Lookup(name); setFlag != nil && len(nameValue) == 1 {
+			boolValue, isBool := setFlag.Value.(interface{ IsBoolFlag() bool })
+			takesValue = !isBool || !boolValue.IsBoolFlag()
+		}
+	}
+	return expanded
+}
+
+// Returns whether the default value of a flag is the zero value of its type, which
+// the help leaves out.
+func isZeroFlagDefault(defValue string) bool {
+	switch defValue {
+	case "", "false", "0", "0s":
+		return true
+	}
+	return false
+}
+
+// Prints a flag the way flag.PrintDefaults does, with its short name if it has one.
+func printFlagDefault(out io.Writer, printed *flag.Flag, shortNames map[string]string) {
+	line := "  -" + printed.Name
+	if short, ok := shortNames[printed.Name]; ok {
+		line += ", -" + short
+	}
+	valueName, usage := flag.UnquoteUsage(printed)
+	if valueName != "" {
+		line += " " + valueName
+	}
+	line += "\n    \t" + strings.Replace(usage, "\n", "\n    \t", -1)
+	if !isZeroFlagDefault(printed.DefValue) {
+		if _, isString := printed.Value.(flag.Getter).Get().(string); isString {
+			line += fmt.Sprintf(" (default %q)", printed.DefValue)
+		} else {
+			line += fmt.Sprintf(" (default %v)", printed.DefValue)
+		}
+	}
+	fmt.Fprintln(out, line)
+}
+
+// Returns the flag set of a command, like "serve" or "theme check", whose help shows
+// the usage and summary of the command and its flags like the help of the build.
+func newCommandFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		out := flags.Output()
+		fmt.Fprintf(out, "usage: docmodule %v [flags]\n", name)
+		if summary, ok := commandSummaries[name]; ok {
+			fmt.Fprintln(out)
+			fmt.Fprintln(out, summary)
+		}
+		fmt.Fprintln(out)
+		fmt.Fprintln(out, "flags:")
+		flags.VisitAll(func(printed *flag.Flag) {
+			printFlagDefault(out, printed, nil)
+		})
+	}
+	return flags
+}
+
+// Prints the flags of the build command by category, then the ones in none of them.
+func printBuildFlags(out io.Writer, flags *flag.FlagSet) {
+	shortNames := make(map[string]string)
+	for short, long := range shortFlagNames {
+		shortNames[long] = short
+	}
+
+	grouped := make(map[string]bool)
+	for _, group := range buildFlagGroups {
+		fmt.Fprintln(out, group.Title+":")
+		for _, name := range group.Flags {
+			if printed := flags.Lookup(name); printed != nil {
+				printFlagDefault(out, printed, shortNames)
+				grouped[name] = true
+			}
+		}
+		fmt.Fprintln(out)
+	}
+
+	other := make([]*flag.Flag, 0)
+	flags.VisitAll(func(candidate *flag.Flag) {
+		if !grouped[candidate.Name] {
+			other = append(other, candidate)
+		}
+	})
+	if len(other) == 0 {
+		return
+	}
+	fmt.Fprintln(out, "other flags:")
+	for _, printed := range other {
+		printFlagDefault(out, printed, shortNames)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestExpandShortFlags(t *testing.T) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	flags.String("build-path", "", "")
+	flags.String("config", "", "")
+	flags.String("theme", "", "")
+	flags.Bool("strict", false, "")
+
+	cases := []struct {
+		args []string
+		want []string
+	}{
+		{
+			args: []string{"-o", "docs"},
+			want: []string{"-build-path", "docs"},
+		},
+		{
+			args: []string{"-o=docs", "--c=docmodule.json"},
+			want: []string{"-build-path=docs", "-config=docmodule.json"},
+		},
+		{
+			// Values which look like short flags are left alone.
+			args: []string{"-theme", "-o", "-strict", "-t", "dark"},
+			want: []string{"-theme", "-o", "-strict", "-theme", "dark"},
+		},
+		{
+			args: []string{"--build-path", "docs", "-strict"},
+			want: []string{"--build-path", "docs", "-strict"},
+		},
+		{
+			args: []string{"-o", "docs", "--", "-t"},
+			want: []string{"-build-path", "docs", "--", "-t"},
+		},
+	}
+	for _, testCase := range cases {
+		got := expandShortFlags(flags, testCase.args)
+		if !reflect.DeepEqual(got, testCase.want) {
+			t.Errorf("expandShortFlags(%q) = %q, want %q", testCase.args, got, testCase.want)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"golang.org/x/xerrors"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Name of the file describing the build, written to the root of the build directory.
+const buildInfoName = "build-info.json"
+
+// BuildInfo describes a finished build. It is published with the docs so served and
+// hosted copies can report which version they show.
+type BuildInfo struct {
+	// Module name
+	Module string `json:"module"`
+	// Version of the module the docs were built from
+	Version string `json:"version"`
+	// Time the build finished
+	BuiltAt time.Time `json:"builtAt"`
+	// Digest of the godoc template overrides, if any
+	Templates string `json:"templates,omitempty"`
+	// Toolchain and tools the docs were built with, to reproduce the build later
+	Environment *BuildEnvironment `json:"environment,omitempty"`
+}
+
+// BuildEnvironment records what a build depended on besides the module's source.
+type BuildEnvironment struct {
+	// The variables of `go env` which affect the docs, see reproducibleGoEnv
+	GoEnv map[string]string `json:"goEnv"`
+	// Hex sha256 of the output of `go mod graph`, empty if it could not be listed
+	ModuleGraph string `json:"moduleGraph,omitempty"`
+	// Version of docmodule and of the Go toolchain it was built with
+	ToolVersion   string `json:"toolVersion"`
+	ToolGoVersion string `json:"toolGoVersion"`
+	// Versions of the doc server and the other external tools run, by name
+	Tools map[string]string `json:"tools,omitempty"`
+}
+
+// Variables of `go env` recorded in the build info: the ones affecting which code
+// the docs are built from and how it is analyzed. Local paths are left out, since
+// the build info is published.
+var reproducibleGoEnv = []string{
+	"GOVERSION", "GOTOOLCHAIN", "GOOS", "GOARCH", "GOEXPERIMENT", "GOFLAGS",
+	"CGO_ENABLED", "GO111MODULE", "GOPROXY", "GOSUMDB", "GOPRIVATE", "GONOPROXY",
+	"GONOSUMDB",
+}
+
+// Regex for the user info of the URLs in go env values, like the credentials of a
+// private GOPROXY.
+var urlUserInfoRegex = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*://)[^/@\s,|]*@`)
+
+// Returns the variables of reproducibleGoEnv set in goEnv, without the credentials
+// of their URLs.
+func redactedGoEnv(goEnv map[string]string) map[string]string {
+	redacted := make(map[string]string)
+	for _, name := range reproducibleGoEnv {
+		if value, ok := goEnv[name]; ok && value != "" {
+			redacted[name] = urlUserInfoRegex.ReplaceAllString(value, "$1")
+		}
+	}
+	return redacted
+}
+
+// Returns the version of a Go binary's main module, read with `go version -m`.
+func goBinaryVersion(binaryPath string) string {
+	output, err := exec.Command("go", "version", "-m", binaryPath).Output()
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 3 && fields[0] == "mod" {
+			return fields[1] + "@" + fields[2]
+		}
+	}
+	return ""
+}
+
+// Returns the first line of a tool's --version output.
+func toolVersion(name string) string {
+	output, err := exec.Command(name, "--version").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.SplitN(string(output), "\n", 2)[0])
+}
+
+// Snapshots the go environment, module graph and tool versions of the build.
+func snapshotEnvironment(settings *Settings) *BuildEnvironment {
+	environment := &BuildEnvironment{
+		ToolVersion:   docmoduleVersion(),
+		ToolGoVersion: runtime.Version(),
+		Tools:         make(map[string]string),
+	}
+
+	command := exec.Command("go", "env", "-json")
+	command.Dir = settings.ModuleRootPath
+	output, err := command.Output()
+	if err != nil {
+		log.Panicf("error inspecting go environment: %v", err)
+	}
+	goEnv := make(map[string]string)
+	if err := json.Unmarshal(output, &goEnv); err != nil {
+		log.Panicf("error parsing go environment: %v", err)
+	}
+	environment.GoEnv = redactedGoEnv(goEnv)
+
+	command = exec.Command("go", "mod", "graph")
+	command.Dir = settings.ModuleRootPath
+	if output, err := command.Output(); err != nil {
+		log.Printf("error listing module graph, leaving it out of the build info: %v", err)
+	} else {
+		digest := sha256.Sum256(output)
+		environment.ModuleGraph = hex.EncodeToString(digest[:])
+	}
+
+	if settings.BackendBinary != "" {
+		if version := goBinaryVersion(settings.BackendBinary); version != "" {
+			environment.Tools[settings.Backend] = version
+		}
+	}
+	if settings.hasFormat(formatHTML) && settings.Fixtures == "" && settings.Replay == "" {
+		if version := toolVersion("wget"); version != "" {
+			environment.Tools["wget"] = version
+		}
+	}
+	if settings.hasFormat(formatPDF) {
+		if version := toolVersion("pandoc"); version != "" {
+			environment.Tools["pandoc"] = version
+		}
+	}
+	return environment
+}
+
+// Returns the version of the module being documented: --doc-version if given,
+// otherwise the output of `git describe` in the module root, otherwise "dev".
+func detectDocVersion(settings *Settings) string {
+	if settings.DocVersion != "" {
+		return settings.DocVersion
+	}
+
+	command := exec.Command("git", "describe", "--tags", "--always")
+	command.Dir = settings.ModuleRootPath
+	output, err := command.Output()
+	if err != nil || len(strings.TrimSpace(string(output))) == 0 {
+		return "dev"
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// Writes build-info.json into the build directory.
+func writeBuildInfo(settings *Settings) {
+	info := BuildInfo{
+		Module:      settings.ModName,
+		Version:     detectDocVersion(settings),
+		BuiltAt:     time.Now().UTC(),
+		Environment: snapshotEnvironment(settings),
+	}
+	if settings.TemplatesDir != "" {
+		digest, err := templatesDigest(settings.TemplatesDir)
+		if err != nil {
+			log.Panicf("error hashing templates: %v", err)
+		}
+		info.Templates = digest
+	}
+
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		log.Panicf("error encoding build info: %v", err)
+	}
+
+	err = ioutil.WriteFile(settings.BuildDir+"/"+buildInfoName, data, os.ModePerm)
+	if err != nil {
+		log.Panicf("error writing build info: %v", err)
+	}
+}
+
+// Reads build-info.json from buildDir.
+func readBuildInfo(buildDir string) (*BuildInfo, error) {
+	data, err := ioutil.ReadFile(buildDir + "/" + buildInfoName)
+	if err != nil {
+		return nil, xerrors.Errorf("error reading build info: %w", err)
+	}
+
+	info := new(BuildInfo)
+	if err := json.Unmarshal(data, info); err != nil {
+		return nil, xerrors.Errorf("error parsing build info: %w", err)
+	}
+	return info, nil
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// One line descriptions of the subcommands, shown by `docmodule help`. Commands
+// missing here are hidden.
+var commandSummaries = map[string]string{
+	"completion":     "Print a bash, zsh, fish or PowerShell completion script.",
+	"config":         "Print the schema of " + configFileName + " or validate it.",
+	"deploy":         "Deploy an existing build directory to a hosting target.",
+	"diff":           "Report the API changes between two git refs.",
+	"doctor":         "Check the environment a build needs and how to fix it.",
+	"help":           "Show help for docmodule or one of its commands.",
+	"init":           "Scaffold a docs setup for the module.",
+	"migrate":        "Generate " + configFileName + " from a Sphinx project.",
+	"modules":        "Build several modules into one site with an index linking them.",
+	"semver":         "Recommend the next version from the API changes.",
+	"serve":          "Serve build directories over HTTP.",
+	"template-funcs": "Print the reference of the functions page templates may call.",
+	"theme":          "Add pinned themes or check template overrides.",
+	"version":        "Print the version of docmodule.",
+}
+
+// Publish targets of a build, by the flag enabling them.
+var publisherFlags = map[string]string{
+	"webdav":   "webdav-url",
+	"artifact": "artifact-repo",
+	"ipfs":     "ipfs",
+}
+
+// Returns the sorted keys of a map of strings.
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Returns the names of the subcommands.
+func commandNames() []string {
+	return sortedKeys(commandSummaries)
+}
+
+// Returns the names of the backends.
+func backendNames() []string {
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Prints the help of the build command: what can be built and where it can go,
+// followed by its flags.
+func printBuildUsage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintln(out, "usage: docmodule [flags]")
+	fmt.Fprintln(out, "       docmodule <command> [flags]")
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Builds the docs of the module in the working directory.")
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "commands:")
+	for _, name := range commandNames() {
+		fmt.Fprintf(out, "  %-15v %v\n", name, commandSummaries[name])
+	}
+	fmt.Fprintln(out)
+	fmt.Fprintf(out, "backends:        %v\n", strings.Join(backendNames(), ", "))
+	fmt.Fprintf(out, "formats:         %v\n", strings.Join(formatNames(), ", "))
+	publishers := make([]string, 0, len(publisherFlags))
+	for _, name := range sortedKeys(publisherFlags) {
+		publishers = append(publishers, name+" (--"+publisherFlags[name]+")")
+	}
+	fmt.Fprintf(out, "publishers:      %v\n", strings.Join(publishers, ", "))
+	fmt.Fprintf(out, "deploy targets:  %v\n", strings.Join(deployTargets, ", "))
+	fmt.Fprintln(out)
+	fmt.Fprintf(
+		out,
+		"Every flag can also be set by the environment, like --build-path by\n"+
+			"$%v or $DOCMODULE_BUILD_DIR, --godoc-host by $DOCMODULE_HOST and\n"+
+			"--html-file-name by $DOCMODULE_HTML_BASE. Flags win over the environment,\n"+
+			"which wins over the config.\n",
+		envFlagName("build-path"),
+	)
+	fmt.Fprintln(out)
+	printBuildFlags(out, flag.CommandLine)
+}
+
+// Shows the help of docmodule, or of the command named by args.
+func runHelpCommand(args []string) {
+	if len(args) > 0 {
+		if _, ok := commandSummaries[args[0]]; !ok {
+			log.Fatalf("unknown command %q", args[0])
+		}
+	}
+	command := exec.Command(os.Args[0], append(args, "-h")...)
+	command.Stdout = os.Stdout
+	command.Stderr = os.Stderr
+	_ = command.Run()
+}
+
+// Regex for a flag in the output of -h.
+var usageFlagRegex = regexp.MustCompile(`(?m)^  (-+[A-Za-z0-9][\w-]*)`)
+
+// Returns the flags of the command with args, read from its -h output.
+func discoverFlags(args []string) []string {
+	command := exec.Command(os.Args[0], append(args, "-h")...)
+	output, _ := command.CombinedOutput()
+
+	flags := make([]string, 0)
+	for _, match := range usageFlagRegex.FindAllStringSubmatch(string(output), -1) {
+		flags = append(flags, match[1])
+	}
+	return flags
+}
+
+// Returns the values a flag can take, if they are known.
+func flagValues(name string) []string {
+	switch strings.TrimLeft(name, "-") {
+	case "backend":
+		return backendNames()
+	case "formats":
+		return formatNames()
+	case "target":
+		return deployTargets
+	case "skeleton":
+		return []string{skeletonNone, skeletonSphinx, skeletonMkDocs}
+	case "layout":
+		return []string{layoutFlat, layoutNested}
+	case "example-order":
+		return []string{exampleOrderAlpha, exampleOrderDeclared}
+	case "comment-tables":
+		return []string{commentTablesOff, commentTablesAuto, commentTablesDirective}
+	}
+	return nil
+}
+
+// Returns the completions of the last of words, the command line after "docmodule".
+func completions(words []string) []string {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	current := words[len(words)-1]
+	previous := words[:len(words)-1]
+
+	if len(previous) > 0 {
+		if values := flagValues(previous[len(previous)-1]); values != nil {
+			return values
+		}
+	}
+
+	// The words naming the command, e.g. theme add.
+	commandPath := make([]string, 0)
+	for _, word := range previous {
+		if strings.HasPrefix(word, "-") {
+			break
+		}
+		commandPath = append(commandPath, word)
+	}
+
+	if !strings.HasPrefix(current, "-") {
+		switch {
+		case len(commandPath) == 0:
+			return commandNames()
+		case len(commandPath) == 1 && commandPath[0] == "theme":
+			return []string{"add", "check"}
+		case len(commandPath) == 1 && commandPath[0] == "config":
+			return []string{"schema", "validate"}
+		case len(commandPath) == 1 && commandPath[0] == "completion":
+			return completionShells
+		case len(commandPath) == 1 && commandPath[0] == "help":
+			return commandNames()
+		}
+		return nil
+	}
+	return discoverFlags(commandPath)
+}
+
+// Prints the completions of the command line passed as arguments, one per line. Used
+// by the completion scripts.
+func runCompleteCommand(args []string) {
+	if len(args) == 0 {
+		args = []string{""}
+	}
+	current := args[len(args)-1]
+	for _, candidate := range completions(args) {
+		// Flags work with one or two dashes, so complete them the way they are typed.
+		if strings.HasPrefix(current, "--") && strings.HasPrefix(candidate, "-") {
+			candidate = "-" + candidate
+		}
+		if strings.HasPrefix(candidate, current) {
+			fmt.Println(candidate)
+		}
+	}
+}
+
+// Shells completion scripts are generated for.
+var completionShells = []string{"bash", "zsh", "fish", "powershell"}
+
+const bashCompletion = `_docmodule() {
+  local IFS=$'\n'
+  COMPREPLY=($(docmodule __complete "${COMP_WORDS[@]:1:COMP_CWORD}"))
+}
+complete -o default -F _docmodule docmodule
+`
+
+const zshCompletion = `#compdef docmodule
+_docmodule() {
+  local -a candidates
+  candidates=("${(@f)$(docmodule __complete "${(@)words[2,CURRENT]}")}")
+  compadd -a candidates
+}
+compdef _docmodule docmodule
+`
+
+const fishCompletion = `function __docmodule_complete
+  set -l words (commandline -opc)
+  docmodule __complete $words[2..-1] (commandline -ct)
+end
+complete -c docmodule -f -a '(__docmodule_complete)'
+`
+
+const powershellCompletion = `Register-ArgumentCompleter -Native -CommandName docmodule -ScriptBlock {
+  param($wordToComplete, $commandAst, $cursorPosition)
+  $words = @($commandAst.CommandElements | Select-Object -Skip 1 | ForEach-Object { $_.ToString() })
+  if ($wordToComplete -eq '') {
+    # Legacy argument passing drops empty arguments of native commands.
+    if ($PSVersionTable.PSVersion -lt [version]'7.3.0' -or $PSNativeCommandArgumentPassing -eq 'Legacy') {
+      $words += '""'
+    } else {
+      $words += ''
+    }
+  }
+  docmodule __complete @words | ForEach-Object {
+    [System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_)
+  }
+}
+`
+
+// Prints the completion script of a shell.
+func runCompletionCommand(args []string) {
+	if len(args) != 1 {
+		log.Fatalf("usage: docmodule completion %v", strings.Join(completionShells, "|"))
+	}
+	switch args[0] {
+	case "bash":
+		fmt.Print(bashCompletion)
+	case "zsh":
+		fmt.Print(zshCompletion)
+	case "fish":
+		fmt.Print(fishCompletion)
+	case "powershell":
+		fmt.Print(powershellCompletion)
+	default:
+		log.Fatalf("unknown shell %q, expected one of %v", args[0], completionShells)
+	}
+}
@@ -0,0 +1,483 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"golang.org/x/xerrors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Name of the config file looked up in the module root.
+const configFileName = "docmodule.json"
+
+// Default names of the files the config points to, relative to the config file.
+const (
+	defaultProseDir   = "docs"
+	defaultNavFile    = "nav.yaml"
+	defaultIgnoreFile = ".docmoduleignore"
+)
+
+// Config is the content of docmodule.json, which holds a module's doc settings so
+// builds don't have to repeat them on the command line.
+type Config struct {
+	// Location of the config's JSON Schema, for editors.
+	Schema string `json:"$schema,omitempty"`
+	// Values of command line flags by name, without dashes, e.g. "sidebar": true.
+	// Flags given on the command line take precedence.
+	Flags map[string]interface{} `json:"flags,omitempty"`
+	// Directory of markdown prose pages, relative to the config file.
+	Docs string `json:"docs,omitempty"`
+	// File ordering and titling the prose pages, relative to the config file.
+	Nav string `json:"nav,omitempty"`
+	// File of package patterns to leave out of the docs, relative to the config file.
+	Ignore string `json:"ignore,omitempty"`
+	// Shell commands run around the build.
+	Hooks *ConfigHooks `json:"hooks,omitempty"`
+	// Page processors added to the pipeline.
+	Processors []*ConfigProcessor `json:"processors,omitempty"`
+	// Doc comment preprocessors, run in order on every doc comment of the module.
+	Preprocessors []*ConfigPreprocessor `json:"preprocessors,omitempty"`
+}
+
+func readConfig(filePath string) (*Config, error) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, xerrors.Errorf("error reading config: %w", err)
+	}
+	config := new(Config)
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(config); err != nil {
+		return nil, xerrors.Errorf("error parsing config '%v': %w", filePath, err)
+	}
+	return config, nil
+}
+
+// Returns the command line flag called name, ignoring leading dashes.
+func lookupFlag(flags *flag.FlagSet, name string) *flag.Flag {
+	var found *flag.Flag
+	flags.VisitAll(func(candidate *flag.Flag) {
+		if strings.TrimLeft(candidate.Name, "-") == name {
+			found = candidate
+		}
+	})
+	return found
+}
+
+// Prefix of the environment variables setting the flags of the build command.
+const envFlagPrefix = "DOCMODULE_"
+
+// Shorter environment variables for common flags, besides the ones named after the
+// flags.
+var envFlagAliases = map[string]string{
+	"DOCMODULE_BUILD_DIR": "build-path",
+	"DOCMODULE_HOST":      "godoc-host",
+	"DOCMODULE_HTML_BASE": "html-file-name",
+}
+
+// Returns the environment variable setting a flag, like DOCMODULE_BUILD_PATH for
+// --build-path.
+func envFlagName(name string) string {
+	return envFlagPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// Sets the flags not given on the command line from the environment, so CI can
+// configure builds without templating command lines. Values of the environment win
+// over the config, which only sets flags still unset.
+func applyEnvFlags(flags *flag.FlagSet) error {
+	given := make(map[string]bool)
+	flags.Visit(func(set *flag.Flag) {
+		given[set.Name] = true
+	})
+
+	names := make(map[string]string)
+	for variable, name := range envFlagAliases {
+		names[name] = variable
+	}
+	var err error
+	flags.VisitAll(func(envFlag *flag.Flag) {
+		if err != nil || given[envFlag.Name] {
+			return
+		}
+		variables := []string{envFlagName(envFlag.Name)}
+		if alias, ok := names[envFlag.Name]; ok {
+			variables = append(variables, alias)
+		}
+		for _, variable := range variables {
+			value, ok := os.LookupEnv(variable)
+			if !ok {
+				continue
+			}
+			if setErr := flags.Set(envFlag.Name, value); setErr != nil {
+				err = xerrors.Errorf("invalid value %q of $%v: %w", value, variable, setErr)
+			}
+			return
+		}
+	})
+	return err
+}
+
+// Flags the config can't set, as they are needed to find and read it.
+var cliOnlyFlags = map[string]bool{"config": true, "module-root": true, "module-name": true}
+
+// Sets the flags of the config which were not given on the command line.
+func applyConfigFlags(flags *flag.FlagSet, config *Config) error {
+	given := make(map[string]bool)
+	flags.Visit(func(set *flag.Flag) {
+		given[set.Name] = true
+	})
+
+	for name, value := range config.Flags {
+		target := lookupFlag(flags, name)
+		if target == nil {
+			return xerrors.Errorf("config sets unknown flag %q", name)
+		}
+		if cliOnlyFlags[target.Name] {
+			return xerrors.Errorf("flag %q can only be given on the command line", name)
+		}
+		if given[target.Name] {
+			continue
+		}
+		// Flags given more than once on the command line take a list.
+		values, ok := value.([]interface{})
+		if !ok {
+			values = []interface{}{value}
+		}
+		for _, value := range values {
+			if err := flags.Set(target.Name, fmt.Sprint(value)); err != nil {
+				return xerrors.Errorf("config sets invalid value %v for %q: %w", value, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Resolves a path of the config relative to its directory. Falls back to
+// defaultName if the config doesn't name the file and that file exists.
+func configPath(configDir string, name string, defaultName string) string {
+	if name == "" {
+		defaultPath := filepath.Join(configDir, defaultName)
+		if exists, _ := fileExists(defaultPath); exists {
+			return defaultPath
+		}
+		return ""
+	}
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(configDir, name)
+}
+
+// Returns the package patterns of an ignore file, one per line. Blank lines and lines
+// starting with # are skipped.
+func readIgnoreFile(filePath string) ([]string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, xerrors.Errorf("error reading ignore file: %w", err)
+	}
+	defer file.Close()
+
+	patterns := make([]string, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, scanner.Err()
+}
+
+// Loads the config at configFlag, or docmodule.json in the module root if it exists,
+// applying its flags to the command line ones and resolving the files it points to.
+func loadConfig(settings *Settings, flags *flag.FlagSet, configFlag string) error {
+	configFile := configFlag
+	if configFile == "" {
+		configFile = filepath.Join(settings.ModuleRootPath, configFileName)
+		if exists, _ := fileExists(configFile); !exists {
+			configFile = ""
+		}
+	}
+
+	config := new(Config)
+	configDir := settings.ModuleRootPath
+	if configFile != "" {
+		var err error
+		if config, err = readConfig(configFile); err != nil {
+			return err
+		}
+		if err := applyConfigFlags(flags, config); err != nil {
+			return xerrors.Errorf("error applying config '%v': %w", configFile, err)
+		}
+		configDir = filepath.Dir(configFile)
+	}
+
+	settings.Hooks = config.Hooks
+	for _, processor := range config.Processors {
+		if processor.Plugin != "" {
+			processor.Plugin = configPath(configDir, processor.Plugin, "")
+		}
+	}
+	settings.Processors = config.Processors
+	settings.Preprocessors = config.Preprocessors
+	settings.ProseDir = configPath(configDir, config.Docs, defaultProseDir)
+	settings.NavFile = configPath(configDir, config.Nav, defaultNavFile)
+	if ignoreFile := configPath(configDir, config.Ignore, defaultIgnoreFile); ignoreFile != "" {
+		patterns, err := readIgnoreFile(ignoreFile)
+		if err != nil {
+			return err
+		}
+		settings.IgnorePatterns = patterns
+	}
+	return nil
+}
+
+// Reports whether the package importPath is left out of the docs by the ignore file.
+// Patterns are relative to the module unless they start with the module's path.
+func (settings *Settings) ignored(importPath string) bool {
+	for _, pattern := range settings.IgnorePatterns {
+		if !strings.HasPrefix(pattern, settings.ModName) {
+			pattern = strings.TrimSuffix(settings.ModName+"/"+strings.TrimPrefix(pattern, "./"), "/.")
+		}
+		if matchPackagePattern(pattern, importPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the JSON Schema type of a flag, from the type of its value.
+func flagSchemaType(value flag.Value) string {
+	getter, ok := value.(flag.Getter)
+	if !ok {
+		return "string"
+	}
+	switch getter.Get().(type) {
+	case bool:
+		return "boolean"
+	case int, int64, uint, uint64:
+		return "integer"
+	case float64:
+		return "number"
+	case []string:
+		return "array"
+	}
+	return "string"
+}
+
+// Returns the JSON Schema of the commands of a hook point.
+func hookSchema(description string) map[string]interface{} {
+	return map[string]interface{}{
+		"description": description,
+		"type":        "array",
+		"items":       map[string]interface{}{"type": "string"},
+	}
+}
+
+// Returns the JSON Schema of the config file, with a property for every flag of the
+// build command so editors can complete and check them.
+func configSchema() map[string]interface{} {
+	buildFlags := flag.NewFlagSet("build", flag.ContinueOnError)
+	registerBuildFlags(buildFlags)
+
+	flagProperties := make(map[string]interface{})
+	buildFlags.VisitAll(func(buildFlag *flag.Flag) {
+		if cliOnlyFlags[buildFlag.Name] {
+			return
+		}
+		schemaType := flagSchemaType(buildFlag.Value)
+		property := map[string]interface{}{
+			"type":        schemaType,
+			"description": buildFlag.Usage,
+		}
+		if schemaType == "array" {
+			property["items"] = map[string]interface{}{"type": "string"}
+		}
+		if schemaType == "string" && buildFlag.DefValue != "" {
+			property["default"] = buildFlag.DefValue
+		} else if schemaType != "string" {
+			property["default"] = buildFlag.Value.(flag.Getter).Get()
+		}
+		if values := flagValues(buildFlag.Name); values != nil {
+			if buildFlag.Name == "formats" {
+				choice := "(" + strings.Join(values, "|") + ")"
+				property["pattern"] = "^" + choice + "(," + choice + ")*$"
+			} else {
+				property["enum"] = values
+			}
+		}
+		flagProperties[buildFlag.Name] = property
+	})
+
+	return map[string]interface{}{
+		"$schema":              "http://json-schema.org/draft-07/schema#",
+		"title":                "docmodule config",
+		"type":                 "object",
+		"additionalProperties": false,
+		"properties": map[string]interface{}{
+			"$schema": map[string]interface{}{
+				"description": "Location of this schema, e.g. written by 'docmodule config schema'.",
+				"type":        "string",
+			},
+			"flags": map[string]interface{}{
+				"description":          "Values of the build flags. Flags given on the command line take precedence.",
+				"type":                 "object",
+				"additionalProperties": false,
+				"properties":           flagProperties,
+			},
+			"docs": map[string]interface{}{
+				"description": "Directory of markdown prose pages, relative to the config file.",
+				"type":        "string",
+				"default":     defaultProseDir,
+			},
+			"nav": map[string]interface{}{
+				"description": "File ordering and titling the prose pages, relative to the config file.",
+				"type":        "string",
+				"default":     defaultNavFile,
+			},
+			"ignore": map[string]interface{}{
+				"description": "File of package patterns to leave out of the docs, relative to the config file.",
+				"type":        "string",
+				"default":     defaultIgnoreFile,
+			},
+			"processors": map[string]interface{}{
+				"description": "Page processors added to the pipeline, each a shell command reading a page on stdin and writing it to stdout, or a Go plugin exporting func Process(relPath string, data []byte) ([]byte, error).",
+				"type":        "array",
+				"items": map[string]interface{}{
+					"type":                 "object",
+					"additionalProperties": false,
+					"required":             []string{"name"},
+					"properties": map[string]interface{}{
+						"name":      map[string]interface{}{"type": "string"},
+						"command":   map[string]interface{}{"type": "string"},
+						"plugin":    map[string]interface{}{"type": "string", "description": "Path of the plugin, relative to the config file."},
+						"after":     map[string]interface{}{"type": "string", "description": "Stage to run after, the last one if empty. The built-in stages are " + strings.Join(processorNames(), ", ") + "."},
+						"all_pages": map[string]interface{}{"type": "boolean", "description": "Process every HTML page rather than only package pages."},
+					},
+				},
+			},
+			"preprocessors": map[string]interface{}{
+				"description": "Doc comment preprocessors, run in order on every doc comment before the docs are rendered. Each is a built-in preprocessor, " + strings.Join(preprocessorNames(), ", ") + ", or a shell command reading a comment on stdin and writing it to stdout.",
+				"type":        "array",
+				"items": map[string]interface{}{
+					"type":                 "object",
+					"additionalProperties": false,
+					"required":             []string{"name"},
+					"properties": map[string]interface{}{
+						"name":    map[string]interface{}{"type": "string"},
+						"command": map[string]interface{}{"type": "string"},
+						"macros": map[string]interface{}{
+							"description":          "Values of the {{NAME}} macros of the macros preprocessor.",
+							"type":                 "object",
+							"additionalProperties": map[string]interface{}{"type": "string"},
+						},
+					},
+				},
+			},
+			"hooks": map[string]interface{}{
+				"description":          "Shell commands run in the module root around the build. They find the build directory in $DOCMODULE_BUILD_DIR and its build info in $DOCMODULE_MANIFEST.",
+				"type":                 "object",
+				"additionalProperties": false,
+				"properties": map[string]interface{}{
+					hookPreClean:  hookSchema("Run before the build directory is cleared."),
+					hookPreBuild:  hookSchema("Run before the docs are extracted."),
+					hookPostBuild: hookSchema("Run once the build is written, before publishing."),
+				},
+			},
+		},
+	}
+}
+
+// Prints the JSON Schema of the config file.
+func runConfigSchemaCommand(args []string) {
+	flags := newCommandFlagSet("config schema")
+	output := flags.String(
+		"output",
+		"-",
+		"File to write the schema to, '-' for stdout.",
+	)
+	_ = flags.Parse(args)
+
+	data, err := json.MarshalIndent(configSchema(), "", "  ")
+	if err != nil {
+		log.Fatal(xerrors.Errorf("error encoding config schema: %w", err))
+	}
+	data = append(data, '\n')
+
+	if *output == "-" {
+		_, _ = os.Stdout.Write(data)
+		return
+	}
+	if err := ioutil.WriteFile(*output, data, os.ModePerm); err != nil {
+		log.Fatal(xerrors.Errorf("error writing config schema: %w", err))
+	}
+}
+
+// Checks the config file the way a build would load it, exiting with every problem
+// found. Meant for pre-commit hooks and CI.
+func runConfigValidateCommand(args []string) {
+	flags := newCommandFlagSet("config validate")
+	configFlag := flags.String(
+		"config",
+		"",
+		"Config file to check. Defaults to "+configFileName+" in the module root.",
+	)
+	_ = flags.Parse(args)
+
+	settings := new(Settings)
+	getEnvSettings(settings)
+	getGoModName(settings)
+
+	configFile := *configFlag
+	if configFile == "" {
+		configFile = filepath.Join(settings.ModuleRootPath, configFileName)
+	}
+	if exists, _ := fileExists(configFile); !exists {
+		log.Fatalf("config '%v' does not exist", configFile)
+	}
+
+	buildFlags := flag.NewFlagSet("build", flag.ContinueOnError)
+	cliArgs := registerBuildFlags(buildFlags)
+	if err := loadConfig(settings, buildFlags, configFile); err != nil {
+		log.Fatal(err)
+	}
+
+	if settings.ProseDir != "" {
+		if exists, _ := fileExists(settings.ProseDir); !exists {
+			log.Fatalf("docs directory '%v' does not exist", settings.ProseDir)
+		}
+	}
+	if settings.NavFile != "" {
+		if _, err := readNavFile(settings.NavFile); err != nil {
+			log.Fatal(xerrors.Errorf("error reading nav file: %w", err))
+		}
+	}
+
+	applyCliArgs(settings, cliArgs)
+	fmt.Printf("%v is valid\n", configFile)
+}
+
+// Subcommands of `docmodule config`.
+var configCommands = map[string]func(args []string){
+	"schema":   runConfigSchemaCommand,
+	"validate": runConfigValidateCommand,
+}
+
+func runConfigCommand(args []string) {
+	if len(args) == 0 {
+		log.Fatal("usage: docmodule config schema|validate")
+	}
+	command, ok := configCommands[args[0]]
+	if !ok {
+		log.Fatalf("unknown config command %q", args[0])
+	}
+	command(args[1:])
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/doc"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Name of the doc-coverage badge written to the root of the build directory.
+const coverageBadgeName = "doc-coverage.svg"
+
+// DocCoverage counts the exported identifiers of a module and how many of them have
+// a doc comment.
+type DocCoverage struct {
+	Exported   int
+	Documented int
+}
+
+// Returns the share of documented identifiers in percent.
+func (coverage *DocCoverage) Percent() float64 {
+	if coverage.Exported == 0 {
+		return 100
+	}
+	return float64(coverage.Documented) / float64(coverage.Exported) * 100
+}
+
+// Counts an identifier with the given doc comment.
+func (coverage *DocCoverage) add(docComment string) {
+	coverage.Exported++
+	if strings.TrimSpace(docComment) != "" {
+		coverage.Documented++
+	}
+}
+
+// Counts the values of a const or var group. A group's comment documents all of
+// its values.
+func (coverage *DocCoverage) addValues(values []*doc.Value) {
+	for _, value := range values {
+		for _, spec := range value.Decl.Specs {
+			valueSpec := spec.(*ast.ValueSpec)
+			for _, name := range valueSpec.Names {
+				if !name.IsExported() {
+					continue
+				}
+				comment := value.Doc
+				if valueSpec.Doc != nil {
+					comment += valueSpec.Doc.Text()
+				}
+				if valueSpec.Comment != nil {
+					comment += valueSpec.Comment.Text()
+				}
+				coverage.add(comment)
+			}
+		}
+	}
+}
+
+// Counts the exported identifiers of a package and their doc comments.
+func (coverage *DocCoverage) addPackage(pkg *doc.Package) {
+	coverage.add(pkg.Doc)
+	coverage.addValues(pkg.Consts)
+	coverage.addValues(pkg.Vars)
+	for _, function := range pkg.Funcs {
+		coverage.add(function.Doc)
+	}
+	for _, docType := range pkg.Types {
+		coverage.add(docType.Doc)
+		coverage.addValues(docType.Consts)
+		coverage.addValues(docType.Vars)
+		for _, function := range docType.Funcs {
+			coverage.add(function.Doc)
+		}
+		for _, method := range docType.Methods {
+			coverage.add(method.Doc)
+		}
+	}
+}
+
+// A non-main package of the module, as listed by go list.
+type listedPackage struct {
+	ImportPath string
+	Dir        string
+	Name       string
+}
+
+// Lists the module's non-main packages.
+func listModulePackages(settings *Settings) []*listedPackage {
+	command := exec.Command("go", "list", "-f", "{{.ImportPath}}\t{{.Dir}}\t{{.Name}}", "./...")
+	command.Dir = settings.ModuleRootPath
+	output, err := command.Output()
+	if err != nil {
+		log.Panicf("error listing packages: %v", err)
+	}
+
+	packages := make([]*listedPackage, 0)
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		parts := strings.SplitN(line, "\t", 3)
+		if len(parts) != 3 || parts[2] == "main" {
+			continue
+		}
+		packages = append(packages, &listedPackage{ImportPath: parts[0], Dir: parts[1], Name: parts[2]})
+	}
+	return packages
+}
+
+// Computes the doc coverage of a package listed by go list.
+func computePackageCoverage(listed *listedPackage) *DocCoverage {
+	fileSet := token.NewFileSet()
+	packages, err := parser.ParseDir(
+		fileSet,
+		listed.Dir,
+		func(info os.FileInfo) bool { return !strings.HasSuffix(info.Name(), "_test.go") },
+		parser.ParseComments,
+	)
+	if err != nil {
+		log.Panicf("error parsing %v: %v", listed.ImportPath, err)
+	}
+	coverage := new(DocCoverage)
+	if pkg, ok := packages[listed.Name]; ok {
+		coverage.addPackage(doc.New(pkg, listed.ImportPath, 0))
+	}
+	return coverage
+}
+
+// Computes the doc coverage of the module's non-main packages.
+func computeDocCoverage(settings *Settings) *DocCoverage {
+	coverage := new(DocCoverage)
+	for _, listed := range listModulePackages(settings) {
+		packageCoverage := computePackageCoverage(listed)
+		coverage.Exported += packageCoverage.Exported
+		coverage.Documented += packageCoverage.Documented
+	}
+	return coverage
+}
+
+// Returns the badge color for a coverage percentage.
+func coverageBadgeColor(percent float64) string {
+	switch {
+	case percent >= 80:
+		return badgeColorGreen
+	case percent >= 50:
+		return badgeColorYellow
+	default:
+		return badgeColorRed
+	}
+}
+
+// Writes a "docs <coverage>%" badge into the build directory for READMEs to embed.
+func writeCoverageBadge(settings *Settings) {
+	if !settings.CoverageBadge {
+		return
+	}
+
+	coverage := computeDocCoverage(settings)
+	percent := coverage.Percent()
+	log.Printf(
+		"doc coverage: %v of %v exported identifiers documented (%.1f%%).",
+		coverage.Documented,
+		coverage.Exported,
+		percent,
+	)
+
+	badge := renderBadgeSVG("docs", fmt.Sprintf("%d%%", int(percent)), coverageBadgeColor(percent))
+	err := ioutil.WriteFile(settings.BuildDir+"/"+coverageBadgeName, badge, os.ModePerm)
+	if err != nil {
+		log.Panicf("error writing coverage badge: %v", err)
+	}
+}
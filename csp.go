@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Content-Security-Policy the pages of a --csp build comply with: every script and
+// stylesheet is a file of the site.
+const cspPolicy = "default-src 'self'; script-src 'self'; style-src 'self'; " +
+	"img-src 'self' data:; font-src 'self'; object-src 'none'; base-uri 'self'; " +
+	"form-action 'self'"
+
+// Regexes for the inline scripts and styles of a page, with their attributes in the
+// first group and their content in the second, and for the inline event handlers and
+// style attributes which the policy blocks without a way to move them to a file.
+var (
+	inlineScriptRegex = regexp.MustCompile(`(?is)<script(\s[^>]*)?>(.*?)</script>`)
+	inlineStyleRegex  = regexp.MustCompile(`(?is)<style(\s[^>]*)?>(.*?)</style>`)
+	scriptSrcRegex    = regexp.MustCompile(`(?i)\ssrc\s*=`)
+	scriptTypeRegex   = regexp.MustCompile(`(?i)\stype\s*=\s*["']?([^"'\s>]+)`)
+	inlineAttrRegex   = regexp.MustCompile(`(?i)<[a-z][^>]*\s(?:on[a-z]+|style)\s*=`)
+)
+
+// Types of the scripts browsers run. Others, like JSON-LD, are data the policy
+// doesn't apply to.
+var executedScriptTypes = map[string]bool{
+	"":                       true,
+	"text/javascript":        true,
+	"application/javascript": true,
+	"module":                 true,
+}
+
+// Writes the content of an inline script or style to a file at the root of the build
+// named after its hash, like inline.3fa9c2e1.js, so pages with the same blocks
+// share the file. Returns the name of the file.
+func writeInlineAsset(settings *Settings, content []byte, extension string) (string, error) {
+	sum := sha256.Sum256(content)
+	name := "inline." + hex.EncodeToString(sum[:])[:assetHashLength] + extension
+	filePath := filepath.Join(settings.BuildDir, name)
+	if exists, err := fileExists(filePath); err != nil || exists {
+		return name, err
+	}
+	return name, ioutil.WriteFile(filePath, content, os.ModePerm)
+}
+
+// Returns the processor moving the inline scripts and styles of every page to files
+// and adding the Content-Security-Policy they comply with as a meta tag, so the docs
+// can be hosted on sites with a strict policy.
+func newCSPProcessor(ctx context.Context, runInfo *RunInfo) Processor {
+	settings := runInfo.Settings
+	if !settings.CSP {
+		return nil
+	}
+	log.Printf("recommended Content-Security-Policy header: %v", cspPolicy)
+
+	meta := []byte(`<meta http-equiv="Content-Security-Policy" content="` + cspPolicy + `">` + "\n")
+	return ProcessorFunc(func(page *Page) error {
+		pageDir := path.Dir(page.RelPath)
+		var err error
+		page.Data = inlineScriptRegex.ReplaceAllFunc(page.Data, func(match []byte) []byte {
+			groups := inlineScriptRegex.FindSubmatch(match)
+			attributes := groups[1]
+			scriptType := ""
+			if typeMatch := scriptTypeRegex.FindSubmatch(attributes); typeMatch != nil {
+				scriptType = strings.ToLower(string(typeMatch[1]))
+			}
+			if err != nil || scriptSrcRegex.Match(attributes) || !executedScriptTypes[scriptType] {
+				return match
+			}
+			name, writeErr := writeInlineAsset(settings, groups[2], ".js")
+			if writeErr != nil {
+				err = writeErr
+				return match
+			}
+			return []byte(
+				`<script` + string(attributes) + ` src="` + relativeLink(pageDir, name) + `"></script>`,
+			)
+		})
+		page.Data = inlineStyleRegex.ReplaceAllFunc(page.Data, func(match []byte) []byte {
+			if err != nil {
+				return match
+			}
+			name, writeErr := writeInlineAsset(settings, inlineStyleRegex.FindSubmatch(match)[2], ".css")
+			if writeErr != nil {
+				err = writeErr
+				return match
+			}
+			return []byte(`<link rel="stylesheet" href="` + relativeLink(pageDir, name) + `">`)
+		})
+		if err != nil {
+			return err
+		}
+
+		if inlineAttrRegex.Match(page.Data) {
+			log.Printf(
+				"%v has inline event handlers or style attributes, which the policy blocks.",
+				page.RelPath,
+			)
+		}
+		page.Data = injectIntoHeadStart(page.Data, meta)
+		return nil
+	})
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Extensions of the assets deduplicated by content.
+var dedupedAssetExtensions = map[string]bool{
+	".css":   true,
+	".js":    true,
+	".png":   true,
+	".jpg":   true,
+	".jpeg":  true,
+	".gif":   true,
+	".svg":   true,
+	".ico":   true,
+	".woff":  true,
+	".woff2": true,
+	".ttf":   true,
+}
+
+// Reports whether a file of the build is an asset, including the copies wget saves
+// as style.css.1 when it downloads a name twice.
+func isDedupedAsset(name string) bool {
+	extension := filepath.Ext(name)
+	if strings.Trim(extension, ".0123456789") == "" && extension != "" {
+		extension = filepath.Ext(strings.TrimSuffix(name, extension))
+	}
+	return dedupedAssetExtensions[extension]
+}
+
+// Deletes the assets of the build identical to another one, keeping the one with the
+// shortest name. Returns the kept path by deleted path, relative to the build
+// directory and slash-separated.
+func dedupeAssets(settings *Settings) map[string]string {
+	byHash := make(map[[sha256.Size]byte][]string)
+	err := filepath.Walk(settings.BuildDir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !isDedupedAsset(info.Name()) {
+			return err
+		}
+		data, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		byHash[sum] = append(byHash[sum], pageRelPath(settings, filePath))
+		return nil
+	})
+	if err != nil {
+		log.Panicf("error hashing assets: %v", err)
+	}
+
+	kept := make(map[string]string)
+	for _, relPaths := range byHash {
+		if len(relPaths) < 2 {
+			continue
+		}
+		sort.Slice(relPaths, func(i, j int) bool {
+			if len(relPaths[i]) != len(relPaths[j]) {
+				return len(relPaths[i]) < len(relPaths[j])
+			}
+			return relPaths[i] < relPaths[j]
+		})
+		for _, duplicate := range relPaths[1:] {
+			if err := os.Remove(filepath.Join(settings.BuildDir, filepath.FromSlash(duplicate))); err != nil {
+				log.Panicf("error removing duplicate asset: %v", err)
+			}
+			kept[duplicate] = relPaths[0]
+		}
+	}
+	return kept
+}
+
+// Regex for the @import rules of stylesheets naming the imported file as a string,
+// rather than with url().
+var cssImportRegex = regexp.MustCompile(`@import\s+(?:"([^"]*)"|'([^']*)')`)
+
+// Returns the link from the directory fromDir to the asset kept in place of the asset
+// link points to, with the query and fragment of link, and whether link points to a
+// deleted duplicate.
+func keptAssetLink(kept map[string]string, fromDir string, link string) (string, bool) {
+	if strings.Contains(link, ":") {
+		return "", false
+	}
+	suffix := ""
+	if i := strings.IndexAny(link, "?#"); i >= 0 {
+		link, suffix = link[:i], link[i:]
+	}
+	if strings.HasPrefix(link, "/") {
+		original, ok := kept[strings.TrimPrefix(link, "/")]
+		return "/" + original + suffix, ok
+	}
+	original, ok := kept[path.Join(fromDir, link)]
+	if !ok {
+		return "", false
+	}
+	relLink, err := filepath.Rel(filepath.FromSlash(fromDir), filepath.FromSlash(original))
+	if err != nil {
+		return "", false
+	}
+	return filepath.ToSlash(relLink) + suffix, true
+}
+
+// Points the url() references and string @imports in data to the assets kept in place
+// of deleted duplicates. fromDir is the directory the references are relative to.
+func rewriteCSSReferences(data []byte, kept map[string]string, fromDir string) []byte {
+	replace := func(regex *regexp.Regexp) func([]byte) []byte {
+		return func(match []byte) []byte {
+			for _, group := range regex.FindSubmatch(match)[1:] {
+				link := strings.TrimSpace(string(group))
+				if link == "" {
+					continue
+				}
+				if keptLink, ok := keptAssetLink(kept, fromDir, link); ok {
+					return bytes.Replace(match, []byte(link), []byte(keptLink), 1)
+				}
+				break
+			}
+			return match
+		}
+	}
+	data = cssURLRegex.ReplaceAllFunc(data, replace(cssURLRegex))
+	return cssImportRegex.ReplaceAllFunc(data, replace(cssImportRegex))
+}
+
+// Points the references of the stylesheets of the build to the assets kept by
+// dedupeAssets.
+func rewriteStylesheetReferences(settings *Settings, kept map[string]string) {
+	err := filepath.Walk(settings.BuildDir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || filepath.Ext(info.Name()) != ".css" {
+			return err
+		}
+		data, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+		relDir := path.Dir(pageRelPath(settings, filePath))
+		rewritten := rewriteCSSReferences(data, kept, relDir)
+		if bytes.Equal(rewritten, data) {
+			return nil
+		}
+		return ioutil.WriteFile(filePath, rewritten, info.Mode())
+	})
+	if err != nil {
+		log.Panicf("error rewriting stylesheet references: %v", err)
+	}
+}
+
+// Returns the processor pointing the links and style urls of the pages to the assets
+// kept by dedupeAssets, which also rewrites the references of the stylesheets, or
+// nil if the build has no duplicate assets.
+func newDedupeAssetsProcessor(ctx context.Context, runInfo *RunInfo) Processor {
+	kept := dedupeAssets(runInfo.Settings)
+	if len(kept) == 0 {
+		return nil
+	}
+	log.Printf("removed %v duplicate assets.", len(kept))
+	rewriteStylesheetReferences(runInfo.Settings, kept)
+
+	return ProcessorFunc(func(page *Page) error {
+		pageDir := path.Dir(page.RelPath)
+		page.Data = localLinkRegex.ReplaceAllFunc(page.Data, func(match []byte) []byte {
+			groups := localLinkRegex.FindSubmatch(match)
+			keptLink, ok := keptAssetLink(kept, pageDir, string(groups[2]))
+			if !ok {
+				return match
+			}
+			return []byte(string(groups[1]) + `="` + keptLink)
+		})
+		page.Data = rewriteCSSReferences(page.Data, kept, pageDir)
+		return nil
+	})
+}
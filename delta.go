@@ -0,0 +1,223 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Names of the delta written to the root of the build directory, and of the state of
+// the build the next delta is computed against.
+const (
+	deltaName      = "delta.json"
+	deltaStateName = ".docmodule-symbols.json"
+)
+
+// A symbol as recorded in the delta state.
+type deltaSymbol struct {
+	ImportPath string `json:"importPath"`
+	Name       string `json:"name"`
+	Kind       string `json:"kind"`
+	// Hex sha256 of the symbol's signature and doc comment.
+	Hash string `json:"hash,omitempty"`
+}
+
+// State of a build the next build's delta is computed against.
+type buildSnapshot struct {
+	Version string
+	// Hex sha256 of the pages by path relative to the build directory.
+	Pages map[string]string
+	// Symbols by key.
+	Symbols map[string]*deltaSymbol
+}
+
+// Paths added, removed and modified between two builds.
+type pageDelta struct {
+	Added    []string `json:"added"`
+	Removed  []string `json:"removed"`
+	Modified []string `json:"modified"`
+}
+
+// Symbols added, removed and modified between two builds.
+type symbolDelta struct {
+	Added    []*deltaSymbol `json:"added"`
+	Removed  []*deltaSymbol `json:"removed"`
+	Modified []*deltaSymbol `json:"modified"`
+}
+
+// BuildDelta lists what changed since the previous build in the same build
+// directory, so consumers like search indexes and CDNs can update only that.
+type BuildDelta struct {
+	Module string `json:"module"`
+	// Versions of the previous and of this build.
+	From string `json:"from,omitempty"`
+	To   string `json:"to"`
+	// Whether there was no previous build to compare with, making everything added.
+	Full bool `json:"full"`
+	// Packages with added, removed or modified symbols.
+	Packages []string    `json:"packages"`
+	Pages    pageDelta   `json:"pages"`
+	Symbols  symbolDelta `json:"symbols"`
+}
+
+// Returns the hashes of the HTML pages of a build directory.
+func pageHashes(buildDir string) map[string]string {
+	manifest, err := buildSiteManifest(buildDir)
+	if err != nil {
+		log.Panicf("error hashing pages: %v", err)
+	}
+	pages := make(map[string]string)
+	for relPath, sum := range manifest.Files {
+		if strings.HasSuffix(relPath, ".html") {
+			pages[relPath] = sum
+		}
+	}
+	return pages
+}
+
+// Reads the state of the build currently in the build directory, or returns nil if
+// there is none or it was built without --delta.
+func snapshotBuild(settings *Settings) *buildSnapshot {
+	data, err := ioutil.ReadFile(filepath.Join(settings.BuildDir, deltaStateName))
+	if err != nil {
+		return nil
+	}
+	snapshot := &buildSnapshot{Pages: pageHashes(settings.BuildDir)}
+	if err := json.Unmarshal(data, &snapshot.Symbols); err != nil {
+		log.Printf("ignoring the previous build's state: %v", err)
+		return nil
+	}
+	if info, err := readBuildInfo(settings.BuildDir); err == nil {
+		snapshot.Version = info.Version
+	}
+	return snapshot
+}
+
+// Returns the symbols of a doc model by key, hashed for comparison.
+func deltaSymbols(model *DocModel) map[string]*deltaSymbol {
+	symbols := make(map[string]*deltaSymbol)
+	for key, symbol := range model.symbols() {
+		content := symbol.Kind + "\x00" + symbol.Signature + "\x00" + symbol.Doc
+		sum := sha256.Sum256([]byte(content))
+		symbols[key] = &deltaSymbol{
+			ImportPath: symbol.ImportPath,
+			Name:       symbol.Name,
+			Kind:       symbol.Kind,
+			Hash:       hex.EncodeToString(sum[:]),
+		}
+	}
+	return symbols
+}
+
+// Returns the sorted paths of a diff of two sets of hashes.
+func diffHashes(old map[string]string, current map[string]string) pageDelta {
+	delta := pageDelta{Added: []string{}, Removed: []string{}, Modified: []string{}}
+	for relPath, sum := range current {
+		if oldSum, ok := old[relPath]; !ok {
+			delta.Added = append(delta.Added, relPath)
+		} else if oldSum != sum {
+			delta.Modified = append(delta.Modified, relPath)
+		}
+	}
+	for relPath := range old {
+		if _, ok := current[relPath]; !ok {
+			delta.Removed = append(delta.Removed, relPath)
+		}
+	}
+	sort.Strings(delta.Added)
+	sort.Strings(delta.Removed)
+	sort.Strings(delta.Modified)
+	return delta
+}
+
+// Returns the symbols of keys, sorted by key, without their hashes.
+func deltaSymbolList(symbols map[string]*deltaSymbol, keys []string) []*deltaSymbol {
+	list := make([]*deltaSymbol, len(keys))
+	for i, key := range keys {
+		symbol := *symbols[key]
+		symbol.Hash = ""
+		list[i] = &symbol
+	}
+	return list
+}
+
+// Writes delta.json, listing the pages and symbols added, removed and modified since
+// previous, and the state the next build's delta is computed against.
+func writeBuildDelta(settings *Settings, previous *buildSnapshot) {
+	if !settings.Delta {
+		return
+	}
+
+	model, err := loadBuildDocModel(settings)
+	if err != nil {
+		log.Panicf("error extracting doc model: %v", err)
+	}
+	symbols := deltaSymbols(model)
+
+	delta := &BuildDelta{
+		Module: settings.ModName,
+		To:     detectDocVersion(settings),
+		Full:   previous == nil,
+	}
+	if previous == nil {
+		previous = &buildSnapshot{
+			Pages:   make(map[string]string),
+			Symbols: make(map[string]*deltaSymbol),
+		}
+	}
+	delta.From = previous.Version
+	delta.Pages = diffHashes(previous.Pages, pageHashes(settings.BuildDir))
+
+	oldHashes := make(map[string]string, len(previous.Symbols))
+	for key, symbol := range previous.Symbols {
+		oldHashes[key] = symbol.Hash
+	}
+	hashes := make(map[string]string, len(symbols))
+	for key, symbol := range symbols {
+		hashes[key] = symbol.Hash
+	}
+	keys := diffHashes(oldHashes, hashes)
+	delta.Symbols = symbolDelta{
+		Added:    deltaSymbolList(symbols, keys.Added),
+		Removed:  deltaSymbolList(previous.Symbols, keys.Removed),
+		Modified: deltaSymbolList(symbols, keys.Modified),
+	}
+
+	packages := make(map[string]bool)
+	for _, list := range [][]*deltaSymbol{
+		delta.Symbols.Added, delta.Symbols.Removed, delta.Symbols.Modified,
+	} {
+		for _, symbol := range list {
+			packages[symbol.ImportPath] = true
+		}
+	}
+	delta.Packages = make([]string, 0, len(packages))
+	for importPath := range packages {
+		delta.Packages = append(delta.Packages, importPath)
+	}
+	sort.Strings(delta.Packages)
+
+	data, err := json.MarshalIndent(delta, "", "  ")
+	if err != nil {
+		log.Panicf("error encoding delta: %v", err)
+	}
+	deltaPath := filepath.Join(settings.BuildDir, deltaName)
+	if err := ioutil.WriteFile(deltaPath, data, os.ModePerm); err != nil {
+		log.Panicf("error writing delta: %v", err)
+	}
+
+	state, err := json.Marshal(symbols)
+	if err != nil {
+		log.Panicf("error encoding delta state: %v", err)
+	}
+	statePath := filepath.Join(settings.BuildDir, deltaStateName)
+	if err := ioutil.WriteFile(statePath, state, os.ModePerm); err != nil {
+		log.Panicf("error writing delta state: %v", err)
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+// Deploy targets.
+const (
+	deployGitHubPages = "gh-pages"
+	deployS3          = "s3"
+	deploySFTP        = "sftp"
+)
+
+var deployTargets = []string{deployGitHubPages, deployS3, deploySFTP}
+
+// DeploySettings configure `docmodule deploy`.
+type DeploySettings struct {
+	Target string
+	// Build directory to deploy.
+	BuildDir string
+	// Branch, remote and commit message of the gh-pages target.
+	Branch  string
+	Remote  string
+	Message string
+	// Endpoint, region, bucket and key prefix of the s3 target, and the CloudFront
+	// distribution to invalidate after uploading.
+	Endpoint     string
+	Region       string
+	Bucket       string
+	Prefix       string
+	PathStyle    bool
+	Distribution string
+	// Remote path, SSH port and key of the sftp target.
+	Destination string
+	Port        int
+	KeyFile     string
+	// Whether the sftp target only lists the changes it would make.
+	DryRun bool
+	// Whether files no longer in the build are deleted from the s3 and sftp targets.
+	DeleteExtraneous bool
+}
+
+// Returns the build directory set in the module's config, or the default of the
+// build command.
+func configuredBuildDir(settings *Settings) string {
+	config, err := readConfig(filepath.Join(settings.ModuleRootPath, configFileName))
+	if err == nil {
+		if buildDir, ok := config.Flags["build-path"].(string); ok && buildDir != "" {
+			return buildDir
+		}
+	}
+	return "zdocs/source/_static"
+}
+
+func parseDeployArgs(args []string, settings *Settings) *DeploySettings {
+	flags := newCommandFlagSet("deploy")
+	target := flags.String(
+		"target",
+		"",
+		"Where to deploy the build to: '"+strings.Join(deployTargets, "', '")+"'.",
+	)
+	buildDir := flags.String(
+		"build-path",
+		configuredBuildDir(settings),
+		"Build directory to deploy. Defaults to the one of "+configFileName+".",
+	)
+	branch := flags.String(
+		"branch",
+		"gh-pages",
+		"gh-pages: branch to commit the build to.",
+	)
+	remote := flags.String(
+		"remote",
+		"origin",
+		"gh-pages: remote to push the branch to, '' to only commit locally.",
+	)
+	message := flags.String(
+		"message",
+		"",
+		"gh-pages: commit message. Defaults to one naming the module, its version "+
+			"and the commit the docs were built from.",
+	)
+	endpoint := flags.String(
+		"endpoint",
+		"",
+		"s3: endpoint URL of the object store. Defaults to the AWS endpoint of "+
+			"--region.",
+	)
+	region := flags.String(
+		"region",
+		"us-east-1",
+		"s3: region of the bucket.",
+	)
+	bucket := flags.String(
+		"bucket",
+		"",
+		"s3: bucket to upload the build to. Credentials are read from "+
+			s3AccessKeyEnv+", "+s3SecretKeyEnv+" and "+s3SessionTokenEnv+".",
+	)
+	prefix := flags.String(
+		"prefix",
+		"",
+		"s3: key prefix to upload the build under.",
+	)
+	pathStyle := flags.Bool(
+		"path-style",
+		false,
+		"s3: address the bucket in the URL path rather than as a subdomain. "+
+			"Defaults to true with --endpoint.",
+	)
+	distribution := flags.String(
+		"cloudfront-distribution",
+		"",
+		"s3: id of a CloudFront distribution to invalidate the changed files of.",
+	)
+	deleteExtraneous := flags.Bool(
+		"delete-extraneous",
+		false,
+		"s3, sftp: delete files a previous deploy uploaded which are no longer in "+
+			"the build.",
+	)
+	destination := flags.String(
+		"destination",
+		"",
+		"sftp: remote path to copy the build to, e.g. deploy@example.com:/var/www/docs.",
+	)
+	port := flags.Int(
+		"ssh-port",
+		0,
+		"sftp: SSH port. Defaults to the one of the ssh config.",
+	)
+	keyFile := flags.String(
+		"ssh-key",
+		"",
+		"sftp: SSH private key. Defaults to the ssh config or agent.",
+	)
+	dryRun := flags.Bool(
+		"dry-run",
+		false,
+		"sftp: only list the files which would be copied and deleted.",
+	)
+	_ = flags.Parse(args)
+
+	pathStyleSet := false
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == "path-style" {
+			pathStyleSet = true
+		}
+	})
+	if !pathStyleSet {
+		*pathStyle = *endpoint != ""
+	}
+	if *endpoint == "" {
+		*endpoint = "https://s3." + *region + ".amazonaws.com"
+	}
+
+	return &DeploySettings{
+		Target:           *target,
+		BuildDir:         *buildDir,
+		Branch:           *branch,
+		Remote:           *remote,
+		Message:          *message,
+		Endpoint:         *endpoint,
+		Region:           *region,
+		Bucket:           *bucket,
+		Prefix:           *prefix,
+		PathStyle:        *pathStyle,
+		Distribution:     *distribution,
+		Destination:      *destination,
+		Port:             *port,
+		KeyFile:          *keyFile,
+		DryRun:           *dryRun,
+		DeleteExtraneous: *deleteExtraneous,
+	}
+}
+
+// Returns the publisher deploying to the target.
+func deployPublisher(deploySettings *DeploySettings) Publisher {
+	switch deploySettings.Target {
+	case deployGitHubPages:
+		if deploySettings.Branch == "" {
+			log.Fatal("--branch is required")
+		}
+		return &GitHubPagesPublisher{
+			Branch:  deploySettings.Branch,
+			Remote:  deploySettings.Remote,
+			Message: deploySettings.Message,
+		}
+	case deployS3:
+		if deploySettings.Bucket == "" {
+			log.Fatal("--bucket is required")
+		}
+		return NewS3Publisher(deploySettings)
+	case deploySFTP:
+		if deploySettings.Destination == "" {
+			log.Fatal("--destination is required")
+		}
+		publisher, err := NewSFTPPublisher(deploySettings)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return publisher
+	case "":
+		log.Fatal("--target is required")
+	}
+	log.Fatalf("unknown deploy target %q", deploySettings.Target)
+	return nil
+}
+
+// Deploys an existing build directory to a hosting target, without rebuilding it.
+func runDeployCommand(args []string) {
+	runInfo := NewRunInfo()
+	settings := runInfo.Settings
+	getEnvSettings(settings)
+	getGoModName(settings)
+
+	deploySettings := parseDeployArgs(args, settings)
+	settings.BuildDir = deploySettings.BuildDir
+	settings.DeleteExtraneous = deploySettings.DeleteExtraneous
+	if exists, _ := fileExists(settings.BuildDir); !exists {
+		log.Fatalf("build directory '%v' does not exist, build the docs first", settings.BuildDir)
+	}
+
+	publisher := deployPublisher(deploySettings)
+	log.Println("deploying docs to", publisher.Name()+".")
+	if err := publisher.Publish(context.Background(), runInfo); err != nil {
+		log.Fatal(err)
+	}
+	writeBuildSummary(runInfo)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"golang.org/x/xerrors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Output format of the records of an Algolia DocSearch index.
+const formatDocSearch = "docsearch"
+
+// Name of the records written to the root of the build directory.
+const docSearchRecordsName = "docsearch-records.json"
+
+// Levels of the hierarchy of DocSearch records.
+const docSearchLevels = 7
+
+// A record of a DocSearch index, in the structure the DocSearch crawler writes and the
+// DocSearch frontend queries: a heading of a page at a level of the hierarchy, or a
+// paragraph of content under the headings of its hierarchy.
+type docSearchRecord struct {
+	ObjectID string `json:"objectID"`
+	// lvl0 to lvl6, or content.
+	Type string `json:"type"`
+	// Headings by level, lvl0 to lvl6, null below the level of the record.
+	Hierarchy        map[string]*string `json:"hierarchy"`
+	Content          *string            `json:"content"`
+	URL              string             `json:"url"`
+	URLWithoutAnchor string             `json:"url_without_anchor"`
+	Anchor           *string            `json:"anchor"`
+	Weight           docSearchWeight    `json:"weight"`
+	Lang             string             `json:"lang"`
+	Version          []string           `json:"version,omitempty"`
+}
+
+type docSearchWeight struct {
+	PageRank int `json:"pageRank"`
+	// 100 for lvl0, 10 less for every level below, and 0 for content.
+	Level int `json:"level"`
+	// Order of the record among the records, which follows the order of a page.
+	Position int `json:"position"`
+}
+
+// DocSearchFormat writes docsearch-records.json, the records of the module's packages,
+// symbols and paragraphs of their docs for an Algolia DocSearch index, with the
+// module as lvl0, the packages as lvl1 and the symbols as lvl2. Only the packages with
+// a page in the build have records, which link to the pages absolutely with
+// --base-url.
+type DocSearchFormat struct{}
+
+func (format *DocSearchFormat) Name() string {
+	return formatDocSearch
+}
+
+// Returns the hierarchy of a record with the headings from lvl0 down.
+func docSearchHierarchy(headings ...string) map[string]*string {
+	hierarchy := make(map[string]*string, docSearchLevels)
+	for level := 0; level < docSearchLevels; level++ {
+		hierarchy["lvl"+strconv.Itoa(level)] = nil
+		if level < len(headings) {
+			heading := headings[level]
+			hierarchy["lvl"+strconv.Itoa(level)] = &heading
+		}
+	}
+	return hierarchy
+}
+
+// Returns the records of the model.
+func docSearchRecords(
+	settings *Settings, model *DocModel, pages map[string]string, anchors map[string]string,
+) []*docSearchRecord {
+	var version []string
+	if model.Version != "" {
+		version = []string{model.Version}
+	}
+	baseURL := ""
+	if settings.BaseURL != "" {
+		baseURL = strings.TrimSuffix(settings.BaseURL, "/") + "/"
+	}
+
+	records := make([]*docSearchRecord, 0)
+	// Adds the record of a heading and the records of the paragraphs of its doc.
+	addRecords := func(id string, page string, anchor string, doc string, headings ...string) {
+		pageURL := baseURL + page
+		record := &docSearchRecord{
+			ObjectID:         id,
+			Type:             "lvl" + strconv.Itoa(len(headings)-1),
+			Hierarchy:        docSearchHierarchy(headings...),
+			URL:              pageURL,
+			URLWithoutAnchor: pageURL,
+			Weight: docSearchWeight{
+				Level: 100 - 10*(len(headings)-1), Position: len(records),
+			},
+			Lang:    "en",
+			Version: version,
+		}
+		if anchor != "" {
+			record.URL += "#" + anchor
+			record.Anchor = &anchor
+		}
+		records = append(records, record)
+		for i, paragraph := range docParagraphs(doc) {
+			text := paragraph
+			content := *record
+			content.ObjectID = id + "#" + strconv.Itoa(i+1)
+			content.Type = "content"
+			content.Content = &text
+			content.Weight = docSearchWeight{Position: len(records)}
+			records = append(records, &content)
+		}
+	}
+
+	for _, pkg := range model.Packages {
+		page, ok := pages[pkg.ImportPath]
+		if !ok {
+			continue
+		}
+		addRecords(pkg.ImportPath, page, "", pkg.Doc, model.Module, pkg.ImportPath)
+		for _, symbol := range pkg.Symbols {
+			key := symbol.key()
+			addRecords(
+				key, page, anchors[key], symbol.Doc,
+				model.Module, pkg.ImportPath, symbol.Kind+" "+symbol.Name,
+			)
+		}
+	}
+	return records
+}
+
+func (format *DocSearchFormat) Generate(
+	ctx context.Context, settings *Settings, model *DocModel,
+) error {
+	pages, anchors, err := modelPageAnchors(settings, model)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(docSearchRecords(settings, model, pages, anchors), "", "  ")
+	if err != nil {
+		return xerrors.Errorf("error encoding docsearch records: %w", err)
+	}
+	recordsPath := filepath.Join(settings.BuildDir, docSearchRecordsName)
+	if err := ioutil.WriteFile(recordsPath, data, os.ModePerm); err != nil {
+		return xerrors.Errorf("error writing docsearch records: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"golang.org/x/xerrors"
+	"strings"
+	"text/template"
+)
+
+// Content section of static site generators the package pages are written under.
+const siteReferenceSection = "reference"
+
+// siteMarkdown renders the packages of the doc model as the markdown pages of a
+// static site generator, with the doc links of their comments pointing to the pages.
+type siteMarkdown struct {
+	Model *DocModel
+	// Returns the link from the page of the package from to the page of the package
+	// to, and to an anchor of it if fragment is set.
+	Link func(from *ModelPackage, to *ModelPackage, fragment string) string
+	// Escapes the prose of doc comments for the markdown dialect of the site, if set.
+	// Code blocks are left as is.
+	Escape func(text string) string
+}
+
+var siteMarkdownTemplate = template.Must(template.New("site").Parse(
+	`{{if .Package.Doc}}{{call .Doc}}
+{{end}}` + "```go\nimport \"{{.Package.ImportPath}}\"\n```" + `
+{{range .Package.Symbols}}
+## {{.Kind}} {{.Name}} {#{{call $.Anchor .Name}}}
+
+` + "```go\n{{.Signature}}\n```" + `
+{{if .Doc}}
+{{call $.SymbolDoc .}}{{end}}{{end}}`))
+
+// Returns the anchor of a symbol on its page: its name, with Type.Method as
+// Type-Method, as markdown heading ids can't hold dots.
+func markdownAnchor(name string) string {
+	return strings.Replace(name, ".", "-", -1)
+}
+
+// Returns the path of the page of a package relative to the content section, the
+// path of the package in the module, or "" for its root package.
+func packageRelDir(model *DocModel, pkg *ModelPackage) string {
+	return strings.TrimPrefix(strings.TrimPrefix(pkg.ImportPath, model.Module), "/")
+}
+
+// Resolves the target of a doc link of a comment of pkg to a package of the module
+// and a symbol of it, which is empty for links to the package itself.
+func (site *siteMarkdown) resolveDocLink(pkg *ModelPackage, target string) (*ModelPackage, string) {
+	hasSymbol := func(candidate *ModelPackage, name string) bool {
+		for _, symbol := range candidate.Symbols {
+			if symbol.Name == name {
+				return true
+			}
+		}
+		return false
+	}
+	if hasSymbol(pkg, target) {
+		return pkg, target
+	}
+
+	// The package is named by its import path or its name, up to the first dot
+	// after the last slash.
+	packagePart, name := target, ""
+	lastSlash := strings.LastIndex(target, "/")
+	if dot := strings.Index(target[lastSlash+1:], "."); dot >= 0 {
+		packagePart, name = target[:lastSlash+1+dot], target[lastSlash+2+dot:]
+	}
+	var found *ModelPackage
+	for _, candidate := range site.Model.Packages {
+		if candidate.ImportPath != packagePart && candidate.Name != packagePart {
+			continue
+		}
+		if found != nil && candidate.ImportPath != packagePart {
+			// The name is ambiguous.
+			return nil, ""
+		}
+		found = candidate
+	}
+	if found == nil || (name != "" && !hasSymbol(found, name)) {
+		return nil, ""
+	}
+	return found, name
+}
+
+// Reports whether a line of a doc comment is part of a code block.
+func isDocCodeLine(line string) bool {
+	return strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")
+}
+
+// Returns lines without the indentation they all share, ignoring blank lines.
+func dedentLines(lines []string) []string {
+	indent := ""
+	first := true
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		lineIndent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		if first {
+			indent, first = lineIndent, false
+		}
+		for !strings.HasPrefix(lineIndent, indent) {
+			indent = indent[:len(indent)-1]
+		}
+	}
+	dedented := make([]string, len(lines))
+	for i, line := range lines {
+		dedented[i] = strings.TrimPrefix(line, indent)
+	}
+	return dedented
+}
+
+// Renders a doc comment of pkg: the prose is escaped and its doc links to the module
+// point to the pages, while its indented code blocks are fenced, as some dialects,
+// like MDX, have no indented code blocks.
+func (site *siteMarkdown) renderDoc(pkg *ModelPackage, doc string) string {
+	lines := strings.Split(doc, "\n")
+	rendered := make([]string, 0, len(lines))
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if isDocCodeLine(line) {
+			// The block runs to the last indented line, over blank lines.
+			end := i
+			for j := i; j < len(lines); j++ {
+				if isDocCodeLine(lines[j]) {
+					end = j
+				} else if strings.TrimSpace(lines[j]) != "" {
+					break
+				}
+			}
+			rendered = append(rendered, "```")
+			rendered = append(rendered, dedentLines(lines[i:end+1])...)
+			rendered = append(rendered, "```")
+			i = end
+			continue
+		}
+
+		if site.Escape != nil {
+			line = site.Escape(line)
+		}
+		rendered = append(rendered, docLinkRegex.ReplaceAllStringFunc(line, func(match string) string {
+			groups := docLinkRegex.FindStringSubmatch(match)
+			target, symbol := site.resolveDocLink(pkg, groups[1])
+			if target == nil {
+				return match
+			}
+			fragment := ""
+			if symbol != "" {
+				fragment = markdownAnchor(symbol)
+			}
+			return "[" + groups[1] + "](" + site.Link(pkg, target, fragment) + ")"
+		}))
+	}
+	return strings.Join(rendered, "\n")
+}
+
+// Renders the body of the page of a package, without a title, which the front matter
+// of the page holds.
+func (site *siteMarkdown) renderPackage(pkg *ModelPackage) ([]byte, error) {
+	buffer := new(bytes.Buffer)
+	err := siteMarkdownTemplate.Execute(buffer, map[string]interface{}{
+		"Package": pkg,
+		"Anchor":  markdownAnchor,
+		"Doc": func() string {
+			return site.renderDoc(pkg, pkg.Doc)
+		},
+		"SymbolDoc": func(symbol *ModelSymbol) string {
+			return site.renderDoc(pkg, symbol.Doc)
+		},
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("error rendering markdown: %w", err)
+	}
+	return buffer.Bytes(), nil
+}
+
+// Returns the title of the page of a package: its path in the module, or the module
+// path for the root package.
+func packagePageTitle(model *DocModel, pkg *ModelPackage) string {
+	if relDir := packageRelDir(model, pkg); relDir != "" {
+		return relDir
+	}
+	return model.Module
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// The outcome of a check of `docmodule doctor`. Fix is empty if the check passed.
+type doctorResult struct {
+	Detail string
+	Fix    string
+}
+
+// A check of the environment of a build run by `docmodule doctor`.
+type doctorCheck struct {
+	Name string
+	Run  func(args *CliArgs, root string) *doctorResult
+}
+
+// The checks of `docmodule doctor`, in the order they are reported.
+var doctorChecks = []*doctorCheck{
+	{Name: "go toolchain", Run: checkDoctorGo},
+	{Name: "go.mod", Run: checkDoctorGoMod},
+	{Name: "doc server", Run: checkDoctorBackend},
+	{Name: "wget", Run: checkDoctorWget},
+	{Name: "doc server port", Run: checkDoctorPort},
+	{Name: "build directory", Run: checkDoctorBuildDir},
+}
+
+// Reports whether the build scrapes a doc server rather than fixtures or a replayed
+// session.
+func scrapesDocServer(args *CliArgs) bool {
+	return *args.Fixtures == "" && *args.Replay == ""
+}
+
+func checkDoctorGo(args *CliArgs, root string) *doctorResult {
+	output, err := exec.Command("go", "version").Output()
+	if err != nil {
+		return &doctorResult{
+			Detail: fmt.Sprintf("go version failed: %v", err),
+			Fix:    "Install Go from https://go.dev/dl/ and put its bin directory on PATH.",
+		}
+	}
+	return &doctorResult{Detail: strings.TrimSpace(string(output))}
+}
+
+func checkDoctorGoMod(args *CliArgs, root string) *doctorResult {
+	command := exec.Command("go", "env", "GOMOD")
+	command.Dir = root
+	output, err := command.Output()
+	if err != nil {
+		return &doctorResult{
+			Detail: fmt.Sprintf("go env failed: %v", err),
+			Fix:    "Fix the go toolchain first.",
+		}
+	}
+	goModPath := strings.TrimSpace(string(output))
+	if goModPath == "" || goModPath == os.DevNull {
+		if *args.ModuleName != "" {
+			return &doctorResult{Detail: "none, documenting " + *args.ModuleName + " in GOPATH mode"}
+		}
+		if importPath := gopathImportPath(goEnvGopath(root), root); importPath != "" {
+			return &doctorResult{Detail: "none, documenting " + importPath + " in GOPATH mode"}
+		}
+		return &doctorResult{
+			Detail: "no go.mod in " + root,
+			Fix: "Run `go mod init <module path>`, run docmodule from within a module, " +
+				"or name the import path of the directory with --module-name.",
+		}
+	}
+	return &doctorResult{Detail: goModPath}
+}
+
+// Returns the GOPATH of the go environment in dir.
+func goEnvGopath(dir string) string {
+	command := exec.Command("go", "env", "GOPATH")
+	command.Dir = dir
+	output, err := command.Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
+
+func checkDoctorBackend(args *CliArgs, root string) *doctorResult {
+	backend, ok := backends[*args.Backend]
+	if !ok {
+		return &doctorResult{
+			Detail: fmt.Sprintf("unknown backend %q", *args.Backend),
+			Fix:    "Set --backend to one of " + strings.Join(backendNames(), ", ") + ".",
+		}
+	}
+	if !scrapesDocServer(args) {
+		return &doctorResult{Detail: "not needed with --fixtures or --replay"}
+	}
+	if *args.RemoteServer != "" {
+		return &doctorResult{Detail: "scraping " + *args.RemoteServer}
+	}
+	binaryPath := findBackendBinary(backend)
+	if binaryPath == "" {
+		if *args.AutoInstall {
+			return &doctorResult{Detail: backend.Binary() + " is installed by --auto-install"}
+		}
+		return &doctorResult{
+			Detail: backend.Binary() + " not found on PATH or in " + toolCacheDir(),
+			Fix: "Run `go install " + backend.InstallPackage() + "` and make sure GOBIN " +
+				"is on PATH, or build with --auto-install.",
+		}
+	}
+	return &doctorResult{Detail: binaryPath}
+}
+
+func checkDoctorWget(args *CliArgs, root string) *doctorResult {
+	if !scrapesDocServer(args) {
+		return &doctorResult{Detail: "not needed with --fixtures or --replay"}
+	}
+	wgetPath, err := exec.LookPath("wget")
+	if err != nil {
+		return &doctorResult{
+			Detail: "wget not found on PATH",
+			Fix: "Install wget with your package manager, like `apt-get install wget` or " +
+				"`brew install wget`.",
+		}
+	}
+	if version := toolVersion("wget"); version != "" {
+		return &doctorResult{Detail: wgetPath + ", " + version}
+	}
+	return &doctorResult{Detail: wgetPath}
+}
+
+func checkDoctorPort(args *CliArgs, root string) *doctorResult {
+	if !scrapesDocServer(args) {
+		return &doctorResult{Detail: "not needed with --fixtures or --replay"}
+	}
+	listener, err := net.Listen("tcp", *args.ServerHost)
+	if err != nil {
+		return &doctorResult{
+			Detail: fmt.Sprintf("can't listen on %v: %v", *args.ServerHost, err),
+			Fix: "Stop the process using the port, or run the doc server on another " +
+				"one with --godoc-host.",
+		}
+	}
+	_ = listener.Close()
+	return &doctorResult{Detail: *args.ServerHost + " is free"}
+}
+
+func checkDoctorBuildDir(args *CliArgs, root string) *doctorResult {
+	buildDir, err := filepath.Abs(*args.BuildDir)
+	if err != nil {
+		return &doctorResult{Detail: err.Error(), Fix: "Check the value of --build-path."}
+	}
+	// The build creates the directory, so check the closest one that exists.
+	dir := buildDir
+	for {
+		if info, err := os.Stat(dir); err == nil {
+			if !info.IsDir() {
+				return &doctorResult{
+					Detail: dir + " is not a directory",
+					Fix:    "Remove it or choose another --build-path.",
+				}
+			}
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	probe, err := ioutil.TempFile(dir, ".docmodule-doctor-")
+	if err != nil {
+		return &doctorResult{
+			Detail: fmt.Sprintf("%v is not writable: %v", dir, err),
+			Fix:    "Fix the permissions of " + dir + " or choose another --build-path.",
+		}
+	}
+	_ = probe.Close()
+	_ = os.Remove(probe.Name())
+	return &doctorResult{Detail: buildDir + " is writable"}
+}
+
+// Prints the result of a check, returning whether it passed.
+func printDoctorResult(name string, result *doctorResult) bool {
+	if result.Fix == "" {
+		fmt.Printf("ok    %v: %v\n", name, result.Detail)
+		return true
+	}
+	fmt.Printf("FAIL  %v: %v\n      fix: %v\n", name, result.Detail, result.Fix)
+	return false
+}
+
+// Checks the environment a build with the given build flags needs, printing a fix
+// for every check that fails. Exits with an error if any does.
+func runDoctorCommand(args []string) {
+	flags := newCommandFlagSet("doctor")
+	flags.Usage = func() {
+		out := flags.Output()
+		fmt.Fprintln(out, "usage: docmodule doctor [build flags]")
+		fmt.Fprintln(out)
+		fmt.Fprintln(out, "Checks the go toolchain, go.mod, doc server, wget, doc server port and")
+		fmt.Fprintln(out, "build directory a build with the flags needs, and how to fix what is")
+		fmt.Fprintln(out, "missing.")
+		fmt.Fprintln(out)
+		printBuildFlags(out, flags)
+	}
+	cliArgs := registerBuildFlags(flags)
+	_ = flags.Parse(expandShortFlags(flags, args))
+	if err := applyEnvFlags(flags); err != nil {
+		log.Fatal(err)
+	}
+
+	root, err := filepath.Abs(*cliArgs.ModuleRoot)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// The config may set the backend and build directory the checks look at.
+	configFile := *cliArgs.Config
+	if configFile == "" {
+		if exists, _ := fileExists(filepath.Join(root, configFileName)); exists {
+			configFile = filepath.Join(root, configFileName)
+		}
+	}
+	failed := 0
+	if configFile != "" {
+		result := &doctorResult{Detail: configFile}
+		config, err := readConfig(configFile)
+		if err == nil {
+			err = applyConfigFlags(flags, config)
+		}
+		if err != nil {
+			result = &doctorResult{
+				Detail: err.Error(),
+				Fix:    "Correct " + configFile + ", checking it with 'docmodule config validate'.",
+			}
+		}
+		if !printDoctorResult("config", result) {
+			failed++
+		}
+	}
+	for _, check := range doctorChecks {
+		if !printDoctorResult(check.Name, check.Run(cliArgs, root)) {
+			failed++
+		}
+	}
+	if failed > 0 {
+		log.Fatalf("%v of the checks failed", failed)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"golang.org/x/xerrors"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Output format of the docs of a Docusaurus site.
+const formatDocusaurus = "docusaurus"
+
+// Directory of the build directory the Docusaurus docs are written to, with the
+// pages under docs/ and the sidebar next to it, to be copied into the site.
+const docusaurusDir = "docusaurus"
+
+// Name of the sidebar of the Docusaurus output.
+const docusaurusSidebarName = "sidebars.reference.js"
+
+// DocusaurusFormat writes an MDX page per package under docs/reference/, laid out
+// like the module, and a sidebar of the pages for sidebars.js, for Docusaurus v3.
+type DocusaurusFormat struct{}
+
+func (format *DocusaurusFormat) Name() string {
+	return formatDocusaurus
+}
+
+// Replacer of the characters of prose which MDX would read as JSX: braces of
+// expressions and angle brackets of tags.
+var mdxEscaper = strings.NewReplacer("{", `\{`, "}", `\}`, "<", "&lt;", ">", "&gt;")
+
+// Returns the path of the page of a package in the docs directory.
+func docusaurusPagePath(model *DocModel, pkg *ModelPackage) string {
+	return path.Join(siteReferenceSection, packageRelDir(model, pkg), "index.mdx")
+}
+
+// Returns the id of the doc of a page, its path without extension.
+func docusaurusDocID(pagePath string) string {
+	return strings.TrimSuffix(pagePath, path.Ext(pagePath))
+}
+
+// An item of a Docusaurus sidebar: a doc, or a category of items linking to a doc.
+type docusaurusSidebarItem struct {
+	Type  string                   `json:"type"`
+	ID    string                   `json:"id,omitempty"`
+	Label string                   `json:"label"`
+	Link  map[string]string        `json:"link,omitempty"`
+	Items []*docusaurusSidebarItem `json:"items,omitempty"`
+}
+
+// A directory of the module in the sidebar, with the doc of its package if any.
+type docusaurusSidebarNode struct {
+	DocID    string
+	Children map[string]*docusaurusSidebarNode
+	Order    []string
+}
+
+// Returns the items of a node of the sidebar tree: a category for each directory
+// with sub directories, linking to the doc of its package, and a doc for the others.
+func (node *docusaurusSidebarNode) items() []*docusaurusSidebarItem {
+	items := make([]*docusaurusSidebarItem, 0, len(node.Order))
+	for _, name := range node.Order {
+		child := node.Children[name]
+		if len(child.Order) == 0 {
+			items = append(items, &docusaurusSidebarItem{Type: "doc", ID: child.DocID, Label: name})
+			continue
+		}
+		category := &docusaurusSidebarItem{Type: "category", Label: name, Items: child.items()}
+		if child.DocID != "" {
+			category.Link = map[string]string{"type": "doc", "id": child.DocID}
+		}
+		items = append(items, category)
+	}
+	return items
+}
+
+// Renders the sidebar of the packages as a CommonJS module exporting its items.
+func renderDocusaurusSidebar(model *DocModel) ([]byte, error) {
+	root := &docusaurusSidebarNode{Children: make(map[string]*docusaurusSidebarNode)}
+	rootItems := make([]*docusaurusSidebarItem, 0, 1)
+	for _, pkg := range model.Packages {
+		docID := docusaurusDocID(docusaurusPagePath(model, pkg))
+		relDir := packageRelDir(model, pkg)
+		if relDir == "" {
+			rootItems = append(rootItems, &docusaurusSidebarItem{Type: "doc", ID: docID, Label: pkg.Name})
+			continue
+		}
+		node := root
+		for _, name := range strings.Split(relDir, "/") {
+			child, ok := node.Children[name]
+			if !ok {
+				child = &docusaurusSidebarNode{Children: make(map[string]*docusaurusSidebarNode)}
+				node.Children[name] = child
+				node.Order = append(node.Order, name)
+			}
+			node = child
+		}
+		node.DocID = docID
+	}
+
+	data, err := json.MarshalIndent(append(rootItems, root.items()...), "", "  ")
+	if err != nil {
+		return nil, xerrors.Errorf("error encoding sidebar: %w", err)
+	}
+	header := "// Sidebar items of the reference of " + model.Module + ", generated by\n" +
+		"// docmodule. Add them to a sidebar of sidebars.js with\n" +
+		"// items: require('./" + docusaurusSidebarName + "').\n"
+	return append([]byte(header+"module.exports = "), append(data, ";\n"...)...), nil
+}
+
+func (format *DocusaurusFormat) Generate(
+	ctx context.Context, settings *Settings, model *DocModel,
+) error {
+	site := &siteMarkdown{
+		Model: model,
+		Link: func(from *ModelPackage, to *ModelPackage, fragment string) string {
+			fromDir := path.Dir(docusaurusPagePath(model, from))
+			link := relativeLink(fromDir, docusaurusPagePath(model, to))
+			if fragment != "" {
+				link += "#" + fragment
+			}
+			return link
+		},
+		Escape: mdxEscaper.Replace,
+	}
+
+	docsDir := filepath.Join(settings.BuildDir, docusaurusDir, "docs")
+	for i, pkg := range model.Packages {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		body, err := site.renderPackage(pkg)
+		if err != nil {
+			return err
+		}
+		frontMatter := renderFrontMatter(frontMatterYAML, [][2]interface{}{
+			{"title", packagePageTitle(model, pkg)},
+			{"sidebar_label", pkg.Name},
+			{"sidebar_position", i + 1},
+			{"description", pkg.Synopsis},
+		})
+
+		filePath := filepath.Join(docsDir, filepath.FromSlash(docusaurusPagePath(model, pkg)))
+		if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
+			return xerrors.Errorf("error creating docusaurus docs directory: %w", err)
+		}
+		data := append([]byte(frontMatter+"\n"), body...)
+		if err := ioutil.WriteFile(filePath, data, os.ModePerm); err != nil {
+			return xerrors.Errorf("error writing docusaurus page: %w", err)
+		}
+	}
+
+	sidebar, err := renderDocusaurusSidebar(model)
+	if err != nil {
+		return err
+	}
+	sidebarPath := filepath.Join(settings.BuildDir, docusaurusDir, docusaurusSidebarName)
+	if err := ioutil.WriteFile(sidebarPath, sidebar, os.ModePerm); err != nil {
+		return xerrors.Errorf("error writing docusaurus sidebar: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"go/ast"
+	"golang.org/x/xerrors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Prefix of the edition directives of doc comments.
+const editionDirectivePrefix = "//docmodule:"
+
+// Regex matching an edition directive: `//docmodule:if enterprise,cloud`,
+// `//docmodule:if !enterprise`, `//docmodule:else` or `//docmodule:endif`.
+var editionDirectiveRegex = regexp.MustCompile(`^//docmodule:(if|else|endif)(?:\s+(\S+))?\s*$`)
+
+// Reports whether a condition of an `if` directive holds for the editions: it holds
+// if any of its comma separated editions is selected, or with a leading !, if none
+// of them is.
+func editionConditionHolds(condition string, editions map[string]bool) bool {
+	negated := strings.HasPrefix(condition, "!")
+	holds := false
+	for _, edition := range strings.Split(strings.TrimPrefix(condition, "!"), ",") {
+		holds = holds || editions[edition]
+	}
+	return holds != negated
+}
+
+// Returns the comments of a doc comment group left once the edition blocks are
+// resolved for the editions, without the directives. Blocks may nest.
+func resolveEditionBlocks(group *ast.CommentGroup, editions map[string]bool) (*ast.CommentGroup, error) {
+	type block struct {
+		// Whether the condition of the block holds, and whether we are past its else.
+		holds, inElse bool
+	}
+	blocks := make([]block, 0)
+	shown := func() bool {
+		for _, open := range blocks {
+			if open.holds == open.inElse {
+				return false
+			}
+		}
+		return true
+	}
+
+	resolved := &ast.CommentGroup{List: make([]*ast.Comment, 0, len(group.List))}
+	for _, comment := range group.List {
+		if !strings.HasPrefix(comment.Text, editionDirectivePrefix) {
+			if shown() {
+				resolved.List = append(resolved.List, comment)
+			}
+			continue
+		}
+
+		match := editionDirectiveRegex.FindStringSubmatch(comment.Text)
+		if match == nil {
+			return nil, xerrors.Errorf("invalid directive %q", comment.Text)
+		}
+		switch match[1] {
+		case "if":
+			if match[2] == "" {
+				return nil, xerrors.New("//docmodule:if without an edition")
+			}
+			blocks = append(blocks, block{holds: editionConditionHolds(match[2], editions)})
+		case "else":
+			if len(blocks) == 0 || blocks[len(blocks)-1].inElse {
+				return nil, xerrors.New("//docmodule:else without //docmodule:if")
+			}
+			blocks[len(blocks)-1].inElse = true
+		case "endif":
+			if len(blocks) == 0 {
+				return nil, xerrors.New("//docmodule:endif without //docmodule:if")
+			}
+			blocks = blocks[:len(blocks)-1]
+		}
+	}
+	if len(blocks) > 0 {
+		return nil, xerrors.New("//docmodule:if without //docmodule:endif")
+	}
+	return resolved, nil
+}
+
+// Reports whether any Go file of the module has edition directives, in which case
+// the docs are rendered from a preprocessed copy.
+func moduleHasEditionBlocks(settings *Settings) (bool, error) {
+	found := false
+	root := settings.ModuleRootPath
+	err := filepath.Walk(root, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || found {
+			return err
+		}
+		if info.IsDir() {
+			if filePath != root && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(info.Name(), ".go") {
+			return nil
+		}
+		data, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+		found = bytes.Contains(data, []byte(editionDirectivePrefix))
+		return nil
+	})
+	if err != nil {
+		return false, xerrors.Errorf("error scanning for edition directives: %w", err)
+	}
+	return found, nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"golang.org/x/xerrors"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Output format of the bulk index file of Elasticsearch and OpenSearch.
+const formatElasticsearch = "elasticsearch"
+
+// Name of the bulk index file written to the root of the build directory.
+const searchBulkName = "search-bulk.ndjson"
+
+// A document of the search index: a package, a symbol, or a paragraph of the doc of
+// either.
+type searchDocument struct {
+	// package, symbol or paragraph.
+	Type    string `json:"type"`
+	Module  string `json:"module"`
+	Version string `json:"version,omitempty"`
+	Package string `json:"package"`
+	// Name of the package, or of the symbol, Type.Method for methods.
+	Name      string `json:"name"`
+	Kind      string `json:"kind,omitempty"`
+	Signature string `json:"signature,omitempty"`
+	Synopsis  string `json:"synopsis,omitempty"`
+	// Doc of a package or symbol, or the text of a paragraph.
+	Content string `json:"content,omitempty"`
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	// Link to the page of the package and the anchor of the symbol, if the build has
+	// an HTML site, absolute if --base-url is set.
+	URL string `json:"url,omitempty"`
+}
+
+// ElasticsearchFormat writes search-bulk.ndjson, the body of a request to the _bulk
+// API of Elasticsearch and OpenSearch indexing every package, symbol and paragraph of
+// their docs, by ids stable across builds.
+type ElasticsearchFormat struct{}
+
+func (format *ElasticsearchFormat) Name() string {
+	return formatElasticsearch
+}
+
+// Regex for the characters index names can't hold.
+var searchIndexNameRegex = regexp.MustCompile(`[^a-z0-9_.\-]+`)
+
+// Returns the index the documents are written to: --search-index, or the last
+// element of the module path, in the lower case index names must be in.
+func searchIndexName(settings *Settings, model *DocModel) string {
+	if settings.SearchIndex != "" {
+		return settings.SearchIndex
+	}
+	name := strings.ToLower(path.Base(model.Module))
+	name = searchIndexNameRegex.ReplaceAllString(name, "-")
+	return strings.TrimLeft(name, "-_.")
+}
+
+// Returns the paragraphs of the prose of a doc comment, without its code blocks.
+func docParagraphs(doc string) []string {
+	paragraphs := make([]string, 0)
+	paragraph := make([]string, 0)
+	for _, line := range strings.Split(doc, "\n") {
+		if strings.TrimSpace(line) == "" || isDocCodeLine(line) {
+			if len(paragraph) > 0 {
+				paragraphs = append(paragraphs, strings.Join(paragraph, " "))
+				paragraph = paragraph[:0]
+			}
+			continue
+		}
+		paragraph = append(paragraph, line)
+	}
+	if len(paragraph) > 0 {
+		paragraphs = append(paragraphs, strings.Join(paragraph, " "))
+	}
+	return paragraphs
+}
+
+// Writes the action and source lines indexing a document by id.
+func writeBulkIndex(
+	bulk *bytes.Buffer, index string, id string, document *searchDocument,
+) error {
+	action := map[string]map[string]string{"index": {"_index": index, "_id": id}}
+	for _, line := range []interface{}{action, document} {
+		data, err := json.Marshal(line)
+		if err != nil {
+			return xerrors.Errorf("error encoding search document %v: %w", id, err)
+		}
+		bulk.Write(append(data, '\n'))
+	}
+	return nil
+}
+
+// Writes the documents of the paragraphs of a doc, with ids following the id of the
+// package or symbol.
+func writeBulkParagraphs(
+	bulk *bytes.Buffer, index string, id string, document searchDocument, doc string,
+) error {
+	document.Type = "paragraph"
+	document.Signature = ""
+	document.Synopsis = ""
+	for i, paragraph := range docParagraphs(doc) {
+		document.Content = paragraph
+		paragraphID := id + "#" + strconv.Itoa(i+1)
+		if err := writeBulkIndex(bulk, index, paragraphID, &document); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (format *ElasticsearchFormat) Generate(
+	ctx context.Context, settings *Settings, model *DocModel,
+) error {
+	pages, anchors, err := modelPageAnchors(settings, model)
+	if err != nil {
+		return err
+	}
+	link := func(page string, anchor string) string {
+		if page == "" {
+			return ""
+		}
+		if anchor != "" {
+			page += "#" + anchor
+		}
+		if settings.BaseURL != "" {
+			return strings.TrimSuffix(settings.BaseURL, "/") + "/" + page
+		}
+		return page
+	}
+
+	index := searchIndexName(settings, model)
+	bulk := new(bytes.Buffer)
+	for _, pkg := range model.Packages {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		document := searchDocument{
+			Type:     "package",
+			Module:   model.Module,
+			Version:  model.Version,
+			Package:  pkg.ImportPath,
+			Name:     pkg.Name,
+			Synopsis: pkg.Synopsis,
+			Content:  pkg.Doc,
+			URL:      link(pages[pkg.ImportPath], ""),
+		}
+		if err := writeBulkIndex(bulk, index, pkg.ImportPath, &document); err != nil {
+			return err
+		}
+		err := writeBulkParagraphs(bulk, index, pkg.ImportPath, document, pkg.Doc)
+		if err != nil {
+			return err
+		}
+
+		for _, symbol := range pkg.Symbols {
+			key := symbol.key()
+			document := searchDocument{
+				Type:      "symbol",
+				Module:    model.Module,
+				Version:   model.Version,
+				Package:   pkg.ImportPath,
+				Name:      symbol.Name,
+				Kind:      symbol.Kind,
+				Signature: symbol.Signature,
+				Content:   symbol.Doc,
+				File:      symbol.File,
+				Line:      symbol.Line,
+				URL:       link(pages[pkg.ImportPath], anchors[key]),
+			}
+			if err := writeBulkIndex(bulk, index, key, &document); err != nil {
+				return err
+			}
+			if err := writeBulkParagraphs(bulk, index, key, document, symbol.Doc); err != nil {
+				return err
+			}
+		}
+	}
+
+	bulkPath := filepath.Join(settings.BuildDir, searchBulkName)
+	if err := ioutil.WriteFile(bulkPath, bulk.Bytes(), os.ModePerm); err != nil {
+		return xerrors.Errorf("error writing search bulk file: %w", err)
+	}
+	return nil
+}
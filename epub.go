@@ -0,0 +1,320 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"golang.org/x/xerrors"
+	"html"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Output format of the e-book of the module.
+const formatEPUB = "epub"
+
+// Container of an EPUB, pointing readers to its package document.
+const epubContainer = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`
+
+// Style sheet of the pages of the e-book.
+const epubStyle = `body { font-family: serif; line-height: 1.4; }
+h1, h2 { font-family: sans-serif; }
+h2 { font-size: 1.1em; margin-top: 1.5em; }
+pre { font-size: 0.8em; white-space: pre-wrap; background: #f4f4f4; padding: 0.5em; }
+a { color: #00599c; }
+`
+
+// EPUBFormat packages the module into an EPUB 3 e-book, with a cover, a chapter per
+// package and a table of contents listing the packages and their symbols. The e-book
+// also holds an NCX table of contents for EPUB 2 readers.
+type EPUBFormat struct{}
+
+func (format *EPUBFormat) Name() string {
+	return formatEPUB
+}
+
+// Returns the name of the chapter of the package with the index in the model.
+func epubChapterName(index int) string {
+	return fmt.Sprintf("package-%03d.xhtml", index+1)
+}
+
+// Returns an XHTML page of the e-book with the body.
+func epubPage(title string, body string) string {
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" lang="en">
+<head>
+<title>` + html.EscapeString(title) + `</title>
+<link rel="stylesheet" type="text/css" href="style.css"/>
+</head>
+<body>
+` + body + `</body>
+</html>
+`
+}
+
+// Renders a doc comment of pkg as paragraphs and preformatted code blocks, with its
+// doc links to the module as links to the chapters.
+func renderEPUBDoc(
+	site *siteMarkdown, chapters map[*ModelPackage]string, pkg *ModelPackage, doc string,
+) string {
+	rendered := new(strings.Builder)
+	paragraph := make([]string, 0)
+	flushParagraph := func() {
+		if len(paragraph) == 0 {
+			return
+		}
+		text := html.EscapeString(strings.Join(paragraph, " "))
+		text = docLinkRegex.ReplaceAllStringFunc(text, func(match string) string {
+			groups := docLinkRegex.FindStringSubmatch(match)
+			target, symbol := site.resolveDocLink(pkg, groups[1])
+			if target == nil {
+				return match
+			}
+			href := chapters[target]
+			if symbol != "" {
+				href += "#" + markdownAnchor(symbol)
+			}
+			return `<a href="` + href + `">` + groups[1] + "</a>"
+		})
+		rendered.WriteString("<p>" + text + "</p>\n")
+		paragraph = paragraph[:0]
+	}
+
+	lines := strings.Split(doc, "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if isDocCodeLine(line) {
+			flushParagraph()
+			end := i
+			for j := i; j < len(lines); j++ {
+				if isDocCodeLine(lines[j]) {
+					end = j
+				} else if strings.TrimSpace(lines[j]) != "" {
+					break
+				}
+			}
+			code := strings.Join(dedentLines(lines[i:end+1]), "\n")
+			rendered.WriteString("<pre><code>" + html.EscapeString(code) + "</code></pre>\n")
+			i = end
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			flushParagraph()
+			continue
+		}
+		paragraph = append(paragraph, line)
+	}
+	flushParagraph()
+	return rendered.String()
+}
+
+// Renders the chapter of a package.
+func renderEPUBChapter(
+	site *siteMarkdown, chapters map[*ModelPackage]string, pkg *ModelPackage,
+) string {
+	title := pkg.ImportPath
+	body := new(strings.Builder)
+	body.WriteString("<section epub:type=\"chapter\">\n")
+	body.WriteString("<h1>" + html.EscapeString(title) + "</h1>\n")
+	importLine := `import "` + pkg.ImportPath + `"`
+	body.WriteString("<pre><code>" + html.EscapeString(importLine) + "</code></pre>\n")
+	body.WriteString(renderEPUBDoc(site, chapters, pkg, pkg.Doc))
+	for _, symbol := range pkg.Symbols {
+		fmt.Fprintf(
+			body, "<h2 id=\"%v\">%v</h2>\n",
+			markdownAnchor(symbol.Name), html.EscapeString(symbol.Kind+" "+symbol.Name),
+		)
+		signature := html.EscapeString(symbol.Signature)
+		body.WriteString("<pre><code>" + signature + "</code></pre>\n")
+		body.WriteString(renderEPUBDoc(site, chapters, pkg, symbol.Doc))
+	}
+	body.WriteString("</section>\n")
+	return epubPage(title, body.String())
+}
+
+// Renders the cover, an SVG image with the name, path and version of the module.
+func renderEPUBCover(model *DocModel) string {
+	text := func(y int, size int, content string) string {
+		return fmt.Sprintf(
+			`  <text x="300" y="%v" font-size="%v" text-anchor="middle">%v</text>`+"\n",
+			y, size, html.EscapeString(content),
+		)
+	}
+	cover := new(strings.Builder)
+	cover.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<svg xmlns="http://www.w3.org/2000/svg" width="600" height="800" viewBox="0 0 600 800"
+  font-family="sans-serif">
+  <rect width="600" height="800" fill="#00599c"/>
+  <rect x="40" y="40" width="520" height="720" fill="none" stroke="#ffffff" stroke-width="4"/>
+  <g fill="#ffffff">
+`)
+	cover.WriteString(text(320, 48, path.Base(model.Module)))
+	cover.WriteString(text(380, 18, model.Module))
+	if model.Version != "" {
+		cover.WriteString(text(420, 18, model.Version))
+	}
+	cover.WriteString(text(700, 22, "Go package reference"))
+	cover.WriteString("  </g>\n</svg>\n")
+	return cover.String()
+}
+
+// Renders the navigation document, the table of contents of EPUB 3 readers, listing
+// the packages and the symbols of each.
+func renderEPUBNav(model *DocModel, chapters map[*ModelPackage]string) string {
+	nav := new(strings.Builder)
+	nav.WriteString("<nav epub:type=\"toc\" id=\"toc\">\n<h1>Contents</h1>\n<ol>\n")
+	for _, pkg := range model.Packages {
+		fmt.Fprintf(
+			nav, "<li><a href=\"%v\">%v</a>", chapters[pkg], html.EscapeString(pkg.ImportPath),
+		)
+		if len(pkg.Symbols) > 0 {
+			nav.WriteString("\n<ol>\n")
+			for _, symbol := range pkg.Symbols {
+				fmt.Fprintf(
+					nav, "<li><a href=\"%v#%v\">%v</a></li>\n",
+					chapters[pkg], markdownAnchor(symbol.Name), html.EscapeString(symbol.Name),
+				)
+			}
+			nav.WriteString("</ol>\n")
+		}
+		nav.WriteString("</li>\n")
+	}
+	nav.WriteString("</ol>\n</nav>\n")
+	return epubPage("Contents", nav.String())
+}
+
+// Renders the NCX table of contents of EPUB 2 readers, listing the packages.
+func renderEPUBNCX(
+	model *DocModel, identifier string, chapters map[*ModelPackage]string,
+) string {
+	ncx := new(strings.Builder)
+	fmt.Fprintf(ncx, `<?xml version="1.0" encoding="UTF-8"?>
+<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1">
+<head><meta name="dtb:uid" content="%v"/></head>
+<docTitle><text>%v</text></docTitle>
+<navMap>
+`, html.EscapeString(identifier), html.EscapeString(model.Module))
+	for i, pkg := range model.Packages {
+		fmt.Fprintf(
+			ncx, "<navPoint id=\"nav-%v\" playOrder=\"%v\"><navLabel><text>%v</text></navLabel>"+
+				"<content src=\"%v\"/></navPoint>\n",
+			i+1, i+1, html.EscapeString(pkg.ImportPath), chapters[pkg],
+		)
+	}
+	ncx.WriteString("</navMap>\n</ncx>\n")
+	return ncx.String()
+}
+
+// Renders the package document, listing the files of the e-book and the reading
+// order: the cover, the contents and the chapters.
+func renderEPUBPackage(
+	model *DocModel, identifier string, modified string, chapters []string,
+) string {
+	opf := new(strings.Builder)
+	fmt.Fprintf(opf, `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="book-id">
+<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+<dc:identifier id="book-id">%v</dc:identifier>
+<dc:title>%v</dc:title>
+<dc:language>en</dc:language>
+<meta property="dcterms:modified">%v</meta>
+<meta name="cover" content="cover-image"/>
+</metadata>
+<manifest>
+<item id="cover-image" href="cover.svg" media-type="image/svg+xml" properties="cover-image"/>
+<item id="cover" href="cover.xhtml" media-type="application/xhtml+xml"/>
+<item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+<item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml"/>
+<item id="style" href="style.css" media-type="text/css"/>
+`, html.EscapeString(identifier), html.EscapeString(model.Module), modified)
+	for i, chapter := range chapters {
+		fmt.Fprintf(
+			opf, "<item id=\"chapter-%v\" href=\"%v\" media-type=\"application/xhtml+xml\"/>\n",
+			i+1, chapter,
+		)
+	}
+	opf.WriteString("</manifest>\n<spine toc=\"ncx\">\n")
+	opf.WriteString("<itemref idref=\"cover\" linear=\"no\"/>\n<itemref idref=\"nav\"/>\n")
+	for i := range chapters {
+		fmt.Fprintf(opf, "<itemref idref=\"chapter-%v\"/>\n", i+1)
+	}
+	opf.WriteString("</spine>\n</package>\n")
+	return opf.String()
+}
+
+func (format *EPUBFormat) Generate(
+	ctx context.Context, settings *Settings, model *DocModel,
+) error {
+	site := &siteMarkdown{Model: model}
+	chapters := make(map[*ModelPackage]string)
+	chapterNames := make([]string, len(model.Packages))
+	for i, pkg := range model.Packages {
+		chapters[pkg] = epubChapterName(i)
+		chapterNames[i] = chapters[pkg]
+	}
+	identifier := "urn:docmodule:" + model.Module
+	if model.Version != "" {
+		identifier += "@" + model.Version
+	}
+	modTime := archiveModTime()
+
+	// The mimetype comes first, uncompressed and without the extra field of its time,
+	// so readers can identify the file.
+	files := [][2]string{
+		{"mimetype", "application/epub+zip"},
+		{"META-INF/container.xml", epubContainer},
+		{"OEBPS/content.opf", renderEPUBPackage(
+			model, identifier, modTime.Format("2006-01-02T15:04:05Z"), chapterNames,
+		)},
+		{"OEBPS/cover.svg", renderEPUBCover(model)},
+		{"OEBPS/cover.xhtml", epubPage(model.Module, "<div>\n"+
+			`<img src="cover.svg" alt="`+html.EscapeString(model.Module)+`" style="width: 100%"/>`+
+			"\n</div>\n")},
+		{"OEBPS/nav.xhtml", renderEPUBNav(model, chapters)},
+		{"OEBPS/toc.ncx", renderEPUBNCX(model, identifier, chapters)},
+		{"OEBPS/style.css", epubStyle},
+	}
+	for _, pkg := range model.Packages {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		files = append(files, [2]string{
+			"OEBPS/" + chapters[pkg], renderEPUBChapter(site, chapters, pkg),
+		})
+	}
+
+	destPath := filepath.Join(settings.BuildDir, path.Base(model.Module)+".epub")
+	dest, err := os.Create(destPath)
+	if err != nil {
+		return xerrors.Errorf("error creating epub: %w", err)
+	}
+	defer dest.Close()
+	writer := zip.NewWriter(dest)
+	for i, file := range files {
+		header := &zip.FileHeader{Name: file[0], Method: zip.Store}
+		if i > 0 {
+			header.Method = zip.Deflate
+			header.Modified = modTime
+		}
+		entry, err := writer.CreateHeader(header)
+		if err != nil {
+			return xerrors.Errorf("error adding %v to epub: %w", file[0], err)
+		}
+		if _, err := entry.Write([]byte(file[1])); err != nil {
+			return xerrors.Errorf("error adding %v to epub: %w", file[0], err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return xerrors.Errorf("error writing epub: %w", err)
+	}
+	return dest.Close()
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"go/ast"
+	"go/doc"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Directory of the build directory the standalone example pages are written to.
+const examplePagesDir = "examples"
+
+// Endpoint of the Go Playground's share API and the prefix of shared snippets.
+const (
+	playgroundShareURL = "https://play.golang.org/share"
+	playgroundURL      = "https://go.dev/play/p/"
+)
+
+// An example rendered on its own page.
+type examplePage struct {
+	ImportPath string
+	Name       string
+	Title      string
+	Doc        string
+	Code       string
+	Output     string
+	// Symbol the example documents, empty for examples of the package.
+	Symbol string
+	// Whether the output is compared ignoring line order.
+	Unordered bool
+	// Whether the code is a complete program that can run in the playground.
+	Runnable bool
+	// Path of the page relative to the build directory.
+	RelPath string
+	// Links relative to the page.
+	PackageLink    string
+	Stylesheet     string
+	NavStylesheet  string
+	NavScript      string
+	IndexLink      string
+	PlaygroundLink string
+}
+
+var examplePageTemplate = template.Must(template.New("example").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta http-equiv="Content-Type" content="text/html; charset=utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.ImportPath}}{{if .Symbol}}.{{.Symbol}}{{end}} - {{.Title}}</title>
+<link type="text/css" rel="stylesheet" href="{{.Stylesheet}}">
+<link type="text/css" rel="stylesheet" href="{{.NavStylesheet}}">
+</head>
+<body>
+<div id="page" class="wide">
+<div class="container">
+<p><a href="{{.IndexLink}}">All examples</a></p>
+<h1>{{.Title}}</h1>
+<p>Example of <a href="{{if .PackageLink}}{{.PackageLink}}{{else}}{{.IndexLink}}{{end}}">{{.ImportPath}}</a>{{if .Symbol}}.{{.Symbol}}{{end}}.</p>
+{{if .Doc}}<p>{{.Doc}}</p>{{end}}
+<h2 id="code">Code</h2>
+<div class="docmodule-example-actions">
+<button type="button" class="docmodule-copy" data-copy="example-code">Copy</button>
+{{if .PlaygroundLink}}<a href="{{.PlaygroundLink}}" target="_blank" rel="noopener">Run in Go Playground</a>{{end}}
+</div>
+<pre id="example-code">{{.Code}}</pre>
+{{if .Output}}
+<h2 id="output">{{if .Unordered}}Output (in any order){{else}}Output{{end}}</h2>
+<pre>{{.Output}}</pre>
+{{end}}
+</div>
+</div>
+<script src="{{.NavScript}}"></script>
+</body>
+</html>
+`))
+
+var exampleIndexTemplate = template.Must(template.New("example-index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta http-equiv="Content-Type" content="text/html; charset=utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Module}} - Examples</title>
+<link type="text/css" rel="stylesheet" href="{{.Stylesheet}}">
+</head>
+<body>
+<div id="page" class="wide">
+<div class="container">
+<h1>Examples of {{.Module}}</h1>
+{{range .Packages}}
+<h2 id="{{.ImportPath}}">{{.ImportPath}}</h2>
+<ul>
+{{range .Examples}}<li><a href="{{.Link}}">{{if .Symbol}}{{.Symbol}}: {{end}}{{.Title}}</a></li>
+{{end}}</ul>
+{{end}}
+</div>
+</div>
+</body>
+</html>
+`))
+
+// Examples of a package as listed on the examples index.
+type exampleIndexPackage struct {
+	ImportPath string
+	Examples   []*exampleIndexEntry
+}
+
+type exampleIndexEntry struct {
+	Symbol string
+	Title  string
+	Link   string
+}
+
+// Returns the source of an example: the complete program if it can run on its own,
+// otherwise the body of the example function.
+func exampleSource(fileSet *token.FileSet, example *doc.Example) (string, bool) {
+	buffer := new(bytes.Buffer)
+	config := printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 8}
+
+	if example.Play != nil {
+		if err := config.Fprint(buffer, fileSet, example.Play); err != nil {
+			log.Panicf("error printing example: %v", err)
+		}
+		return buffer.String(), true
+	}
+
+	node := example.Code
+	if block, ok := node.(*ast.BlockStmt); ok {
+		for _, stmt := range block.List {
+			if err := config.Fprint(buffer, fileSet, stmt); err != nil {
+				log.Panicf("error printing example: %v", err)
+			}
+			buffer.WriteString("\n")
+		}
+		return buffer.String(), false
+	}
+	if err := config.Fprint(buffer, fileSet, node); err != nil {
+		log.Panicf("error printing example: %v", err)
+	}
+	return buffer.String(), false
+}
+
+// Returns the examples declared in the test files of the package in dir.
+func packageExamples(dir string) (*token.FileSet, []*doc.Example) {
+	testFiles, err := filepath.Glob(filepath.Join(dir, "*_test.go"))
+	if err != nil {
+		log.Panicf("error listing test files: %v", err)
+	}
+	sort.Strings(testFiles)
+
+	fileSet := token.NewFileSet()
+	files := make([]*ast.File, 0, len(testFiles))
+	for _, testFile := range testFiles {
+		file, err := parser.ParseFile(fileSet, testFile, nil, parser.ParseComments)
+		if err != nil {
+			log.Printf("skipping examples of '%v': %v", testFile, err)
+			continue
+		}
+		files = append(files, file)
+	}
+	return fileSet, doc.Examples(files...)
+}
+
+// Shares code on the Go Playground, returning the link to the snippet.
+func shareOnPlayground(ctx context.Context, code string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(
+		ctx, http.MethodPost, playgroundShareURL, strings.NewReader(code),
+	)
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Content-Type", "text/plain; charset=utf-8")
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return "", err
+	}
+	if response.StatusCode != http.StatusOK {
+		return "", &httpStatusError{Status: response.Status}
+	}
+	return playgroundURL + strings.TrimSpace(string(body)), nil
+}
+
+// httpStatusError is returned for unexpected responses of remote services.
+type httpStatusError struct {
+	Status string
+}
+
+func (err *httpStatusError) Error() string {
+	return "unexpected response: " + err.Status
+}
+
+// Writes every Example function of the module's test files to a page of its own,
+// with its expected output, a copy button and, with --playground-links, a link
+// running it in the Go Playground. An index lists all examples.
+func generateExamplePages(ctx context.Context, runInfo *RunInfo) {
+	settings := runInfo.Settings
+	if !settings.ExamplePages {
+		return
+	}
+
+	command := exec.Command("go", "list", "-f", "{{.ImportPath}}\t{{.Dir}}", "./...")
+	command.Dir = settings.ModuleRootPath
+	output, err := command.Output()
+	if err != nil {
+		log.Panicf("error listing packages: %v", err)
+	}
+
+	pages := packagePages(runInfo)
+	exampleTemplate := pageTemplate(runInfo, examplePageTemplate)
+
+	writeNavStylesheet(settings)
+	writeNavScript(settings)
+	indexRelPath := examplePagesDir + "/index.html"
+	stylesheet := selectBackend(settings).SentinelAsset()
+	indexPackages := make([]*exampleIndexPackage, 0)
+
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		parts := strings.SplitN(line, "\t", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		importPath, dir := parts[0], parts[1]
+		if settings.ignored(importPath) {
+			continue
+		}
+
+		fileSet, examples := packageExamples(dir)
+		if len(examples) == 0 {
+			continue
+		}
+
+		relDir := strings.TrimPrefix(strings.TrimPrefix(importPath, settings.ModName), "/")
+		pageDir := path.Join(examplePagesDir, relDir)
+		indexPackage := &exampleIndexPackage{ImportPath: importPath}
+
+		for _, example := range examples {
+			if ctx.Err() != nil {
+				log.Panicf("error generating example pages: %v", ctx.Err())
+			}
+
+			name := "Example" + example.Name
+			symbol, _ := splitExampleName(example.Name)
+			code, runnable := exampleSource(fileSet, example)
+			page := &examplePage{
+				ImportPath:    importPath,
+				Name:          name,
+				Symbol:        symbol,
+				Title:         exampleTitle(example.Name),
+				Doc:           example.Doc,
+				Code:          code,
+				Output:        example.Output,
+				Unordered:     example.Unordered,
+				Runnable:      runnable,
+				RelPath:       path.Join(pageDir, name+".html"),
+				Stylesheet:    relativeLink(pageDir, stylesheet),
+				NavStylesheet: relativeLink(pageDir, navStylesheetName),
+				NavScript:     relativeLink(pageDir, navScriptName),
+				IndexLink:     relativeLink(pageDir, indexRelPath),
+			}
+			if packagePage, ok := pages[importPath]; ok {
+				page.PackageLink = relativeLink(pageDir, packagePage)
+			}
+			if settings.PlaygroundLinks && runnable {
+				link, err := shareOnPlayground(ctx, code)
+				if err != nil {
+					log.Printf("error sharing %v on the playground: %v", name, err)
+				}
+				page.PlaygroundLink = link
+			}
+
+			buffer := new(bytes.Buffer)
+			if err := exampleTemplate.Execute(buffer, page); err != nil {
+				log.Panicf("error rendering example page: %v", err)
+			}
+			pagePath := filepath.Join(settings.BuildDir, filepath.FromSlash(page.RelPath))
+			if err := os.MkdirAll(filepath.Dir(pagePath), os.ModePerm); err != nil {
+				log.Panicf("error creating example directory: %v", err)
+			}
+			if err := ioutil.WriteFile(pagePath, buffer.Bytes(), os.ModePerm); err != nil {
+				log.Panicf("error writing example page: %v", err)
+			}
+			runInfo.HtmlFiles = append(runInfo.HtmlFiles, pagePath)
+
+			indexPackage.Examples = append(indexPackage.Examples, &exampleIndexEntry{
+				Symbol: symbol,
+				Title:  page.Title,
+				Link:   relativeLink(examplePagesDir, page.RelPath),
+			})
+		}
+		indexPackages = append(indexPackages, indexPackage)
+	}
+
+	buffer := new(bytes.Buffer)
+	err = pageTemplate(runInfo, exampleIndexTemplate).Execute(buffer, map[string]interface{}{
+		"Module":     settings.ModName,
+		"Stylesheet": relativeLink(examplePagesDir, stylesheet),
+		"Packages":   indexPackages,
+	})
+	if err != nil {
+		log.Panicf("error rendering examples index: %v", err)
+	}
+	indexPath := filepath.Join(settings.BuildDir, examplePagesDir, "index.html")
+	if err := os.MkdirAll(filepath.Dir(indexPath), os.ModePerm); err != nil {
+		log.Panicf("error creating example directory: %v", err)
+	}
+	if err := ioutil.WriteFile(indexPath, buffer.Bytes(), os.ModePerm); err != nil {
+		log.Panicf("error writing examples index: %v", err)
+	}
+	runInfo.HtmlFiles = append(runInfo.HtmlFiles, indexPath)
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"html"
+	"log"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Orders examples can be listed in.
+const (
+	// Alphabetically, as godoc lists them.
+	exampleOrderAlpha = "alpha"
+	// In the order the example functions are declared in the package's test files.
+	exampleOrderDeclared = "declared"
+)
+
+// Regex for the start of an example godoc rendered, capturing the example's name.
+var exampleStartRegex = regexp.MustCompile(`<div id="example_([^"]*)" class="toggle">`)
+
+// Regex for the title of an example.
+var exampleTitleRegex = regexp.MustCompile(`(<span class="text">)Example[^<]*(</span>)`)
+
+var divTagRegex = regexp.MustCompile(`<div[\s>]|</div>`)
+
+// An example rendered on a package page.
+type renderedExample struct {
+	Name  string
+	Start int
+	End   int
+}
+
+// Returns the index right after the </div> closing the div opened at start.
+func divEnd(data []byte, start int) int {
+	depth := 0
+	for _, location := range divTagRegex.FindAllIndex(data[start:], -1) {
+		if data[start+location[0]+1] == '/' {
+			depth--
+		} else {
+			depth++
+		}
+		if depth == 0 {
+			return start + location[1]
+		}
+	}
+	return len(data)
+}
+
+// Splits the name of an example, without its Example prefix, into the symbol it
+// documents and its suffix. The suffix starts at the first underscore followed by a
+// lower case letter, so ExampleFoo_bar_baz documents Foo with suffix bar_baz.
+func splitExampleName(name string) (string, string) {
+	for i := 0; i < len(name)-1; i++ {
+		if name[i] != '_' {
+			continue
+		}
+		next, _ := utf8.DecodeRuneInString(name[i+1:])
+		if unicode.IsLower(next) {
+			return name[:i], name[i+1:]
+		}
+	}
+	return name, ""
+}
+
+// Returns the human friendly title of an example, e.g. "Example (Bar baz)".
+func exampleTitle(name string) string {
+	_, suffix := splitExampleName(name)
+	if suffix == "" {
+		return "Example"
+	}
+	suffix = strings.Replace(suffix, "_", " ", -1)
+	first, size := utf8.DecodeRuneInString(suffix)
+	return "Example (" + string(unicode.ToUpper(first)) + suffix[size:] + ")"
+}
+
+// Returns example name -> position for the example functions declared in the
+// package in dir, ordered by file name and then by position in the file.
+func declaredExampleOrder(dir string) map[string]int {
+	files, err := filepath.Glob(filepath.Join(dir, "*_test.go"))
+	if err != nil {
+		log.Panicf("error listing test files: %v", err)
+	}
+	sort.Strings(files)
+
+	order := make(map[string]int)
+	fileSet := token.NewFileSet()
+	for _, file := range files {
+		parsed, err := parser.ParseFile(fileSet, file, nil, 0)
+		if err != nil {
+			log.Printf("skipping examples of '%v': %v", file, err)
+			continue
+		}
+		for _, decl := range parsed.Decls {
+			function, ok := decl.(*ast.FuncDecl)
+			if !ok || function.Recv != nil || !strings.HasPrefix(function.Name.Name, "Example") {
+				continue
+			}
+			name := strings.TrimPrefix(function.Name.Name, "Example")
+			if name == "" {
+				name = "package"
+			}
+			order[name] = len(order)
+		}
+	}
+	return order
+}
+
+// Returns the examples rendered on a page, grouped into runs of adjacent examples.
+// godoc renders all examples of a symbol next to each other.
+func exampleGroups(data []byte) [][]*renderedExample {
+	groups := make([][]*renderedExample, 0)
+	var group []*renderedExample
+
+	for _, match := range exampleStartRegex.FindAllSubmatchIndex(data, -1) {
+		example := &renderedExample{
+			Name:  string(data[match[2]:match[3]]),
+			Start: match[0],
+		}
+		if group != nil && example.Start < group[len(group)-1].End {
+			// Nested in the previous example, which cannot happen with godoc's
+			// templates.
+			continue
+		}
+		example.End = divEnd(data, example.Start)
+
+		if group != nil && len(bytes.TrimSpace(data[group[len(group)-1].End:example.Start])) == 0 {
+			group = append(group, example)
+			continue
+		}
+		if group != nil {
+			groups = append(groups, group)
+		}
+		group = []*renderedExample{example}
+	}
+	if group != nil {
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// Renders a group of examples, reordered and wrapped in tabs as configured.
+func renderExampleGroup(
+	data []byte, group []*renderedExample, settings *Settings, order map[string]int,
+) []byte {
+	if settings.ExampleOrder == exampleOrderDeclared {
+		sort.SliceStable(group, func(i, j int) bool {
+			iOrder, iOK := order[group[i].Name]
+			jOrder, jOK := order[group[j].Name]
+			if iOK != jOK {
+				return iOK
+			}
+			return iOrder < jOrder
+		})
+	}
+
+	rendered := new(bytes.Buffer)
+	tabbed := settings.ExampleTabs && len(group) > 1
+	if tabbed {
+		rendered.WriteString(`<div class="docmodule-example-tabs"><div class="docmodule-tab-buttons">`)
+		for _, example := range group {
+			rendered.WriteString(`<button type="button">` +
+				html.EscapeString(exampleTitle(example.Name)) + "</button>")
+		}
+		rendered.WriteString("</div>\n")
+	}
+	for _, example := range group {
+		if tabbed {
+			rendered.WriteString(`<div class="docmodule-tab-panel">`)
+		}
+		title := []byte("${1}" + html.EscapeString(exampleTitle(example.Name)) + "${2}")
+		rendered.Write(exampleTitleRegex.ReplaceAll(data[example.Start:example.End], title))
+		if tabbed {
+			rendered.WriteString("</div>")
+		}
+		rendered.WriteString("\n")
+	}
+	if tabbed {
+		rendered.WriteString("</div>")
+	}
+	return rendered.Bytes()
+}
+
+// Returns the processor giving the examples on godoc's package pages human friendly
+// titles, and optionally listing them in declaration order and grouping the examples
+// of each symbol into tabs.
+func newExamplesProcessor(ctx context.Context, runInfo *RunInfo) Processor {
+	settings := runInfo.Settings
+	if settings.Backend != "godoc" {
+		return nil
+	}
+	if settings.ExampleTabs {
+		writeNavStylesheet(settings)
+		writeNavScript(settings)
+	}
+
+	return ProcessorFunc(func(page *Page) error {
+		data := page.Data
+		groups := exampleGroups(data)
+		if len(groups) == 0 {
+			return nil
+		}
+
+		var order map[string]int
+		if settings.ExampleOrder == exampleOrderDeclared {
+			relPath := strings.TrimPrefix(page.ImportPath(), settings.ModName)
+			order = declaredExampleOrder(
+				filepath.Join(settings.ModuleRootPath, filepath.FromSlash(relPath)),
+			)
+		}
+
+		rewritten := new(bytes.Buffer)
+		last := 0
+		for _, group := range groups {
+			rewritten.Write(data[last:group[0].Start])
+			rewritten.Write(renderExampleGroup(data, group, settings, order))
+			last = group[len(group)-1].End
+		}
+		rewritten.Write(data[last:])
+		data = rewritten.Bytes()
+
+		if settings.ExampleTabs {
+			fromDir := path.Dir(page.RelPath)
+			data = linkNavStylesheet(data, fromDir)
+			data = linkNavScript(data, fromDir)
+		}
+		page.Data = data
+		return nil
+	})
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"path"
+)
+
+const expandControls = `<div class="docmodule-expand-controls">` +
+	`<button type="button" data-expand="all">Expand all</button>` +
+	`<button type="button" data-expand="none">Collapse all</button></div>`
+
+// Returns the processor adding controls expanding or collapsing every example and
+// collapsible section of a package page. The choice is remembered in local storage
+// and applied on every page.
+func newExpandControlsProcessor(ctx context.Context, runInfo *RunInfo) Processor {
+	settings := runInfo.Settings
+	if !settings.ExpandControls {
+		return nil
+	}
+
+	writeNavStylesheet(settings)
+	writeNavScript(settings)
+
+	return ProcessorFunc(func(page *Page) error {
+		fromDir := path.Dir(page.RelPath)
+		data := injectBefore(page.Data, firstHeadingRegex, []byte(expandControls))
+		data = linkNavStylesheet(data, fromDir)
+		page.Data = linkNavScript(data, fromDir)
+		return nil
+	})
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"golang.org/x/xerrors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// HAR archive as written by browsers' dev tools, reduced to what we replay.
+type harArchive struct {
+	Log struct {
+		Entries []struct {
+			Request struct {
+				URL string `json:"url"`
+			} `json:"request"`
+			Response struct {
+				Status  int `json:"status"`
+				Content struct {
+					MimeType string `json:"mimeType"`
+					Text     string `json:"text"`
+					Encoding string `json:"encoding"`
+				} `json:"content"`
+			} `json:"response"`
+		} `json:"entries"`
+	} `json:"log"`
+}
+
+// A response replayed from a fixture.
+type fixtureResponse struct {
+	Status      int
+	ContentType string
+	Body        []byte
+}
+
+// HARHandler replays the responses of a HAR archive, matching requests by path and
+// query. Later entries for the same url win.
+type HARHandler struct {
+	responses map[string]*fixtureResponse
+}
+
+func NewHARHandler(harPath string) (*HARHandler, error) {
+	data, err := ioutil.ReadFile(harPath)
+	if err != nil {
+		return nil, xerrors.Errorf("error reading HAR fixture: %w", err)
+	}
+	archive := new(harArchive)
+	if err := json.Unmarshal(data, archive); err != nil {
+		return nil, xerrors.Errorf("error parsing HAR fixture: %w", err)
+	}
+
+	handler := &HARHandler{responses: make(map[string]*fixtureResponse)}
+	for _, entry := range archive.Log.Entries {
+		entryURL, err := url.Parse(entry.Request.URL)
+		if err != nil {
+			return nil, xerrors.Errorf("error parsing HAR entry url: %w", err)
+		}
+
+		body := []byte(entry.Response.Content.Text)
+		if entry.Response.Content.Encoding == "base64" {
+			body, err = base64.StdEncoding.DecodeString(entry.Response.Content.Text)
+			if err != nil {
+				return nil, xerrors.Errorf("error decoding HAR entry body: %w", err)
+			}
+		}
+
+		handler.responses[entryURL.RequestURI()] = &fixtureResponse{
+			Status:      entry.Response.Status,
+			ContentType: entry.Response.Content.MimeType,
+			Body:        body,
+		}
+	}
+	return handler, nil
+}
+
+func (handler *HARHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	response, ok := handler.responses[request.URL.RequestURI()]
+	if !ok {
+		http.NotFound(writer, request)
+		return
+	}
+	if response.ContentType != "" {
+		writer.Header().Set("Content-Type", response.ContentType)
+	}
+	writer.WriteHeader(response.Status)
+	_, _ = writer.Write(response.Body)
+}
+
+// Returns a handler replaying the fixtures at fixturePath: either a HAR archive or a
+// directory of saved responses laid out by url path, with index.html standing in
+// for directory urls like /pkg/.
+func newFixtureHandler(fixturePath string) (http.Handler, error) {
+	info, err := os.Stat(fixturePath)
+	if err != nil {
+		return nil, xerrors.Errorf("error reading fixtures: %w", err)
+	}
+	if info.IsDir() {
+		return http.FileServer(http.Dir(fixturePath)), nil
+	}
+	return NewHARHandler(fixturePath)
+}
+
+// Scrapes the recorded fixtures instead of a live doc server, so the rename and
+// rewrite stages run against deterministic input.
+func scrapeFixtures(ctx context.Context, settings *Settings) []*BuildProblem {
+	handler, err := newFixtureHandler(settings.Fixtures)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	log.Println("serving fixtures", settings.Fixtures, "at", settings.ServerHost+".")
+	fixtureCtx, stopFixtures := context.WithCancel(ctx)
+	defer stopFixtures()
+	serveLocal(fixtureCtx, settings.ServerHost, handler, "fixture server")
+
+	return scrapeModulePages(ctx, settings)
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"golang.org/x/xerrors"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// Output formats, as passed to --formats.
+const (
+	// The site scraped from the doc server.
+	formatHTML     = "html"
+	formatMarkdown = "markdown"
+	formatJSON     = "json"
+	formatPDF      = "pdf"
+	formatSQLite   = "sqlite"
+)
+
+// Directory of the build directory markdown output is written to.
+const markdownDir = "markdown"
+
+// ModelFormat is an output format rendered from the doc model rather than scraped.
+type ModelFormat interface {
+	// Name of the format, as passed to --formats.
+	Name() string
+	// Writes the format into settings.BuildDir.
+	Generate(ctx context.Context, settings *Settings, model *DocModel) error
+}
+
+// Formats rendered from the doc model by name.
+var modelFormats = map[string]ModelFormat{
+	formatDocSearch:     new(DocSearchFormat),
+	formatDocusaurus:    new(DocusaurusFormat),
+	formatElasticsearch: new(ElasticsearchFormat),
+	formatEPUB:          new(EPUBFormat),
+	formatHugo:          new(HugoFormat),
+	formatJekyll:        new(JekyllFormat),
+	formatMan:           new(ManFormat),
+	formatJSON:          new(JSONFormat),
+	formatMarkdown:      new(MarkdownFormat),
+	formatPDF:           new(PDFFormat),
+	formatSQLite:        new(SQLiteFormat),
+}
+
+// Returns the names of all formats.
+func formatNames() []string {
+	names := []string{formatHTML}
+	for name := range modelFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Returns whether format was requested.
+func (settings *Settings) hasFormat(format string) bool {
+	for _, requested := range settings.Formats {
+		if requested == format {
+			return true
+		}
+	}
+	return false
+}
+
+// JSONFormat writes the doc model itself.
+type JSONFormat struct{}
+
+func (format *JSONFormat) Name() string {
+	return formatJSON
+}
+
+func (format *JSONFormat) Generate(
+	ctx context.Context, settings *Settings, model *DocModel,
+) error {
+	return writeDocModel(model, settings.BuildDir+"/"+docModelName)
+}
+
+var markdownTemplate = template.Must(template.New("markdown").Parse(
+	`{{.Heading}} package {{.Package.Name}}
+
+` + "```go\nimport \"{{.Package.ImportPath}}\"\n```" + `
+{{if .Package.Doc}}
+{{.Package.Doc}}{{end}}
+{{range .Package.Symbols}}
+{{$.Heading}}# {{.Kind}} {{.Name}}
+
+` + "```go\n{{.Signature}}\n```" + `
+{{if .Doc}}
+{{.Doc}}{{end}}{{end}}`))
+
+// Renders a package as markdown, with its title at heading level.
+func renderPackageMarkdown(pkg *ModelPackage, level int) ([]byte, error) {
+	buffer := new(bytes.Buffer)
+	err := markdownTemplate.Execute(buffer, map[string]interface{}{
+		"Heading": strings.Repeat("#", level),
+		"Package": pkg,
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("error rendering markdown: %w", err)
+	}
+	return buffer.Bytes(), nil
+}
+
+// MarkdownFormat writes a markdown file per package, laid out like the module.
+type MarkdownFormat struct{}
+
+func (format *MarkdownFormat) Name() string {
+	return formatMarkdown
+}
+
+func (format *MarkdownFormat) Generate(
+	ctx context.Context, settings *Settings, model *DocModel,
+) error {
+	for _, pkg := range model.Packages {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		data, err := renderPackageMarkdown(pkg, 1)
+		if err != nil {
+			return err
+		}
+
+		relDir := strings.TrimPrefix(strings.TrimPrefix(pkg.ImportPath, model.Module), "/")
+		filePath := filepath.Join(
+			settings.BuildDir, markdownDir, filepath.FromSlash(path.Join(relDir, "index.md")),
+		)
+		if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
+			return xerrors.Errorf("error creating markdown directory: %w", err)
+		}
+		if err := ioutil.WriteFile(filePath, data, os.ModePerm); err != nil {
+			return xerrors.Errorf("error writing markdown: %w", err)
+		}
+	}
+	return nil
+}
+
+// PDFFormat renders the whole module into a single PDF with pandoc, which must be
+// on PATH.
+type PDFFormat struct{}
+
+func (format *PDFFormat) Name() string {
+	return formatPDF
+}
+
+func (format *PDFFormat) Generate(
+	ctx context.Context, settings *Settings, model *DocModel,
+) error {
+	pandoc, err := exec.LookPath("pandoc")
+	if err != nil {
+		return xerrors.New("pdf output needs pandoc on PATH")
+	}
+
+	document := new(bytes.Buffer)
+	document.WriteString("---\ntitle: " + model.Module + "\n")
+	if model.Version != "" {
+		document.WriteString("subtitle: " + model.Version + "\n")
+	}
+	document.WriteString("---\n\n")
+	for _, pkg := range model.Packages {
+		data, err := renderPackageMarkdown(pkg, 1)
+		if err != nil {
+			return err
+		}
+		document.Write(data)
+		document.WriteString("\n")
+	}
+
+	source, err := ioutil.TempFile("", "docmodule-*.md")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(source.Name())
+	if _, err := source.Write(document.Bytes()); err != nil {
+		source.Close()
+		return err
+	}
+	if err := source.Close(); err != nil {
+		return err
+	}
+
+	destPath := filepath.Join(settings.BuildDir, path.Base(model.Module)+".pdf")
+	command := exec.CommandContext(ctx, pandoc, "--toc", "-o", destPath, source.Name())
+	if output, err := command.CombinedOutput(); err != nil {
+		return xerrors.Errorf("error running pandoc: %w, output: %v", err, string(output))
+	}
+	return nil
+}
+
+// Extracts the doc model once and renders every requested model format from it
+// concurrently.
+func generateModelFormats(ctx context.Context, runInfo *RunInfo) {
+	settings := runInfo.Settings
+
+	formats := make([]ModelFormat, 0)
+	for _, name := range settings.Formats {
+		if format, ok := modelFormats[name]; ok {
+			formats = append(formats, format)
+		}
+	}
+	if len(formats) == 0 {
+		return
+	}
+
+	model, err := loadBuildDocModel(settings)
+	if err != nil {
+		log.Panicf("error extracting doc model: %v", err)
+	}
+	model.Version = detectDocVersion(settings)
+
+	errs := make([]error, len(formats))
+	var group sync.WaitGroup
+	for i, format := range formats {
+		group.Add(1)
+		go func(i int, format ModelFormat) {
+			defer group.Done()
+			log.Println("generating", format.Name(), "output.")
+			if err := format.Generate(ctx, settings, model); err != nil {
+				errs[i] = xerrors.Errorf("error generating %v output: %w", format.Name(), err)
+			}
+		}(i, format)
+	}
+	group.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"golang.org/x/xerrors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Returns the import path of dir derived from its place in the src directory of one
+// of the entries of gopath, or an empty string if it is in none of them.
+func gopathImportPath(gopath string, dir string) string {
+	for _, entry := range filepath.SplitList(gopath) {
+		if entry == "" {
+			continue
+		}
+		relDir, err := filepath.Rel(filepath.Join(entry, "src"), dir)
+		if err != nil || relDir == "." || strings.HasPrefix(relDir, "..") {
+			continue
+		}
+		return filepath.ToSlash(relDir)
+	}
+	return ""
+}
+
+// Sets up the build of the project in dir, which has no go.mod: a package tree in
+// GOPATH/src, whose import path is derived from its directory, or a plain directory
+// of Go files named by moduleName, which also overrides the derived import path.
+// buildDir is left out of the project.
+//
+// The project is copied to <temporary GOPATH>/src/<import path>, put in front of the
+// GOPATH, and documented from there in GOPATH mode. The copy gets a go.mod naming the
+// import path, which GOPATH mode ignores, for the parts of the build reading the
+// module's name from it.
+func setupGopathProject(settings *Settings, dir string, moduleName string, buildDir string) {
+	if moduleName == "" {
+		moduleName = gopathImportPath(settings.GoPath, dir)
+	}
+	if moduleName == "" {
+		log.Fatal(
+			"go.mod not found, run docmodule from within a Go module or a GOPATH/src " +
+				"package tree, or name the import path of the directory with --module-name",
+		)
+	}
+
+	gopath, err := ioutil.TempDir("", "docmodule-gopath-")
+	if err != nil {
+		log.Fatal(xerrors.Errorf("error creating GOPATH: %w", err))
+	}
+	settings.TempGoPath = gopath
+	root := filepath.Join(gopath, "src", filepath.FromSlash(moduleName))
+	buildDir, err = filepath.Abs(buildDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := copyGopathProject(dir, root, buildDir); err != nil {
+		log.Fatal(xerrors.Errorf("error copying project into GOPATH: %w", err))
+	}
+	settings.GoModPath = filepath.Join(root, "go.mod")
+	goMod := []byte("module " + moduleName + "\n")
+	if err := ioutil.WriteFile(settings.GoModPath, goMod, os.ModePerm); err != nil {
+		log.Fatal(xerrors.Errorf("error writing go.mod: %w", err))
+	}
+
+	settings.ModName = moduleName
+	settings.ModuleRootPath = root
+	settings.GoPath = strings.Join([]string{gopath, settings.GoPath}, string(os.PathListSeparator))
+	// The go commands and the doc server run by the build inherit GOPATH mode.
+	for name, value := range map[string]string{"GO111MODULE": "off", "GOPATH": settings.GoPath} {
+		if err := os.Setenv(name, value); err != nil {
+			log.Fatal(err)
+		}
+	}
+	log.Printf("no go.mod found, documenting %v in GOPATH mode.", moduleName)
+}
+
+// Copies the files of the project in dir to root, leaving out hidden directories
+// and the build directory.
+func copyGopathProject(dir string, root string, buildDir string) error {
+	return filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(root, relPath)
+		if info.IsDir() {
+			if filePath != dir && (strings.HasPrefix(info.Name(), ".") || filePath == buildDir) {
+				return filepath.SkipDir
+			}
+			return os.MkdirAll(target, os.ModePerm)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		return copyFile(filePath, target)
+	})
+}
+
+// Removes the temporary GOPATH of a project without go.mod.
+func removeTempGopath(settings *Settings) {
+	if settings.TempGoPath == "" {
+		return
+	}
+	if err := os.RemoveAll(settings.TempGoPath); err != nil {
+		log.Printf("error removing temporary GOPATH: %v", err)
+	}
+}
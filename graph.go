@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Name of the page showing the import graph of the module's packages.
+const graphPageName = "graph.html"
+
+// Dimensions of the rendered graph, in pixels.
+const (
+	graphNodeHeight  = 28
+	graphCharWidth   = 7
+	graphNodePadding = 16
+	graphColumnGap   = 24
+	graphRowGap      = 64
+	graphMargin      = 16
+)
+
+// A package in the import graph.
+type graphNode struct {
+	ImportPath string
+	Label      string
+	// Link to the package's page, relative to the graph page. Empty for packages
+	// outside the module.
+	Link     string
+	External bool
+	Imports  []string
+	// Position and size of the node's box.
+	X     int
+	Y     int
+	Width int
+}
+
+// An import drawn in the graph, from the bottom of the importer to the top of the
+// imported package.
+type graphEdge struct {
+	From string
+	To   string
+	X1   int
+	Y1   int
+	X2   int
+	Y2   int
+}
+
+var graphTemplate = template.Must(template.New("graph").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta http-equiv="Content-Type" content="text/html; charset=utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Module}} - Package graph</title>
+<link type="text/css" rel="stylesheet" href="{{.Stylesheet}}">
+<style>
+.docmodule-graph { overflow-x: auto; }
+.docmodule-graph rect { fill: #f8f8f8; stroke: #375eab; }
+.docmodule-graph .external rect { stroke: #999; stroke-dasharray: 4 2; }
+.docmodule-graph text { font: 12px monospace; fill: #222; }
+.docmodule-graph line { stroke: #bbb; }
+.docmodule-graph line.active { stroke: #375eab; stroke-width: 2; }
+.docmodule-graph g.active rect { fill: #e9f0fb; stroke-width: 2; }
+</style>
+</head>
+<body>
+<div id="page" class="wide">
+<div class="container">
+<h1>Package graph of {{.Module}}</h1>
+<p>Packages import the packages below them. Hover a package to highlight its imports and importers.</p>
+<div class="docmodule-graph">
+<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="{{.Height}}">
+<defs><marker id="arrow" viewBox="0 0 10 10" refX="10" refY="5" markerWidth="6" markerHeight="6" orient="auto"><path d="M0,0 L10,5 L0,10 z" fill="#999"/></marker></defs>
+{{range .Edges}}<line x1="{{.X1}}" y1="{{.Y1}}" x2="{{.X2}}" y2="{{.Y2}}" data-from="{{.From}}" data-to="{{.To}}" marker-end="url(#arrow)"/>
+{{end}}{{range .Nodes}}<g data-package="{{.ImportPath}}"{{if .External}} class="external"{{end}}>{{if .Link}}<a href="{{.Link}}">{{end}}<title>{{.ImportPath}}</title><rect x="{{.X}}" y="{{.Y}}" width="{{.Width}}" height="{{$.NodeHeight}}" rx="4"/><text x="{{.X}}" y="{{.Y}}" dx="8" dy="18">{{.Label}}</text>{{if .Link}}</a>{{end}}</g>
+{{end}}</svg>
+</div>
+</div>
+</div>
+<script>
+document.querySelectorAll(".docmodule-graph g").forEach(function (node) {
+  var name = node.getAttribute("data-package");
+  function highlight(active) {
+    node.classList.toggle("active", active);
+    document.querySelectorAll(".docmodule-graph line").forEach(function (edge) {
+      if (edge.getAttribute("data-from") === name || edge.getAttribute("data-to") === name) {
+        edge.classList.toggle("active", active);
+      }
+    });
+  }
+  node.addEventListener("mouseenter", function () { highlight(true); });
+  node.addEventListener("mouseleave", function () { highlight(false); });
+});
+</script>
+</body>
+</html>
+`))
+
+// Reports whether importPath belongs to the standard library, whose first path
+// element has no dot.
+func isStandardPackage(importPath string) bool {
+	return !strings.Contains(strings.SplitN(importPath, "/", 2)[0], ".")
+}
+
+// Returns the packages of the module with their imports via `go list`, including
+// the imported packages outside the module except the standard library when
+// external is set.
+func importGraph(settings *Settings, external bool) map[string]*graphNode {
+	command := exec.Command("go", "list", "-f", `{{.ImportPath}}	{{join .Imports " "}}`, "./...")
+	command.Dir = settings.ModuleRootPath
+	output, err := command.Output()
+	if err != nil {
+		log.Panicf("error listing packages: %v", err)
+	}
+
+	nodes := make(map[string]*graphNode)
+	imports := make(map[string][]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		parts := strings.SplitN(line, "\t", 2)
+		if len(parts) != 2 || settings.ignored(parts[0]) {
+			continue
+		}
+		label := strings.TrimPrefix(strings.TrimPrefix(parts[0], settings.ModName), "/")
+		if label == "" {
+			label = path.Base(settings.ModName)
+		}
+		nodes[parts[0]] = &graphNode{ImportPath: parts[0], Label: label}
+		imports[parts[0]] = strings.Fields(parts[1])
+	}
+
+	for importPath, node := range nodes {
+		for _, imported := range imports[importPath] {
+			if _, ok := nodes[imported]; !ok {
+				if !external || isStandardPackage(imported) {
+					continue
+				}
+				nodes[imported] = &graphNode{ImportPath: imported, Label: imported, External: true}
+			}
+			node.Imports = append(node.Imports, imported)
+		}
+		sort.Strings(node.Imports)
+	}
+	return nodes
+}
+
+// Lays the graph out in rows, with every package above the packages it imports.
+// Returns the edges and the size of the drawing.
+func layoutGraph(nodes map[string]*graphNode) ([]*graphEdge, int, int) {
+	levels := make(map[string]int)
+	var level func(importPath string) int
+	level = func(importPath string) int {
+		if depth, ok := levels[importPath]; ok {
+			return depth
+		}
+		depth := 0
+		for _, imported := range nodes[importPath].Imports {
+			if importedDepth := level(imported) + 1; importedDepth > depth {
+				depth = importedDepth
+			}
+		}
+		levels[importPath] = depth
+		return depth
+	}
+
+	maxLevel := 0
+	for importPath := range nodes {
+		if depth := level(importPath); depth > maxLevel {
+			maxLevel = depth
+		}
+	}
+
+	rows := make([][]*graphNode, maxLevel+1)
+	for importPath, node := range nodes {
+		row := maxLevel - levels[importPath]
+		rows[row] = append(rows[row], node)
+	}
+
+	rowWidths := make([]int, len(rows))
+	width := 0
+	for i, row := range rows {
+		sort.Slice(row, func(a, b int) bool {
+			return row[a].ImportPath < row[b].ImportPath
+		})
+		for j, node := range row {
+			node.Width = len(node.Label)*graphCharWidth + graphNodePadding
+			if j > 0 {
+				rowWidths[i] += graphColumnGap
+			}
+			rowWidths[i] += node.Width
+		}
+		if rowWidths[i] > width {
+			width = rowWidths[i]
+		}
+	}
+
+	for i, row := range rows {
+		x := graphMargin + (width-rowWidths[i])/2
+		for _, node := range row {
+			node.X = x
+			node.Y = graphMargin + i*(graphNodeHeight+graphRowGap)
+			x += node.Width + graphColumnGap
+		}
+	}
+
+	edges := make([]*graphEdge, 0)
+	for _, row := range rows {
+		for _, node := range row {
+			for _, imported := range node.Imports {
+				target := nodes[imported]
+				edges = append(edges, &graphEdge{
+					From: node.ImportPath,
+					To:   imported,
+					X1:   node.X + node.Width/2,
+					Y1:   node.Y + graphNodeHeight,
+					X2:   target.X + target.Width/2,
+					Y2:   target.Y,
+				})
+			}
+		}
+	}
+
+	height := len(rows)*(graphNodeHeight+graphRowGap) - graphRowGap + 2*graphMargin
+	return edges, width + 2*graphMargin, height
+}
+
+// Writes a page drawing the import graph of the module's packages, each linked to
+// its page.
+func generateGraphPage(runInfo *RunInfo) {
+	settings := runInfo.Settings
+	if !settings.Graph {
+		return
+	}
+
+	nodes := importGraph(settings, settings.GraphExternal)
+	pages := packagePages(runInfo)
+	for importPath, node := range nodes {
+		node.Link = pages[importPath]
+	}
+	edges, width, height := layoutGraph(nodes)
+
+	sortedNodes := make([]*graphNode, 0, len(nodes))
+	for _, node := range nodes {
+		sortedNodes = append(sortedNodes, node)
+	}
+	sort.Slice(sortedNodes, func(i, j int) bool {
+		return sortedNodes[i].ImportPath < sortedNodes[j].ImportPath
+	})
+
+	buffer := new(bytes.Buffer)
+	err := graphTemplate.Execute(buffer, map[string]interface{}{
+		"Module":     settings.ModName,
+		"Stylesheet": selectBackend(settings).SentinelAsset(),
+		"Nodes":      sortedNodes,
+		"Edges":      edges,
+		"Width":      width,
+		"Height":     height,
+		"NodeHeight": graphNodeHeight,
+	})
+	if err != nil {
+		log.Panicf("error rendering package graph: %v", err)
+	}
+
+	graphPath := settings.BuildDir + "/" + graphPageName
+	if err := ioutil.WriteFile(graphPath, buffer.Bytes(), os.ModePerm); err != nil {
+		log.Panicf("error writing package graph: %v", err)
+	}
+	runInfo.HtmlFiles = append(runInfo.HtmlFiles, graphPath)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"golang.org/x/xerrors"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Name of the archive index of the published versions, written to the root of the
+// build directory by --version-archive.
+const versionArchiveName = "versions.html"
+
+// PublishedVersion is a version of the docs published to a target, as recorded in
+// the history of its manifest.
+type PublishedVersion struct {
+	Version     string    `json:"version"`
+	PublishedAt time.Time `json:"publishedAt"`
+	// Url the version's docs were published for, from its --base-url.
+	Location string `json:"location,omitempty"`
+	// Symbols added, removed and changed since the previous version, by
+	// importPath.Name. Empty for the first version.
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+	Changed []string `json:"changed,omitempty"`
+}
+
+// Returns the history of the remote manifest with the version being published
+// appended, and the signatures of the version's symbols by key. Publishing the last
+// version again only updates its date and location.
+func recordPublishedVersion(
+	settings *Settings, remote *SiteManifest,
+) ([]*PublishedVersion, map[string]string, error) {
+	model, err := loadBuildDocModel(settings)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("error loading doc model: %w", err)
+	}
+	api := make(map[string]string)
+	for key, symbol := range model.symbols() {
+		api[key] = symbol.Signature
+	}
+
+	version := &PublishedVersion{
+		Version:     detectDocVersion(settings),
+		PublishedAt: time.Now().UTC(),
+		Location:    settings.BaseURL,
+	}
+	history := append([]*PublishedVersion{}, remote.History...)
+	if last := len(history) - 1; last >= 0 && history[last].Version == version.Version {
+		history[last].PublishedAt = version.PublishedAt
+		history[last].Location = version.Location
+		return history, api, nil
+	}
+
+	if len(history) > 0 {
+		for key, signature := range api {
+			oldSignature, ok := remote.API[key]
+			if !ok {
+				version.Added = append(version.Added, key)
+			} else if oldSignature != signature {
+				version.Changed = append(version.Changed, key)
+			}
+		}
+		for key := range remote.API {
+			if _, ok := api[key]; !ok {
+				version.Removed = append(version.Removed, key)
+			}
+		}
+		sort.Strings(version.Added)
+		sort.Strings(version.Removed)
+		sort.Strings(version.Changed)
+	}
+	return append(history, version), api, nil
+}
+
+var versionArchiveTemplate = template.Must(template.New("version-archive").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta http-equiv="Content-Type" content="text/html; charset=utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Module}} - Published versions</title>
+</head>
+<body>
+<h1>Published versions of {{.Module}}</h1>
+<table>
+<tr><th>Version</th><th>Published</th><th>API changes</th></tr>
+{{range .Versions}}<tr>
+<td>{{if .Location}}<a href="{{.Location}}">{{.Version}}</a>{{else}}{{.Version}}{{end}}</td>
+<td>{{.PublishedAt.Format "2006-01-02"}}</td>
+<td>{{if or .Added .Removed .Changed}}{{len .Added}} added, {{len .Removed}} removed, {{len .Changed}} changed
+{{if .Removed}}<details><summary>Removed</summary><ul>{{range .Removed}}<li><code>{{.}}</code></li>{{end}}</ul></details>{{end}}
+{{if .Changed}}<details><summary>Changed</summary><ul>{{range .Changed}}<li><code>{{.}}</code></li>{{end}}</ul></details>{{end}}
+{{if .Added}}<details><summary>Added</summary><ul>{{range .Added}}<li><code>{{.}}</code></li>{{end}}</ul></details>{{end}}
+{{else}}-{{end}}</td>
+</tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// Writes the archive index of the versions of history to the build directory,
+// newest first.
+func writeVersionArchive(settings *Settings, history []*PublishedVersion) error {
+	versions := make([]*PublishedVersion, len(history))
+	for i, version := range history {
+		versions[len(history)-1-i] = version
+	}
+
+	buffer := new(bytes.Buffer)
+	err := versionArchiveTemplate.Execute(buffer, struct {
+		Module   string
+		Versions []*PublishedVersion
+	}{settings.ModName, versions})
+	if err != nil {
+		return xerrors.Errorf("error rendering version archive: %w", err)
+	}
+	filePath := filepath.Join(settings.BuildDir, versionArchiveName)
+	if err := ioutil.WriteFile(filePath, buffer.Bytes(), os.ModePerm); err != nil {
+		return xerrors.Errorf("error writing version archive: %w", err)
+	}
+	return nil
+}
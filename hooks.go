@@ -0,0 +1,78 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// Hook points commands of the config can run at.
+const (
+	hookPreClean  = "pre_clean"
+	hookPreBuild  = "pre_build"
+	hookPostBuild = "post_build"
+)
+
+// ConfigHooks are shell commands the config runs around a build.
+type ConfigHooks struct {
+	// Run before the build directory is cleared, while it still holds the last build.
+	PreClean []string `json:"pre_clean,omitempty"`
+	// Run once the build directory is cleared, before the docs are extracted.
+	PreBuild []string `json:"pre_build,omitempty"`
+	// Run once the build, its formats and archive are written, before publishing.
+	PostBuild []string `json:"post_build,omitempty"`
+}
+
+// Returns the commands of a hook point.
+func (hooks *ConfigHooks) commands(hook string) []string {
+	if hooks == nil {
+		return nil
+	}
+	switch hook {
+	case hookPreClean:
+		return hooks.PreClean
+	case hookPreBuild:
+		return hooks.PreBuild
+	case hookPostBuild:
+		return hooks.PostBuild
+	}
+	return nil
+}
+
+// Runs the commands of a hook point with sh in the module root, in order, stopping
+// at the first which fails. Commands find the build in $DOCMODULE_BUILD_DIR and its
+// build info in $DOCMODULE_MANIFEST.
+func runHooks(settings *Settings, hook string) {
+	commands := settings.Hooks.commands(hook)
+	if len(commands) == 0 {
+		return
+	}
+
+	buildDir, err := filepath.Abs(settings.BuildDir)
+	if err != nil {
+		log.Panicf("error resolving build directory: %v", err)
+	}
+	env := append(
+		os.Environ(),
+		"DOCMODULE_HOOK="+hook,
+		"DOCMODULE_MODULE="+settings.ModName,
+		"DOCMODULE_BUILD_DIR="+buildDir,
+		"DOCMODULE_MANIFEST="+filepath.Join(buildDir, buildInfoName),
+	)
+	if settings.Archive != "" {
+		env = append(env, "DOCMODULE_ARCHIVE="+settings.Archive)
+	}
+
+	for _, command := range commands {
+		log.Printf("running %v hook: %v", hook, command)
+		shell := exec.Command("sh", "-c", command)
+		shell.Dir = settings.ModuleRootPath
+		shell.Env = env
+		shell.Stdout = os.Stderr
+		shell.Stderr = os.Stderr
+		if err := shell.Run(); err != nil {
+			log.Panicf("%v hook %q failed: %v", hook, command, err)
+		}
+	}
+}
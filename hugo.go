@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"golang.org/x/xerrors"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Output format of the content of a Hugo site.
+const formatHugo = "hugo"
+
+// Directory of the build directory the Hugo content is written to, to be copied or
+// mounted into the site.
+const hugoDir = "hugo"
+
+// Front matter formats of the Hugo pages, as passed to --hugo-front-matter.
+const (
+	frontMatterYAML = "yaml"
+	frontMatterTOML = "toml"
+)
+
+// HugoFormat writes a Hugo content page per package under content/reference/, laid
+// out like the module, with front matter and the doc links of the comments as
+// relrefs.
+type HugoFormat struct{}
+
+func (format *HugoFormat) Name() string {
+	return formatHugo
+}
+
+// Renders the front matter of a page with the fields in order, in YAML or TOML.
+func renderFrontMatter(frontMatter string, fields [][2]interface{}) string {
+	delimiter, separator := "---", ": "
+	if frontMatter == frontMatterTOML {
+		delimiter, separator = "+++", " = "
+	}
+	rendered := new(strings.Builder)
+	rendered.WriteString(delimiter + "\n")
+	for _, field := range fields {
+		value := field[1]
+		if text, ok := value.(string); ok {
+			value = fmt.Sprintf("%q", text)
+		}
+		fmt.Fprintf(rendered, "%v%v%v\n", field[0], separator, value)
+	}
+	rendered.WriteString(delimiter + "\n")
+	return rendered.String()
+}
+
+// Returns the relref shortcode linking to the page of a package and an anchor of it.
+func hugoRelref(model *DocModel, pkg *ModelPackage, fragment string) string {
+	target := path.Join("/", siteReferenceSection, packageRelDir(model, pkg))
+	if fragment != "" {
+		target += "#" + fragment
+	}
+	return `{{< relref "` + target + `" >}}`
+}
+
+func (format *HugoFormat) Generate(
+	ctx context.Context, settings *Settings, model *DocModel,
+) error {
+	site := &siteMarkdown{
+		Model: model,
+		Link: func(from *ModelPackage, to *ModelPackage, fragment string) string {
+			return hugoRelref(model, to, fragment)
+		},
+		// Hugo runs shortcodes in content, so the ones in comments are commented out.
+		Escape: func(text string) string {
+			text = strings.Replace(text, "{{<", "{{</*", -1)
+			text = strings.Replace(text, ">}}", "*/>}}", -1)
+			text = strings.Replace(text, "{{%", "{{%/*", -1)
+			return strings.Replace(text, "%}}", "*/%}}", -1)
+		},
+	}
+
+	for i, pkg := range model.Packages {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		body, err := site.renderPackage(pkg)
+		if err != nil {
+			return err
+		}
+		frontMatter := renderFrontMatter(settings.HugoFrontMatter, [][2]interface{}{
+			{"title", packagePageTitle(model, pkg)},
+			{"description", pkg.Synopsis},
+			{"weight", i + 1},
+			{"section", siteReferenceSection},
+		})
+
+		filePath := filepath.Join(
+			settings.BuildDir, hugoDir, "content", siteReferenceSection,
+			filepath.FromSlash(packageRelDir(model, pkg)), "_index.md",
+		)
+		if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
+			return xerrors.Errorf("error creating hugo content directory: %w", err)
+		}
+		data := append([]byte(frontMatter+"\n"), body...)
+		if err := ioutil.WriteFile(filePath, data, os.ModePerm); err != nil {
+			return xerrors.Errorf("error writing hugo content: %w", err)
+		}
+	}
+	return nil
+}
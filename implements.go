@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"go/importer"
+	"go/token"
+	"go/types"
+	"html"
+	"log"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+)
+
+// A named type of the module, referenced from an implementations list.
+type typeRef struct {
+	ImportPath string
+	Name       string
+	// Whether only the pointer to the type satisfies the interface.
+	Pointer bool
+}
+
+func (ref *typeRef) key() string {
+	return ref.ImportPath + "." + ref.Name
+}
+
+// Interfaces of the module and the types satisfying them, both keyed by
+// importPath.Name.
+type implementations struct {
+	// Interface -> types implementing it.
+	Implementers map[string][]*typeRef
+	// Type -> interfaces it implements.
+	Implements map[string][]*typeRef
+}
+
+// Type checks the packages of the module, returning its exported interfaces with
+// methods and its other exported named types.
+func moduleTypeNames(settings *Settings) (interfaces []*types.TypeName, concrete []*types.TypeName) {
+	command := exec.Command("go", "list", "-f", "{{.ImportPath}}\t{{.Dir}}", "./...")
+	command.Dir = settings.ModuleRootPath
+	output, err := command.Output()
+	if err != nil {
+		log.Panicf("error listing packages: %v", err)
+	}
+
+	fileSet := token.NewFileSet()
+	// The source importer shares imported packages between calls, so the types of
+	// different packages are comparable.
+	typeImporter := importer.ForCompiler(fileSet, "source", nil).(types.ImporterFrom)
+
+	interfaces = make([]*types.TypeName, 0)
+	concrete = make([]*types.TypeName, 0)
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		parts := strings.SplitN(line, "\t", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		pkg, err := typeImporter.ImportFrom(parts[0], parts[1], 0)
+		if err != nil {
+			log.Printf("skipping implementations of '%v': %v", parts[0], err)
+			continue
+		}
+
+		scope := pkg.Scope()
+		for _, name := range scope.Names() {
+			typeName, ok := scope.Lookup(name).(*types.TypeName)
+			if !ok || !typeName.Exported() || typeName.IsAlias() {
+				continue
+			}
+			if iface, ok := typeName.Type().Underlying().(*types.Interface); ok {
+				if iface.NumMethods() > 0 {
+					interfaces = append(interfaces, typeName)
+				}
+				continue
+			}
+			concrete = append(concrete, typeName)
+		}
+	}
+	return interfaces, concrete
+}
+
+// Type checks the packages of the module and matches every exported concrete type
+// against every exported interface with methods.
+func computeImplementations(settings *Settings) *implementations {
+	interfaces, concrete := moduleTypeNames(settings)
+	impls := &implementations{
+		Implementers: make(map[string][]*typeRef),
+		Implements:   make(map[string][]*typeRef),
+	}
+	for _, iface := range interfaces {
+		ifaceType := iface.Type().Underlying().(*types.Interface)
+		ifaceRef := &typeRef{ImportPath: iface.Pkg().Path(), Name: iface.Name()}
+		for _, typeName := range concrete {
+			ref := &typeRef{ImportPath: typeName.Pkg().Path(), Name: typeName.Name()}
+			if !types.Implements(typeName.Type(), ifaceType) {
+				if !types.Implements(types.NewPointer(typeName.Type()), ifaceType) {
+					continue
+				}
+				ref.Pointer = true
+			}
+			impls.Implementers[ifaceRef.key()] = append(impls.Implementers[ifaceRef.key()], ref)
+			impls.Implements[ref.key()] = append(impls.Implements[ref.key()], ifaceRef)
+		}
+	}
+	return impls
+}
+
+// Renders a list of types on the page of importPath, linking the ones documented.
+func renderTypeRefs(
+	title string, refs []*typeRef, importPath string, fromDir string, pages map[string]string,
+) string {
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].key() < refs[j].key()
+	})
+
+	rendered := new(bytes.Buffer)
+	rendered.WriteString(`<div class="docmodule-implements"><h4>` + title + "</h4>\n<ul>\n")
+	for _, ref := range refs {
+		name := ref.Name
+		if ref.ImportPath != importPath {
+			name = path.Base(ref.ImportPath) + "." + name
+		}
+		if ref.Pointer {
+			name = "*" + name
+		}
+		name = html.EscapeString(name)
+
+		if link := resolveSymbolLink(pages, ref.key()); link != "" {
+			name = `<a href="` + relativeLink(fromDir, link) + `">` + name + "</a>"
+		}
+		rendered.WriteString("<li>" + name + "</li>\n")
+	}
+	rendered.WriteString("</ul></div>")
+	return rendered.String()
+}
+
+// Returns the processor listing under every interface on the package pages the
+// module's types implementing it, and under every type the module's interfaces it
+// implements.
+func newImplementationsProcessor(ctx context.Context, runInfo *RunInfo) Processor {
+	settings := runInfo.Settings
+	if !settings.Implementations {
+		return nil
+	}
+
+	log.Println("computing interface implementations.")
+	impls := computeImplementations(settings)
+	pages := packagePages(runInfo)
+	writeNavStylesheet(settings)
+
+	return ProcessorFunc(func(page *Page) error {
+		importPath := page.ImportPath()
+		fromDir := path.Dir(page.RelPath)
+		data := typeSectionRegex.ReplaceAllFunc(page.Data, func(section []byte) []byte {
+			key := importPath + "." + string(typeSectionRegex.FindSubmatch(section)[1])
+			if implementers := impls.Implementers[key]; len(implementers) > 0 {
+				section = append(section, renderTypeRefs(
+					"Implemented by", implementers, importPath, fromDir, pages,
+				)...)
+			}
+			if implemented := impls.Implements[key]; len(implemented) > 0 {
+				section = append(section, renderTypeRefs(
+					"Implements", implemented, importPath, fromDir, pages,
+				)...)
+			}
+			return section
+		})
+		page.Data = linkNavStylesheet(data, fromDir)
+		return nil
+	})
+}
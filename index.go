@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Package as listed on the generated index page.
+type indexPackage struct {
+	ImportPath string
+	// Path relative to the module root, "." for the root package.
+	RelPath  string
+	Name     string
+	Synopsis string
+	// Link to the package's page, relative to the index page.
+	Link string
+}
+
+// Packages sharing a parent directory.
+type indexGroup struct {
+	Dir      string
+	Packages []*indexPackage
+}
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta http-equiv="Content-Type" content="text/html; charset=utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Module}} - Packages</title>
+<link type="text/css" rel="stylesheet" href="{{.Stylesheet}}">
+</head>
+<body>
+<div id="page" class="wide">
+<div class="container">
+<h1>Packages of {{.Module}}</h1>
+{{if .GuideLink}}<p><a href="{{.GuideLink}}">Guide</a></p>{{end}}
+{{if .GraphLink}}<p><a href="{{.GraphLink}}">Package graph</a></p>{{end}}
+{{range .Groups}}
+<h2 id="{{.Dir}}">{{.Dir}}</h2>
+<div class="pkg-dir">
+<table>
+<tr><th class="pkg-name">Name</th><th class="pkg-synopsis">Synopsis</th></tr>
+{{range .Packages}}<tr>
+<td class="pkg-name"><a href="{{.Link}}">{{.Name}}</a></td>
+<td class="pkg-synopsis">{{.Synopsis}}</td>
+</tr>
+{{end}}</table>
+</div>
+{{end}}
+{{if .Modules}}
+<h2 id="modules">Nested modules</h2>
+<div class="pkg-dir">
+<table>
+<tr><th class="pkg-name">Module</th><th class="pkg-synopsis">Directory</th></tr>
+{{range .Modules}}<tr>
+<td class="pkg-name"><a href="{{.Link}}">{{.ModName}}</a></td>
+<td class="pkg-synopsis">{{.Dir}}</td>
+</tr>
+{{end}}</table>
+</div>
+{{end}}
+</div>
+</div>
+</body>
+</html>
+`))
+
+// Returns import path -> synopsis for every package of the module via `go list`.
+func packageSynopses(settings *Settings) map[string]string {
+	command := exec.Command("go", "list", "-f", "{{.ImportPath}}\t{{.Doc}}", "./...")
+	command.Dir = settings.ModuleRootPath
+	output, err := command.Output()
+	if err != nil {
+		log.Panicf("error listing packages: %v", err)
+	}
+
+	synopses := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		parts := strings.SplitN(line, "\t", 2)
+		if len(parts) == 2 {
+			synopses[parts[0]] = parts[1]
+		}
+	}
+	return synopses
+}
+
+// Returns the name of the generated index page. In the nested layout index.html is
+// the module's root page, so the package list is written next to it.
+func indexPageName(settings *Settings) string {
+	if settings.Layout == layoutNested {
+		return "packages.html"
+	}
+	return "index.html"
+}
+
+// Writes a landing page listing every documented package with its synopsis,
+// grouped by parent directory.
+func generateIndexPage(runInfo *RunInfo) {
+	settings := runInfo.Settings
+	synopses := packageSynopses(settings)
+
+	groups := make(map[string]*indexGroup)
+	for _, info := range runInfo.DocFileInfo {
+		if info.ImportPath == "" {
+			continue
+		}
+
+		relPath := strings.TrimPrefix(info.ImportPath, settings.ModName)
+		relPath = strings.TrimPrefix(relPath, "/")
+		if relPath == "" {
+			relPath = "."
+		}
+		dir := path.Dir(relPath)
+
+		group, ok := groups[dir]
+		if !ok {
+			group = &indexGroup{Dir: dir}
+			groups[dir] = group
+		}
+		group.Packages = append(group.Packages, &indexPackage{
+			ImportPath: info.ImportPath,
+			RelPath:    relPath,
+			Name:       path.Base(info.ImportPath),
+			Synopsis:   synopses[info.ImportPath],
+			Link:       info.NewRelPath,
+		})
+	}
+
+	sortedGroups := make([]*indexGroup, 0, len(groups))
+	for _, group := range groups {
+		sort.Slice(group.Packages, func(i, j int) bool {
+			return group.Packages[i].RelPath < group.Packages[j].RelPath
+		})
+		sortedGroups = append(sortedGroups, group)
+	}
+	sort.Slice(sortedGroups, func(i, j int) bool {
+		return sortedGroups[i].Dir < sortedGroups[j].Dir
+	})
+
+	graphLink := ""
+	if settings.Graph {
+		graphLink = graphPageName
+	}
+
+	buffer := new(bytes.Buffer)
+	err := pageTemplate(runInfo, indexTemplate).Execute(buffer, map[string]interface{}{
+		"Module":     settings.ModName,
+		"Stylesheet": selectBackend(settings).SentinelAsset(),
+		"Groups":     sortedGroups,
+		"GraphLink":  graphLink,
+		"GuideLink":  proseIndexLink(settings),
+		"Modules":    runInfo.Submodules,
+	})
+	if err != nil {
+		log.Panicf("error rendering index page: %v", err)
+	}
+
+	indexPath := settings.BuildDir + "/" + indexPageName(settings)
+	if err := ioutil.WriteFile(indexPath, buffer.Bytes(), os.ModePerm); err != nil {
+		log.Panicf("error writing index page: %v", err)
+	}
+	runInfo.HtmlFiles = append(runInfo.HtmlFiles, indexPath)
+}
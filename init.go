@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"golang.org/x/xerrors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Site generators `docmodule init` can scaffold a skeleton for.
+const (
+	skeletonNone   = "none"
+	skeletonSphinx = "sphinx"
+	skeletonMkDocs = "mkdocs"
+)
+
+// Build directories the skeletons serve the API reference from.
+const (
+	sphinxBuildPath = "zdocs/source/_static"
+	mkdocsBuildPath = "site/api"
+)
+
+// A file scaffolded by `docmodule init`, relative to the module root.
+type scaffoldFile struct {
+	Path    string
+	Content string
+}
+
+// Sphinx source directories, relative to the module root, whose conf.py
+// `docmodule init` looks for.
+var sphinxSourceDirs = []string{"zdocs/source", "docs/source", "docs", "doc"}
+
+// Returns the build directory to suggest for the module at root: the one of the
+// skeleton, or else the one serving an existing MkDocs or Sphinx site from the
+// module, defaulting to the one of --build-path.
+func suggestBuildPath(root string, skeleton string) string {
+	switch skeleton {
+	case skeletonSphinx:
+		return sphinxBuildPath
+	case skeletonMkDocs:
+		return mkdocsBuildPath
+	}
+	for _, name := range []string{"mkdocs.yml", "mkdocs.yaml"} {
+		if exists, _ := fileExists(filepath.Join(root, name)); exists {
+			return mkdocsBuildPath
+		}
+	}
+	for _, dir := range sphinxSourceDirs {
+		if exists, _ := fileExists(filepath.Join(root, filepath.FromSlash(dir), "conf.py")); exists {
+			return dir + "/_static"
+		}
+	}
+	return sphinxBuildPath
+}
+
+// Returns ignore patterns for the internal packages of the module at root, which
+// users of the module can't import.
+func internalPackagePatterns(root string) ([]string, error) {
+	patterns := make([]string, 0)
+	err := filepath.Walk(root, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() || filePath == root {
+			return nil
+		}
+		name := info.Name()
+		if strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") ||
+			name == "vendor" || name == "testdata" {
+			return filepath.SkipDir
+		}
+		// Nested modules are documented on their own.
+		if exists, _ := fileExists(filepath.Join(filePath, "go.mod")); exists {
+			return filepath.SkipDir
+		}
+		if name != "internal" {
+			return nil
+		}
+		relDir, err := filepath.Rel(root, filePath)
+		if err != nil {
+			return err
+		}
+		patterns = append(patterns, filepath.ToSlash(relDir)+"/...")
+		return filepath.SkipDir
+	})
+	return patterns, err
+}
+
+// Returns the files scaffolding the docs of module with the skeleton, building the
+// API reference into buildPath and leaving out the packages of ignorePatterns.
+func scaffoldFiles(
+	module string, skeleton string, buildPath string, ignorePatterns []string,
+) ([]*scaffoldFile, error) {
+	config := &Config{
+		Flags: map[string]interface{}{
+			"build-path": buildPath,
+			"sidebar":    true,
+			"toc":        true,
+		},
+		Docs:   defaultProseDir,
+		Nav:    defaultNavFile,
+		Ignore: defaultIgnoreFile,
+	}
+	configData, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	ignoreContent := "# internal/...\n"
+	if len(ignorePatterns) > 0 {
+		ignoreContent = "# Internal packages, which users of the module can't import.\n" +
+			strings.Join(ignorePatterns, "\n") + "\n"
+	}
+
+	files := []*scaffoldFile{
+		{Path: configFileName, Content: string(configData) + "\n"},
+		{
+			Path: defaultProseDir + "/index.md",
+			Content: "# " + path.Base(module) + "\n\n" +
+				"Guides for " + module + " go in this directory as markdown files. " +
+				"They are rendered next to the API reference, in the order of " +
+				defaultNavFile + ".\n",
+		},
+		{
+			Path: defaultNavFile,
+			Content: "# Prose pages in reading order, relative to " + defaultProseDir + "/.\n" +
+				"- title: Introduction\n" +
+				"  path: index.md\n",
+		},
+		{
+			Path: defaultIgnoreFile,
+			Content: "# Packages to leave out of the docs, one pattern per line, relative to\n" +
+				"# the module. Patterns ending in /... match everything below them.\n" +
+				ignoreContent,
+		},
+	}
+
+	switch skeleton {
+	case skeletonSphinx:
+		files = append(files,
+			&scaffoldFile{
+				Path: "zdocs/source/conf.py",
+				Content: "project = \"" + path.Base(module) + "\"\n" +
+					"master_doc = \"index\"\n" +
+					"html_static_path = [\"_static\"]\n",
+			},
+			&scaffoldFile{
+				Path: "zdocs/source/index.rst",
+				Content: path.Base(module) + "\n" +
+					strings.Repeat("=", len(path.Base(module))) + "\n\n" +
+					"`API reference <_static/index.html>`_\n\n" +
+					"Build the reference with ``docmodule`` before running Sphinx.\n",
+			},
+		)
+	case skeletonMkDocs:
+		files = append(files,
+			&scaffoldFile{
+				Path: "mkdocs.yml",
+				Content: "site_name: " + path.Base(module) + "\n" +
+					"docs_dir: site\n" +
+					"nav:\n" +
+					"  - Home: index.md\n" +
+					"  - API reference: api/index.html\n",
+			},
+			&scaffoldFile{
+				Path: "site/index.md",
+				Content: "# " + path.Base(module) + "\n\n" +
+					"See the [API reference](api/index.html), built with `docmodule` " +
+					"before running MkDocs.\n",
+			},
+		)
+	case skeletonNone:
+	default:
+		return nil, xerrors.Errorf("unknown skeleton %q, expected none, sphinx or mkdocs", skeleton)
+	}
+	return files, nil
+}
+
+// Scaffolds the config file, prose directory, nav file and ignore file of the
+// module in the working directory, and optionally a Sphinx or MkDocs site serving
+// the build. The config builds into the directory of the skeleton or of an existing
+// site, and the ignore file leaves out the internal packages. Existing files are
+// kept unless --force is set.
+func runInitCommand(args []string) {
+	flags := newCommandFlagSet("init")
+	skeleton := flags.String(
+		"skeleton",
+		skeletonNone,
+		"Site generator to scaffold around the API reference: 'none', 'sphinx' or 'mkdocs'.",
+	)
+	force := flags.Bool(
+		"force",
+		false,
+		"Overwrite files which already exist.",
+	)
+	_ = flags.Parse(args)
+
+	settings := new(Settings)
+	getEnvSettings(settings)
+	getGoModName(settings)
+
+	buildPath := suggestBuildPath(settings.ModuleRootPath, *skeleton)
+	ignorePatterns, err := internalPackagePatterns(settings.ModuleRootPath)
+	if err != nil {
+		log.Fatal(xerrors.Errorf("error looking for internal packages: %w", err))
+	}
+	files, err := scaffoldFiles(settings.ModName, *skeleton, buildPath, ignorePatterns)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("module  %v, building the API reference into %v\n", settings.ModName, buildPath)
+
+	for _, file := range files {
+		filePath := filepath.Join(settings.ModuleRootPath, filepath.FromSlash(file.Path))
+		if exists, _ := fileExists(filePath); exists && !*force {
+			fmt.Printf("kept    %v\n", file.Path)
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
+			log.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filePath, []byte(file.Content), 0644); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("created %v\n", file.Path)
+	}
+}
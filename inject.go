@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+)
+
+// Inserts snippet right before the closing tag matched by marker, or appends it when
+// the page has no such tag.
+func injectBefore(data []byte, marker *regexp.Regexp, snippet []byte) []byte {
+	location := marker.FindIndex(data)
+	if location == nil {
+		return append(data, snippet...)
+	}
+
+	result := make([]byte, 0, len(data)+len(snippet))
+	result = append(result, data[:location[0]]...)
+	result = append(result, snippet...)
+	result = append(result, data[location[0]:]...)
+	return result
+}
+
+var headEndRegex = regexp.MustCompile(`(?i)</head>`)
+var bodyEndRegex = regexp.MustCompile(`(?i)</body>`)
+var bodyStartRegex = regexp.MustCompile(`(?i)<body[^>]*>`)
+var headStartRegex = regexp.MustCompile(`(?i)<head(?:\s[^>]*)?>`)
+
+// Inserts snippet at the end of the page's <head>.
+func injectIntoHead(data []byte, snippet []byte) []byte {
+	return injectBefore(data, headEndRegex, snippet)
+}
+
+// Inserts snippet at the end of the page's <body>.
+func injectIntoBody(data []byte, snippet []byte) []byte {
+	return injectBefore(data, bodyEndRegex, snippet)
+}
+
+// Inserts snippet at the start of the page's <body>, or prepends it when the page has
+// no body tag.
+func injectIntoBodyStart(data []byte, snippet []byte) []byte {
+	location := bodyStartRegex.FindIndex(data)
+	if location == nil {
+		return append(append([]byte{}, snippet...), data...)
+	}
+
+	result := make([]byte, 0, len(data)+len(snippet))
+	result = append(result, data[:location[1]]...)
+	result = append(result, snippet...)
+	result = append(result, data[location[1]:]...)
+	return result
+}
+
+// Inserts snippet at the start of the page's <head>, before anything it applies to,
+// or at the end of the head when the page has no head tag.
+func injectIntoHeadStart(data []byte, snippet []byte) []byte {
+	location := headStartRegex.FindIndex(data)
+	if location == nil {
+		return injectIntoHead(data, snippet)
+	}
+
+	result := make([]byte, 0, len(data)+len(snippet))
+	result = append(result, data[:location[1]]...)
+	result = append(result, snippet...)
+	result = append(result, data[location[1]:]...)
+	return result
+}
+
+// Regex for HTML tags, used to reduce markup to its text.
+var htmlTagRegex = regexp.MustCompile(`<[^>]*>`)
+
+// Returns the text of an HTML fragment with tags removed and whitespace collapsed.
+func htmlText(fragment []byte) string {
+	text := htmlTagRegex.ReplaceAll(fragment, nil)
+	return string(bytes.Join(bytes.Fields(text), []byte(" ")))
+}
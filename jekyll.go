@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"golang.org/x/xerrors"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+)
+
+// Output format of the pages of a Jekyll site.
+const formatJekyll = "jekyll"
+
+// Directory of the build directory the Jekyll pages are written to, to be copied into
+// the source of the site.
+const jekyllDir = "jekyll"
+
+// JekyllFormat writes a markdown page with front matter per package under reference/,
+// laid out like the module, for Jekyll sites like those of GitHub Pages.
+type JekyllFormat struct{}
+
+func (format *JekyllFormat) Name() string {
+	return formatJekyll
+}
+
+// Regex for the endraw tags of a page, which would end the raw block it is wrapped in.
+var liquidEndRawRegex = regexp.MustCompile(`\{%-?\s*endraw\s*-?%\}`)
+
+// Returns the body of a page as a raw block, so Liquid leaves the tags and outputs
+// of code samples and comments, like {{ .Name }} in a template, as they are. Endraw
+// tags of the body are output by Liquid instead.
+func liquidRaw(body []byte) []byte {
+	body = liquidEndRawRegex.ReplaceAllFunc(body, func(tag []byte) []byte {
+		return []byte("{% endraw %}{{ '" + string(tag) + "' }}{% raw %}")
+	})
+	raw := append([]byte("{% raw %}\n"), body...)
+	return append(raw, "{% endraw %}\n"...)
+}
+
+// Returns the path of the page of a package in the Jekyll output.
+func jekyllPagePath(model *DocModel, pkg *ModelPackage) string {
+	return path.Join(siteReferenceSection, packageRelDir(model, pkg), "index.md")
+}
+
+func (format *JekyllFormat) Generate(
+	ctx context.Context, settings *Settings, model *DocModel,
+) error {
+	site := &siteMarkdown{
+		Model: model,
+		// Pages are linked by their directory, which Jekyll writes index.html to.
+		Link: func(from *ModelPackage, to *ModelPackage, fragment string) string {
+			fromDir := path.Dir(jekyllPagePath(model, from))
+			link := relativeLink(fromDir, path.Dir(jekyllPagePath(model, to))+"/")
+			if fragment != "" {
+				link += "#" + fragment
+			}
+			return link
+		},
+	}
+
+	for i, pkg := range model.Packages {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		body, err := site.renderPackage(pkg)
+		if err != nil {
+			return err
+		}
+		frontMatter := renderFrontMatter(frontMatterYAML, [][2]interface{}{
+			{"layout", settings.JekyllLayout},
+			{"title", packagePageTitle(model, pkg)},
+			{"description", pkg.Synopsis},
+			{"nav_order", i + 1},
+		})
+
+		filePath := filepath.Join(
+			settings.BuildDir, jekyllDir, filepath.FromSlash(jekyllPagePath(model, pkg)),
+		)
+		if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
+			return xerrors.Errorf("error creating jekyll page directory: %w", err)
+		}
+		data := append([]byte(frontMatter+"\n"), liquidRaw(body)...)
+		if err := ioutil.WriteFile(filePath, data, os.ModePerm); err != nil {
+			return xerrors.Errorf("error writing jekyll page: %w", err)
+		}
+	}
+	return nil
+}
@@ -1,16 +1,20 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"golang.org/x/xerrors"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 )
 
@@ -22,35 +26,26 @@ func fileExists(path string) (bool, error) {
 	return true, err
 }
 
-func killDeferred(process *os.Process, shutdownComplete *sync.WaitGroup) {
-	defer shutdownComplete.Done()
-	log.Println("shutting down godoc server.")
-	err := process.Kill()
-	if err != nil {
-		log.Panicf("error killing godoc server process: %v", err)
-	}
-	log.Println("go doc server shut down.")
-}
-
-func runDocServer(
-	settings *Settings,
-	shutdownSignal *sync.WaitGroup,
-	shutdownComplete *sync.WaitGroup,
-) {
-	log.Println("starting up godoc server at", settings.ServerHost+".")
-	command := exec.Command("godoc", "-http="+settings.ServerHost)
+// Starts the backend's doc server. The server process is killed when ctx is done.
+func startDocServer(ctx context.Context, settings *Settings) *exec.Cmd {
+	backend := selectBackend(settings)
+	log.Println("starting up", backend.Name(), "server at", settings.ServerHost+".")
+	command := backend.Command(ctx, settings)
 
 	if err := command.Start(); err != nil {
-		log.Panicf("error starting godoc server: %v", err)
+		log.Panicf("error starting %v server: %v", backend.Name(), err)
 	}
-	defer killDeferred(command.Process, shutdownComplete)
-	shutdownSignal.Wait()
+	return command
 }
 
-func scrapeModulePages(settings *Settings) {
-	pathRegex := regexp.QuoteMeta("/pkg/" + settings.ModName) + `|\.css|\.png|\.js`
+// Downloads the module's pages from the doc server. Returns the downloads which
+// failed if --strict is set.
+func scrapeModulePages(ctx context.Context, settings *Settings) []*BuildProblem {
+	backend := selectBackend(settings)
+	pathRegex := backend.AcceptRegex(settings)
 
-	wgetCommand := exec.Command(
+	wgetCommand := exec.CommandContext(
+		ctx,
 		"wget",
 		// save HTML/CSS documents with proper extensions
 		"-E",
@@ -72,20 +67,40 @@ func scrapeModulePages(settings *Settings) {
 		"-P", settings.BuildDir,
 		// execute a `.wgetrc'-style command
 		"-erobots=off",
-		// root path to start crawl
-		settings.ServerHost+"/pkg/"+settings.ModName,
 	)
+	// root paths to start crawl
+	wgetCommand.Args = append(wgetCommand.Args, settings.ServerHost+backend.ModulePath(settings))
+	wgetCommand.Args = append(wgetCommand.Args, stdlibPageURLs(settings)...)
 	log.Println("wget command:", wgetCommand.Args)
-	output, err := wgetCommand.CombinedOutput()
+	settings.Progress.Start("scrape", 0)
+	wgetOutput := &wgetProgressWriter{progress: settings.Progress}
+	wgetCommand.Stdout = wgetOutput
+	wgetCommand.Stderr = wgetOutput
+	err := wgetCommand.Run()
+	output := wgetOutput.output.Bytes()
+
+	if ctx.Err() != nil {
+		log.Panicf("error scraping docs: %v", ctx.Err())
+	}
 
+	var problems []*BuildProblem
 	if err != nil {
 		// check if the download worked at all
-		exists, err := fileExists(settings.BuildDir + "/style.css")
-		if !exists || err != nil {
+		exists, existsErr := fileExists(settings.BuildDir + "/" + backend.SentinelAsset())
+		if !exists || existsErr != nil {
 			log.Panicf(
-				"error scraping docs: %v, output: %v", err, string(output),
+				"error scraping docs: %v, output: %v", existsErr, string(output),
 			)
 		}
+		if settings.Strict {
+			problems = wgetProblems(string(output))
+			if len(problems) == 0 {
+				problems = append(problems, &BuildProblem{
+					Page:    settings.ServerHost + backend.ModulePath(settings),
+					Message: "wget failed: " + err.Error(),
+				})
+			}
+		}
 	}
 
 	log.Print(
@@ -93,62 +108,172 @@ func scrapeModulePages(settings *Settings) {
 		string(output),
 		"\n\n##### END OUTPUT #####\n\n",
 	)
+	return problems
 }
 
-func waitForServer(settings *Settings) {
-	timer := time.AfterFunc(10*time.Second, func() {
-		log.Panicf("timeout checking server.")
-	})
+// Upper bound for the backed-off polling interval while waiting for the server.
+const maxPollInterval = 5 * time.Second
+
+// A single readiness check made while waiting for the doc server.
+type serverAttempt struct {
+	// Time since we started waiting.
+	Elapsed time.Duration
+	// Response status or error.
+	Result string
+}
+
+// ServerTimeoutError is returned when the doc server does not come up in time. It
+// lists every attempt made so slow indexing can be told apart from a dead server.
+type ServerTimeoutError struct {
+	URL      string
+	Timeout  time.Duration
+	Attempts []serverAttempt
+}
+
+func (err *ServerTimeoutError) Error() string {
+	message := fmt.Sprintf(
+		"doc server at %v not ready after %v (%v attempts):",
+		err.URL,
+		err.Timeout,
+		len(err.Attempts),
+	)
+	for i, attempt := range err.Attempts {
+		message += fmt.Sprintf(
+			"\n  attempt %v at %v: %v",
+			i+1,
+			attempt.Elapsed.Round(time.Millisecond),
+			attempt.Result,
+		)
+	}
+	return message
+}
+
+// Polls the doc server until it responds, backing off exponentially from
+// settings.PollInterval. Returns a *ServerTimeoutError if settings.ServerTimeout
+// passes first, or the context's error if ctx is done first.
+func waitForServer(ctx context.Context, settings *Settings) error {
+	backend := selectBackend(settings)
+	getPath := "http://" + settings.ServerHost + backend.ReadyPath(settings)
+
+	started := time.Now()
+	deadline := started.Add(settings.ServerTimeout)
+	interval := settings.PollInterval
+	attempts := make([]serverAttempt, 0)
 
 	client := http.Client{Timeout: 1 * time.Second}
 	for true {
 
-		getPath := "http://" + settings.ServerHost + "/pkg/"
 		log.Println("Checking Server Status:", getPath)
 
-		resp, err := client.Get(getPath)
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, getPath, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(request)
 
 		var responsePrint string
 		if err != nil {
 			responsePrint = err.Error()
 		} else {
 			responsePrint = resp.Status
+			resp.Body.Close()
 		}
 		log.Println("Response:", responsePrint)
+		attempts = append(
+			attempts,
+			serverAttempt{Elapsed: time.Since(started), Result: responsePrint},
+		)
 
-		if err != nil || resp == nil || resp.StatusCode != 200 {
-			time.Sleep(time.Second)
-			continue
-		} else {
-			timer.Stop()
+		if err == nil && resp.StatusCode == 200 {
 			break
 		}
+
+		// The last wait ends at the deadline, where the server gets a final check.
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return &ServerTimeoutError{
+				URL:      getPath,
+				Timeout:  settings.ServerTimeout,
+				Attempts: attempts,
+			}
+		}
+		wait := interval
+		if wait > remaining {
+			wait = remaining
+		}
+		select {
+		case <-ctx.Done():
+			return xerrors.Errorf("waiting for doc server: %w", ctx.Err())
+		case <-time.After(wait):
+		}
+
+		interval *= 2
+		if interval > maxPollInterval {
+			interval = maxPollInterval
+		}
+		if interval < settings.PollInterval {
+			interval = settings.PollInterval
+		}
 	}
+
+	return nil
 }
 
-func runServerAndScrapeDocs(settings *Settings) {
+func runServerAndScrapeDocs(ctx context.Context, settings *Settings) []*BuildProblem {
+	if settings.Fixtures != "" {
+		return scrapeFixtures(ctx, settings)
+	}
+	if settings.Replay != "" {
+		return replaySession(ctx, settings)
+	}
+	if settings.RemoteServer != "" {
+		return scrapeRemoteServer(ctx, settings)
+	}
+
+	// We need to kill the doc server if it is running.
+	_ = exec.Command("killall", selectBackend(settings).Binary()).Run()
 
-	// We need to kill godoc if it is running.
-	_ = exec.Command("killall", "godoc").Run()
+	// The server lives until we are done scraping, or until the run is cancelled.
+	serverCtx, stopServer := context.WithCancel(ctx)
 
-	// Set up a shutdown event to signal to the goroutine running our docs server to
-	// kill that process.
-	shutDownSignal := sync.WaitGroup{}
-	shutDownSignal.Add(1)
-	shutDownComplete := sync.WaitGroup{}
-	shutDownComplete.Add(1)
-	// Defer sending a signal to shutdown the server and wait for it to shut down.
+	// To include unexported identifiers, record the session or throttle the scrape,
+	// the server runs on a private address behind a proxy doing so.
+	var recorder *SessionRecorder
+	if settings.Record != "" {
+		recorder = NewSessionRecorder()
+	}
+	serverSettings := settings
+	if settings.IncludeUnexported || recorder != nil || settings.throttlesScrape() {
+		backendHost, err := freeLocalAddress()
+		if err != nil {
+			log.Panic(err)
+		}
+		serverSettings = new(Settings)
+		*serverSettings = *settings
+		serverSettings.ServerHost = backendHost
+	}
+
+	command := startDocServer(serverCtx, serverSettings)
+	// Defer shutting down the server and waiting for the process to exit.
 	defer func() {
-		shutDownSignal.Done()
-		shutDownComplete.Wait()
+		log.Println("shutting down doc server.")
+		stopServer()
+		_ = command.Wait()
+		log.Println("doc server shut down.")
 	}()
 
-	// Run the godoc server in a different goroutine.
-	go runDocServer(settings, &shutDownSignal, &shutDownComplete)
-	waitForServer(settings)
+	if err := waitForServer(ctx, serverSettings); err != nil {
+		log.Panic(err)
+	}
+	if serverSettings != settings {
+		backend := &url.URL{Scheme: "http", Host: serverSettings.ServerHost}
+		startScrapeProxy(serverCtx, settings, backend, recorder)
+	}
 
 	// Scrape all the documentation from the server.
-	scrapeModulePages(settings)
+	problems := scrapeModulePages(ctx, settings)
+	writeRecordedSession(settings, recorder)
+	return problems
 }
 
 // Making the directory with os.MkDirAll can cause permissions errors that don't occur
@@ -158,9 +283,15 @@ func createBuildDir(path string) {
 	var makeDir string
 
 	// go through the directories individually.
-	for _, subdir := range strings.Split(path, "/") {
-		makeDir += "/" + subdir
-		makeDir = strings.TrimPrefix(makeDir, "/")
+	for i, subdir := range strings.Split(path, "/") {
+		if subdir == "" {
+			// keep the root of absolute paths, skip doubled slashes.
+			if i == 0 {
+				makeDir = "/"
+			}
+			continue
+		}
+		makeDir = filepath.Join(makeDir, subdir)
 		if err := os.Mkdir(makeDir, os.ModePerm); err != nil {
 			if strings.HasSuffix(err.Error(), "file exists") {
 				continue
@@ -194,78 +325,259 @@ func renameEntryPoint(runInfo *RunInfo) (newPath string) {
 	goDocBaseName := stringSplit[len(stringSplit)-1]
 
 	oldPath := settings.BuildDir + "/" + goDocBaseName + ".html"
-	newPath = settings.BuildDir + "/" + settings.HTMLBaseName + "-root.html"
+	newRelPath := settings.HTMLBaseName + "-root.html"
+
+	if settings.Layout == layoutNested {
+		// The module root becomes the site root, so we no longer need the dummy index
+		// reserving its name.
+		newRelPath = "index.html"
+		if err := os.Remove(settings.BuildDir + "/index.html"); err != nil {
+			log.Panicf("error removing dummy index: %v", err)
+		}
+	}
+	newPath = settings.BuildDir + "/" + newRelPath
 
 	err := os.Rename(oldPath, newPath)
 	if err != nil {
 		log.Panic("error while renaming entry file:", err)
 	}
 
+	info := NewDocFileInfo(oldPath, newRelPath)
+	info.ImportPath = settings.ModName
+	runInfo.DocFileInfo = append(runInfo.DocFileInfo, info)
 	runInfo.HtmlFiles = append(runInfo.HtmlFiles, newPath)
 
 	return newPath
 }
 
-func renameOutputFiles(runInfo *RunInfo) {
-	// make a mapping of the current files to what we want to rename them to.
-	settings := runInfo.Settings
-	entryPoint := renameEntryPoint(runInfo)
+// Regex for extracting the import path from a godoc package page.
+var importPathRegex = regexp.MustCompile(`<code>import "([^"]+)"</code>`)
 
-	matches, err := filepath.Glob(settings.BuildDir + "/" + "*" + ".html")
-	if err != nil {
-		log.Panic("could not find result files:", err.Error())
+// Returns the import path documented by a scraped page, or an empty string if the
+// page is not a package page.
+func pageImportPath(settings *Settings, data []byte) string {
+	match := selectBackend(settings).ImportPathRegex().FindSubmatch(data)
+	if len(match) < 2 {
+		return ""
 	}
+	return string(match[1])
+}
 
-	for i, oldPath := range matches {
+// Returns the path, relative to the build directory, that a scraped page is moved to
+// under the nested layout. Pages which are not a package of this module keep their
+// name at the root of the build directory.
+func nestedPagePath(settings *Settings, oldPath string, importPath string) string {
+	if !strings.HasPrefix(importPath, settings.ModName+"/") {
+		return filepath.Base(oldPath)
+	}
+
+	return strings.TrimPrefix(importPath, settings.ModName+"/") + "/index.html"
+}
+
+// Returns the processor moving the pages scraped from the doc server to their names
+// in the build, once the module's root page is in place, and recording them in
+// runInfo.
+func newRenameProcessor(ctx context.Context, runInfo *RunInfo) Processor {
+	settings := runInfo.Settings
+	entryPoint := renameEntryPoint(runInfo)
+
+	index := 0
+	return ProcessorFunc(func(page *Page) error {
+		index++
+		oldPath := settings.BuildDir + "/" + page.RelPath
 		// skip the entry-point since we have already renamed it
 		if oldPath == entryPoint {
-			continue
+			return nil
+		}
+		// the dummy index has served its purpose, the real one is generated later
+		if page.RelPath == "index.html" {
+			page.Data = nil
+			return nil
 		}
-		index := i + 1
 
-		newPath := settings.HTMLBaseName + "." + strconv.Itoa(index) + ".html"
-		newPath = settings.BuildDir + "/" + newPath
+		importPath := pageImportPath(settings, page.Data)
 
-		err := os.Rename(oldPath, newPath)
-		if err != nil {
-			log.Panicf("error renaming %q to %q", oldPath, newPath)
+		// the standard library pages of --include-stdlib are not listed with the
+		// module's packages
+		stdlibPage := settings.includesStdlibPackage(importPath)
+
+		var newRelPath string
+		if settings.Layout == layoutNested && stdlibPage {
+			newRelPath = "std/" + importPath + "/index.html"
+		} else if settings.Layout == layoutNested {
+			newRelPath = nestedPagePath(settings, oldPath, importPath)
+		} else {
+			newRelPath = settings.HTMLBaseName + "." + strconv.Itoa(index) + ".html"
 		}
 
-		runInfo.DocFileInfo = append(
-			runInfo.DocFileInfo, NewDocFileInfo(oldPath, newPath),
-		)
-		runInfo.HtmlFiles = append(runInfo.HtmlFiles, newPath)
-	}
+		info := NewDocFileInfo(oldPath, newRelPath)
+		if !stdlibPage {
+			info.ImportPath = importPath
+		}
+		runInfo.DocFileInfo = append(runInfo.DocFileInfo, info)
+		runInfo.HtmlFiles = append(runInfo.HtmlFiles, settings.BuildDir+"/"+newRelPath)
+		page.RelPath = newRelPath
+		return nil
+	})
 }
 
-// rewrites the internal links of the html files
-func rewriteHTMLLinks(runInfo *RunInfo) {
+// Checks the renamed pages and generates the pages which don't come from the doc
+// server, which the stages after it process along with the package pages.
+func newGeneratedPagesStage(ctx context.Context, runInfo *RunInfo) Processor {
+	runInfo.Settings.Progress.Start("pages", 0)
+	excludeIgnoredPackages(runInfo)
+	checkPackagePages(runInfo)
+	detectSubmodules(runInfo)
+	writePageIndex(runInfo)
+	generateIndexPage(runInfo)
+	generateGraphPage(runInfo)
+	generateProsePages(runInfo)
+	generateExamplePages(ctx, runInfo)
+	generateNotesPages(runInfo)
+	return nil
+}
 
-	for _, filePath := range runInfo.HtmlFiles {
+// Regex for links to assets wget placed at the root of the build directory.
+var assetLinkRegex = regexp.MustCompile(
+	`(href|src)="([^"/:#?]+\.(?:css|js|png|jpg|gif|svg|ico))"`,
+)
 
-		for _, info := range runInfo.DocFileInfo {
+// Returns the processor pointing the links of every page at the renamed package
+// pages, making asset links of pages in sub directories climb back up to the shared
+// assets and adding the --inject-head snippet.
+func newLinksProcessor(ctx context.Context, runInfo *RunInfo) Processor {
+	settings := runInfo.Settings
 
-			data, err := ioutil.ReadFile(filePath)
-			if err != nil {
-				log.Panicf("error opening file '%v': %v", filePath, err)
-			}
+	var headSnippet []byte
+	if settings.InjectHeadFile != "" {
+		var err error
+		headSnippet, err = ioutil.ReadFile(settings.InjectHeadFile)
+		if err != nil {
+			log.Panicf("error reading head snippet: %v", err)
+		}
+	}
+
+	newPaths := make(map[string]string, len(runInfo.DocFileInfo))
+	for _, info := range runInfo.DocFileInfo {
+		newPaths[info.OldName] = info.NewRelPath
+	}
 
-			data = info.HtmlReplaceRegex1.ReplaceAll(data, info.HtmlReplaceWith1)
-			data = info.HtmlReplaceRegex2.ReplaceAll(data, info.HtmlReplaceWith2)
+	return ProcessorFunc(func(page *Page) error {
+		fromDir := path.Dir(page.RelPath)
+		data := replaceRenamedLinks(page.Data, fromDir, newPaths)
 
-			err = ioutil.WriteFile(filePath, data, os.ModePerm)
-			if err != nil {
-				log.Panicf("error altering output file: %v", err)
-			}
+		// Pages in sub directories need to climb back up to the shared assets.
+		if fromDir != "." {
+			prefix := relativeLink(fromDir, "")
+			data = assetLinkRegex.ReplaceAll(data, []byte(`$1="`+prefix+`$2"`))
 		}
-	}
 
+		// Add the user's snippet, e.g. analytics tags, to every page's head.
+		if len(headSnippet) > 0 {
+			data = injectIntoHead(data, headSnippet)
+		}
+		page.Data = data
+		return nil
+	})
+}
+
+// Subcommands by name. Running without a subcommand builds the docs.
+var commands = map[string]func(args []string){
+	"__complete":     runCompleteCommand,
+	"completion":     runCompletionCommand,
+	"config":         runConfigCommand,
+	"deploy":         runDeployCommand,
+	"doctor":         runDoctorCommand,
+	"init":           runInitCommand,
+	"migrate":        runMigrateCommand,
+	"modules":        runModulesCommand,
+	"serve":          runServeCommand,
+	"diff":           runDiffCommand,
+	"help":           runHelpCommand,
+	"semver":         runSemverCommand,
+	"template-funcs": runTemplateFuncsCommand,
+	"theme":          runThemeCommand,
+	"version":        runVersionCommand,
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			command(os.Args[2:])
+			return
+		}
+	}
+
 	runInfo := setupRunInfo()
+	defer removeTempGopath(runInfo.Settings)
+	runInfo.Settings.Progress = NewProgress(os.Stderr, runInfo.Settings.NoProgress)
+	if runInfo.Settings.Progress.bar {
+		log.SetOutput(runInfo.Settings.Progress)
+		defer log.SetOutput(os.Stderr)
+	}
+
+	// Bound the whole run by --timeout. Everything below stops its child processes
+	// and requests once ctx is done.
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if runInfo.Settings.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), runInfo.Settings.Timeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	defer cancel()
+
+	if runInfo.Settings.hasFormat(formatHTML) {
+		checkBackendBinary(runInfo.Settings)
+	}
+	runHooks(runInfo.Settings, hookPreClean)
+	var previousBuild *buildSnapshot
+	if runInfo.Settings.Delta {
+		previousBuild = snapshotBuild(runInfo.Settings)
+	}
 	setupBuildDir(runInfo.Settings)
-	runServerAndScrapeDocs(runInfo.Settings)
-	renameOutputFiles(runInfo)
-	rewriteHTMLLinks(runInfo)
+	runHooks(runInfo.Settings, hookPreBuild)
+	preprocessDocSource(runInfo.Settings)
+	defer removeDocSource(runInfo.Settings)
+	if runInfo.Settings.hasFormat(formatHTML) {
+		buildHTMLSite(ctx, runInfo)
+	} else if err := os.Remove(runInfo.Settings.BuildDir + "/index.html"); err != nil {
+		log.Panicf("error removing dummy index: %v", err)
+	}
+	runInfo.Settings.Progress.Start("outputs", 0)
+	writeBuildInfo(runInfo.Settings)
+	writeCoverageBadge(runInfo.Settings)
+	writeDocMetrics(runInfo)
+	generateModelFormats(ctx, runInfo)
+	registerModuleSymbols(runInfo)
+	writeAnchorIndex(runInfo)
+	writeSphinxInventory(runInfo)
+	writeBuildDelta(runInfo.Settings, previousBuild)
+	precompressBuild(runInfo.Settings)
+	writeBuildArchive(runInfo.Settings)
+	runHooks(runInfo.Settings, hookPostBuild)
+	writeBuildStats(runInfo)
+	runInfo.Settings.Progress.Start("publish", 0)
+	publishBuild(ctx, runInfo)
+	runInfo.Summary.Stages = runInfo.Settings.Progress.Stages()
+	writeBuildSummary(runInfo)
+}
+
+// Scrapes the doc server and post-processes the pages into the HTML site.
+func buildHTMLSite(ctx context.Context, runInfo *RunInfo) {
+	runInfo.Settings.Progress.Start("doc server", 0)
+	resolveStdlibPackages(runInfo.Settings)
+	problems := runServerAndScrapeDocs(ctx, runInfo.Settings)
+	runInfo.Summary.Problems = append(runInfo.Summary.Problems, problems...)
+	runProcessors(ctx, runInfo)
+	buildSubmodules(ctx, runInfo)
+	if runInfo.Settings.Strict {
+		runInfo.Settings.Progress.Start("link check", len(runInfo.HtmlFiles))
+		checkLocalLinks(runInfo)
+	}
+	if runInfo.Settings.VerifyOffline {
+		runInfo.Settings.Progress.Start("offline check", 0)
+		verifyOfflineBuild(runInfo)
+	}
+	failOnProblems(runInfo)
 }
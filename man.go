@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"golang.org/x/xerrors"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Output format of the man pages of the packages.
+const formatMan = "man"
+
+// Directory of the build directory the man pages are written to, laid out like a
+// man path with man3/ and man7/.
+const manDir = "man"
+
+// Name of the script installing the man pages.
+const manInstallScriptName = "install.sh"
+
+// Script installing the man pages into a man path and updating its index where
+// mandb is available.
+const manInstallScript = `#!/bin/sh
+# Installs the man pages of %v, generated by docmodule, into
+# $MANPREFIX, by default ~/.local/share/man, which man searches on most systems.
+set -e
+prefix="${MANPREFIX:-$HOME/.local/share/man}"
+cd "$(dirname "$0")"
+for section in man3 man7; do
+	mkdir -p "$prefix/$section"
+	cp "$section"/* "$prefix/$section/"
+done
+if command -v mandb >/dev/null 2>&1; then
+	mandb -q "$prefix" || true
+fi
+echo "installed the man pages of %v to $prefix, try man %v"
+`
+
+// ManFormat writes a section 3 man page per package, named like mymodule-client for
+// the package client of mymodule, or per symbol with --man-per-symbol, and a section
+// 7 page named like the module indexing them.
+type ManFormat struct{}
+
+func (format *ManFormat) Name() string {
+	return formatMan
+}
+
+// Returns the name of the man page of a package: the last element of the module path
+// followed by the path of the package in the module, joined by dashes.
+func manPageName(model *DocModel, pkg *ModelPackage) string {
+	name := path.Base(model.Module)
+	if relDir := packageRelDir(model, pkg); relDir != "" {
+		name += "-" + strings.Replace(relDir, "/", "-", -1)
+	}
+	return name
+}
+
+// Returns the name of the man page of a symbol of a package.
+func manSymbolPageName(model *DocModel, pkg *ModelPackage, symbol *ModelSymbol) string {
+	return manPageName(model, pkg) + "-" + markdownAnchor(symbol.Name)
+}
+
+// Escapes text for troff: backslashes are printed with \e, and lines starting with
+// the control characters . and ' are guarded by the zero width \&.
+func manEscape(text string) string {
+	lines := strings.Split(strings.Replace(text, `\`, `\e`, -1), "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, ".") || strings.HasPrefix(line, "'") {
+			lines[i] = `\&` + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Returns the first sentence of a doc comment, for the NAME section of a page.
+func manSummary(doc string) string {
+	summary := strings.Join(strings.Fields(doc), " ")
+	if end := strings.Index(summary, ". "); end >= 0 {
+		summary = summary[:end+1]
+	}
+	return summary
+}
+
+// Writes a block of code, without filling or adjusting its lines.
+func writeManCode(page *strings.Builder, code string) {
+	page.WriteString(".PP\n.RS 4\n.nf\n" + manEscape(code) + "\n.fi\n.RE\n")
+}
+
+// Writes a doc comment as paragraphs, with its indented code blocks as code.
+func writeManDoc(page *strings.Builder, doc string) {
+	paragraph := make([]string, 0)
+	flushParagraph := func() {
+		if len(paragraph) > 0 {
+			page.WriteString(".PP\n" + manEscape(strings.Join(paragraph, "\n")) + "\n")
+			paragraph = paragraph[:0]
+		}
+	}
+
+	lines := strings.Split(strings.TrimRight(doc, "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if isDocCodeLine(line) {
+			flushParagraph()
+			end := i
+			for j := i; j < len(lines); j++ {
+				if isDocCodeLine(lines[j]) {
+					end = j
+				} else if strings.TrimSpace(lines[j]) != "" {
+					break
+				}
+			}
+			writeManCode(page, strings.Join(dedentLines(lines[i:end+1]), "\n"))
+			i = end
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			flushParagraph()
+			continue
+		}
+		paragraph = append(paragraph, strings.TrimSpace(line))
+	}
+	flushParagraph()
+}
+
+// Writes the header and NAME section of a page.
+func writeManHeader(
+	page *strings.Builder, model *DocModel, name string, section int, summary string,
+) {
+	fmt.Fprintf(
+		page, ".TH %q %v \"\" %q \"Go package reference\"\n",
+		strings.ToUpper(name), section, strings.TrimSpace(model.Module+" "+model.Version),
+	)
+	page.WriteString(".SH NAME\n" + manEscape(name))
+	if summary != "" {
+		page.WriteString(` \- ` + manEscape(summary))
+	}
+	page.WriteString("\n")
+}
+
+// Writes the SEE ALSO section of a page, referring to the pages named in section 3
+// and to the index.
+func writeManSeeAlso(page *strings.Builder, model *DocModel, names []string) {
+	page.WriteString(".SH SEE ALSO\n")
+	references := make([]string, 0, len(names)+1)
+	for _, name := range names {
+		references = append(references, `\fB`+manEscape(name)+`\fR(3)`)
+	}
+	references = append(references, `\fB`+manEscape(path.Base(model.Module))+`\fR(7)`)
+	page.WriteString(strings.Join(references, ",\n") + "\n")
+}
+
+// Returns the pages of the packages of the module which are the parent or a child of
+// pkg, for its SEE ALSO section.
+func manRelatedPages(model *DocModel, pkg *ModelPackage) []string {
+	names := make([]string, 0)
+	for _, other := range model.Packages {
+		if other == pkg {
+			continue
+		}
+		if strings.HasPrefix(pkg.ImportPath, other.ImportPath+"/") ||
+			strings.HasPrefix(other.ImportPath, pkg.ImportPath+"/") {
+			names = append(names, manPageName(model, other))
+		}
+	}
+	return names
+}
+
+// Renders the page of a package. With perSymbol, the symbols are only listed by
+// signature and referred to their own pages.
+func renderManPackage(model *DocModel, pkg *ModelPackage, perSymbol bool) string {
+	page := new(strings.Builder)
+	writeManHeader(page, model, manPageName(model, pkg), 3, manSummary(pkg.Synopsis))
+	page.WriteString(".SH SYNOPSIS\n")
+	writeManCode(page, `import "`+pkg.ImportPath+`"`)
+	if pkg.Doc != "" {
+		page.WriteString(".SH DESCRIPTION\n")
+		writeManDoc(page, pkg.Doc)
+	}
+
+	seeAlso := manRelatedPages(model, pkg)
+	if len(pkg.Symbols) > 0 {
+		page.WriteString(".SH SYMBOLS\n")
+	}
+	for _, symbol := range pkg.Symbols {
+		if perSymbol {
+			writeManCode(page, symbol.Signature)
+			seeAlso = append(seeAlso, manSymbolPageName(model, pkg, symbol))
+			continue
+		}
+		fmt.Fprintf(page, ".SS %q\n", manEscape(symbol.Kind+" "+symbol.Name))
+		writeManCode(page, symbol.Signature)
+		writeManDoc(page, symbol.Doc)
+	}
+	writeManSeeAlso(page, model, seeAlso)
+	return page.String()
+}
+
+// Renders the page of a symbol of a package.
+func renderManSymbol(model *DocModel, pkg *ModelPackage, symbol *ModelSymbol) string {
+	page := new(strings.Builder)
+	name := manSymbolPageName(model, pkg, symbol)
+	writeManHeader(page, model, name, 3, manSummary(symbol.Doc))
+	page.WriteString(".SH SYNOPSIS\n")
+	writeManCode(page, `import "`+pkg.ImportPath+`"`+"\n\n"+symbol.Signature)
+	if symbol.Doc != "" {
+		page.WriteString(".SH DESCRIPTION\n")
+		writeManDoc(page, symbol.Doc)
+	}
+	writeManSeeAlso(page, model, []string{manPageName(model, pkg)})
+	return page.String()
+}
+
+// Renders the index of the packages, a section 7 page named like the module.
+func renderManIndex(model *DocModel) string {
+	page := new(strings.Builder)
+	writeManHeader(page, model, path.Base(model.Module), 7, "packages of "+model.Module)
+	page.WriteString(".SH PACKAGES\n")
+	for _, pkg := range model.Packages {
+		fmt.Fprintf(page, ".TP\n\\fB%v\\fR(3)\n", manEscape(manPageName(model, pkg)))
+		page.WriteString(manEscape(pkg.ImportPath))
+		if pkg.Synopsis != "" {
+			page.WriteString(` \- ` + manEscape(manSummary(pkg.Synopsis)))
+		}
+		page.WriteString("\n")
+	}
+	return page.String()
+}
+
+func (format *ManFormat) Generate(
+	ctx context.Context, settings *Settings, model *DocModel,
+) error {
+	outputDir := filepath.Join(settings.BuildDir, manDir)
+	pages := map[string]string{
+		filepath.Join("man7", path.Base(model.Module)+".7"): renderManIndex(model),
+	}
+	for _, pkg := range model.Packages {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		pageName := manPageName(model, pkg) + ".3"
+		pages[filepath.Join("man3", pageName)] = renderManPackage(
+			model, pkg, settings.ManPerSymbol,
+		)
+		if !settings.ManPerSymbol {
+			continue
+		}
+		for _, symbol := range pkg.Symbols {
+			pageName := manSymbolPageName(model, pkg, symbol) + ".3"
+			pages[filepath.Join("man3", pageName)] = renderManSymbol(model, pkg, symbol)
+		}
+	}
+
+	for relPath, page := range pages {
+		filePath := filepath.Join(outputDir, relPath)
+		if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
+			return xerrors.Errorf("error creating man directory: %w", err)
+		}
+		if err := ioutil.WriteFile(filePath, []byte(page), os.ModePerm); err != nil {
+			return xerrors.Errorf("error writing man page: %w", err)
+		}
+	}
+
+	moduleName := path.Base(model.Module)
+	script := fmt.Sprintf(manInstallScript, model.Module, model.Module, moduleName)
+	scriptPath := filepath.Join(outputDir, manInstallScriptName)
+	if err := ioutil.WriteFile(scriptPath, []byte(script), 0755); err != nil {
+		return xerrors.Errorf("error writing man install script: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Regex for the title of a page.
+var pageTitleRegex = regexp.MustCompile(`(?is)<title>(.*?)</title>`)
+
+// Renders a meta tag, using the property attribute for Open Graph tags.
+func metaTag(name string, content string) string {
+	attribute := "name"
+	if strings.HasPrefix(name, "og:") {
+		attribute = "property"
+	}
+	return `<meta ` + attribute + `="` + name + `" content="` + html.EscapeString(content) + `">`
+}
+
+// Returns the processor adding a description and Open Graph and Twitter card tags to
+// every page so links to the published docs get a preview when shared. Package pages
+// are described by their synopsis. Pages only get an og:url when the base url is
+// absolute.
+func newMetaTagsProcessor(ctx context.Context, runInfo *RunInfo) Processor {
+	settings := runInfo.Settings
+	if !settings.MetaTags {
+		return nil
+	}
+
+	siteName := settings.SiteName
+	if siteName == "" {
+		siteName = settings.ModName
+	}
+
+	var baseURL string
+	if parsed, err := url.Parse(settings.BaseURL); err == nil && parsed.IsAbs() {
+		baseURL = strings.TrimSuffix(settings.BaseURL, "/")
+	}
+
+	synopses := packageSynopses(settings)
+
+	return ProcessorFunc(func(page *Page) error {
+		title := siteName
+		if match := pageTitleRegex.FindSubmatch(page.Data); match != nil {
+			title = html.UnescapeString(htmlText(match[1]))
+		}
+		description := "Documentation of " + settings.ModName + "."
+		if importPath := page.ImportPath(); importPath != "" && synopses[importPath] != "" {
+			description = synopses[importPath]
+		}
+
+		tags := []string{
+			metaTag("description", description),
+			metaTag("og:type", "website"),
+			metaTag("og:site_name", siteName),
+			metaTag("og:title", title),
+			metaTag("og:description", description),
+			metaTag("twitter:card", "summary"),
+			metaTag("twitter:title", title),
+			metaTag("twitter:description", description),
+		}
+		if baseURL != "" {
+			tags = append(tags, metaTag("og:url", baseURL+"/"+page.RelPath))
+		}
+
+		page.Data = injectIntoHead(page.Data, []byte(strings.Join(tags, "\n")+"\n"))
+		return nil
+	})
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Name of the OpenMetrics exposition of the doc-quality metrics written to the root
+// of the build directory.
+const docMetricsName = "doc-metrics.txt"
+
+// A metric family of the doc-quality metrics, with a value per package.
+type docMetric struct {
+	Name string
+	Type string
+	Help string
+	// Values by import path.
+	Values map[string]float64
+}
+
+// Escapes a label value of the OpenMetrics text format.
+var metricLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Renders the metrics in the OpenMetrics text format, labelled with the module and
+// the package.
+func renderOpenMetrics(module string, metrics []*docMetric) []byte {
+	output := new(strings.Builder)
+	for _, metric := range metrics {
+		fmt.Fprintf(output, "# TYPE %v %v\n", metric.Name, metric.Type)
+		fmt.Fprintf(output, "# HELP %v %v\n", metric.Name, metric.Help)
+		importPaths := make([]string, 0, len(metric.Values))
+		for importPath := range metric.Values {
+			importPaths = append(importPaths, importPath)
+		}
+		sort.Strings(importPaths)
+		for _, importPath := range importPaths {
+			fmt.Fprintf(
+				output,
+				"%v{module=\"%v\",package=\"%v\"} %v\n",
+				metric.Name,
+				metricLabelEscaper.Replace(module),
+				metricLabelEscaper.Replace(importPath),
+				metric.Values[importPath],
+			)
+		}
+	}
+	output.WriteString("# EOF\n")
+	return []byte(output.String())
+}
+
+// Writes the doc-quality metrics of every package of the module in the OpenMetrics
+// format, for dashboards to trend alongside test coverage: doc coverage, examples,
+// and the size and broken links of the package's page if the build has one.
+func writeDocMetrics(runInfo *RunInfo) {
+	settings := runInfo.Settings
+	if !settings.Metrics {
+		return
+	}
+
+	coverage := &docMetric{
+		Name:   "docmodule_doc_coverage_ratio",
+		Type:   "gauge",
+		Help:   "Share of the exported identifiers of the package with a doc comment.",
+		Values: make(map[string]float64),
+	}
+	exported := &docMetric{
+		Name:   "docmodule_exported_identifiers",
+		Type:   "gauge",
+		Help:   "Exported identifiers of the package, including the package itself.",
+		Values: make(map[string]float64),
+	}
+	examples := &docMetric{
+		Name:   "docmodule_examples",
+		Type:   "gauge",
+		Help:   "Examples of the package.",
+		Values: make(map[string]float64),
+	}
+	brokenLinks := &docMetric{
+		Name:   "docmodule_broken_links",
+		Type:   "gauge",
+		Help:   "Links of the package's page to pages missing from the build.",
+		Values: make(map[string]float64),
+	}
+	pageSize := &docMetric{
+		Name:   "docmodule_page_size_bytes",
+		Type:   "gauge",
+		Help:   "Size of the package's page.",
+		Values: make(map[string]float64),
+	}
+
+	pages := packagePages(runInfo)
+	for _, listed := range listModulePackages(settings) {
+		packageCoverage := computePackageCoverage(listed)
+		coverage.Values[listed.ImportPath] = packageCoverage.Percent() / 100
+		exported.Values[listed.ImportPath] = float64(packageCoverage.Exported)
+		_, packageExamples := packageExamples(listed.Dir)
+		examples.Values[listed.ImportPath] = float64(len(packageExamples))
+
+		page, ok := pages[listed.ImportPath]
+		if !ok {
+			continue
+		}
+		filePath := filepath.Join(settings.BuildDir, filepath.FromSlash(page))
+		info, err := os.Stat(filePath)
+		if err != nil {
+			log.Panicf("error reading page of %v: %v", listed.ImportPath, err)
+		}
+		pageSize.Values[listed.ImportPath] = float64(info.Size())
+		brokenLinks.Values[listed.ImportPath] = float64(len(brokenPageLinks(filePath)))
+	}
+
+	data := renderOpenMetrics(
+		settings.ModName, []*docMetric{coverage, exported, examples, brokenLinks, pageSize},
+	)
+	metricsPath := filepath.Join(settings.BuildDir, docMetricsName)
+	if err := ioutil.WriteFile(metricsPath, data, os.ModePerm); err != nil {
+		log.Panicf("error writing doc metrics: %v", err)
+	}
+	log.Printf("wrote doc metrics of %v packages to %v.", len(coverage.Values), docMetricsName)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Places a Sphinx project's conf.py is looked for, relative to the module root.
+var sphinxConfPaths = []string{
+	"zdocs/source/conf.py",
+	"zdocs/conf.py",
+	"docs/source/conf.py",
+	"docs/conf.py",
+}
+
+// Regex for a top level assignment of a string or a list of strings in conf.py,
+// capturing the name and the value.
+var confAssignmentRegex = regexp.MustCompile(`(?m)^([A-Za-z_][A-Za-z0-9_]*)\s*=\s*(.+?)\s*$`)
+
+// Regex for a quoted string in a conf.py value.
+var confStringRegex = regexp.MustCompile(`'([^']*)'|"([^"]*)"`)
+
+// Returns the literal string values assigned to top level names of a conf.py, by
+// name. A list's strings are all kept; values which are not literals are skipped.
+func readSphinxConf(data []byte) map[string][]string {
+	values := make(map[string][]string)
+	for _, match := range confAssignmentRegex.FindAllStringSubmatch(string(data), -1) {
+		value := match[2]
+		if !strings.HasPrefix(value, "'") && !strings.HasPrefix(value, `"`) &&
+			!strings.HasPrefix(value, "[") {
+			continue
+		}
+		strs := make([]string, 0)
+		for _, str := range confStringRegex.FindAllStringSubmatch(value, -1) {
+			strs = append(strs, str[1]+str[2])
+		}
+		values[match[1]] = strs
+	}
+	return values
+}
+
+// Returns the docmodule config equivalent to a Sphinx project with the conf.py at
+// confPath, relative to moduleRoot, and notes on what was carried over.
+func sphinxToConfig(moduleRoot string, confPath string, data []byte) (*Config, []string) {
+	conf := readSphinxConf(data)
+	sourceDir, err := filepath.Rel(moduleRoot, filepath.Dir(confPath))
+	if err != nil {
+		sourceDir = filepath.Dir(confPath)
+	}
+	sourceDir = filepath.ToSlash(sourceDir)
+
+	config := &Config{Flags: make(map[string]interface{})}
+	notes := make([]string, 0)
+	first := func(name string) string {
+		if strs := conf[name]; len(strs) > 0 {
+			return strs[0]
+		}
+		return ""
+	}
+
+	// docmodule was made to write godoc's pages into Sphinx's static directory, which
+	// is then served next to the Sphinx site.
+	staticPath := first("html_static_path")
+	if staticPath == "" {
+		staticPath = "_static"
+	}
+	config.Flags["build-path"] = sourceDir + "/" + staticPath
+	notes = append(notes, "build-path: "+sourceDir+"/"+staticPath+" from html_static_path")
+
+	if project := first("project"); project != "" {
+		config.Flags["site-name"] = project
+		notes = append(notes, "site-name: "+project+" from project")
+	}
+	version := first("release")
+	if version == "" {
+		version = first("version")
+	}
+	if version != "" {
+		config.Flags["doc-version"] = version
+		notes = append(notes, "doc-version: "+version+" from release")
+	}
+	if baseURL := first("html_baseurl"); baseURL != "" {
+		config.Flags["base-url"] = baseURL
+		notes = append(notes, "base-url: "+baseURL+" from html_baseurl")
+	}
+
+	markdown, _ := filepath.Glob(filepath.Join(filepath.Dir(confPath), "*.md"))
+	if len(markdown) > 0 {
+		config.Docs = sourceDir
+		notes = append(notes, "docs: "+sourceDir+", which holds markdown pages")
+	}
+	if theme := first("html_theme"); theme != "" {
+		notes = append(notes, "html_theme "+theme+" is not carried over, see --templates")
+	}
+	return config, notes
+}
+
+// Generates docmodule.json from the Sphinx project the module's docs were built with
+// so far, e.g. zdocs/source/conf.py.
+func runMigrateCommand(args []string) {
+	flags := newCommandFlagSet("migrate")
+	conf := flags.String(
+		"conf",
+		"",
+		"Path of the Sphinx project's conf.py. Defaults to the first of "+
+			strings.Join(sphinxConfPaths, ", ")+" which exists.",
+	)
+	force := flags.Bool(
+		"force",
+		false,
+		"Overwrite an existing "+configFileName+".",
+	)
+	_ = flags.Parse(args)
+
+	settings := new(Settings)
+	getEnvSettings(settings)
+
+	confPath := *conf
+	if confPath == "" {
+		for _, candidate := range sphinxConfPaths {
+			candidate = filepath.Join(settings.ModuleRootPath, filepath.FromSlash(candidate))
+			if exists, _ := fileExists(candidate); exists {
+				confPath = candidate
+				break
+			}
+		}
+	}
+	if confPath == "" {
+		log.Fatalf("no Sphinx conf.py found, set one with --conf")
+	}
+	data, err := ioutil.ReadFile(confPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	configPath := filepath.Join(settings.ModuleRootPath, configFileName)
+	if exists, _ := fileExists(configPath); exists && !*force {
+		log.Fatalf("%v already exists, use --force to overwrite it", configFileName)
+	}
+
+	config, notes := sphinxToConfig(settings.ModuleRootPath, confPath, data)
+	configData, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile(configPath, append(configData, '\n'), 0644); err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("wrote %v from %v:\n", configFileName, confPath)
+	for _, note := range notes {
+		fmt.Println("  " + note)
+	}
+}
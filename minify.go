@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+)
+
+// Regexes for the elements whose text minifying leaves alone, the comments it strips
+// and the whitespace it collapses.
+var (
+	minifyKeepRegex = regexp.MustCompile(
+		`(?is)<pre\b.*?</pre>|<textarea\b.*?</textarea>|<script\b.*?</script>|<style\b.*?</style>`,
+	)
+	minifyCommentRegex    = regexp.MustCompile(`(?s)<!--.*?-->`)
+	minifyWhitespaceRegex = regexp.MustCompile(`[ \t\r\n]*\n[ \t\r\n]*`)
+)
+
+// Returns the processor minifying every page with --minify.
+func newMinifyProcessor(ctx context.Context, runInfo *RunInfo) Processor {
+	if !runInfo.Settings.Minify {
+		return nil
+	}
+	return ProcessorFunc(func(page *Page) error {
+		page.Data = minifyHTML(page.Data)
+		return nil
+	})
+}
+
+// Strips the comments of a page, but for conditional ones, and collapses whitespace
+// spanning lines into a single newline, which renders the same. Preformatted text,
+// text areas, scripts and styles are kept as is.
+func minifyHTML(data []byte) []byte {
+	minified := make([]byte, 0, len(data))
+	minifyText := func(text []byte) []byte {
+		text = minifyCommentRegex.ReplaceAllFunc(text, func(comment []byte) []byte {
+			if bytes.HasPrefix(comment, []byte("<!--[if")) {
+				return comment
+			}
+			return nil
+		})
+		return minifyWhitespaceRegex.ReplaceAll(text, []byte("\n"))
+	}
+
+	last := 0
+	for _, match := range minifyKeepRegex.FindAllIndex(data, -1) {
+		minified = append(minified, minifyText(data[last:match[0]])...)
+		minified = append(minified, data[match[0]:match[1]]...)
+		last = match[1]
+	}
+	return append(minified, minifyText(data[last:])...)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestMinifyHTML(t *testing.T) {
+	cases := []struct {
+		name   string
+		input  string
+		output string
+	}{
+		{
+			name:   "whitespace spanning lines",
+			input:  "<ul>\n    <li>a</li>\n\n    <li>b  c</li>\n</ul>",
+			output: "<ul>\n<li>a</li>\n<li>b  c</li>\n</ul>",
+		},
+		{
+			name:   "comments",
+			input:  "<p>a<!-- note\n -->b<!--[if IE]>ie<![endif]--></p>",
+			output: "<p>ab<!--[if IE]>ie<![endif]--></p>",
+		},
+		{
+			name:   "preformatted text",
+			input:  "<pre>\n  a\n\n  b <!-- kept -->\n</pre>\n\n<p>c</p>",
+			output: "<pre>\n  a\n\n  b <!-- kept -->\n</pre>\n<p>c</p>",
+		},
+		{
+			name:   "scripts and styles",
+			input:  "<script>\n  a = 1\n</script>\n  <STYLE>\n  p {}\n</STYLE>",
+			output: "<script>\n  a = 1\n</script>\n<STYLE>\n  p {}\n</STYLE>",
+		},
+	}
+	for _, testCase := range cases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if got := string(minifyHTML([]byte(testCase.input))); got != testCase.output {
+				t.Errorf("minifyHTML(%q) = %q, want %q", testCase.input, got, testCase.output)
+			}
+		})
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"go/ast"
+	"go/doc"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"golang.org/x/xerrors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Name of the doc model written to the root of the build directory.
+const docModelName = "model.json"
+
+// DocModel is the documentation of a module extracted from its source, independent
+// of any output format. It is persisted as model.json so other commands can work
+// from it without extracting the docs again.
+type DocModel struct {
+	Module string `json:"module"`
+	// Version or git ref the model was extracted at.
+	Version  string          `json:"version,omitempty"`
+	Packages []*ModelPackage `json:"packages"`
+}
+
+// ModelPackage is a package of the doc model.
+type ModelPackage struct {
+	ImportPath string         `json:"importPath"`
+	Name       string         `json:"name"`
+	Synopsis   string         `json:"synopsis,omitempty"`
+	Doc        string         `json:"doc,omitempty"`
+	Symbols    []*ModelSymbol `json:"symbols"`
+}
+
+// ModelSymbol is an exported symbol of the module's API.
+type ModelSymbol struct {
+	ImportPath string `json:"importPath"`
+	// Name of the symbol, Type.Method for methods.
+	Name string `json:"name"`
+	// func, method, type, const or var.
+	Kind string `json:"kind"`
+	// Declaration of the symbol without its doc comment or body.
+	Signature string `json:"signature"`
+	Doc       string `json:"doc,omitempty"`
+	// File declaring the symbol, relative to the module root, and line of its name.
+	File string `json:"file,omitempty"`
+	Line int    `json:"line,omitempty"`
+}
+
+// Key identifying the symbol across versions.
+func (symbol *ModelSymbol) key() string {
+	return symbol.ImportPath + "." + symbol.Name
+}
+
+// Returns the model's symbols by key.
+func (model *DocModel) symbols() map[string]*ModelSymbol {
+	symbols := make(map[string]*ModelSymbol)
+	for _, pkg := range model.Packages {
+		for _, symbol := range pkg.Symbols {
+			symbols[symbol.key()] = symbol
+		}
+	}
+	return symbols
+}
+
+// Reports whether importPath is an internal package, which other modules can't
+// import.
+func isInternalPackage(importPath string) bool {
+	return strings.HasSuffix(importPath, "/internal") || strings.Contains(importPath, "/internal/")
+}
+
+// Returns the symbols of the model's API by key: those of its packages other modules
+// can import, like apidiff and gorelease compare.
+func (model *DocModel) apiSymbols() map[string]*ModelSymbol {
+	symbols := make(map[string]*ModelSymbol)
+	for key, symbol := range model.symbols() {
+		if !isInternalPackage(symbol.ImportPath) {
+			symbols[key] = symbol
+		}
+	}
+	return symbols
+}
+
+// Writes model as JSON to destPath.
+func writeDocModel(model *DocModel, destPath string) error {
+	data, err := json.MarshalIndent(model, "", "  ")
+	if err != nil {
+		return xerrors.Errorf("error encoding doc model: %w", err)
+	}
+	if err := ioutil.WriteFile(destPath, data, os.ModePerm); err != nil {
+		return xerrors.Errorf("error writing doc model: %w", err)
+	}
+	return nil
+}
+
+// Reads a doc model written by writeDocModel.
+func readDocModel(filePath string) (*DocModel, error) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, xerrors.Errorf("error reading doc model: %w", err)
+	}
+	model := new(DocModel)
+	if err := json.Unmarshal(data, model); err != nil {
+		return nil, xerrors.Errorf("error parsing doc model: %w", err)
+	}
+	return model, nil
+}
+
+// Prints node as Go source.
+func formatNode(fileSet *token.FileSet, node interface{}) string {
+	buffer := new(bytes.Buffer)
+	if err := printer.Fprint(buffer, fileSet, node); err != nil {
+		log.Panicf("error printing declaration: %v", err)
+	}
+	return buffer.String()
+}
+
+// Returns the declaration of a function without its doc comment and body.
+func funcSignature(fileSet *token.FileSet, decl *ast.FuncDecl) string {
+	signature := *decl
+	signature.Doc = nil
+	signature.Body = nil
+	return formatNode(fileSet, &signature)
+}
+
+// Returns the exported symbols of a package in the directory relDir of the module.
+func packageSymbols(
+	fileSet *token.FileSet, pkg *doc.Package, importPath string, relDir string,
+) []*ModelSymbol {
+	symbols := make([]*ModelSymbol, 0)
+	// Sets where the symbol is declared from the position of its name.
+	declaredAt := func(symbol *ModelSymbol, name *ast.Ident) *ModelSymbol {
+		position := fileSet.Position(name.Pos())
+		symbol.File = path.Join(filepath.ToSlash(relDir), filepath.Base(position.Filename))
+		symbol.Line = position.Line
+		return symbol
+	}
+
+	addValues := func(kind string, values []*doc.Value) {
+		for _, value := range values {
+			for _, spec := range value.Decl.Specs {
+				valueSpec := *spec.(*ast.ValueSpec)
+				valueSpec.Doc = nil
+				valueSpec.Comment = nil
+				signature := kind + " " + formatNode(fileSet, &valueSpec)
+				for _, name := range valueSpec.Names {
+					if name.IsExported() {
+						symbols = append(symbols, declaredAt(&ModelSymbol{
+							ImportPath: importPath,
+							Name:       name.Name,
+							Kind:       kind,
+							Signature:  signature,
+							Doc:        value.Doc,
+						}, name))
+					}
+				}
+			}
+		}
+	}
+	addFuncs := func(funcs []*doc.Func) {
+		for _, function := range funcs {
+			symbols = append(symbols, declaredAt(&ModelSymbol{
+				ImportPath: importPath,
+				Name:       function.Name,
+				Kind:       "func",
+				Signature:  funcSignature(fileSet, function.Decl),
+				Doc:        function.Doc,
+			}, function.Decl.Name))
+		}
+	}
+
+	addValues("const", pkg.Consts)
+	addValues("var", pkg.Vars)
+	addFuncs(pkg.Funcs)
+	for _, docType := range pkg.Types {
+		for _, spec := range docType.Decl.Specs {
+			typeSpec := *spec.(*ast.TypeSpec)
+			if typeSpec.Name.Name != docType.Name {
+				continue
+			}
+			typeSpec.Doc = nil
+			typeSpec.Comment = nil
+			symbols = append(symbols, declaredAt(&ModelSymbol{
+				ImportPath: importPath,
+				Name:       docType.Name,
+				Kind:       "type",
+				Signature:  "type " + formatNode(fileSet, &typeSpec),
+				Doc:        docType.Doc,
+			}, typeSpec.Name))
+		}
+		addValues("const", docType.Consts)
+		addValues("var", docType.Vars)
+		addFuncs(docType.Funcs)
+		for _, method := range docType.Methods {
+			symbols = append(symbols, declaredAt(&ModelSymbol{
+				ImportPath: importPath,
+				Name:       docType.Name + "." + method.Name,
+				Kind:       "method",
+				Signature:  funcSignature(fileSet, method.Decl),
+				Doc:        method.Doc,
+			}, method.Decl.Name))
+		}
+	}
+	return symbols
+}
+
+// Extracts the doc model of the module being built, without the packages of the
+// ignore file, which every output of the build leaves out.
+func loadBuildDocModel(settings *Settings) (*DocModel, error) {
+	model, err := loadDocModel(settings.docSourceRoot())
+	if err != nil {
+		return nil, err
+	}
+	packages := model.Packages[:0]
+	for _, pkg := range model.Packages {
+		if !settings.ignored(pkg.ImportPath) {
+			packages = append(packages, pkg)
+		}
+	}
+	model.Packages = packages
+	return model, nil
+}
+
+// Extracts the doc model of every non-main package of the module in moduleDir.
+func loadDocModel(moduleDir string) (*DocModel, error) {
+	goMod, err := ioutil.ReadFile(filepath.Join(moduleDir, "go.mod"))
+	if err != nil {
+		return nil, xerrors.Errorf("error reading go.mod: %w", err)
+	}
+	match := modNameRegex.FindSubmatch(goMod)
+	if match == nil {
+		return nil, xerrors.New("go.mod has no module directive")
+	}
+	model := &DocModel{Module: string(match[1])}
+	err = filepath.Walk(moduleDir, func(dir string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		name := info.Name()
+		if dir != moduleDir &&
+			(name == "testdata" || name == "vendor" ||
+				strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+			return filepath.SkipDir
+		}
+		if dir != moduleDir {
+			// Nested modules are not part of this module's API.
+			if exists, _ := fileExists(filepath.Join(dir, "go.mod")); exists {
+				return filepath.SkipDir
+			}
+		}
+
+		relDir, err := filepath.Rel(moduleDir, dir)
+		if err != nil {
+			return err
+		}
+		importPath := path.Join(model.Module, filepath.ToSlash(relDir))
+
+		fileSet := token.NewFileSet()
+		packages, err := parser.ParseDir(
+			fileSet,
+			dir,
+			func(info os.FileInfo) bool { return !strings.HasSuffix(info.Name(), "_test.go") },
+			parser.ParseComments,
+		)
+		if err != nil {
+			return xerrors.Errorf("error parsing %v: %w", importPath, err)
+		}
+		for pkgName, pkg := range packages {
+			if pkgName == "main" {
+				continue
+			}
+			docPackage := doc.New(pkg, importPath, 0)
+			model.Packages = append(model.Packages, &ModelPackage{
+				ImportPath: importPath,
+				Name:       docPackage.Name,
+				Synopsis:   doc.Synopsis(docPackage.Doc),
+				Doc:        docPackage.Doc,
+				Symbols:    packageSymbols(fileSet, docPackage, importPath, relDir),
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return model, nil
+}
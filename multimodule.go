@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"golang.org/x/xerrors"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// A module of `docmodule modules`, built into a subdirectory of the build directory.
+type familyModule struct {
+	ModName string
+	// Subdirectory of the build directory the module is built into.
+	Dir     string
+	Version string
+	Error   string
+}
+
+var familyIndexTemplate = template.Must(template.New("family-index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta http-equiv="Content-Type" content="text/html; charset=utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<table>
+<tr><th>Module</th><th>Version</th></tr>
+{{range .Modules}}<tr>
+<td>{{if .Error}}{{.ModName}}{{else}}<a href="{{.Dir}}/index.html">{{.ModName}}</a>{{end}}</td>
+<td>{{if .Error}}build failed{{else}}{{.Version}}{{end}}</td>
+</tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// Expands the module roots and globs of patterns into the directories they match.
+func expandModuleRoots(patterns []string) ([]string, error) {
+	roots := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, xerrors.Errorf("invalid module root %q: %w", pattern, err)
+		}
+		for _, match := range matches {
+			root, err := filepath.Abs(match)
+			if err != nil {
+				return nil, err
+			}
+			if info, err := os.Stat(root); err != nil || !info.IsDir() || seen[root] {
+				continue
+			}
+			seen[root] = true
+			roots = append(roots, root)
+		}
+	}
+	return roots, nil
+}
+
+// Returns the module path of the module at root, or the name of the directory if it
+// has no go.mod.
+func familyModuleName(root string) string {
+	goMod, err := ioutil.ReadFile(filepath.Join(root, "go.mod"))
+	if err == nil {
+		if match := modNameRegex.FindSubmatch(goMod); match != nil {
+			return string(match[1])
+		}
+	}
+	return filepath.Base(root)
+}
+
+// Builds the docs of several modules into subdirectories of a build directory, each
+// with the build flags given after "--", and writes an index page linking them.
+func runModulesCommand(args []string) {
+	flags := newCommandFlagSet("modules")
+	flags.Usage = func() {
+		out := flags.Output()
+		fmt.Fprintln(out, "usage: docmodule modules [flags] <module root or glob>... [-- <build flags>]")
+		fmt.Fprintln(out)
+		fmt.Fprintln(out, "Builds the docs of every module into a subdirectory of the build")
+		fmt.Fprintln(out, "directory, named after the last element of its module path, and links")
+		fmt.Fprintln(out, "them from an index page.")
+		fmt.Fprintln(out)
+		fmt.Fprintln(out, "flags:")
+		flags.PrintDefaults()
+	}
+	buildPath := flags.String(
+		"build-path",
+		"zdocs/source/_static",
+		"Directory to build the modules into.",
+	)
+	baseURL := flags.String(
+		"base-url",
+		"",
+		"URL the build directory will be hosted at. Every module is built with "+
+			"--base-url set to its subdirectory of it.",
+	)
+	title := flags.String(
+		"title",
+		"Modules",
+		"Title of the index page.",
+	)
+	_ = flags.Parse(args)
+
+	patterns := flags.Args()
+	buildArgs := make([]string, 0)
+	for i, arg := range patterns {
+		if arg == "--" {
+			buildArgs = patterns[i+1:]
+			patterns = patterns[:i]
+			break
+		}
+	}
+	for _, name := range []string{"build-path", "module-root", "base-url"} {
+		if hasFlagArg(buildArgs, name) {
+			log.Fatalf("--%v is set by docmodule modules for every module", name)
+		}
+	}
+	roots, err := expandModuleRoots(patterns)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(roots) == 0 {
+		log.Fatal("no module roots given or matched")
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.RemoveAll(*buildPath); err != nil {
+		log.Fatal(xerrors.Errorf("error clearing build directory: %w", err))
+	}
+	createBuildDir(*buildPath)
+
+	modules := make([]*familyModule, 0, len(roots))
+	dirs := make(map[string]bool)
+	failed := 0
+	for _, root := range roots {
+		module := &familyModule{ModName: familyModuleName(root)}
+		module.Dir = path.Base(module.ModName)
+		for i := 2; dirs[module.Dir]; i++ {
+			module.Dir = fmt.Sprintf("%v-%v", path.Base(module.ModName), i)
+		}
+		dirs[module.Dir] = true
+		modules = append(modules, module)
+
+		moduleBuildPath := filepath.Join(*buildPath, module.Dir)
+		moduleArgs := []string{"-module-root", root, "-build-path", moduleBuildPath}
+		if *baseURL != "" {
+			moduleURL := strings.TrimSuffix(*baseURL, "/") + "/" + module.Dir
+			moduleArgs = append(moduleArgs, "-base-url", moduleURL)
+		}
+		command := exec.Command(executable, append(moduleArgs, buildArgs...)...)
+		command.Stdout = os.Stdout
+		command.Stderr = os.Stderr
+
+		log.Printf("building %v into %v.", module.ModName, moduleBuildPath)
+		if err := command.Run(); err != nil {
+			module.Error = err.Error()
+			failed++
+			log.Printf("error building %v: %v", module.ModName, err)
+			continue
+		}
+		if info, err := readBuildInfo(moduleBuildPath); err == nil {
+			module.Version = info.Version
+		}
+	}
+
+	buffer := new(bytes.Buffer)
+	err = familyIndexTemplate.Execute(buffer, map[string]interface{}{
+		"Title":   *title,
+		"Modules": modules,
+	})
+	if err != nil {
+		log.Fatal(xerrors.Errorf("error rendering index page: %w", err))
+	}
+	indexPath := filepath.Join(*buildPath, "index.html")
+	if err := ioutil.WriteFile(indexPath, buffer.Bytes(), os.ModePerm); err != nil {
+		log.Fatal(xerrors.Errorf("error writing index page: %w", err))
+	}
+
+	if failed > 0 {
+		log.Fatalf("%v of %v modules failed to build", failed, len(modules))
+	}
+	log.Printf("built %v modules into %v.", len(modules), *buildPath)
+}
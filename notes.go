@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"html"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Regex a note marker must match to be picked up by go/doc, e.g. BUG or SECURITY.
+var noteMarkerRegex = regexp.MustCompile(`^[A-Z][A-Z]+$`)
+
+// Regex for the notes section godoc renders for a marker, capturing the marker and
+// the section's list items.
+var noteSectionRegex = regexp.MustCompile(
+	`(?s)<h2 id="pkg-note-([A-Z]+)">.*?</h2>\s*<ul[^>]*>(.*?)</ul>`,
+)
+
+// Regex for a single note of a notes section.
+var noteItemRegex = regexp.MustCompile(`(?s)<li>(.*?)</li>`)
+
+// Regex for a paragraph of a doc comment marking its symbol as deprecated.
+var deprecatedRegex = regexp.MustCompile(`(?s)<p>\s*Deprecated:.*?</p>`)
+
+// Notes of one marker found on a package's page.
+type notePackage struct {
+	ImportPath string
+	// Link to the package's page, relative to the notes page.
+	Link  string
+	Notes []template.HTML
+}
+
+// A deprecated symbol found on a package's page.
+type deprecatedSymbol struct {
+	ImportPath string
+	// Symbol, empty if the package itself is deprecated.
+	Symbol string
+	// Link to the symbol, relative to the notes page.
+	Link    string
+	Message string
+}
+
+// Notes of every marker and deprecations found across the module.
+type moduleNotes struct {
+	Markers    map[string][]*notePackage
+	Deprecated []*deprecatedSymbol
+}
+
+var notesTemplate = template.Must(template.New("notes").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta http-equiv="Content-Type" content="text/html; charset=utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Module}} - {{.Marker}} notes</title>
+<link type="text/css" rel="stylesheet" href="{{.Stylesheet}}">
+<link type="text/css" rel="stylesheet" href="{{.NavStylesheet}}">
+</head>
+<body>
+<div id="page" class="wide">
+<div class="container">
+<h1>{{.Marker}} notes of {{.Module}}</h1>
+{{range .Packages}}
+<h2 id="{{.ImportPath}}"><a href="{{.Link}}">{{.ImportPath}}</a></h2>
+<ul class="docmodule-notes">
+{{range .Notes}}<li>{{.}}</li>
+{{end}}</ul>
+{{else}}
+<p>No {{.Marker}} notes.</p>
+{{end}}
+</div>
+</div>
+</body>
+</html>
+`))
+
+// Name of the page aggregating all notes and deprecations of the module.
+const allNotesPageName = "notes.html"
+
+var allNotesTemplate = template.Must(template.New("all-notes").Funcs(template.FuncMap{
+	"calloutClass": calloutClass,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta http-equiv="Content-Type" content="text/html; charset=utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Module}} - Notes</title>
+<link type="text/css" rel="stylesheet" href="{{.Stylesheet}}">
+<link type="text/css" rel="stylesheet" href="{{.NavStylesheet}}">
+</head>
+<body>
+<div id="page" class="wide">
+<div class="container">
+<h1>Notes of {{.Module}}</h1>
+<h2 id="deprecated">Deprecated</h2>
+{{range .Notes.Deprecated}}
+<div class="{{calloutClass "deprecated"}}">
+<a href="{{.Link}}">{{.ImportPath}}{{if .Symbol}}.{{.Symbol}}{{end}}</a>: {{.Message}}
+</div>
+{{else}}
+<p>Nothing is deprecated.</p>
+{{end}}
+{{range $marker := .Markers}}
+<h2 id="{{$marker}}">{{$marker}}</h2>
+{{range index $.Notes.Markers $marker}}
+<div class="{{calloutClass $marker}}">
+<h3><a href="{{.Link}}">{{.ImportPath}}</a></h3>
+<ul class="docmodule-notes">
+{{range .Notes}}<li>{{.}}</li>
+{{end}}</ul>
+</div>
+{{else}}
+<p>No {{$marker}} notes.</p>
+{{end}}
+{{end}}
+</div>
+</div>
+</body>
+</html>
+`))
+
+// Returns the godoc -notes regex matching the configured markers.
+func notesFlagValue(markers []string) string {
+	quoted := make([]string, len(markers))
+	for i, marker := range markers {
+		quoted[i] = regexp.QuoteMeta(marker)
+	}
+	return strings.Join(quoted, "|")
+}
+
+// Prefix of the names of the pages aggregating the notes of a marker.
+const notesPagePrefix = "notes-"
+
+// Returns the name of the page aggregating the notes of marker.
+func notesPageName(marker string) string {
+	return notesPagePrefix + strings.ToLower(marker) + ".html"
+}
+
+// Returns the markers whose notes are aggregated: the configured ones, or godoc's
+// default of BUG.
+func aggregatedNoteMarkers(settings *Settings) []string {
+	if len(settings.NoteMarkers) > 0 {
+		return settings.NoteMarkers
+	}
+	return []string{"BUG"}
+}
+
+// Returns the id of the last symbol heading before offset, or an empty string when
+// offset is in the package's overview.
+func enclosingSymbol(data []byte, offset int) string {
+	symbol := ""
+	for _, match := range symbolHeadingRegex.FindAllSubmatchIndex(data[:offset], -1) {
+		symbol = string(data[match[4]:match[5]])
+	}
+	if strings.HasPrefix(symbol, "pkg-") {
+		return ""
+	}
+	return symbol
+}
+
+// Collects the notes of every marker and the deprecations from the package pages.
+func collectNotes(runInfo *RunInfo) *moduleNotes {
+	settings := runInfo.Settings
+	notes := &moduleNotes{Markers: make(map[string][]*notePackage)}
+
+	for _, info := range readingOrder(runInfo) {
+		data, err := ioutil.ReadFile(settings.BuildDir + "/" + info.NewRelPath)
+		if err != nil {
+			log.Panicf("error opening file '%v': %v", info.NewRelPath, err)
+		}
+
+		for _, section := range noteSectionRegex.FindAllSubmatch(data, -1) {
+			marker := string(section[1])
+			pkg := &notePackage{ImportPath: info.ImportPath, Link: info.NewRelPath}
+			for _, item := range noteItemRegex.FindAllSubmatch(section[2], -1) {
+				pkg.Notes = append(pkg.Notes, template.HTML(bytes.TrimSpace(item[1])))
+			}
+			notes.Markers[marker] = append(notes.Markers[marker], pkg)
+		}
+
+		for _, location := range deprecatedRegex.FindAllIndex(data, -1) {
+			symbol := enclosingSymbol(data, location[0])
+			link := info.NewRelPath
+			if symbol != "" {
+				link += "#" + symbol
+			}
+			message := htmlText(data[location[0]:location[1]])
+			notes.Deprecated = append(notes.Deprecated, &deprecatedSymbol{
+				ImportPath: info.ImportPath,
+				Symbol:     symbol,
+				Link:       link,
+				Message:    html.UnescapeString(strings.TrimPrefix(message, "Deprecated: ")),
+			})
+		}
+	}
+	return notes
+}
+
+// Writes one page per configured marker listing its notes by package and, with
+// --note-callouts, a page aggregating all notes and deprecations of the module.
+func generateNotesPages(runInfo *RunInfo) {
+	settings := runInfo.Settings
+	if len(settings.NoteMarkers) == 0 && !settings.NoteCallouts {
+		return
+	}
+	notes := collectNotes(runInfo)
+	writeNavStylesheet(settings)
+
+	for _, marker := range settings.NoteMarkers {
+		buffer := new(bytes.Buffer)
+		err := pageTemplate(runInfo, notesTemplate).Execute(buffer, map[string]interface{}{
+			"Module":        settings.ModName,
+			"Marker":        marker,
+			"Stylesheet":    selectBackend(settings).SentinelAsset(),
+			"NavStylesheet": navStylesheetName,
+			"Packages":      notes.Markers[marker],
+		})
+		if err != nil {
+			log.Panicf("error rendering notes page: %v", err)
+		}
+
+		notesPath := path.Join(settings.BuildDir, notesPageName(marker))
+		if err := ioutil.WriteFile(notesPath, buffer.Bytes(), os.ModePerm); err != nil {
+			log.Panicf("error writing notes page: %v", err)
+		}
+		runInfo.HtmlFiles = append(runInfo.HtmlFiles, notesPath)
+	}
+
+	if !settings.NoteCallouts {
+		return
+	}
+
+	buffer := new(bytes.Buffer)
+	err := pageTemplate(runInfo, allNotesTemplate).Execute(buffer, map[string]interface{}{
+		"Module":        settings.ModName,
+		"Stylesheet":    selectBackend(settings).SentinelAsset(),
+		"NavStylesheet": navStylesheetName,
+		"Markers":       aggregatedNoteMarkers(settings),
+		"Notes":         notes,
+	})
+	if err != nil {
+		log.Panicf("error rendering notes page: %v", err)
+	}
+
+	notesPath := path.Join(settings.BuildDir, allNotesPageName)
+	if err := ioutil.WriteFile(notesPath, buffer.Bytes(), os.ModePerm); err != nil {
+		log.Panicf("error writing notes page: %v", err)
+	}
+	runInfo.HtmlFiles = append(runInfo.HtmlFiles, notesPath)
+}
+
+// Returns the class of the callout box for notes of marker.
+func calloutClass(marker string) string {
+	return "docmodule-callout docmodule-callout-" + strings.ToLower(marker)
+}
+
+// Returns the processor wrapping the notes sections and deprecation paragraphs of
+// every package page in styled callout boxes so they stand out from the rest of the
+// docs.
+func newNoteCalloutsProcessor(ctx context.Context, runInfo *RunInfo) Processor {
+	settings := runInfo.Settings
+	if !settings.NoteCallouts {
+		return nil
+	}
+
+	writeNavStylesheet(settings)
+
+	return ProcessorFunc(func(page *Page) error {
+		data := noteSectionRegex.ReplaceAllFunc(page.Data, func(section []byte) []byte {
+			marker := string(noteSectionRegex.FindSubmatch(section)[1])
+			return []byte(`<div class="` + calloutClass(marker) + `">` +
+				string(section) + "</div>")
+		})
+		data = deprecatedRegex.ReplaceAllFunc(data, func(paragraph []byte) []byte {
+			return []byte(`<div class="` + calloutClass("deprecated") + `">` +
+				string(paragraph) + "</div>")
+		})
+		page.Data = linkNavStylesheet(data, path.Dir(page.RelPath))
+		return nil
+	})
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Regexes for the references of a page to other files: the attributes of its
+// elements, and the urls of its styles and of the stylesheets of the build.
+var (
+	referenceAttrRegex = regexp.MustCompile(
+		`(?i)\s(?:href|src|action|poster|data)\s*=\s*(?:"([^"]*)"|'([^']*)')`,
+	)
+	srcsetAttrRegex  = regexp.MustCompile(`(?i)\ssrcset\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+	cssURLRegex      = regexp.MustCompile(`url\(\s*(?:"([^"]*)"|'([^']*)'|([^)'"]*))\s*\)`)
+	htmlCommentRegex = regexp.MustCompile(`(?s)<!--.*?-->`)
+)
+
+// Returns the first non-empty group of each match of regex in data.
+func matchedReferences(regex *regexp.Regexp, data []byte) []string {
+	references := make([]string, 0)
+	for _, match := range regex.FindAllSubmatch(data, -1) {
+		for _, group := range match[1:] {
+			if len(group) > 0 {
+				references = append(references, strings.TrimSpace(string(group)))
+				break
+			}
+		}
+	}
+	return references
+}
+
+// Returns the references of an HTML page or a stylesheet to other files and urls.
+func fileReferences(filePath string, data []byte) []string {
+	if filepath.Ext(filePath) == ".css" {
+		return matchedReferences(cssURLRegex, data)
+	}
+
+	// Markup in comments is never loaded.
+	data = htmlCommentRegex.ReplaceAll(data, nil)
+	references := matchedReferences(referenceAttrRegex, data)
+	for _, srcset := range matchedReferences(srcsetAttrRegex, data) {
+		for _, candidate := range strings.Split(srcset, ",") {
+			if fields := strings.Fields(candidate); len(fields) > 0 {
+				references = append(references, fields[0])
+			}
+		}
+	}
+	return append(references, matchedReferences(cssURLRegex, data)...)
+}
+
+// Checks a reference of the file at filePath, returning why it doesn't resolve
+// inside the build directory, or an empty string if it does. Links into --base-url
+// are resolved against the build directory, which is published there.
+func checkOfflineReference(settings *Settings, filePath string, reference string) string {
+	parsed, err := url.Parse(reference)
+	if err != nil {
+		return "invalid reference " + reference
+	}
+	switch parsed.Scheme {
+	case "data", "javascript", "mailto", "about":
+		// Inline content or no request at all.
+		return ""
+	}
+
+	target := ""
+	baseURL := strings.TrimSuffix(settings.BaseURL, "/") + "/"
+	switch {
+	case settings.BaseURL != "" && strings.HasPrefix(reference, baseURL):
+		baseParsed, err := url.Parse(strings.TrimPrefix(reference, baseURL))
+		if err != nil {
+			return "invalid reference " + reference
+		}
+		target = filepath.Join(settings.BuildDir, filepath.FromSlash(baseParsed.Path))
+	case parsed.Host != "" && parsed.Host == settings.ServerHost:
+		return "reference " + reference + " to the doc server"
+	case parsed.Scheme != "" || parsed.Host != "":
+		return "external reference " + reference
+	case strings.HasPrefix(parsed.Path, "/"):
+		return "reference " + reference + " to the root of the server, not a file of the build"
+	case parsed.Path == "":
+		// A fragment or a query of the page itself.
+		return ""
+	default:
+		target = filepath.Join(filepath.Dir(filePath), filepath.FromSlash(parsed.Path))
+	}
+
+	buildDir := filepath.Clean(settings.BuildDir)
+	if target != buildDir && !strings.HasPrefix(target, buildDir+string(filepath.Separator)) {
+		return "reference " + reference + " outside of the build directory"
+	}
+	info, err := os.Stat(target)
+	if err == nil && info.IsDir() {
+		info, err = os.Stat(filepath.Join(target, "index.html"))
+	}
+	if err != nil {
+		return "reference " + reference + " to a missing file"
+	}
+	return ""
+}
+
+// Checks that every page and stylesheet of the build only references files inside
+// the build directory, so the docs work without the doc server or a network, and
+// records the references which don't as problems.
+func verifyOfflineBuild(runInfo *RunInfo) {
+	settings := runInfo.Settings
+	checked := 0
+	err := filepath.Walk(settings.BuildDir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		extension := filepath.Ext(filePath)
+		if info.IsDir() || (extension != ".html" && extension != ".css") {
+			return nil
+		}
+		data, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+
+		checked++
+		settings.Progress.Add(1, 0)
+		relPath := pageRelPath(settings, filePath)
+		seen := make(map[string]bool)
+		for _, reference := range fileReferences(filePath, data) {
+			if seen[reference] {
+				continue
+			}
+			seen[reference] = true
+			if message := checkOfflineReference(settings, filePath, reference); message != "" {
+				runInfo.Summary.AddProblem(relPath, message)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Panicf("error verifying offline build: %v", err)
+	}
+	log.Printf("verified the references of %v files for offline use.", checked)
+}
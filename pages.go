@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"golang.org/x/xerrors"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+)
+
+// Name of the file mapping generated pages to the packages they document, written to
+// the root of the build directory.
+const pageIndexName = "pages.json"
+
+// PageIndexEntry maps one generated page to the package it documents.
+type PageIndexEntry struct {
+	// Path of the page relative to the build directory, slash-separated.
+	Path string `json:"path"`
+	// Import path of the documented package.
+	ImportPath string `json:"importPath"`
+}
+
+// Writes pages.json listing every package page of the build.
+func writePageIndex(runInfo *RunInfo) {
+	entries := make([]*PageIndexEntry, 0)
+	for _, info := range runInfo.DocFileInfo {
+		if info.ImportPath == "" {
+			continue
+		}
+		entries = append(
+			entries, &PageIndexEntry{Path: info.NewRelPath, ImportPath: info.ImportPath},
+		)
+	}
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		log.Panicf("error encoding page index: %v", err)
+	}
+
+	path := runInfo.Settings.BuildDir + "/" + pageIndexName
+	if err := ioutil.WriteFile(path, data, os.ModePerm); err != nil {
+		log.Panicf("error writing page index: %v", err)
+	}
+}
+
+// Reads pages.json from buildDir, returning page path -> import path.
+func readPageIndex(buildDir string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(buildDir + "/" + pageIndexName)
+	if err != nil {
+		return nil, xerrors.Errorf("error reading page index: %w", err)
+	}
+
+	entries := make([]*PageIndexEntry, 0)
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, xerrors.Errorf("error parsing page index: %w", err)
+	}
+
+	pages := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		pages[entry.Path] = entry.ImportPath
+	}
+	return pages, nil
+}
+
+// Reports whether importPath matches a package pattern. Patterns ending in "/..."
+// match the package and everything below it, like the go tool's patterns.
+func matchPackagePattern(pattern string, importPath string) bool {
+	if strings.HasSuffix(pattern, "/...") {
+		root := strings.TrimSuffix(pattern, "/...")
+		return importPath == root || strings.HasPrefix(importPath, root+"/")
+	}
+	return pattern == importPath
+}
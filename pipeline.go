@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"golang.org/x/xerrors"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"plugin"
+	"strings"
+)
+
+// Page is an HTML page of the build going through the processor pipeline.
+type Page struct {
+	// Path of the page relative to the build directory, slash-separated. The page is
+	// moved if a processor changes it.
+	RelPath string
+	// File the page was renamed from, nil for pages which don't document a package.
+	Info *DocFileInfo
+	// Contents of the page, written back once the processor returns. The page is
+	// removed if a processor sets it to nil.
+	Data []byte
+}
+
+// Package documented by the page, empty for other pages.
+func (page *Page) ImportPath() string {
+	if page.Info == nil {
+		return ""
+	}
+	return page.Info.ImportPath
+}
+
+// Processor is a stage of the pipeline transforming the pages of the build, e.g.
+// rewriting links or injecting the sidebar.
+type Processor interface {
+	Process(page *Page) error
+}
+
+// ProcessorFunc adapts a function to Processor.
+type ProcessorFunc func(page *Page) error
+
+func (process ProcessorFunc) Process(page *Page) error {
+	return process(page)
+}
+
+// A stage of the pipeline.
+type processorStage struct {
+	Name string
+	// Whether the stage processes every HTML page rather than only package pages.
+	AllPages bool
+	// Whether the stage processes the pages as scraped from the doc server, at the
+	// root of the build directory, rather than the pages of the build.
+	Scraped bool
+	// Returns the processor of a build, or nil if the stage is disabled or has done
+	// its work without processing pages one by one. Called once the previous stages
+	// have processed every page.
+	New func(ctx context.Context, runInfo *RunInfo) Processor
+}
+
+// Built-in stages, in the order they run. Builds run them along with the processors
+// of their config, see buildProcessorStages.
+var processorStages = []*processorStage{
+	{Name: "rename", Scraped: true, New: newRenameProcessor},
+	{Name: "pages", New: newGeneratedPagesStage},
+	{Name: "dedupe-assets", AllPages: true, New: newDedupeAssetsProcessor},
+	{Name: "sanitize", AllPages: true, New: newSanitizeProcessor},
+	{Name: "note-callouts", New: newNoteCalloutsProcessor},
+	{Name: "comment-tables", New: newCommentTablesProcessor},
+	{Name: "verify-examples", New: newVerifyExamplesProcessor},
+	{Name: "examples", New: newExamplesProcessor},
+	{Name: "implementations", New: newImplementationsProcessor},
+	{Name: "anchors", New: newAnchorsProcessor},
+	{Name: "links", AllPages: true, New: newLinksProcessor},
+	{Name: "sidebar", AllPages: true, New: newSidebarProcessor},
+	{Name: "toc", New: newTOCProcessor},
+	{Name: "meta-tags", AllPages: true, New: newMetaTagsProcessor},
+	{Name: "type-popovers", New: newTypePopoversProcessor},
+	{Name: "expand-controls", New: newExpandControlsProcessor},
+	{Name: "asset-hashes", AllPages: true, New: newAssetHashesProcessor},
+	{Name: "base-url", AllPages: true, New: newBaseURLProcessor},
+	{Name: "symbol-links", AllPages: true, New: newSymbolLinksProcessor},
+	{Name: "generator", AllPages: true, New: newGeneratorProcessor},
+	{Name: "csp", AllPages: true, New: newCSPProcessor},
+	{Name: "minify", AllPages: true, New: newMinifyProcessor},
+	{Name: "text-only", AllPages: true, New: newTextOnlyProcessor},
+}
+
+// Returns a copy of stages with stage inserted right after the stage named after, or
+// at the end if after is empty.
+func insertProcessorStage(
+	stages []*processorStage, stage *processorStage, after string,
+) ([]*processorStage, error) {
+	if after == "" {
+		return append(append([]*processorStage{}, stages...), stage), nil
+	}
+	for i, existing := range stages {
+		if existing.Name == after {
+			inserted := append([]*processorStage{}, stages[:i+1]...)
+			inserted = append(inserted, stage)
+			return append(inserted, stages[i+1:]...), nil
+		}
+	}
+	return nil, xerrors.Errorf("no processor stage %q to insert %q after", after, stage.Name)
+}
+
+// Returns the names of the stages, in order.
+func processorNames() []string {
+	names := make([]string, len(processorStages))
+	for i, stage := range processorStages {
+		names[i] = stage.Name
+	}
+	return names
+}
+
+// ConfigProcessor is a processor supplied by the config: a shell command reading the
+// page on stdin and writing the processed page to stdout, or a Go plugin exporting
+// `func Process(relPath string, data []byte) ([]byte, error)`.
+type ConfigProcessor struct {
+	Name    string `json:"name"`
+	Command string `json:"command,omitempty"`
+	// Path of the plugin, relative to the config file.
+	Plugin string `json:"plugin,omitempty"`
+	// Stage to run after, empty to run last.
+	After string `json:"after,omitempty"`
+	// Process every HTML page rather than only package pages.
+	AllPages bool `json:"all_pages,omitempty"`
+}
+
+// Signature of the Process function of a processor plugin.
+type pluginProcessFunc = func(relPath string, data []byte) ([]byte, error)
+
+// Returns the stage of a processor of the config.
+func configProcessorStage(configProcessor *ConfigProcessor) (*processorStage, error) {
+	if (configProcessor.Command == "") == (configProcessor.Plugin == "") {
+		return nil, xerrors.Errorf(
+			"processor %q needs either a command or a plugin", configProcessor.Name,
+		)
+	}
+
+	stage := &processorStage{Name: configProcessor.Name, AllPages: configProcessor.AllPages}
+	if configProcessor.Command != "" {
+		stage.New = func(ctx context.Context, runInfo *RunInfo) Processor {
+			return newCommandProcessor(ctx, runInfo.Settings, configProcessor.Command)
+		}
+		return stage, nil
+	}
+
+	loaded, err := plugin.Open(configProcessor.Plugin)
+	if err != nil {
+		return nil, xerrors.Errorf("error loading processor %q: %w", configProcessor.Name, err)
+	}
+	symbol, err := loaded.Lookup("Process")
+	if err != nil {
+		return nil, xerrors.Errorf("error loading processor %q: %w", configProcessor.Name, err)
+	}
+	process, ok := symbol.(pluginProcessFunc)
+	if !ok {
+		return nil, xerrors.Errorf(
+			"processor %q: Process must be a func(string, []byte) ([]byte, error)",
+			configProcessor.Name,
+		)
+	}
+	stage.New = func(ctx context.Context, runInfo *RunInfo) Processor {
+		return ProcessorFunc(func(page *Page) error {
+			data, err := process(page.RelPath, page.Data)
+			if err != nil {
+				return err
+			}
+			page.Data = data
+			return nil
+		})
+	}
+	return stage, nil
+}
+
+// Returns a processor piping every page through a shell command run in the module
+// root. The command finds the page's path in $DOCMODULE_PAGE and its package in
+// $DOCMODULE_IMPORT_PATH.
+func newCommandProcessor(ctx context.Context, settings *Settings, command string) Processor {
+	buildDir, err := filepath.Abs(settings.BuildDir)
+	if err != nil {
+		log.Panicf("error resolving build directory: %v", err)
+	}
+
+	return ProcessorFunc(func(page *Page) error {
+		shell := exec.CommandContext(ctx, "sh", "-c", command)
+		shell.Dir = settings.ModuleRootPath
+		shell.Env = append(
+			os.Environ(),
+			"DOCMODULE_BUILD_DIR="+buildDir,
+			"DOCMODULE_PAGE="+page.RelPath,
+			"DOCMODULE_IMPORT_PATH="+page.ImportPath(),
+		)
+		shell.Stdin = bytes.NewReader(page.Data)
+		stderr := new(bytes.Buffer)
+		shell.Stderr = stderr
+		output, err := shell.Output()
+		if err != nil {
+			return xerrors.Errorf("%w: %v", err, strings.TrimSpace(stderr.String()))
+		}
+		if len(bytes.TrimSpace(output)) == 0 {
+			return xerrors.New("command wrote an empty page")
+		}
+		page.Data = output
+		return nil
+	})
+}
+
+// Returns the stages of a build: the built-in stages with the processors of its
+// config inserted.
+func buildProcessorStages(configProcessors []*ConfigProcessor) ([]*processorStage, error) {
+	stages := processorStages
+	for _, configProcessor := range configProcessors {
+		stage, err := configProcessorStage(configProcessor)
+		if err != nil {
+			return nil, err
+		}
+		stages, err = insertProcessorStage(stages, stage, configProcessor.After)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return stages, nil
+}
+
+// Returns the pages a stage processes.
+func stagePages(runInfo *RunInfo, stage *processorStage) []*Page {
+	if stage.Scraped {
+		matches, err := filepath.Glob(runInfo.Settings.BuildDir + "/*.html")
+		if err != nil {
+			log.Panic("could not find result files:", err.Error())
+		}
+		pages := make([]*Page, len(matches))
+		for i, match := range matches {
+			pages[i] = &Page{RelPath: filepath.Base(match)}
+		}
+		return pages
+	}
+
+	if !stage.AllPages {
+		infos := readingOrder(runInfo)
+		pages := make([]*Page, len(infos))
+		for i, info := range infos {
+			pages[i] = &Page{RelPath: info.NewRelPath, Info: info}
+		}
+		return pages
+	}
+
+	infos := make(map[string]*DocFileInfo, len(runInfo.DocFileInfo))
+	for _, info := range runInfo.DocFileInfo {
+		if info.ImportPath != "" {
+			infos[info.NewRelPath] = info
+		}
+	}
+	pages := make([]*Page, len(runInfo.HtmlFiles))
+	for i, filePath := range runInfo.HtmlFiles {
+		relPath := pageRelPath(runInfo.Settings, filePath)
+		pages[i] = &Page{RelPath: relPath, Info: infos[relPath]}
+	}
+	return pages
+}
+
+// Runs the pages of the build through every enabled stage of the pipeline, one
+// stage after the other.
+func runProcessors(ctx context.Context, runInfo *RunInfo) {
+	settings := runInfo.Settings
+	stages := settings.ProcessorStages
+	if stages == nil {
+		stages = processorStages
+	}
+
+	for _, stage := range stages {
+		processor := stage.New(ctx, runInfo)
+		if processor == nil {
+			continue
+		}
+
+		pages := stagePages(runInfo, stage)
+		settings.Progress.Start(stage.Name, len(pages))
+		for _, page := range pages {
+			settings.Progress.Add(1, 0)
+			if ctx.Err() != nil {
+				log.Panicf("error running %v processor: %v", stage.Name, ctx.Err())
+			}
+
+			filePath := filepath.Join(settings.BuildDir, filepath.FromSlash(page.RelPath))
+			data, err := ioutil.ReadFile(filePath)
+			if err != nil {
+				log.Panicf("error opening file '%v': %v", filePath, err)
+			}
+			page.Data = data
+
+			relPath := page.RelPath
+			if err := processor.Process(page); err != nil {
+				log.Panicf("error running %v processor on '%v': %v", stage.Name, relPath, err)
+			}
+			if page.Data == nil {
+				if err := os.Remove(filePath); err != nil {
+					log.Panicf("error removing output file: %v", err)
+				}
+				continue
+			}
+			if page.RelPath != relPath {
+				newPath := filepath.Join(settings.BuildDir, filepath.FromSlash(page.RelPath))
+				createBuildDir(filepath.Dir(newPath))
+				if err := os.Rename(filePath, newPath); err != nil {
+					log.Panicf("error renaming %q to %q", filePath, newPath)
+				}
+				filePath = newPath
+			}
+			if bytes.Equal(page.Data, data) {
+				continue
+			}
+			if err := ioutil.WriteFile(filePath, page.Data, os.ModePerm); err != nil {
+				log.Panicf("error altering output file: %v", err)
+			}
+		}
+	}
+	settings.Progress.End()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"go/doc"
+	"golang.org/x/xerrors"
+	"html"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Suffix of the sidecar files holding the definitions of a page's types.
+const symbolSidecarSuffix = ".symbols.json"
+
+// Longest declaration, in lines, shown in a popover.
+const maxPopoverLines = 30
+
+// Regex for a type godoc documents, capturing its id, doc comment and declaration.
+var typeSectionRegex = regexp.MustCompile(
+	`(?s)<h2 id="([^"]+)">type .*?</h2>\s*(.*?)<pre>(.*?)</pre>`,
+)
+
+// Definition of a type shown in a popover.
+type symbolSummary struct {
+	Decl     string `json:"decl"`
+	Synopsis string `json:"synopsis,omitempty"`
+}
+
+// Returns the sidecar of a page, e.g. foo/index.symbols.json for foo/index.html.
+func symbolSidecarPath(pagePath string) string {
+	return strings.TrimSuffix(pagePath, ".html") + symbolSidecarSuffix
+}
+
+// Returns the definitions of the types documented on a page by id.
+func pageTypeSummaries(data []byte) map[string]*symbolSummary {
+	summaries := make(map[string]*symbolSummary)
+	for _, match := range typeSectionRegex.FindAllSubmatch(data, -1) {
+		decl := html.UnescapeString(string(htmlTagRegex.ReplaceAll(match[3], nil)))
+		lines := strings.Split(decl, "\n")
+		if len(lines) > maxPopoverLines {
+			decl = strings.Join(lines[:maxPopoverLines], "\n") + "\n\t..."
+		}
+
+		summaries[string(match[1])] = &symbolSummary{
+			Decl:     decl,
+			Synopsis: doc.Synopsis(html.UnescapeString(htmlText(match[2]))),
+		}
+	}
+	return summaries
+}
+
+// Returns the processor writing a sidecar with the definitions of its types next to
+// every package page and adding the script showing them in a popover when hovering a
+// type in a signature.
+func newTypePopoversProcessor(ctx context.Context, runInfo *RunInfo) Processor {
+	settings := runInfo.Settings
+	if !settings.TypePopovers {
+		return nil
+	}
+
+	writeNavStylesheet(settings)
+	writeNavScript(settings)
+
+	return ProcessorFunc(func(page *Page) error {
+		sidecar, err := json.Marshal(pageTypeSummaries(page.Data))
+		if err != nil {
+			return xerrors.Errorf("error encoding type definitions: %w", err)
+		}
+		sidecarPath := symbolSidecarPath(settings.BuildDir + "/" + page.RelPath)
+		if err := ioutil.WriteFile(sidecarPath, sidecar, os.ModePerm); err != nil {
+			return xerrors.Errorf("error writing type definitions: %w", err)
+		}
+
+		fromDir := path.Dir(page.RelPath)
+		data := linkNavStylesheet(page.Data, fromDir)
+		page.Data = linkNavScript(data, fromDir)
+		return nil
+	})
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"go/types"
+	"golang.org/x/xerrors"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// RegistryType is an exported concrete type of a registry module, with the
+// signatures of its methods.
+type RegistryType struct {
+	// Methods with value receivers.
+	Methods []string `json:"methods,omitempty"`
+	// Methods with pointer receivers.
+	PointerMethods []string `json:"pointerMethods,omitempty"`
+}
+
+// Returns the signature of a method independent of the module it is declared in:
+// its name and its parameter and result types, qualified by full import paths.
+func methodSignature(method *types.Func) string {
+	signature := method.Type().(*types.Signature)
+	qualifier := func(pkg *types.Package) string {
+		return pkg.Path()
+	}
+	tuple := func(vars *types.Tuple, variadic bool) string {
+		parts := make([]string, vars.Len())
+		for i := 0; i < vars.Len(); i++ {
+			varType := vars.At(i).Type()
+			if variadic && i == vars.Len()-1 {
+				parts[i] = "..." + types.TypeString(varType.(*types.Slice).Elem(), qualifier)
+				continue
+			}
+			parts[i] = types.TypeString(varType, qualifier)
+		}
+		return "(" + strings.Join(parts, ", ") + ")"
+	}
+	return method.Name() + tuple(signature.Params(), signature.Variadic()) +
+		tuple(signature.Results(), false)
+}
+
+// Returns the method signatures of the exported interfaces and concrete types of the
+// module, keyed by importPath.Name.
+func moduleMethodSets(settings *Settings) (map[string][]string, map[string]*RegistryType) {
+	interfaces, concrete := moduleTypeNames(settings)
+
+	interfaceMethods := make(map[string][]string, len(interfaces))
+	for _, iface := range interfaces {
+		ifaceType := iface.Type().Underlying().(*types.Interface)
+		methods := make([]string, ifaceType.NumMethods())
+		for i := range methods {
+			methods[i] = methodSignature(ifaceType.Method(i))
+		}
+		interfaceMethods[iface.Pkg().Path()+"."+iface.Name()] = methods
+	}
+
+	typeMethods := make(map[string]*RegistryType, len(concrete))
+	for _, typeName := range concrete {
+		// Method sets include the methods promoted from embedded fields.
+		valueSet := types.NewMethodSet(typeName.Type())
+		pointerSet := types.NewMethodSet(types.NewPointer(typeName.Type()))
+		if pointerSet.Len() == 0 {
+			continue
+		}
+		registryType := new(RegistryType)
+		for i := 0; i < pointerSet.Len(); i++ {
+			method := pointerSet.At(i).Obj().(*types.Func)
+			signature := methodSignature(method)
+			if valueSet.Lookup(method.Pkg(), method.Name()) != nil {
+				registryType.Methods = append(registryType.Methods, signature)
+			} else {
+				registryType.PointerMethods = append(registryType.PointerMethods, signature)
+			}
+		}
+		typeMethods[typeName.Pkg().Path()+"."+typeName.Name()] = registryType
+	}
+	return interfaceMethods, typeMethods
+}
+
+// An interface of the implementers index with the types of other modules
+// implementing it.
+type portalInterface struct {
+	Key    string
+	Module string
+	Link   string
+	// Page of the interface, relative to the index directory.
+	Page         string
+	Implementers []*portalImplementer
+	// Number of modules besides the interface's own with implementers.
+	ModuleCount int
+}
+
+type portalImplementer struct {
+	Module string
+	Key    string
+	Link   string
+	// Whether only the pointer to the type implements the interface.
+	Pointer bool
+}
+
+var portalIndexTemplate = template.Must(template.New("portal-index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta http-equiv="Content-Type" content="text/html; charset=utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Implementers across modules</title>
+</head>
+<body>
+<h1>Implementers across modules</h1>
+<p>Interfaces implemented by the types of other modules, by how many modules implement them.</p>
+<table>
+<tr><th>Interface</th><th>Module</th><th>Implementing modules</th><th>Implementers</th></tr>
+{{range .}}<tr><td><a href="{{.Page}}">{{.Key}}</a></td><td>{{.Module}}</td><td>{{.ModuleCount}}</td><td>{{len .Implementers}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+var portalInterfaceTemplate = template.Must(template.New("portal-interface").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta http-equiv="Content-Type" content="text/html; charset=utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Implementers of {{.Key}}</title>
+</head>
+<body>
+<p><a href="index.html">All interfaces</a></p>
+<h1>Implementers of {{if .Link}}<a href="{{.Link}}">{{.Key}}</a>{{else}}{{.Key}}{{end}}</h1>
+<p>Declared in {{.Module}}.</p>
+<ul>
+{{range .Implementers}}<li>{{if .Pointer}}*{{end}}{{if .Link}}<a href="{{.Link}}">{{.Key}}</a>{{else}}{{.Key}}{{end}} ({{.Module}})</li>
+{{end}}</ul>
+</body>
+</html>
+`))
+
+// Returns the interfaces of the registry's modules implemented by types of other
+// modules, implemented by the most modules first.
+func portalImplementers(registry *SymbolRegistry) []*portalInterface {
+	pages := make(map[string]string)
+	for _, module := range registry.Modules {
+		for importPath, pkg := range module.Packages {
+			pages[importPath] = module.BaseURL + "/" + pkg.Page
+		}
+	}
+
+	result := make([]*portalInterface, 0)
+	for ifaceModule, module := range registry.Modules {
+		for key, methods := range module.Interfaces {
+			iface := &portalInterface{Key: key, Module: ifaceModule, Link: resolveSymbolLink(pages, key)}
+			modules := make(map[string]bool)
+			for typeModule, other := range registry.Modules {
+				if typeModule == ifaceModule {
+					continue
+				}
+				for typeKey, registryType := range other.Types {
+					pointer, ok := implementsMethods(registryType, methods)
+					if !ok {
+						continue
+					}
+					modules[typeModule] = true
+					iface.Implementers = append(iface.Implementers, &portalImplementer{
+						Module:  typeModule,
+						Key:     typeKey,
+						Link:    resolveSymbolLink(pages, typeKey),
+						Pointer: pointer,
+					})
+				}
+			}
+			if len(iface.Implementers) == 0 {
+				continue
+			}
+			sort.Slice(iface.Implementers, func(i, j int) bool {
+				return iface.Implementers[i].Key < iface.Implementers[j].Key
+			})
+			iface.ModuleCount = len(modules)
+			iface.Page = strings.Replace(key, "/", "_", -1) + ".html"
+			result = append(result, iface)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].ModuleCount != result[j].ModuleCount {
+			return result[i].ModuleCount > result[j].ModuleCount
+		}
+		return result[i].Key < result[j].Key
+	})
+	return result
+}
+
+// Reports whether a type has all the methods, and whether only its pointer does.
+func implementsMethods(registryType *RegistryType, methods []string) (pointer bool, ok bool) {
+	valueMethods := make(map[string]bool, len(registryType.Methods))
+	for _, method := range registryType.Methods {
+		valueMethods[method] = true
+	}
+	pointerMethods := make(map[string]bool, len(registryType.PointerMethods))
+	for _, method := range registryType.PointerMethods {
+		pointerMethods[method] = true
+	}
+	for _, method := range methods {
+		if valueMethods[method] {
+			continue
+		}
+		if !pointerMethods[method] {
+			return false, false
+		}
+		pointer = true
+	}
+	return pointer, true
+}
+
+// Writes the implementers index of the registry's modules to dir: index.html and a
+// page for every interface with implementers in other modules. Pages of interfaces
+// without implementers any more are removed.
+func writeImplementersIndex(dir string, registry *SymbolRegistry) error {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return xerrors.Errorf("error creating implementers index: %w", err)
+	}
+	stale, err := filepath.Glob(filepath.Join(dir, "*.html"))
+	if err != nil {
+		return err
+	}
+	for _, page := range stale {
+		if err := os.Remove(page); err != nil {
+			return xerrors.Errorf("error clearing implementers index: %w", err)
+		}
+	}
+
+	interfaces := portalImplementers(registry)
+	write := func(name string, tmpl *template.Template, data interface{}) error {
+		buffer := new(bytes.Buffer)
+		if err := tmpl.Execute(buffer, data); err != nil {
+			return xerrors.Errorf("error rendering %v: %w", name, err)
+		}
+		return ioutil.WriteFile(filepath.Join(dir, name), buffer.Bytes(), os.ModePerm)
+	}
+	for _, iface := range interfaces {
+		if err := write(iface.Page, portalInterfaceTemplate, iface); err != nil {
+			return err
+		}
+	}
+	return write("index.html", portalIndexTemplate, interfaces)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"golang.org/x/xerrors"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Writes .gz and .br variants next to every compressible file of the build, for
+// static hosts and CDNs serving precompressed files. Brotli variants need the
+// brotli command; without it only gzip variants are written.
+func precompressBuild(settings *Settings) {
+	if !settings.Precompress {
+		return
+	}
+
+	brotli, err := exec.LookPath("brotli")
+	if err != nil {
+		log.Println("brotli not found on PATH, writing .gz variants only.")
+		brotli = ""
+	}
+
+	count := 0
+	err = filepath.Walk(settings.BuildDir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !compressibleExtensions[filepath.Ext(filePath)] {
+			return err
+		}
+		data, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+
+		// The gzip header carries no name or time, so unchanged files compress to
+		// unchanged variants and aren't uploaded again.
+		buffer := new(bytes.Buffer)
+		gzipWriter, err := gzip.NewWriterLevel(buffer, gzip.BestCompression)
+		if err != nil {
+			return err
+		}
+		if _, err := gzipWriter.Write(data); err != nil {
+			return err
+		}
+		if err := gzipWriter.Close(); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filePath+".gz", buffer.Bytes(), os.ModePerm); err != nil {
+			return err
+		}
+
+		if brotli != "" {
+			command := exec.Command(brotli, "--best", "--force", "--output="+filePath+".br", filePath)
+			if output, err := command.CombinedOutput(); err != nil {
+				return xerrors.Errorf("brotli failed on '%v': %w: %v", filePath, err, strings.TrimSpace(string(output)))
+			}
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		log.Panicf("error precompressing build: %v", err)
+	}
+	log.Printf("precompressed %v files.", count)
+}
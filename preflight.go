@@ -0,0 +1,79 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// Directory binaries installed by --auto-install are placed in.
+func toolCacheDir() string {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		cacheDir = os.TempDir()
+	}
+	return filepath.Join(cacheDir, "docmodule", "bin")
+}
+
+// Looks up the backend's server binary on PATH and in the tool cache, returning its
+// path or an empty string if it is not installed.
+func findBackendBinary(backend Backend) string {
+	if binaryPath, err := exec.LookPath(backend.Binary()); err == nil {
+		return binaryPath
+	}
+
+	cachedPath := filepath.Join(toolCacheDir(), backend.Binary())
+	if exists, err := fileExists(cachedPath); exists && err == nil {
+		return cachedPath
+	}
+
+	return ""
+}
+
+// Installs the backend's server binary into the tool cache with `go install`.
+func installBackendBinary(backend Backend) string {
+	cacheDir := toolCacheDir()
+	log.Println("installing", backend.InstallPackage(), "into", cacheDir+".")
+
+	command := exec.Command("go", "install", backend.InstallPackage())
+	command.Env = append(os.Environ(), "GOBIN="+cacheDir)
+	output, err := command.CombinedOutput()
+	if err != nil {
+		log.Fatalf(
+			"error installing %v: %v, output: %v",
+			backend.InstallPackage(),
+			err,
+			string(output),
+		)
+	}
+
+	return filepath.Join(cacheDir, backend.Binary())
+}
+
+// Makes sure the backend's server binary is available before we start work,
+// installing it when --auto-install is set.
+func checkBackendBinary(settings *Settings) {
+	// Fixtures, replayed sessions and remote servers replace the server entirely.
+	if settings.Fixtures != "" || settings.Replay != "" || settings.RemoteServer != "" {
+		return
+	}
+	backend := selectBackend(settings)
+
+	binaryPath := findBackendBinary(backend)
+	if binaryPath == "" {
+		if !settings.AutoInstall {
+			log.Fatalf(
+				"%v is not installed. Install it with `go install %v`, or re-run "+
+					"with --auto-install to install it into %v.",
+				backend.Binary(),
+				backend.InstallPackage(),
+				toolCacheDir(),
+			)
+		}
+		binaryPath = installBackendBinary(backend)
+	}
+
+	log.Println("using", backend.Name(), "binary", binaryPath+".")
+	settings.BackendBinary = binaryPath
+}
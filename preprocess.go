@@ -0,0 +1,435 @@
+package main
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"golang.org/x/xerrors"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// DocComment is a doc comment going through the preprocessor chain.
+type DocComment struct {
+	// Go file of the comment, relative to the module root, slash-separated.
+	RelPath string
+	// Text of the comment, without the comment markers.
+	Text string
+}
+
+// Preprocessor transforms the text of doc comments before the docs are rendered.
+type Preprocessor interface {
+	Preprocess(comment *DocComment) error
+}
+
+// PreprocessorFunc adapts a function to Preprocessor.
+type PreprocessorFunc func(comment *DocComment) error
+
+func (preprocess PreprocessorFunc) Preprocess(comment *DocComment) error {
+	return preprocess(comment)
+}
+
+// ConfigPreprocessor is a doc comment preprocessor of the config: one of the
+// built-in preprocessors, or a shell command reading the comment on stdin and
+// writing the new comment to stdout.
+type ConfigPreprocessor struct {
+	Name    string `json:"name"`
+	Command string `json:"command,omitempty"`
+	// Values of the macros preprocessor by macro name.
+	Macros map[string]string `json:"macros,omitempty"`
+}
+
+// Built-in preprocessors by name.
+var preprocessors = map[string]func(settings *Settings, config *ConfigPreprocessor) Preprocessor{
+	"macros":   newMacrosPreprocessor,
+	"include":  newIncludePreprocessor,
+	"audience": newAudiencePreprocessor,
+}
+
+// Returns the names of the built-in preprocessors, sorted.
+func preprocessorNames() []string {
+	names := make([]string, 0, len(preprocessors))
+	for name := range preprocessors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Returns the preprocessors of the config, in the order they run.
+func preprocessorChain(settings *Settings) ([]Preprocessor, error) {
+	chain := make([]Preprocessor, 0, len(settings.Preprocessors))
+	for _, config := range settings.Preprocessors {
+		if config.Command != "" {
+			chain = append(chain, newCommandPreprocessor(settings, config.Command))
+			continue
+		}
+		newPreprocessor, ok := preprocessors[config.Name]
+		if !ok {
+			return nil, xerrors.Errorf(
+				"preprocessor %q needs a command or one of the names %v",
+				config.Name, strings.Join(preprocessorNames(), ", "),
+			)
+		}
+		if config.Macros != nil && config.Name != "macros" {
+			return nil, xerrors.Errorf("only the macros preprocessor takes macros, not %q", config.Name)
+		}
+		chain = append(chain, newPreprocessor(settings, config))
+	}
+	return chain, nil
+}
+
+// Regex matching {{NAME}} macros.
+var macroRegex = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+// Expands {{NAME}} to the value of the macro NAME. {{MODULE}} is the module path
+// unless the config sets it. Unknown macros are left as they are.
+func newMacrosPreprocessor(settings *Settings, config *ConfigPreprocessor) Preprocessor {
+	macros := map[string]string{"MODULE": settings.ModName}
+	for name, value := range config.Macros {
+		macros[name] = value
+	}
+	return PreprocessorFunc(func(comment *DocComment) error {
+		comment.Text = macroRegex.ReplaceAllStringFunc(comment.Text, func(macro string) string {
+			if value, ok := macros[macroRegex.FindStringSubmatch(macro)[1]]; ok {
+				return value
+			}
+			return macro
+		})
+		return nil
+	})
+}
+
+// Regexes of the directive lines of the include and audience preprocessors.
+var (
+	includeRegex     = regexp.MustCompile(`^\s*@include\s+(\S+)\s*$`)
+	audienceRegex    = regexp.MustCompile(`^\s*@audience\s+(\S+)\s*$`)
+	audienceEndRegex = regexp.MustCompile(`^\s*@end\s*$`)
+)
+
+// Replaces `@include path` lines with the contents of the file, relative to the
+// directory of the Go file.
+func newIncludePreprocessor(settings *Settings, config *ConfigPreprocessor) Preprocessor {
+	return PreprocessorFunc(func(comment *DocComment) error {
+		lines := strings.Split(comment.Text, "\n")
+		for i, line := range lines {
+			match := includeRegex.FindStringSubmatch(line)
+			if match == nil {
+				continue
+			}
+			relDir := filepath.Dir(filepath.FromSlash(comment.RelPath))
+			filePath := filepath.Join(settings.ModuleRootPath, relDir, filepath.FromSlash(match[1]))
+			data, err := ioutil.ReadFile(filePath)
+			if err != nil {
+				return xerrors.Errorf("error including '%v': %w", match[1], err)
+			}
+			lines[i] = strings.TrimRight(string(data), "\n")
+		}
+		comment.Text = strings.Join(lines, "\n")
+		return nil
+	})
+}
+
+// Keeps the lines between `@audience a,b` and `@end` only if --audience selects one
+// of the block's audiences. Blocks may nest.
+func newAudiencePreprocessor(settings *Settings, config *ConfigPreprocessor) Preprocessor {
+	selected := make(map[string]bool, len(settings.Audiences))
+	for _, audience := range settings.Audiences {
+		selected[audience] = true
+	}
+	return PreprocessorFunc(func(comment *DocComment) error {
+		kept := make([]string, 0)
+		// Whether each open block is shown, innermost last.
+		blocks := make([]bool, 0)
+		hidden := 0
+		for _, line := range strings.Split(comment.Text, "\n") {
+			if match := audienceRegex.FindStringSubmatch(line); match != nil {
+				shown := false
+				for _, audience := range strings.Split(match[1], ",") {
+					shown = shown || selected[audience]
+				}
+				blocks = append(blocks, shown)
+				if !shown {
+					hidden++
+				}
+				continue
+			}
+			if audienceEndRegex.MatchString(line) {
+				if len(blocks) == 0 {
+					return xerrors.New("@end without @audience")
+				}
+				if !blocks[len(blocks)-1] {
+					hidden--
+				}
+				blocks = blocks[:len(blocks)-1]
+				continue
+			}
+			if hidden == 0 {
+				kept = append(kept, line)
+			}
+		}
+		if len(blocks) > 0 {
+			return xerrors.New("@audience without @end")
+		}
+		comment.Text = strings.Join(kept, "\n")
+		return nil
+	})
+}
+
+// Returns a preprocessor piping every doc comment through a shell command run in
+// the module root. The command finds the comment's file in $DOCMODULE_FILE.
+func newCommandPreprocessor(settings *Settings, command string) Preprocessor {
+	return PreprocessorFunc(func(comment *DocComment) error {
+		shell := exec.Command("sh", "-c", command)
+		shell.Dir = settings.ModuleRootPath
+		shell.Env = append(os.Environ(), "DOCMODULE_FILE="+comment.RelPath)
+		shell.Stdin = strings.NewReader(comment.Text)
+		stderr := new(bytes.Buffer)
+		shell.Stderr = stderr
+		output, err := shell.Output()
+		if err != nil {
+			return xerrors.Errorf("%w: %v", err, strings.TrimSpace(stderr.String()))
+		}
+		comment.Text = string(output)
+		return nil
+	})
+}
+
+// Returns the directory the docs are rendered from: the preprocessed copy of the
+// module if there is one, otherwise the module itself.
+func (settings *Settings) docSourceRoot() string {
+	if settings.DocSourcePath != "" {
+		return settings.DocSourcePath
+	}
+	return settings.ModuleRootPath
+}
+
+// Regex matching replace directives of go.mod pointing at relative paths.
+var relativeReplaceRegex = regexp.MustCompile(`(=>\s*)(\.\.?/\S*|\.\.?)(\s|$)`)
+
+// Copies the module into a temporary directory, resolves the edition blocks of the
+// doc comments of its Go files, runs them through the preprocessors of the config
+// and renders the docs from the copy. Does nothing if the config has no
+// preprocessors and the module no edition blocks.
+func preprocessDocSource(settings *Settings) {
+	if len(settings.Preprocessors) == 0 {
+		hasEditionBlocks, err := moduleHasEditionBlocks(settings)
+		if err != nil {
+			log.Panic(err)
+		}
+		if !hasEditionBlocks {
+			return
+		}
+	}
+	chain, err := preprocessorChain(settings)
+	if err != nil {
+		log.Panic(err)
+	}
+	editions := make(map[string]bool, len(settings.Editions))
+	for _, edition := range settings.Editions {
+		editions[edition] = true
+	}
+
+	sourceDir, err := ioutil.TempDir("", "docmodule-source-")
+	if err != nil {
+		log.Panicf("error creating source directory: %v", err)
+	}
+	settings.DocSourcePath = sourceDir
+	log.Println("preprocessing doc comments into", sourceDir+".")
+
+	buildDir, err := filepath.Abs(settings.BuildDir)
+	if err != nil {
+		log.Panicf("error resolving build directory: %v", err)
+	}
+	root := settings.ModuleRootPath
+	err = filepath.Walk(root, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(root, filePath)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(sourceDir, relPath)
+		if info.IsDir() {
+			if filePath != root && (strings.HasPrefix(info.Name(), ".") || filePath == buildDir) {
+				return filepath.SkipDir
+			}
+			return os.MkdirAll(target, os.ModePerm)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		if err := copyFile(filePath, target); err != nil {
+			return err
+		}
+		if strings.HasSuffix(info.Name(), ".go") {
+			return preprocessFile(target, filepath.ToSlash(relPath), chain, editions)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Panicf("error preprocessing doc comments: %v", err)
+	}
+
+	// Relative replace directives have to keep pointing at the original directories.
+	goModPath := filepath.Join(sourceDir, "go.mod")
+	goMod, err := ioutil.ReadFile(goModPath)
+	if err != nil {
+		log.Panicf("error reading go.mod: %v", err)
+	}
+	goMod = relativeReplaceRegex.ReplaceAllFunc(goMod, func(directive []byte) []byte {
+		match := relativeReplaceRegex.FindSubmatch(directive)
+		replacement := filepath.Join(root, filepath.FromSlash(string(match[2])))
+		return []byte(string(match[1]) + replacement + string(match[3]))
+	})
+	if err := ioutil.WriteFile(goModPath, goMod, os.ModePerm); err != nil {
+		log.Panicf("error writing go.mod: %v", err)
+	}
+}
+
+// Removes the preprocessed copy of the module.
+func removeDocSource(settings *Settings) {
+	if settings.DocSourcePath == "" {
+		return
+	}
+	if err := os.RemoveAll(settings.DocSourcePath); err != nil {
+		log.Printf("error removing preprocessed source: %v", err)
+	}
+	settings.DocSourcePath = ""
+}
+
+func copyFile(source string, target string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Returns the doc comments of a file: of the package clause, declarations, specs
+// and fields.
+func docCommentGroups(file *ast.File) []*ast.CommentGroup {
+	groups := make([]*ast.CommentGroup, 0)
+	add := func(group *ast.CommentGroup) {
+		if group != nil {
+			groups = append(groups, group)
+		}
+	}
+	add(file.Doc)
+	ast.Inspect(file, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.GenDecl:
+			add(node.Doc)
+		case *ast.FuncDecl:
+			add(node.Doc)
+		case *ast.TypeSpec:
+			add(node.Doc)
+		case *ast.ValueSpec:
+			add(node.Doc)
+		case *ast.Field:
+			add(node.Doc)
+		}
+		return true
+	})
+	return groups
+}
+
+// Regex matching directive comments like //go:generate, which Text leaves out.
+var directiveCommentRegex = regexp.MustCompile(`^//(line |extern |export |[a-z0-9]+:[a-z0-9])`)
+
+// Resolves the edition blocks of the doc comments of a Go file and runs them through
+// the chain, rewriting the changed ones as line comments. Files which don't parse are
+// left for the doc server to report.
+func preprocessFile(
+	filePath string, relPath string, chain []Preprocessor, editions map[string]bool,
+) error {
+	source, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	fileSet := token.NewFileSet()
+	file, err := parser.ParseFile(fileSet, filePath, source, parser.ParseComments)
+	if err != nil {
+		return nil
+	}
+
+	groups := docCommentGroups(file)
+	// Rewrite from the end of the file so the offsets of earlier comments hold.
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Pos() > groups[j].Pos()
+	})
+	rewritten := source
+	for _, group := range groups {
+		text := group.Text()
+		position := fileSet.Position(group.Pos())
+		resolved, err := resolveEditionBlocks(group, editions)
+		if err != nil {
+			return xerrors.Errorf("error preprocessing %v:%v: %w", relPath, position.Line, err)
+		}
+		comment := &DocComment{RelPath: relPath, Text: resolved.Text()}
+		for _, preprocessor := range chain {
+			if err := preprocessor.Preprocess(comment); err != nil {
+				return xerrors.Errorf("error preprocessing %v:%v: %w", relPath, position.Line, err)
+			}
+		}
+		if comment.Text == text {
+			continue
+		}
+
+		start := fileSet.Position(group.Pos()).Offset
+		end := fileSet.Position(group.End()).Offset
+		lineStart := bytes.LastIndexByte(rewritten[:start], '\n') + 1
+		indent := string(rewritten[lineStart:start])
+		if strings.TrimSpace(indent) != "" {
+			indent = ""
+		}
+
+		lines := make([]string, 0)
+		if trimmed := strings.TrimRight(comment.Text, "\n"); trimmed != "" {
+			for _, line := range strings.Split(trimmed, "\n") {
+				if line == "" || strings.HasPrefix(line, "\t") {
+					lines = append(lines, "//"+line)
+				} else {
+					lines = append(lines, "// "+line)
+				}
+			}
+		}
+		for _, original := range group.List {
+			if !directiveCommentRegex.MatchString(original.Text) ||
+				strings.HasPrefix(original.Text, editionDirectivePrefix) {
+				continue
+			}
+			// Directives stay apart from the text, as gofmt has them.
+			if len(lines) > 0 && lines[len(lines)-1] != "//" && !directiveCommentRegex.MatchString(lines[len(lines)-1]) {
+				lines = append(lines, "//")
+			}
+			lines = append(lines, original.Text)
+		}
+
+		replacement := []byte(strings.Join(lines, "\n"+indent))
+		rewritten = append(append(append([]byte{}, rewritten[:start]...), replacement...), rewritten[end:]...)
+	}
+
+	if bytes.Equal(rewritten, source) {
+		return nil
+	}
+	return ioutil.WriteFile(filePath, rewritten, os.ModePerm)
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How often the progress bar is redrawn at most.
+const progressRedrawInterval = 100 * time.Millisecond
+
+// Width of the progress bar of stages with a known number of pages.
+const progressBarWidth = 30
+
+// StageTiming records how long a stage of the build took and what it went through.
+type StageTiming struct {
+	Name    string  `json:"name"`
+	Seconds float64 `json:"seconds"`
+	// Pages scraped or processed by the stage.
+	Pages int `json:"pages,omitempty"`
+	// Bytes downloaded by the stage.
+	Bytes int64 `json:"bytes,omitempty"`
+
+	started time.Time
+	// Pages the stage will go through, 0 if unknown.
+	total int
+}
+
+// Progress reports the stages of a build: the time each took, logged when it ends,
+// and while it runs a progress bar of its pages and bytes if the log goes to a
+// terminal. Its methods do nothing on a nil Progress.
+type Progress struct {
+	mutex sync.Mutex
+	out   io.Writer
+	// Whether the progress bar is drawn.
+	bar      bool
+	drawn    bool
+	lastDraw time.Time
+	stage    *StageTiming
+	stages   []*StageTiming
+}
+
+// Returns the progress of a build logging to out. The bar is only drawn when out is
+// a terminal and noBar is false, as it is redrawn in place.
+func NewProgress(out *os.File, noBar bool) *Progress {
+	return &Progress{
+		out:    out,
+		bar:    !noBar && isTerminal(out),
+		stages: make([]*StageTiming, 0),
+	}
+}
+
+// Reports whether file is a terminal able to redraw a line.
+func isTerminal(file *os.File) bool {
+	if os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Ends the current stage and starts the stage called name, going through total
+// pages, or an unknown number if total is 0.
+func (progress *Progress) Start(name string, total int) {
+	if progress == nil {
+		return
+	}
+	progress.End()
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+	progress.stage = &StageTiming{Name: name, started: time.Now(), total: total}
+	progress.draw(true)
+}
+
+// Counts pages and downloaded bytes of the current stage.
+func (progress *Progress) Add(pages int, size int64) {
+	if progress == nil {
+		return
+	}
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+	if progress.stage == nil {
+		return
+	}
+	progress.stage.Pages += pages
+	progress.stage.Bytes += size
+	progress.draw(false)
+}
+
+// Ends the current stage, if any, logging how long it took.
+func (progress *Progress) End() {
+	if progress == nil {
+		return
+	}
+	progress.mutex.Lock()
+	stage := progress.stage
+	if stage == nil {
+		progress.mutex.Unlock()
+		return
+	}
+	progress.stage = nil
+	progress.clear()
+	stage.Seconds = time.Since(stage.started).Seconds()
+	progress.stages = append(progress.stages, stage)
+	progress.mutex.Unlock()
+
+	details := []string{formatElapsed(time.Since(stage.started))}
+	if stage.Pages > 0 {
+		details = append(details, fmt.Sprintf("%v pages", stage.Pages))
+	}
+	if stage.Bytes > 0 {
+		details = append(details, formatByteCount(stage.Bytes))
+	}
+	log.Printf("%v took %v.", stage.Name, strings.Join(details, ", "))
+}
+
+// Ends the current stage and returns the timings of every stage.
+func (progress *Progress) Stages() []*StageTiming {
+	if progress == nil {
+		return nil
+	}
+	progress.End()
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+	return append([]*StageTiming{}, progress.stages...)
+}
+
+// Writes a log line, clearing the progress bar before and redrawing it after, so
+// it stays below the log. Used as the output of the log package.
+func (progress *Progress) Write(data []byte) (int, error) {
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+	progress.clear()
+	written, err := progress.out.Write(data)
+	progress.draw(true)
+	return written, err
+}
+
+// Clears the progress bar from the terminal. Called with the mutex held.
+func (progress *Progress) clear() {
+	if !progress.drawn {
+		return
+	}
+	fmt.Fprint(progress.out, "\r\033[K")
+	progress.drawn = false
+}
+
+// Redraws the progress bar of the current stage, at most every
+// progressRedrawInterval unless force is set. Called with the mutex held.
+func (progress *Progress) draw(force bool) {
+	stage := progress.stage
+	if !progress.bar || stage == nil {
+		return
+	}
+	now := time.Now()
+	if !force && now.Sub(progress.lastDraw) < progressRedrawInterval {
+		return
+	}
+	progress.lastDraw = now
+
+	line := fmt.Sprintf("%-12v", stage.Name)
+	if stage.total > 0 {
+		done := stage.Pages * progressBarWidth / stage.total
+		if done > progressBarWidth {
+			done = progressBarWidth
+		}
+		line += " [" + strings.Repeat("=", done) + strings.Repeat(" ", progressBarWidth-done) + "]"
+		line += fmt.Sprintf(" %v/%v pages", stage.Pages, stage.total)
+	} else if stage.Pages > 0 {
+		line += fmt.Sprintf(" %v pages", stage.Pages)
+	}
+	if stage.Bytes > 0 {
+		line += " " + formatByteCount(stage.Bytes)
+	}
+	line += " " + formatElapsed(now.Sub(stage.started))
+	fmt.Fprint(progress.out, "\r\033[K"+line)
+	progress.drawn = true
+}
+
+// Formats a duration to a tenth of a second.
+func formatElapsed(elapsed time.Duration) string {
+	return elapsed.Round(100 * time.Millisecond).String()
+}
+
+// Formats a byte count with a binary unit, like 1.5 MiB.
+func formatByteCount(count int64) string {
+	const unit = 1024
+	if count < unit {
+		return fmt.Sprintf("%v B", count)
+	}
+	value := float64(count)
+	units := []string{"KiB", "MiB", "GiB", "TiB"}
+	for i, name := range units {
+		value /= unit
+		if value < unit || i == len(units)-1 {
+			return fmt.Sprintf("%.1f %v", value, name)
+		}
+	}
+	return ""
+}
+
+// Regex for the lines of wget's output reporting a saved file and its size, quoted
+// with typographic quotes in UTF-8 locales and with ASCII ones otherwise.
+var wgetSavedRegex = regexp.MustCompile(`['‘]([^'’]+)['’] saved \[(\d+)`)
+
+// Collects the output of wget, counting the pages and bytes it saved into the
+// progress as they are reported.
+type wgetProgressWriter struct {
+	progress *Progress
+	output   bytes.Buffer
+	// Unfinished last line of the output.
+	line []byte
+}
+
+func (writer *wgetProgressWriter) Write(data []byte) (int, error) {
+	writer.output.Write(data)
+	writer.line = append(writer.line, data...)
+	for {
+		end := bytes.IndexByte(writer.line, '\n')
+		if end < 0 {
+			break
+		}
+		line := string(writer.line[:end])
+		writer.line = writer.line[end+1:]
+
+		match := wgetSavedRegex.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		size, _ := strconv.ParseInt(match[2], 10, 64)
+		pages := 0
+		if strings.HasSuffix(match[1], ".html") {
+			pages = 1
+		}
+		writer.progress.Add(pages, size)
+	}
+	return len(data), nil
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Directory of the build directory prose pages are rendered into.
+const proseDir = "guide"
+
+// A prose page, rendered from a markdown file of the docs directory.
+type prosePage struct {
+	Title string
+	// Path of the markdown file relative to the docs directory, slash-separated.
+	Source string
+	// Path of the page relative to the build directory.
+	RelPath string
+}
+
+// Regex for links between markdown files of the docs directory.
+var markdownFileLinkRegex = regexp.MustCompile(`href="([^":#]+)\.md(#[^"]*)?"`)
+
+var prosePageTemplate = template.Must(template.New("prose").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta http-equiv="Content-Type" content="text/html; charset=utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Module}} - {{.Title}}</title>
+<link type="text/css" rel="stylesheet" href="{{.Stylesheet}}">
+</head>
+<body>
+<div id="page" class="wide">
+<div class="container">
+<ul class="docmodule-guide-nav">
+{{range .Pages}}<li>{{if eq .RelPath $.RelPath}}<strong>{{.Title}}</strong>{{else}}<a href="{{call $.Link .RelPath}}">{{.Title}}</a>{{end}}</li>
+{{end}}<li><a href="{{.IndexLink}}">Packages</a></li>
+</ul>
+{{.Content}}
+</div>
+</div>
+</body>
+</html>
+`))
+
+// Reads the nav file, a YAML list of pages with a title and a path relative to the
+// docs directory:
+//
+//   - title: Getting started
+//     path: getting-started.md
+//
+// Only this subset of YAML is understood.
+func readNavFile(filePath string) ([]*prosePage, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	pages := make([]*prosePage, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "-") {
+			pages = append(pages, new(prosePage))
+			line = strings.TrimSpace(strings.TrimPrefix(line, "-"))
+		}
+		if len(pages) == 0 {
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value := strings.Trim(strings.TrimSpace(parts[1]), `"'`)
+		switch strings.TrimSpace(parts[0]) {
+		case "title":
+			pages[len(pages)-1].Title = value
+		case "path":
+			pages[len(pages)-1].Source = filepath.ToSlash(value)
+		}
+	}
+	return pages, scanner.Err()
+}
+
+// Returns the title of a markdown page, its first heading or else its file name.
+func markdownTitle(data []byte, source string) string {
+	for _, line := range strings.Split(string(data), "\n") {
+		if match := markdownHeadingRegex.FindStringSubmatch(strings.TrimSpace(line)); match != nil {
+			return match[2]
+		}
+	}
+	return strings.TrimSuffix(path.Base(source), ".md")
+}
+
+// Returns the prose pages in nav order, or all markdown files of the docs directory
+// in lexical order when there is no nav file.
+func prosePages(settings *Settings) []*prosePage {
+	var pages []*prosePage
+	if settings.NavFile != "" {
+		var err error
+		if pages, err = readNavFile(settings.NavFile); err != nil {
+			log.Panicf("error reading nav file: %v", err)
+		}
+	} else {
+		err := filepath.Walk(settings.ProseDir, func(filePath string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() || filepath.Ext(filePath) != ".md" {
+				return err
+			}
+			relPath, err := filepath.Rel(settings.ProseDir, filePath)
+			if err != nil {
+				return err
+			}
+			pages = append(pages, &prosePage{Source: filepath.ToSlash(relPath)})
+			return nil
+		})
+		if err != nil {
+			log.Panicf("error listing prose pages: %v", err)
+		}
+		sort.Slice(pages, func(i, j int) bool {
+			return pages[i].Source < pages[j].Source
+		})
+	}
+
+	for _, page := range pages {
+		page.RelPath = path.Join(proseDir, strings.TrimSuffix(page.Source, ".md")+".html")
+	}
+	return pages
+}
+
+// Renders the markdown pages of the docs directory into the guide directory of the
+// build, each listing all prose pages in nav order.
+func generateProsePages(runInfo *RunInfo) {
+	settings := runInfo.Settings
+	if settings.ProseDir == "" {
+		return
+	}
+
+	pages := prosePages(settings)
+	stylesheet := selectBackend(settings).SentinelAsset()
+	sources := make(map[string][]byte, len(pages))
+	for _, page := range pages {
+		data, err := ioutil.ReadFile(filepath.Join(settings.ProseDir, filepath.FromSlash(page.Source)))
+		if err != nil {
+			log.Panicf("error reading prose page: %v", err)
+		}
+		sources[page.Source] = data
+		if page.Title == "" {
+			page.Title = markdownTitle(data, page.Source)
+		}
+	}
+
+	for _, page := range pages {
+		fromDir := path.Dir(page.RelPath)
+		content := markdownFileLinkRegex.ReplaceAllString(
+			string(renderMarkdown(string(sources[page.Source]))), `href="$1.html$2"`,
+		)
+
+		buffer := new(bytes.Buffer)
+		err := prosePageTemplate.Execute(buffer, map[string]interface{}{
+			"Module":     settings.ModName,
+			"Title":      page.Title,
+			"RelPath":    page.RelPath,
+			"Pages":      pages,
+			"Content":    template.HTML(content),
+			"Stylesheet": relativeLink(fromDir, stylesheet),
+			"IndexLink":  relativeLink(fromDir, indexPageName(settings)),
+			"Link": func(target string) string {
+				return relativeLink(fromDir, target)
+			},
+		})
+		if err != nil {
+			log.Panicf("error rendering prose page: %v", err)
+		}
+
+		pagePath := filepath.Join(settings.BuildDir, filepath.FromSlash(page.RelPath))
+		if err := os.MkdirAll(filepath.Dir(pagePath), os.ModePerm); err != nil {
+			log.Panicf("error creating prose directory: %v", err)
+		}
+		if err := ioutil.WriteFile(pagePath, buffer.Bytes(), os.ModePerm); err != nil {
+			log.Panicf("error writing prose page: %v", err)
+		}
+		runInfo.HtmlFiles = append(runInfo.HtmlFiles, pagePath)
+	}
+}
+
+// Returns the link of the first prose page relative to the build directory, or an
+// empty string when there are none.
+func proseIndexLink(settings *Settings) string {
+	if settings.ProseDir == "" {
+		return ""
+	}
+	if pages := prosePages(settings); len(pages) > 0 {
+		return pages[0].RelPath
+	}
+	return ""
+}
+
+// Removes the pages of the packages the ignore file leaves out of the docs.
+func excludeIgnoredPackages(runInfo *RunInfo) {
+	settings := runInfo.Settings
+	if len(settings.IgnorePatterns) == 0 {
+		return
+	}
+
+	removed := make(map[string]bool)
+	kept := make([]*DocFileInfo, 0, len(runInfo.DocFileInfo))
+	for _, info := range runInfo.DocFileInfo {
+		if info.ImportPath == "" || !settings.ignored(info.ImportPath) {
+			kept = append(kept, info)
+			continue
+		}
+		filePath := settings.BuildDir + "/" + info.NewRelPath
+		if err := os.Remove(filePath); err != nil {
+			log.Panicf("error removing ignored page: %v", err)
+		}
+		removed[filePath] = true
+	}
+	runInfo.DocFileInfo = kept
+
+	htmlFiles := make([]string, 0, len(runInfo.HtmlFiles))
+	for _, filePath := range runInfo.HtmlFiles {
+		if !removed[filePath] {
+			htmlFiles = append(htmlFiles, filePath)
+		}
+	}
+	runInfo.HtmlFiles = htmlFiles
+}
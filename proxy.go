@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"golang.org/x/xerrors"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Returns a free local address for the doc server to listen on.
+func freeLocalAddress() (string, error) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return "", xerrors.Errorf("error finding free port: %w", err)
+	}
+	defer listener.Close()
+	return listener.Addr().String(), nil
+}
+
+// Starts a proxy on settings.ServerHost in front of the doc server at backend,
+// which wget scrapes instead of the server itself. With --include-unexported the
+// proxy adds godoc's m=all mode to every request, so the pages include unexported
+// identifiers while their links stay free of query strings. The headers and
+// credentials of --remote-server are added to every request. With a recorder, every
+// page served is recorded. --max-requests-per-second and --max-concurrent-fetches
+// throttle the requests reaching the server. The proxy is shut down when ctx is done.
+func startScrapeProxy(
+	ctx context.Context, settings *Settings, backend *url.URL, recorder *SessionRecorder,
+) {
+	proxy := httputil.NewSingleHostReverseProxy(backend)
+	headers := remoteRequestHeaders(settings)
+	director := proxy.Director
+	proxy.Director = func(request *http.Request) {
+		director(request)
+		// Servers behind virtual hosts need their own name, not the proxy's.
+		request.Host = backend.Host
+		for name, values := range headers {
+			request.Header[name] = values
+		}
+		if settings.IncludeUnexported {
+			query := request.URL.Query()
+			query.Set("m", "all")
+			request.URL.RawQuery = query.Encode()
+		}
+	}
+	if recorder != nil {
+		proxy.ModifyResponse = recorder.Record
+	}
+
+	var handler http.Handler = proxy
+	if settings.throttlesScrape() {
+		handler = newScrapeThrottle(
+			proxy, settings.MaxRequestsPerSecond, settings.MaxConcurrentFetches,
+		)
+	}
+	serveLocal(ctx, settings.ServerHost, handler, "scrape proxy")
+}
+
+// scrapeThrottle holds back the requests of the scrape before they reach the doc
+// server, spacing them out to a maximum rate and capping how many run at once.
+type scrapeThrottle struct {
+	handler http.Handler
+	// Minimum time between the starts of two requests, 0 for no limit.
+	interval time.Duration
+	// Slots of the requests running at once, nil for no limit.
+	slots chan struct{}
+
+	mutex sync.Mutex
+	// Earliest time the next request may start.
+	next time.Time
+}
+
+// Returns handler throttled to requestsPerSecond and maxConcurrent requests at once.
+// Zero means no limit.
+func newScrapeThrottle(
+	handler http.Handler, requestsPerSecond float64, maxConcurrent int,
+) *scrapeThrottle {
+	throttle := &scrapeThrottle{handler: handler}
+	if requestsPerSecond > 0 {
+		throttle.interval = time.Duration(float64(time.Second) / requestsPerSecond)
+	}
+	if maxConcurrent > 0 {
+		throttle.slots = make(chan struct{}, maxConcurrent)
+	}
+	return throttle
+}
+
+// Returns how long a request arriving now has to wait for its turn, reserving it.
+func (throttle *scrapeThrottle) reserve() time.Duration {
+	throttle.mutex.Lock()
+	defer throttle.mutex.Unlock()
+	now := time.Now()
+	if throttle.next.Before(now) {
+		throttle.next = now
+	}
+	wait := throttle.next.Sub(now)
+	throttle.next = throttle.next.Add(throttle.interval)
+	return wait
+}
+
+func (throttle *scrapeThrottle) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	ctx := request.Context()
+	if throttle.slots != nil {
+		select {
+		case throttle.slots <- struct{}{}:
+			defer func() { <-throttle.slots }()
+		case <-ctx.Done():
+			return
+		}
+	}
+	if throttle.interval > 0 {
+		timer := time.NewTimer(throttle.reserve())
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+	throttle.handler.ServeHTTP(writer, request)
+}
+
+// Serves handler on host in the background until ctx is done.
+func serveLocal(ctx context.Context, host string, handler http.Handler, name string) {
+	listener, err := net.Listen("tcp", host)
+	if err != nil {
+		log.Panicf("error starting %v: %v", name, err)
+	}
+	server := &http.Server{Handler: handler}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("%v stopped: %v", name, err)
+		}
+	}()
+}
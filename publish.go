@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"golang.org/x/xerrors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Name of the manifest file stored at the root of every publish target.
+const remoteManifestName = ".docmodule-manifest.json"
+
+// SiteManifest records the content hash of every file in a published site so that
+// later publishes only need to transfer what changed.
+type SiteManifest struct {
+	// Relative slash-separated path -> hex sha256 of the file contents.
+	Files map[string]string `json:"files"`
+	// Versions published to the target, oldest first.
+	History []*PublishedVersion `json:"history,omitempty"`
+	// Signatures of the symbols of the last version published, by importPath.Name,
+	// to summarize the API changes of the next one.
+	API map[string]string `json:"api,omitempty"`
+}
+
+func NewSiteManifest() *SiteManifest {
+	return &SiteManifest{Files: make(map[string]string)}
+}
+
+// Publisher sends a finished build directory to a hosting target.
+type Publisher interface {
+	// Name of the target, used in log output.
+	Name() string
+	// Publish the build directory, recording where it went in the run's summary.
+	Publish(ctx context.Context, runInfo *RunInfo) error
+}
+
+// FileTarget is implemented by publishers which address individual files on the
+// remote end. These share the manifest-diff and delete-extraneous behavior of
+// syncToTarget.
+type FileTarget interface {
+	// Fetch the manifest of the currently published site. Returns an empty manifest
+	// if nothing has been published yet.
+	RemoteManifest(ctx context.Context) (*SiteManifest, error)
+	// Upload a single file from the build directory.
+	Upload(ctx context.Context, buildDir string, relPath string) error
+	// Delete a single file from the target.
+	Delete(ctx context.Context, relPath string) error
+	// Store the manifest of the newly published site.
+	WriteManifest(ctx context.Context, manifest *SiteManifest) error
+}
+
+// Hashes every file under buildDir.
+func buildSiteManifest(buildDir string) (*SiteManifest, error) {
+	manifest := NewSiteManifest()
+
+	err := filepath.Walk(buildDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(buildDir, path)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		manifest.Files[filepath.ToSlash(relPath)] = hex.EncodeToString(sum[:])
+		return nil
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("error hashing build directory: %w", err)
+	}
+
+	return manifest, nil
+}
+
+// Returns the files in local which are new or changed compared to remote, and the
+// files in remote which no longer exist locally. Both lists are sorted.
+func diffManifests(remote *SiteManifest, local *SiteManifest) (changed []string, extraneous []string) {
+	for path, sum := range local.Files {
+		if remote.Files[path] != sum {
+			changed = append(changed, path)
+		}
+	}
+	for path := range remote.Files {
+		if _, ok := local.Files[path]; !ok {
+			extraneous = append(extraneous, path)
+		}
+	}
+	sort.Strings(changed)
+	sort.Strings(extraneous)
+	return changed, extraneous
+}
+
+// Parses a manifest previously written by WriteManifest.
+func decodeSiteManifest(data []byte) (*SiteManifest, error) {
+	manifest := NewSiteManifest()
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, xerrors.Errorf("error parsing remote manifest: %w", err)
+	}
+	if manifest.Files == nil {
+		manifest.Files = make(map[string]string)
+	}
+	return manifest, nil
+}
+
+// Uploads the new and changed files of the build directory to target, optionally
+// removing remote files which are no longer part of the build.
+func syncToTarget(
+	ctx context.Context, settings *Settings, name string, target FileTarget,
+) error {
+	remote, err := target.RemoteManifest(ctx)
+	if err != nil {
+		return xerrors.Errorf("error fetching %v manifest: %w", name, err)
+	}
+
+	history, api, err := recordPublishedVersion(settings, remote)
+	if err != nil {
+		return err
+	}
+	if settings.VersionArchive {
+		if err := writeVersionArchive(settings, history); err != nil {
+			return err
+		}
+	}
+
+	local, err := buildSiteManifest(settings.BuildDir)
+	if err != nil {
+		return err
+	}
+	local.History = history
+	local.API = api
+
+	changed, extraneous := diffManifests(remote, local)
+	log.Printf(
+		"%v: %v changed files, %v extraneous files", name, len(changed), len(extraneous),
+	)
+
+	for _, relPath := range changed {
+		if err := target.Upload(ctx, settings.BuildDir, relPath); err != nil {
+			return xerrors.Errorf("error uploading %q to %v: %w", relPath, name, err)
+		}
+	}
+
+	if settings.DeleteExtraneous {
+		for _, relPath := range extraneous {
+			if err := target.Delete(ctx, relPath); err != nil {
+				return xerrors.Errorf("error deleting %q from %v: %w", relPath, name, err)
+			}
+		}
+	} else {
+		// Keep tracking files we left behind so a later run with deletion enabled
+		// still knows about them.
+		for _, relPath := range extraneous {
+			local.Files[relPath] = remote.Files[relPath]
+		}
+	}
+
+	if err := target.WriteManifest(ctx, local); err != nil {
+		return xerrors.Errorf("error writing %v manifest: %w", name, err)
+	}
+
+	return nil
+}
+
+// PUTs body to url, using basic auth when user is set.
+func httpPut(
+	ctx context.Context,
+	client *http.Client,
+	url string,
+	user string,
+	password string,
+	body io.Reader,
+) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPut, url, body)
+	if err != nil {
+		return err
+	}
+	if user != "" {
+		request.SetBasicAuth(user, password)
+	}
+
+	resp, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return xerrors.Errorf("PUT %v: unexpected status %v", url, resp.Status)
+	}
+	return nil
+}
+
+// Escapes every segment of a slash separated path for use in a URL.
+func escapePath(relPath string) string {
+	segments := strings.Split(relPath, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// Returns the publishers configured by settings.
+func configuredPublishers(settings *Settings) []Publisher {
+	publishers := make([]Publisher, 0)
+	if settings.WebDAVURL != "" {
+		publishers = append(publishers, NewWebDAVPublisher(settings))
+	}
+	if settings.ArtifactRepoURL != "" {
+		publishers = append(publishers, NewArtifactPublisher(settings))
+	}
+	if settings.IPFS {
+		publishers = append(publishers, NewIPFSPublisher())
+	}
+	if settings.ConfluenceURL != "" {
+		publishers = append(publishers, NewConfluencePublisher(settings))
+	}
+	if settings.SearchEndpoint != "" {
+		publishers = append(publishers, NewSearchIndexPublisher(settings))
+	}
+	if settings.AlgoliaAppID != "" {
+		publishers = append(publishers, NewAlgoliaPublisher(settings))
+	}
+	return publishers
+}
+
+// Publishes the build directory to every configured target, recording each publish
+// in the audit log of --audit-log.
+func publishBuild(ctx context.Context, runInfo *RunInfo) {
+	publishers := configuredPublishers(runInfo.Settings)
+	if len(publishers) == 0 {
+		return
+	}
+	auditWriter, err := openLogWriter(runInfo.Settings.AuditLog, "docmodule-audit")
+	if err != nil {
+		log.Panicf("error opening audit log: %v", err)
+	}
+	auditLog := NewJSONLogger(auditWriter)
+
+	for _, publisher := range publishers {
+		entry := AuditLogEntry{
+			Action:  "publish",
+			Target:  publisher.Name(),
+			Version: runInfo.Settings.DocVersion,
+		}
+		auditPublish(auditLog, entry, "requested", nil)
+		log.Println("publishing docs to", publisher.Name()+".")
+		if err := publisher.Publish(ctx, runInfo); err != nil {
+			auditPublish(auditLog, entry, "failed", err)
+			log.Panicf("error publishing docs: %v", err)
+		}
+		auditPublish(auditLog, entry, "succeeded", nil)
+	}
+}
+
+// Writes entry to the audit log of publishes with the given outcome.
+func auditPublish(auditLog *JSONLogger, entry AuditLogEntry, outcome string, err error) {
+	entry.Time = time.Now().UTC()
+	entry.Outcome = outcome
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	auditLog.Log(&entry)
+}
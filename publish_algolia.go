@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"golang.org/x/xerrors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Environment variable holding the Algolia API key, which needs the addObject,
+// settings and deleteIndex ACLs.
+const algoliaAPIKeyEnv = "DOCMODULE_ALGOLIA_API_KEY"
+
+// Number of records sent in a batch request.
+const algoliaBatchSize = 1000
+
+// AlgoliaPublisher replaces the records of an Algolia DocSearch index with the records
+// of the docsearch format. The records are sent to a temporary index with the settings
+// of the index, which is then moved over it, so searches never see a partial index.
+type AlgoliaPublisher struct {
+	AppID  string
+	Index  string
+	APIKey string
+	// Host of the API, by default the one of the application.
+	Host   string
+	Client *http.Client
+	// Interval between checks of whether a task of the API is done.
+	PollInterval time.Duration
+}
+
+func NewAlgoliaPublisher(settings *Settings) *AlgoliaPublisher {
+	return &AlgoliaPublisher{
+		AppID:        settings.AlgoliaAppID,
+		Index:        settings.AlgoliaIndex,
+		APIKey:       os.Getenv(algoliaAPIKeyEnv),
+		Host:         "https://" + settings.AlgoliaAppID + ".algolia.net",
+		Client:       &http.Client{Timeout: 60 * time.Second},
+		PollInterval: time.Second,
+	}
+}
+
+func (publisher *AlgoliaPublisher) Name() string {
+	return "algolia index " + publisher.Index
+}
+
+// A task of the API, which changes an index asynchronously.
+type algoliaTask struct {
+	TaskID int64  `json:"taskID"`
+	Status string `json:"status"`
+}
+
+// Sends a request to the API of an index, encoding body and decoding the response
+// into result. Returns the status of the response, also when it is an error.
+func (publisher *AlgoliaPublisher) do(
+	ctx context.Context, method string, index string, apiPath string,
+	body interface{}, result interface{},
+) (int, error) {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return 0, err
+		}
+	}
+	requestURL := publisher.Host + "/1/indexes/" + url.PathEscape(index) + apiPath
+	request, err := http.NewRequestWithContext(ctx, method, requestURL, bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Algolia-Application-Id", publisher.AppID)
+	request.Header.Set("X-Algolia-API-Key", publisher.APIKey)
+
+	resp, err := publisher.Client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	data, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, xerrors.Errorf(
+			"%v %v: unexpected status %v: %v", method, index+apiPath, resp.Status, string(data),
+		)
+	}
+	return resp.StatusCode, json.Unmarshal(data, result)
+}
+
+// Waits until a task of the API on an index is published.
+func (publisher *AlgoliaPublisher) waitTask(
+	ctx context.Context, index string, task *algoliaTask,
+) error {
+	for {
+		status := new(algoliaTask)
+		path := "/task/" + strconv.FormatInt(task.TaskID, 10)
+		if _, err := publisher.do(ctx, http.MethodGet, index, path, nil, status); err != nil {
+			return xerrors.Errorf("error checking task %v: %w", task.TaskID, err)
+		}
+		if status.Status == "published" {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(publisher.PollInterval):
+		}
+	}
+}
+
+// Runs an operation copying or moving an index to destination, and waits for it.
+func (publisher *AlgoliaPublisher) operation(
+	ctx context.Context, index string, operation map[string]interface{},
+) error {
+	task := new(algoliaTask)
+	_, err := publisher.do(ctx, http.MethodPost, index, "/operation", operation, task)
+	if err != nil {
+		return xerrors.Errorf("error running %v of %v: %w", operation["operation"], index, err)
+	}
+	return publisher.waitTask(ctx, index, task)
+}
+
+func (publisher *AlgoliaPublisher) Publish(ctx context.Context, runInfo *RunInfo) error {
+	settings := runInfo.Settings
+	data, err := ioutil.ReadFile(filepath.Join(settings.BuildDir, docSearchRecordsName))
+	if err != nil {
+		return xerrors.Errorf("error reading docsearch records: %w", err)
+	}
+	records := make([]json.RawMessage, 0)
+	if err := json.Unmarshal(data, &records); err != nil {
+		return xerrors.Errorf("error parsing docsearch records: %w", err)
+	}
+
+	// The temporary index left by a failed publish is deleted, and it starts with the
+	// settings, synonyms and rules of the index, unless this is the first publish.
+	tempIndex := publisher.Index + "_tmp"
+	task := new(algoliaTask)
+	if _, err := publisher.do(ctx, http.MethodDelete, tempIndex, "", nil, task); err != nil {
+		return xerrors.Errorf("error deleting %v: %w", tempIndex, err)
+	}
+	if err := publisher.waitTask(ctx, tempIndex, task); err != nil {
+		return err
+	}
+	status, err := publisher.do(
+		ctx, http.MethodGet, publisher.Index, "/settings", nil, new(json.RawMessage),
+	)
+	if err != nil && status != http.StatusNotFound {
+		return xerrors.Errorf("error reading settings of %v: %w", publisher.Index, err)
+	}
+	if status == http.StatusNotFound && len(records) == 0 {
+		return nil
+	}
+	if status != http.StatusNotFound {
+		err = publisher.operation(ctx, publisher.Index, map[string]interface{}{
+			"operation":   "copy",
+			"destination": tempIndex,
+			"scope":       []string{"settings", "synonyms", "rules"},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for start := 0; start < len(records); start += algoliaBatchSize {
+		end := start + algoliaBatchSize
+		if end > len(records) {
+			end = len(records)
+		}
+		requests := make([]map[string]interface{}, 0, end-start)
+		for _, record := range records[start:end] {
+			requests = append(requests, map[string]interface{}{
+				"action": "addObject", "body": record,
+			})
+		}
+		task := new(algoliaTask)
+		body := map[string]interface{}{"requests": requests}
+		_, err := publisher.do(ctx, http.MethodPost, tempIndex, "/batch", body, task)
+		if err != nil {
+			return xerrors.Errorf("error sending docsearch records: %w", err)
+		}
+		if err := publisher.waitTask(ctx, tempIndex, task); err != nil {
+			return err
+		}
+	}
+
+	err = publisher.operation(ctx, tempIndex, map[string]interface{}{
+		"operation":   "move",
+		"destination": publisher.Index,
+	})
+	if err != nil {
+		return err
+	}
+	runInfo.Summary.AddPublished("algolia", publisher.AppID+"/"+publisher.Index)
+	return nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"golang.org/x/xerrors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Environment variable holding the artifact repository password or API token.
+const artifactPasswordEnv = "DOCMODULE_ARTIFACT_PASSWORD"
+
+// ArtifactPublisher uploads the docs as a versioned artifact to a generic
+// Artifactory or Nexus raw repository. Files are laid out maven-style:
+//
+//	<repo>/<group path>/<name>/<version>/<name>-<version>-docs.tar.gz
+//	<repo>/<group path>/<name>/<version>/site/...
+//
+// Versioned paths are immutable, so no manifest diffing is done.
+type ArtifactPublisher struct {
+	// Repository URL, without a trailing slash.
+	RepoURL  string
+	Group    string
+	Artifact string
+	Version  string
+	User     string
+	Password string
+	// Whether to also upload the unpacked site next to the archive.
+	Unpacked bool
+	Client   *http.Client
+}
+
+func NewArtifactPublisher(settings *Settings) *ArtifactPublisher {
+	name := settings.ArtifactName
+	if name == "" {
+		name = path.Base(settings.ModName)
+	}
+
+	return &ArtifactPublisher{
+		RepoURL:  strings.TrimSuffix(settings.ArtifactRepoURL, "/"),
+		Group:    settings.ArtifactGroup,
+		Artifact: name,
+		Version:  settings.ArtifactVersion,
+		User:     settings.ArtifactUser,
+		Password: os.Getenv(artifactPasswordEnv),
+		Unpacked: settings.ArtifactUnpacked,
+		Client:   &http.Client{Timeout: 5 * time.Minute},
+	}
+}
+
+func (publisher *ArtifactPublisher) Name() string {
+	return "artifact repository " + publisher.RepoURL
+}
+
+// URL of the directory holding this version's files.
+func (publisher *ArtifactPublisher) versionURL() string {
+	groupPath := strings.Replace(publisher.Group, ".", "/", -1)
+	return strings.Join(
+		[]string{
+			publisher.RepoURL,
+			escapePath(groupPath),
+			escapePath(publisher.Artifact),
+			escapePath(publisher.Version),
+		},
+		"/",
+	)
+}
+
+func (publisher *ArtifactPublisher) Publish(ctx context.Context, runInfo *RunInfo) error {
+	settings := runInfo.Settings
+	if publisher.Group == "" || publisher.Version == "" {
+		return xerrors.New("artifact group and version are required")
+	}
+
+	tempDir, err := ioutil.TempDir("", "docmodule")
+	if err != nil {
+		return xerrors.Errorf("error creating temp dir: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	archiveName := publisher.Artifact + "-" + publisher.Version + "-docs.tar.gz"
+	archivePath := filepath.Join(tempDir, archiveName)
+	if err := writeTarGz(settings.BuildDir, archivePath); err != nil {
+		return err
+	}
+
+	baseURL := publisher.versionURL()
+	if err := publisher.put(ctx, archivePath, baseURL+"/"+escapePath(archiveName)); err != nil {
+		return err
+	}
+	runInfo.Summary.AddPublished("artifact", baseURL+"/"+archiveName)
+
+	if !publisher.Unpacked {
+		return nil
+	}
+
+	manifest, err := buildSiteManifest(settings.BuildDir)
+	if err != nil {
+		return err
+	}
+	for relPath := range manifest.Files {
+		localPath := filepath.Join(settings.BuildDir, filepath.FromSlash(relPath))
+		if err := publisher.put(ctx, localPath, baseURL+"/site/"+escapePath(relPath)); err != nil {
+			return err
+		}
+	}
+	runInfo.Summary.AddPublished("artifact-site", baseURL+"/site/")
+
+	return nil
+}
+
+func (publisher *ArtifactPublisher) put(
+	ctx context.Context, localPath string, url string,
+) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	err = httpPut(ctx, publisher.Client, url, publisher.User, publisher.Password, file)
+	if err != nil {
+		return xerrors.Errorf("error uploading %q: %w", localPath, err)
+	}
+	return nil
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"golang.org/x/xerrors"
+	"html"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Environment variable holding the Confluence API token, or the personal access token
+// of Confluence Server and Data Center when no user is set.
+const confluenceTokenEnv = "DOCMODULE_CONFLUENCE_TOKEN"
+
+// ConfluencePublisher renders the doc model in the storage format of Confluence and
+// creates or updates a page per package through the REST API. The pages are titled
+// by import path, which is unique in a space, and nested like the packages under
+// the configured parent page.
+type ConfluencePublisher struct {
+	// Base URL of the Confluence instance, like https://example.atlassian.net/wiki,
+	// without a trailing slash.
+	BaseURL string
+	Space   string
+	// Id of the page the pages of the packages are created under.
+	ParentID string
+	User     string
+	Token    string
+	Client   *http.Client
+}
+
+func NewConfluencePublisher(settings *Settings) *ConfluencePublisher {
+	return &ConfluencePublisher{
+		BaseURL:  strings.TrimSuffix(settings.ConfluenceURL, "/"),
+		Space:    settings.ConfluenceSpace,
+		ParentID: settings.ConfluenceParent,
+		User:     settings.ConfluenceUser,
+		Token:    os.Getenv(confluenceTokenEnv),
+		Client:   &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+func (publisher *ConfluencePublisher) Name() string {
+	return "confluence " + publisher.BaseURL + " space " + publisher.Space
+}
+
+// A page of the content API.
+type confluencePage struct {
+	ID        string                `json:"id,omitempty"`
+	Type      string                `json:"type"`
+	Title     string                `json:"title"`
+	Space     *confluenceSpace      `json:"space,omitempty"`
+	Ancestors []*confluenceAncestor `json:"ancestors,omitempty"`
+	Body      *confluenceBody       `json:"body,omitempty"`
+	Version   *confluenceVersion    `json:"version,omitempty"`
+}
+
+type confluenceSpace struct {
+	Key string `json:"key"`
+}
+
+type confluenceAncestor struct {
+	ID string `json:"id"`
+}
+
+type confluenceBody struct {
+	Storage *confluenceStorage `json:"storage"`
+}
+
+type confluenceStorage struct {
+	Value          string `json:"value"`
+	Representation string `json:"representation"`
+}
+
+type confluenceVersion struct {
+	Number int `json:"number"`
+}
+
+// Sends a request to the REST API, encoding body and decoding the response into
+// result if they are set.
+func (publisher *ConfluencePublisher) do(
+	ctx context.Context, method string, apiPath string, body interface{}, result interface{},
+) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	requestURL := publisher.BaseURL + "/rest/api/" + apiPath
+	request, err := http.NewRequestWithContext(ctx, method, requestURL, reader)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", "application/json")
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if publisher.User != "" {
+		request.SetBasicAuth(publisher.User, publisher.Token)
+	} else if publisher.Token != "" {
+		request.Header.Set("Authorization", "Bearer "+publisher.Token)
+	}
+
+	resp, err := publisher.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return xerrors.Errorf(
+			"%v %v: unexpected status %v: %v", method, apiPath, resp.Status, string(data),
+		)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(data, result)
+}
+
+// Returns the page of the space with the title, or nil if there is none.
+func (publisher *ConfluencePublisher) findPage(
+	ctx context.Context, title string,
+) (*confluencePage, error) {
+	query := url.Values{}
+	query.Set("spaceKey", publisher.Space)
+	query.Set("title", title)
+	query.Set("expand", "version")
+	var result struct {
+		Results []*confluencePage `json:"results"`
+	}
+	err := publisher.do(ctx, http.MethodGet, "content?"+query.Encode(), nil, &result)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Results) == 0 {
+		return nil, nil
+	}
+	return result.Results[0], nil
+}
+
+// Creates the page with the title under the parent, or updates it to a new version if
+// it exists, and returns its id.
+func (publisher *ConfluencePublisher) putPage(
+	ctx context.Context, title string, parentID string, storage string,
+) (string, error) {
+	existing, err := publisher.findPage(ctx, title)
+	if err != nil {
+		return "", xerrors.Errorf("error finding page %q: %w", title, err)
+	}
+
+	page := &confluencePage{
+		Type:      "page",
+		Title:     title,
+		Space:     &confluenceSpace{Key: publisher.Space},
+		Ancestors: []*confluenceAncestor{{ID: parentID}},
+		Body: &confluenceBody{
+			Storage: &confluenceStorage{Value: storage, Representation: "storage"},
+		},
+	}
+
+	if existing == nil {
+		result := new(confluencePage)
+		if err := publisher.do(ctx, http.MethodPost, "content", page, result); err != nil {
+			return "", xerrors.Errorf("error creating page %q: %w", title, err)
+		}
+		return result.ID, nil
+	}
+
+	version := 1
+	if existing.Version != nil {
+		version = existing.Version.Number + 1
+	}
+	page.ID = existing.ID
+	page.Version = &confluenceVersion{Number: version}
+	err = publisher.do(ctx, http.MethodPut, "content/"+existing.ID, page, nil)
+	if err != nil {
+		return "", xerrors.Errorf("error updating page %q: %w", title, err)
+	}
+	return existing.ID, nil
+}
+
+func (publisher *ConfluencePublisher) Publish(ctx context.Context, runInfo *RunInfo) error {
+	settings := runInfo.Settings
+	if publisher.Space == "" || publisher.ParentID == "" {
+		return xerrors.New("confluence space and parent page are required")
+	}
+	model, err := loadBuildDocModel(settings)
+	if err != nil {
+		return xerrors.Errorf("error loading doc model: %w", err)
+	}
+
+	// Packages are sorted by import path, so the page of a package's nearest parent
+	// package is put before its own.
+	pageIDs := make(map[string]string)
+	for _, pkg := range model.Packages {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		parentID := publisher.ParentID
+		for parent := pkg.ImportPath; strings.Contains(parent, "/"); {
+			parent = parent[:strings.LastIndex(parent, "/")]
+			if id, ok := pageIDs[parent]; ok {
+				parentID = id
+				break
+			}
+		}
+		storage := renderConfluencePackage(model, pkg)
+		id, err := publisher.putPage(ctx, pkg.ImportPath, parentID, storage)
+		if err != nil {
+			return err
+		}
+		pageIDs[pkg.ImportPath] = id
+	}
+
+	runInfo.Summary.AddPublished(
+		"confluence", publisher.BaseURL+"/pages/viewpage.action?pageId="+publisher.ParentID,
+	)
+	return nil
+}
+
+// Returns text as the content of a CDATA section, splitting the ends of sections it
+// holds.
+func confluenceCDATA(text string) string {
+	return "<![CDATA[" + strings.Replace(text, "]]>", "]]]]><![CDATA[>", -1) + "]]>"
+}
+
+// Returns the code macro displaying code.
+func confluenceCodeMacro(code string) string {
+	return `<ac:structured-macro ac:name="code">` +
+		`<ac:parameter ac:name="language">go</ac:parameter>` +
+		"<ac:plain-text-body>" + confluenceCDATA(code) + "</ac:plain-text-body>" +
+		"</ac:structured-macro>"
+}
+
+// Returns the anchor macro named name, which links to a heading target.
+func confluenceAnchorMacro(name string) string {
+	return `<ac:structured-macro ac:name="anchor">` +
+		`<ac:parameter ac:name="">` + html.EscapeString(name) + "</ac:parameter>" +
+		"</ac:structured-macro>"
+}
+
+// Renders a doc comment of pkg in the storage format: paragraphs of prose, with the
+// doc links to the module as links to the pages, and code blocks as code macros.
+func renderConfluenceDoc(site *siteMarkdown, pkg *ModelPackage, doc string) string {
+	rendered := new(strings.Builder)
+	paragraph := make([]string, 0)
+	flushParagraph := func() {
+		if len(paragraph) == 0 {
+			return
+		}
+		text := html.EscapeString(strings.Join(paragraph, " "))
+		text = docLinkRegex.ReplaceAllStringFunc(text, func(match string) string {
+			groups := docLinkRegex.FindStringSubmatch(match)
+			target, symbol := site.resolveDocLink(pkg, groups[1])
+			if target == nil {
+				return match
+			}
+			link := `<ac:link`
+			if symbol != "" {
+				link += ` ac:anchor="` + html.EscapeString(markdownAnchor(symbol)) + `"`
+			}
+			if target != pkg {
+				link += `><ri:page ri:content-title="` + html.EscapeString(target.ImportPath) + `"/>`
+			} else {
+				link += ">"
+			}
+			return link + "<ac:plain-text-link-body>" +
+				confluenceCDATA(groups[1]) +
+				"</ac:plain-text-link-body></ac:link>"
+		})
+		rendered.WriteString("<p>" + text + "</p>")
+		paragraph = paragraph[:0]
+	}
+
+	lines := strings.Split(doc, "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if isDocCodeLine(line) {
+			flushParagraph()
+			end := i
+			for j := i; j < len(lines); j++ {
+				if isDocCodeLine(lines[j]) {
+					end = j
+				} else if strings.TrimSpace(lines[j]) != "" {
+					break
+				}
+			}
+			code := strings.Join(dedentLines(lines[i:end+1]), "\n")
+			rendered.WriteString(confluenceCodeMacro(code))
+			i = end
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			flushParagraph()
+			continue
+		}
+		paragraph = append(paragraph, line)
+	}
+	flushParagraph()
+	return rendered.String()
+}
+
+// Renders the page of a package in the storage format of Confluence: its doc, its
+// import path and a section per symbol with an anchor named like those of the
+// markdown formats.
+func renderConfluencePackage(model *DocModel, pkg *ModelPackage) string {
+	site := &siteMarkdown{Model: model}
+	rendered := new(strings.Builder)
+	rendered.WriteString(renderConfluenceDoc(site, pkg, pkg.Doc))
+	rendered.WriteString(confluenceCodeMacro(`import "` + pkg.ImportPath + `"`))
+	for _, symbol := range pkg.Symbols {
+		rendered.WriteString(
+			"<h2>" + confluenceAnchorMacro(markdownAnchor(symbol.Name)) +
+				html.EscapeString(symbol.Kind+" "+symbol.Name) + "</h2>",
+		)
+		rendered.WriteString(confluenceCodeMacro(symbol.Signature))
+		rendered.WriteString(renderConfluenceDoc(site, pkg, symbol.Doc))
+	}
+	return rendered.String()
+}
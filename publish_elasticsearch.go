@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"golang.org/x/xerrors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Environment variable holding the password of --search-user, or the API key of the
+// search cluster when no user is set.
+const searchTokenEnv = "DOCMODULE_SEARCH_TOKEN"
+
+// Size the bulk file is split into requests of, below the default 100MB request limit
+// of Elasticsearch and OpenSearch.
+const searchBulkRequestSize = 10 << 20
+
+// SearchIndexPublisher sends the bulk file of the elasticsearch format to the _bulk
+// API of an Elasticsearch or OpenSearch cluster.
+type SearchIndexPublisher struct {
+	// Cluster URL, without a trailing slash.
+	Endpoint string
+	User     string
+	Token    string
+	Client   *http.Client
+}
+
+func NewSearchIndexPublisher(settings *Settings) *SearchIndexPublisher {
+	return &SearchIndexPublisher{
+		Endpoint: strings.TrimSuffix(settings.SearchEndpoint, "/"),
+		User:     settings.SearchUser,
+		Token:    os.Getenv(searchTokenEnv),
+		Client:   &http.Client{Timeout: 5 * time.Minute},
+	}
+}
+
+func (publisher *SearchIndexPublisher) Name() string {
+	return "search index " + publisher.Endpoint
+}
+
+// Response of the _bulk API, with the result of every action.
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		ID     string          `json:"_id"`
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error"`
+	} `json:"items"`
+}
+
+// Sends one request of action and source lines to the _bulk API, failing with the
+// first action the cluster rejected.
+func (publisher *SearchIndexPublisher) sendBulk(ctx context.Context, body []byte) error {
+	url := publisher.Endpoint + "/_bulk"
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/x-ndjson")
+	if publisher.User != "" {
+		request.SetBasicAuth(publisher.User, publisher.Token)
+	} else if publisher.Token != "" {
+		request.Header.Set("Authorization", "ApiKey "+publisher.Token)
+	}
+
+	resp, err := publisher.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return xerrors.Errorf("POST %v: unexpected status %v: %v", url, resp.Status, string(data))
+	}
+
+	result := new(bulkResponse)
+	if err := json.Unmarshal(data, result); err != nil {
+		return xerrors.Errorf("error parsing bulk response: %w", err)
+	}
+	if !result.Errors {
+		return nil
+	}
+	for _, item := range result.Items {
+		for action, outcome := range item {
+			if outcome.Status < 200 || outcome.Status > 299 {
+				return xerrors.Errorf(
+					"%v of %v failed with status %v: %s",
+					action, outcome.ID, outcome.Status, outcome.Error,
+				)
+			}
+		}
+	}
+	return xerrors.New("bulk request reported errors")
+}
+
+func (publisher *SearchIndexPublisher) Publish(ctx context.Context, runInfo *RunInfo) error {
+	settings := runInfo.Settings
+	data, err := ioutil.ReadFile(filepath.Join(settings.BuildDir, searchBulkName))
+	if err != nil {
+		return xerrors.Errorf("error reading search bulk file: %w", err)
+	}
+
+	// Action and source lines come in pairs, which must stay in the same request.
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	body := new(bytes.Buffer)
+	for i := 0; i+1 < len(lines); i += 2 {
+		body.WriteString(lines[i] + "\n" + lines[i+1] + "\n")
+		if body.Len() >= searchBulkRequestSize || i+3 >= len(lines) {
+			if err := publisher.sendBulk(ctx, body.Bytes()); err != nil {
+				return err
+			}
+			body.Reset()
+		}
+	}
+
+	runInfo.Summary.AddPublished("search index", publisher.Endpoint)
+	return nil
+}
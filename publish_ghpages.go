@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"golang.org/x/xerrors"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// GitHubPagesPublisher commits the build directory as the whole tree of a branch of
+// the module's git repository, gh-pages by default, and pushes it.
+type GitHubPagesPublisher struct {
+	Branch string
+	// Remote to push the branch to, empty to only commit locally.
+	Remote string
+	// Commit message, generated from the module and its version when empty.
+	Message string
+}
+
+func (publisher *GitHubPagesPublisher) Name() string {
+	return "git branch " + publisher.Branch
+}
+
+// Runs git in dir with extra environment variables, returning its trimmed output.
+func runGit(
+	ctx context.Context, dir string, env []string, stdin []byte, args ...string,
+) (string, error) {
+	command := exec.CommandContext(ctx, "git", args...)
+	command.Dir = dir
+	command.Env = append(os.Environ(), env...)
+	if stdin != nil {
+		command.Stdin = bytes.NewReader(stdin)
+	}
+	stderr := new(bytes.Buffer)
+	command.Stderr = stderr
+	output, err := command.Output()
+	if err != nil {
+		return "", xerrors.Errorf(
+			"error running git %v: %w, output: %v",
+			args[0],
+			err,
+			strings.TrimSpace(stderr.String()),
+		)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// Returns the message of the deploy commit.
+func (publisher *GitHubPagesPublisher) commitMessage(
+	ctx context.Context, settings *Settings, repoRoot string,
+) string {
+	if publisher.Message != "" {
+		return publisher.Message
+	}
+	message := "Deploy docs of " + settings.ModName
+	if version := detectDocVersion(settings); version != "" {
+		message += " " + version
+	}
+	if source, err := runGit(ctx, repoRoot, nil, nil, "rev-parse", "HEAD"); err == nil {
+		message += "\n\nBuilt from " + source + "."
+	}
+	return message
+}
+
+func (publisher *GitHubPagesPublisher) Publish(ctx context.Context, runInfo *RunInfo) error {
+	settings := runInfo.Settings
+	repoRoot, err := runGit(
+		ctx, settings.ModuleRootPath, nil, nil, "rev-parse", "--show-toplevel",
+	)
+	if err != nil {
+		return err
+	}
+	buildDir, err := filepath.Abs(settings.BuildDir)
+	if err != nil {
+		return err
+	}
+
+	// Stage the build directory in a scratch index, so neither the working tree nor
+	// the index of the repository are touched.
+	indexFile, err := ioutil.TempFile("", "docmodule-index-")
+	if err != nil {
+		return err
+	}
+	indexFile.Close()
+	defer os.Remove(indexFile.Name())
+	// git refuses to read an empty file as index.
+	os.Remove(indexFile.Name())
+	env := []string{"GIT_INDEX_FILE=" + indexFile.Name()}
+
+	_, err = runGit(ctx, repoRoot, env, nil, "--work-tree="+buildDir, "add", "-A", "-f", ".")
+	if err != nil {
+		return err
+	}
+	// Stop GitHub Pages from running the site through Jekyll, which drops files
+	// starting with an underscore.
+	noJekyll, err := runGit(ctx, repoRoot, nil, []byte{}, "hash-object", "-w", "--stdin")
+	if err != nil {
+		return err
+	}
+	_, err = runGit(
+		ctx, repoRoot, env, nil, "update-index", "--add", "--cacheinfo", "100644,"+noJekyll+",.nojekyll",
+	)
+	if err != nil {
+		return err
+	}
+	tree, err := runGit(ctx, repoRoot, env, nil, "write-tree")
+	if err != nil {
+		return err
+	}
+
+	ref := "refs/heads/" + publisher.Branch
+	commitArgs := []string{
+		"commit-tree", tree, "-m", publisher.commitMessage(ctx, settings, repoRoot),
+	}
+	parent, err := runGit(ctx, repoRoot, nil, nil, "rev-parse", "--verify", "--quiet", ref)
+	if err == nil {
+		parentTree, err := runGit(ctx, repoRoot, nil, nil, "rev-parse", parent+"^{tree}")
+		if err != nil {
+			return err
+		}
+		if parentTree == tree {
+			log.Printf("%v already holds this build.", publisher.Branch)
+			return publisher.push(ctx, runInfo, repoRoot)
+		}
+		commitArgs = append(commitArgs, "-p", parent)
+	}
+
+	commit, err := runGit(ctx, repoRoot, nil, nil, commitArgs...)
+	if err != nil {
+		return err
+	}
+	if _, err := runGit(ctx, repoRoot, nil, nil, "update-ref", ref, commit); err != nil {
+		return err
+	}
+	log.Printf("committed build as %v on %v.", commit, publisher.Branch)
+	return publisher.push(ctx, runInfo, repoRoot)
+}
+
+// Pushes the branch to the remote, if one is set.
+func (publisher *GitHubPagesPublisher) push(
+	ctx context.Context, runInfo *RunInfo, repoRoot string,
+) error {
+	location := repoRoot + "#" + publisher.Branch
+	if publisher.Remote != "" {
+		_, err := runGit(ctx, repoRoot, nil, nil, "push", publisher.Remote, publisher.Branch)
+		if err != nil {
+			return err
+		}
+		location = publisher.Remote + "#" + publisher.Branch
+	}
+	runInfo.Summary.AddPublished("git", location)
+	return nil
+}
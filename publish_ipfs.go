@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"golang.org/x/xerrors"
+	"os/exec"
+	"strings"
+)
+
+// IPFSPublisher adds the build directory to the local IPFS node through the ipfs
+// CLI. This is experimental: pinning the content on a public gateway or cluster is
+// left to the caller.
+type IPFSPublisher struct{}
+
+func NewIPFSPublisher() *IPFSPublisher {
+	return new(IPFSPublisher)
+}
+
+func (publisher *IPFSPublisher) Name() string {
+	return "ipfs"
+}
+
+func (publisher *IPFSPublisher) Publish(ctx context.Context, runInfo *RunInfo) error {
+	command := exec.CommandContext(
+		ctx,
+		"ipfs",
+		"add",
+		// add the directory recursively
+		"-r",
+		// only print the root CID
+		"-Q",
+		// CIDv1 is required for subdomain gateways
+		"--cid-version=1",
+		runInfo.Settings.BuildDir,
+	)
+	output, err := command.Output()
+	if err != nil {
+		return xerrors.Errorf("error adding build directory to ipfs: %w", err)
+	}
+
+	cid := strings.TrimSpace(string(output))
+	if cid == "" {
+		return xerrors.New("ipfs did not return a CID")
+	}
+
+	runInfo.Summary.AddPublished("ipfs", "ipfs://"+cid)
+	return nil
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"golang.org/x/xerrors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Environment variables holding the credentials of the S3 and CloudFront requests,
+// the same the AWS tools read.
+const (
+	s3AccessKeyEnv    = "AWS_ACCESS_KEY_ID"
+	s3SecretKeyEnv    = "AWS_SECRET_ACCESS_KEY"
+	s3SessionTokenEnv = "AWS_SESSION_TOKEN"
+)
+
+// Endpoint of the CloudFront API, which is signed for us-east-1 whatever the region
+// of the bucket.
+const cloudFrontEndpoint = "https://cloudfront.amazonaws.com/2020-05-31"
+
+// Most paths invalidated one by one. Past it the whole prefix is invalidated with a
+// single wildcard path, which stays far below CloudFront's limit of 3000 paths per
+// invalidation and is billed as one path.
+const cloudFrontMaxPaths = 100
+
+// S3Publisher syncs the build directory to a bucket of an S3-compatible object
+// store.
+type S3Publisher struct {
+	// Endpoint URL of the store, without a trailing slash.
+	Endpoint string
+	Region   string
+	Bucket   string
+	// Key prefix the site is stored under, without leading or trailing slashes.
+	Prefix string
+	// Address the bucket in the path rather than as a subdomain of the endpoint, as
+	// most S3-compatible stores expect.
+	PathStyle bool
+	// CloudFront distribution to invalidate after the upload, if any.
+	Distribution string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	Client       *http.Client
+	// Keys uploaded or deleted during this run, which need invalidating.
+	touched []string
+}
+
+func NewS3Publisher(deploySettings *DeploySettings) *S3Publisher {
+	return &S3Publisher{
+		Endpoint:     strings.TrimSuffix(deploySettings.Endpoint, "/"),
+		Region:       deploySettings.Region,
+		Bucket:       deploySettings.Bucket,
+		Prefix:       strings.Trim(deploySettings.Prefix, "/"),
+		PathStyle:    deploySettings.PathStyle,
+		Distribution: deploySettings.Distribution,
+		AccessKey:    os.Getenv(s3AccessKeyEnv),
+		SecretKey:    os.Getenv(s3SecretKeyEnv),
+		SessionToken: os.Getenv(s3SessionTokenEnv),
+		Client:       &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+func (publisher *S3Publisher) Name() string {
+	return "s3://" + path.Join(publisher.Bucket, publisher.Prefix)
+}
+
+func (publisher *S3Publisher) Publish(ctx context.Context, runInfo *RunInfo) error {
+	if publisher.AccessKey == "" || publisher.SecretKey == "" {
+		return xerrors.Errorf("%v and %v must be set", s3AccessKeyEnv, s3SecretKeyEnv)
+	}
+
+	err := syncToTarget(ctx, runInfo.Settings, publisher.Name(), publisher)
+	if err != nil {
+		return err
+	}
+	runInfo.Summary.AddPublished("s3", publisher.Name()+"/")
+
+	if publisher.Distribution == "" || len(publisher.touched) == 0 {
+		return nil
+	}
+	if err := publisher.invalidate(ctx); err != nil {
+		return xerrors.Errorf("error invalidating CloudFront distribution: %w", err)
+	}
+	return nil
+}
+
+// Returns the key of a file of the site.
+func (publisher *S3Publisher) key(relPath string) string {
+	if publisher.Prefix == "" {
+		return relPath
+	}
+	return publisher.Prefix + "/" + relPath
+}
+
+// Returns the URL of an object of the bucket.
+func (publisher *S3Publisher) objectURL(key string) (*url.URL, error) {
+	endpoint, err := url.Parse(publisher.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	objectPath := "/" + key
+	if publisher.PathStyle {
+		objectPath = "/" + publisher.Bucket + objectPath
+	} else {
+		endpoint.Host = publisher.Bucket + "." + endpoint.Host
+	}
+	endpoint.Path = strings.TrimSuffix(endpoint.Path, "/") + objectPath
+	endpoint.RawPath = s3EscapePath(endpoint.Path)
+	return endpoint, nil
+}
+
+// Issues a signed request for an object, returning the response and its body, or an
+// error for any status not listed in okStatuses.
+func (publisher *S3Publisher) doExpect(
+	ctx context.Context,
+	method string,
+	key string,
+	body []byte,
+	contentType string,
+	okStatuses ...int,
+) (*http.Response, []byte, error) {
+	objectURL, err := publisher.objectURL(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	request, err := http.NewRequestWithContext(
+		ctx, method, objectURL.String(), bytes.NewReader(body),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+	publisher.sign(request, body, "s3", publisher.Region, time.Now())
+
+	resp, err := publisher.Client.Do(request)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, status := range okStatuses {
+		if resp.StatusCode == status {
+			return resp, data, nil
+		}
+	}
+	return nil, nil, xerrors.Errorf(
+		"%v %v: unexpected status %v: %s", method, key, resp.Status, bytes.TrimSpace(data),
+	)
+}
+
+func (publisher *S3Publisher) RemoteManifest(ctx context.Context) (*SiteManifest, error) {
+	resp, data, err := publisher.doExpect(
+		ctx,
+		http.MethodGet,
+		publisher.key(remoteManifestName),
+		nil,
+		"",
+		http.StatusOK,
+		http.StatusNotFound,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return NewSiteManifest(), nil
+	}
+	return decodeSiteManifest(data)
+}
+
+// Returns the Content-Type of a file of the site from its extension.
+func s3ContentType(relPath string) string {
+	contentType := mime.TypeByExtension(path.Ext(relPath))
+	if contentType == "" {
+		return "application/octet-stream"
+	}
+	return contentType
+}
+
+func (publisher *S3Publisher) Upload(
+	ctx context.Context, buildDir string, relPath string,
+) error {
+	data, err := ioutil.ReadFile(filepath.Join(buildDir, filepath.FromSlash(relPath)))
+	if err != nil {
+		return err
+	}
+	_, _, err = publisher.doExpect(
+		ctx,
+		http.MethodPut,
+		publisher.key(relPath),
+		data,
+		s3ContentType(relPath),
+		http.StatusOK,
+	)
+	if err != nil {
+		return err
+	}
+	publisher.touched = append(publisher.touched, publisher.key(relPath))
+	return nil
+}
+
+func (publisher *S3Publisher) Delete(ctx context.Context, relPath string) error {
+	_, _, err := publisher.doExpect(
+		ctx,
+		http.MethodDelete,
+		publisher.key(relPath),
+		nil,
+		"",
+		http.StatusNoContent,
+		http.StatusOK,
+		http.StatusNotFound,
+	)
+	if err != nil {
+		return err
+	}
+	publisher.touched = append(publisher.touched, publisher.key(relPath))
+	return nil
+}
+
+func (publisher *S3Publisher) WriteManifest(
+	ctx context.Context, manifest *SiteManifest,
+) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, _, err = publisher.doExpect(
+		ctx,
+		http.MethodPut,
+		publisher.key(remoteManifestName),
+		data,
+		"application/json",
+		http.StatusOK,
+	)
+	return err
+}
+
+// Body of a CloudFront CreateInvalidation request.
+type cloudFrontInvalidation struct {
+	XMLName         xml.Name `xml:"InvalidationBatch"`
+	Xmlns           string   `xml:"xmlns,attr"`
+	Quantity        int      `xml:"Paths>Quantity"`
+	Items           []string `xml:"Paths>Items>Path"`
+	CallerReference string   `xml:"CallerReference"`
+}
+
+// Invalidates the keys uploaded or deleted during this run in the CloudFront
+// distribution, along with the directory index of every changed index.html. Past
+// cloudFrontMaxPaths paths, everything under the prefix is invalidated instead.
+func (publisher *S3Publisher) invalidate(ctx context.Context) error {
+	paths := make(map[string]bool)
+	for _, key := range publisher.touched {
+		paths["/"+s3EscapePath(key)] = true
+		if path.Base(key) == "index.html" {
+			paths["/"+s3EscapePath(strings.TrimSuffix(key, "index.html"))] = true
+		}
+	}
+	items := make([]string, 0, len(paths))
+	for item := range paths {
+		items = append(items, item)
+	}
+	sort.Strings(items)
+	if len(items) > cloudFrontMaxPaths {
+		wildcard := "/*"
+		if publisher.Prefix != "" {
+			wildcard = "/" + s3EscapePath(publisher.Prefix) + "/*"
+		}
+		items = []string{wildcard}
+	}
+
+	invalidation := &cloudFrontInvalidation{
+		Xmlns:           "http://cloudfront.amazonaws.com/doc/2020-05-31/",
+		Quantity:        len(items),
+		Items:           items,
+		CallerReference: fmt.Sprintf("docmodule-%v", time.Now().UnixNano()),
+	}
+	body, err := xml.Marshal(invalidation)
+	if err != nil {
+		return err
+	}
+
+	invalidationURL := cloudFrontEndpoint + "/distribution/" +
+		url.PathEscape(publisher.Distribution) + "/invalidation"
+	request, err := http.NewRequestWithContext(
+		ctx, http.MethodPost, invalidationURL, bytes.NewReader(body),
+	)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "text/xml")
+	publisher.sign(request, body, "cloudfront", "us-east-1", time.Now())
+
+	resp, err := publisher.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode != http.StatusCreated {
+		return xerrors.Errorf("unexpected status %v: %s", resp.Status, bytes.TrimSpace(data))
+	}
+	return nil
+}
+
+// Escapes a path the way AWS Signature Version 4 canonicalizes it: every byte but
+// the unreserved characters and slashes is percent-encoded.
+func s3EscapePath(value string) string {
+	var builder strings.Builder
+	for _, b := range []byte(value) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9',
+			b == '-', b == '_', b == '.', b == '~', b == '/':
+			builder.WriteByte(b)
+		default:
+			fmt.Fprintf(&builder, "%%%02X", b)
+		}
+	}
+	return builder.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// Signs request with AWS Signature Version 4.
+func (publisher *S3Publisher) sign(
+	request *http.Request, body []byte, service string, region string, now time.Time,
+) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256.Sum256(body)
+
+	request.Header.Set("X-Amz-Date", amzDate)
+	request.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+	if publisher.SessionToken != "" {
+		request.Header.Set("X-Amz-Security-Token", publisher.SessionToken)
+	}
+
+	headers := map[string]string{"host": request.URL.Host}
+	for name, values := range request.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	canonicalHeaders := new(strings.Builder)
+	for _, name := range sortedKeys(headers) {
+		names = append(names, name)
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	query := request.URL.Query()
+	queryParts := make([]string, 0, len(query))
+	for name, values := range query {
+		for _, value := range values {
+			queryParts = append(queryParts, s3EscapePath(name)+"="+s3EscapePath(value))
+		}
+	}
+	sort.Strings(queryParts)
+
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		s3EscapePath(request.URL.Path),
+		strings.Join(queryParts, "&"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" +
+		hex.EncodeToString(canonicalHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+publisher.SecretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	request.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%v/%v, SignedHeaders=%v, Signature=%v",
+		publisher.AccessKey, scope, signedHeaders, signature,
+	))
+}
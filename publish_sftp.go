@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"golang.org/x/xerrors"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// SFTPPublisher uploads the build directory to a path on a web server over SFTP
+// with the OpenSSH sftp CLI. Only changed files are transferred: uploads and
+// deletions are collected while syncing and sent in a single batch session.
+type SFTPPublisher struct {
+	// SSH host, with an optional user, e.g. deploy@example.com.
+	Host string
+	// Remote directory the site is uploaded to.
+	RemoteDir string
+	// SSH port, 0 for the ssh default or config.
+	Port int
+	// SSH private key, empty for the ssh default or agent.
+	KeyFile string
+	// Only log the commands instead of running them.
+	DryRun bool
+	// sftp batch commands collected while syncing.
+	batch []string
+	// Remote directories created during this run.
+	dirs map[string]bool
+	// Directory of the downloaded and the new manifest.
+	scratchDir string
+}
+
+// Returns a publisher for a destination of the form [user@]host:path.
+func NewSFTPPublisher(deploySettings *DeploySettings) (*SFTPPublisher, error) {
+	parts := strings.SplitN(deploySettings.Destination, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, xerrors.Errorf(
+			"invalid destination %q, expected [user@]host:path", deploySettings.Destination,
+		)
+	}
+	return &SFTPPublisher{
+		Host:      parts[0],
+		RemoteDir: strings.TrimSuffix(parts[1], "/"),
+		Port:      deploySettings.Port,
+		KeyFile:   deploySettings.KeyFile,
+		DryRun:    deploySettings.DryRun,
+		dirs:      make(map[string]bool),
+	}, nil
+}
+
+func (publisher *SFTPPublisher) Name() string {
+	return "sftp " + publisher.Host + ":" + publisher.RemoteDir
+}
+
+func (publisher *SFTPPublisher) Publish(ctx context.Context, runInfo *RunInfo) error {
+	scratchDir, err := ioutil.TempDir("", "docmodule-sftp-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(scratchDir)
+	publisher.scratchDir = scratchDir
+
+	err = syncToTarget(ctx, runInfo.Settings, publisher.Name(), publisher)
+	if err != nil {
+		return err
+	}
+	if !publisher.DryRun {
+		runInfo.Summary.AddPublished("sftp", publisher.Host+":"+publisher.RemoteDir)
+	}
+	return nil
+}
+
+// Quotes a path for an sftp batch file, which only knows backslash escapes.
+func sftpQuote(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	return `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+}
+
+// Returns the remote path of a file of the site.
+func (publisher *SFTPPublisher) remotePath(relPath string) string {
+	return path.Join(publisher.RemoteDir, relPath)
+}
+
+// Runs sftp with commands as its batch file. Commands prefixed with - may fail
+// without aborting the batch.
+func (publisher *SFTPPublisher) run(ctx context.Context, commands []string) error {
+	args := []string{"-q", "-o", "BatchMode=yes", "-b", "-"}
+	if publisher.Port != 0 {
+		args = append(args, "-P", strconv.Itoa(publisher.Port))
+	}
+	if publisher.KeyFile != "" {
+		args = append(args, "-i", publisher.KeyFile)
+	}
+	args = append(args, publisher.Host)
+
+	command := exec.CommandContext(ctx, "sftp", args...)
+	command.Stdin = strings.NewReader(strings.Join(commands, "\n") + "\n")
+	stderr := new(bytes.Buffer)
+	command.Stderr = stderr
+	if err := command.Run(); err != nil {
+		return xerrors.Errorf("error running sftp: %w: %v", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func (publisher *SFTPPublisher) RemoteManifest(ctx context.Context) (*SiteManifest, error) {
+	localPath := filepath.Join(publisher.scratchDir, "remote-manifest.json")
+	err := publisher.run(ctx, []string{
+		"-get " + sftpQuote(publisher.remotePath(remoteManifestName)) + " " +
+			sftpQuote(localPath),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(localPath)
+	if os.IsNotExist(err) {
+		return NewSiteManifest(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeSiteManifest(data)
+}
+
+// Queues the creation of every parent directory of relPath not created yet, up to
+// the remote directory.
+func (publisher *SFTPPublisher) ensureDirs(relPath string) {
+	dir := path.Dir(relPath)
+	if publisher.dirs[dir] {
+		return
+	}
+	if dir != "." {
+		publisher.ensureDirs(dir)
+	}
+	// Fails harmlessly when the directory exists.
+	publisher.batch = append(publisher.batch, "-mkdir "+sftpQuote(publisher.remotePath(dir)))
+	publisher.dirs[dir] = true
+}
+
+func (publisher *SFTPPublisher) Upload(
+	ctx context.Context, buildDir string, relPath string,
+) error {
+	publisher.ensureDirs(relPath)
+	localPath := filepath.Join(buildDir, filepath.FromSlash(relPath))
+	publisher.batch = append(
+		publisher.batch,
+		"put "+sftpQuote(localPath)+" "+sftpQuote(publisher.remotePath(relPath)),
+	)
+	return nil
+}
+
+func (publisher *SFTPPublisher) Delete(ctx context.Context, relPath string) error {
+	publisher.batch = append(publisher.batch, "-rm "+sftpQuote(publisher.remotePath(relPath)))
+	return nil
+}
+
+// Sends the queued uploads and deletions, followed by the manifest, in one session.
+func (publisher *SFTPPublisher) WriteManifest(
+	ctx context.Context, manifest *SiteManifest,
+) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	localPath := filepath.Join(publisher.scratchDir, remoteManifestName)
+	if err := ioutil.WriteFile(localPath, data, os.ModePerm); err != nil {
+		return err
+	}
+	batch := append(
+		publisher.batch,
+		"put "+sftpQuote(localPath)+" "+sftpQuote(publisher.remotePath(remoteManifestName)),
+	)
+
+	if publisher.DryRun {
+		log.Printf(
+			"dry run, would send to %v:\n%v", publisher.Host, strings.Join(publisher.batch, "\n"),
+		)
+		return nil
+	}
+	return publisher.run(ctx, batch)
+}
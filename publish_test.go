@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffManifests(t *testing.T) {
+	cases := []struct {
+		name       string
+		remote     map[string]string
+		local      map[string]string
+		changed    []string
+		extraneous []string
+	}{
+		{
+			name:    "first publish",
+			remote:  map[string]string{},
+			local:   map[string]string{"b.html": "2", "a.html": "1"},
+			changed: []string{"a.html", "b.html"},
+		},
+		{
+			name:   "unchanged",
+			remote: map[string]string{"a.html": "1"},
+			local:  map[string]string{"a.html": "1"},
+		},
+		{
+			name:       "changed and removed",
+			remote:     map[string]string{"a.html": "1", "old/index.html": "3", "c.css": "4"},
+			local:      map[string]string{"a.html": "2", "c.css": "4", "new/index.html": "3"},
+			changed:    []string{"a.html", "new/index.html"},
+			extraneous: []string{"old/index.html"},
+		},
+	}
+
+	for _, testCase := range cases {
+		t.Run(testCase.name, func(t *testing.T) {
+			changed, extraneous := diffManifests(
+				&SiteManifest{Files: testCase.remote}, &SiteManifest{Files: testCase.local},
+			)
+			if !reflect.DeepEqual(changed, testCase.changed) {
+				t.Errorf("changed = %v, want %v", changed, testCase.changed)
+			}
+			if !reflect.DeepEqual(extraneous, testCase.extraneous) {
+				t.Errorf("extraneous = %v, want %v", extraneous, testCase.extraneous)
+			}
+		})
+	}
+}
+
+func TestEscapePath(t *testing.T) {
+	cases := map[string]string{
+		"index.html":           "index.html",
+		"pkg/sub/index.html":   "pkg/sub/index.html",
+		"a b/c#d.html":         "a%20b/c%23d.html",
+		"what?/100%.html":      "what%3F/100%25.html",
+		"collection/":          "collection/",
+		"group/artifact-1.0.0": "group/artifact-1.0.0",
+	}
+	for relPath, want := range cases {
+		if got := escapePath(relPath); got != want {
+			t.Errorf("escapePath(%q) = %q, want %q", relPath, got, want)
+		}
+	}
+}
+
+func TestWebDAVDeleteRemovesEmptyCollections(t *testing.T) {
+	// Members of each collection left on the server, by collection path.
+	members := map[string][]string{
+		"/pkg/":     {"/pkg/sub/"},
+		"/pkg/sub/": {},
+		"/keep/":    {"/keep/other.html"},
+	}
+	deleted := make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(
+		func(writer http.ResponseWriter, request *http.Request) {
+			switch request.Method {
+			case http.MethodDelete:
+				deleted = append(deleted, request.URL.Path)
+				for collection, listed := range members {
+					kept := listed[:0]
+					for _, member := range listed {
+						if member != request.URL.Path {
+							kept = append(kept, member)
+						}
+					}
+					members[collection] = kept
+				}
+				writer.WriteHeader(http.StatusNoContent)
+			case "PROPFIND":
+				if request.Header.Get("Depth") != "1" {
+					t.Errorf("PROPFIND depth = %q, want 1", request.Header.Get("Depth"))
+				}
+				listed, ok := members[request.URL.Path]
+				if !ok {
+					http.NotFound(writer, request)
+					return
+				}
+				body := `<D:multistatus xmlns:D="DAV:"><D:response><D:href>` +
+					request.URL.Path + `</D:href></D:response>`
+				for _, member := range listed {
+					body += "<D:response><D:href>" + member + "</D:href></D:response>"
+				}
+				writer.WriteHeader(http.StatusMultiStatus)
+				_, _ = writer.Write([]byte(body + "</D:multistatus>"))
+			default:
+				t.Errorf("unexpected %v %v", request.Method, request.URL.Path)
+			}
+		},
+	))
+	defer server.Close()
+
+	publisher := &WebDAVPublisher{
+		BaseURL:     server.URL,
+		Client:      server.Client(),
+		collections: make(map[string]bool),
+	}
+	ctx := context.Background()
+	if err := publisher.Delete(ctx, "pkg/sub/index.html"); err != nil {
+		t.Fatal(err)
+	}
+	if err := publisher.Delete(ctx, "keep/index.html"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"/pkg/sub/index.html", "/pkg/sub/", "/pkg/", "/keep/index.html"}
+	if strings.Join(deleted, " ") != strings.Join(want, " ") {
+		t.Errorf("deleted %v, want %v", deleted, want)
+	}
+}
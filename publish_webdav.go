@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"golang.org/x/xerrors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Environment variable holding the WebDAV password, kept off the command line so it
+// does not end up in shell history or process listings.
+const webDAVPasswordEnv = "DOCMODULE_WEBDAV_PASSWORD"
+
+// WebDAVPublisher uploads the build directory to a WebDAV collection.
+type WebDAVPublisher struct {
+	// Root collection URL, without a trailing slash.
+	BaseURL  string
+	User     string
+	Password string
+	Client   *http.Client
+	// Collections we have already created or confirmed during this run.
+	collections map[string]bool
+}
+
+func NewWebDAVPublisher(settings *Settings) *WebDAVPublisher {
+	return &WebDAVPublisher{
+		BaseURL:     strings.TrimSuffix(settings.WebDAVURL, "/"),
+		User:        settings.WebDAVUser,
+		Password:    os.Getenv(webDAVPasswordEnv),
+		Client:      &http.Client{Timeout: 60 * time.Second},
+		collections: make(map[string]bool),
+	}
+}
+
+func (publisher *WebDAVPublisher) Name() string {
+	return "webdav " + publisher.BaseURL
+}
+
+func (publisher *WebDAVPublisher) Publish(ctx context.Context, runInfo *RunInfo) error {
+	err := syncToTarget(ctx, runInfo.Settings, publisher.Name(), publisher)
+	if err != nil {
+		return err
+	}
+	runInfo.Summary.AddPublished("webdav", publisher.BaseURL+"/")
+	return nil
+}
+
+// Returns an authenticated request for relPath.
+func (publisher *WebDAVPublisher) newRequest(
+	ctx context.Context, method string, relPath string, body io.Reader,
+) (*http.Request, error) {
+	// Every segment is escaped, so names with spaces, # or ? address the file itself.
+	requestURL := publisher.BaseURL + "/" + escapePath(relPath)
+	request, err := http.NewRequestWithContext(ctx, method, requestURL, body)
+	if err != nil {
+		return nil, err
+	}
+	if publisher.User != "" {
+		request.SetBasicAuth(publisher.User, publisher.Password)
+	}
+	return request, nil
+}
+
+func (publisher *WebDAVPublisher) do(
+	ctx context.Context, method string, relPath string, body io.Reader,
+) (*http.Response, error) {
+	request, err := publisher.newRequest(ctx, method, relPath, body)
+	if err != nil {
+		return nil, err
+	}
+	return publisher.Client.Do(request)
+}
+
+// Issues a request and discards the response body, returning an error for any
+// status not listed in okStatuses.
+func (publisher *WebDAVPublisher) doExpect(
+	ctx context.Context, method string, relPath string, body io.Reader, okStatuses ...int,
+) error {
+	resp, err := publisher.do(ctx, method, relPath, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	for _, status := range okStatuses {
+		if resp.StatusCode == status {
+			return nil
+		}
+	}
+	return xerrors.Errorf("%v %v: unexpected status %v", method, relPath, resp.Status)
+}
+
+// Creates every parent collection of relPath which we have not seen yet.
+func (publisher *WebDAVPublisher) ensureCollections(
+	ctx context.Context, relPath string,
+) error {
+	dir := path.Dir(relPath)
+	if dir == "." || publisher.collections[dir] {
+		return nil
+	}
+	if err := publisher.ensureCollections(ctx, dir); err != nil {
+		return err
+	}
+
+	// 405 is returned when the collection already exists.
+	err := publisher.doExpect(
+		ctx,
+		"MKCOL", dir+"/", nil, http.StatusCreated, http.StatusMethodNotAllowed,
+	)
+	if err != nil {
+		return err
+	}
+	publisher.collections[dir] = true
+	return nil
+}
+
+func (publisher *WebDAVPublisher) RemoteManifest(
+	ctx context.Context,
+) (*SiteManifest, error) {
+	resp, err := publisher.do(ctx, http.MethodGet, remoteManifestName, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return NewSiteManifest(), nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, xerrors.Errorf("unexpected status %v", resp.Status)
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return decodeSiteManifest(data)
+}
+
+func (publisher *WebDAVPublisher) Upload(
+	ctx context.Context, buildDir string, relPath string,
+) error {
+	if err := publisher.ensureCollections(ctx, relPath); err != nil {
+		return err
+	}
+
+	file, err := os.Open(filepath.Join(buildDir, filepath.FromSlash(relPath)))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return publisher.doExpect(
+		ctx,
+		http.MethodPut,
+		relPath,
+		file,
+		http.StatusCreated,
+		http.StatusNoContent,
+		http.StatusOK,
+	)
+}
+
+// Deletes a file, and then every parent collection it leaves empty, so the
+// collections of renamed packages don't pile up on the server.
+func (publisher *WebDAVPublisher) Delete(ctx context.Context, relPath string) error {
+	err := publisher.doExpect(
+		ctx,
+		http.MethodDelete,
+		relPath,
+		nil,
+		http.StatusNoContent,
+		http.StatusOK,
+		http.StatusNotFound,
+	)
+	if err != nil {
+		return err
+	}
+
+	for dir := path.Dir(relPath); dir != "."; dir = path.Dir(dir) {
+		empty, err := publisher.isEmptyCollection(ctx, dir)
+		if err != nil || !empty {
+			return err
+		}
+		err = publisher.doExpect(
+			ctx,
+			http.MethodDelete,
+			dir+"/",
+			nil,
+			http.StatusNoContent,
+			http.StatusOK,
+			http.StatusNotFound,
+		)
+		if err != nil {
+			return err
+		}
+		delete(publisher.collections, dir)
+	}
+	return nil
+}
+
+// Regex for the response elements of a PROPFIND multistatus, one per member listed.
+var propfindResponseRegex = regexp.MustCompile(`<(?:[\w.-]+:)?response[\s>]`)
+
+// Reports whether the collection dir has no members, listing it with a PROPFIND of
+// depth 1, which responds for the collection itself and each of its members.
+func (publisher *WebDAVPublisher) isEmptyCollection(
+	ctx context.Context, dir string,
+) (bool, error) {
+	request, err := publisher.newRequest(ctx, "PROPFIND", dir+"/", nil)
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Depth", "1")
+	resp, err := publisher.Client.Do(request)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusMultiStatus {
+		return false, xerrors.Errorf("PROPFIND %v: unexpected status %v", dir, resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return false, err
+	}
+	return len(propfindResponseRegex.FindAll(data, -1)) <= 1, nil
+}
+
+func (publisher *WebDAVPublisher) WriteManifest(
+	ctx context.Context, manifest *SiteManifest,
+) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return publisher.doExpect(
+		ctx,
+		http.MethodPut,
+		remoteManifestName,
+		bytes.NewReader(data),
+		http.StatusCreated,
+		http.StatusNoContent,
+		http.StatusOK,
+	)
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"golang.org/x/xerrors"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// SymbolRegistry is shared by the builds of several modules, like the tenants of
+// serve --content-root, so the docs of one module can link to the symbols of the
+// others instead of pkg.go.dev.
+type SymbolRegistry struct {
+	// Modules by module path.
+	Modules map[string]*RegistryModule `json:"modules"`
+}
+
+// RegistryModule is a module of the registry, as of its last build.
+type RegistryModule struct {
+	// Url the module's docs are hosted at, from its --base-url.
+	BaseURL string    `json:"baseUrl"`
+	BuiltAt time.Time `json:"builtAt"`
+	// Packages by import path.
+	Packages map[string]*RegistryPackage `json:"packages"`
+	// Method signatures of the exported interfaces, by importPath.Name.
+	Interfaces map[string][]string `json:"interfaces,omitempty"`
+	// Exported concrete types with methods, by importPath.Name.
+	Types map[string]*RegistryType `json:"types,omitempty"`
+}
+
+// RegistryPackage is a documented package of a registry module.
+type RegistryPackage struct {
+	// Page of the package, relative to the module's base url.
+	Page string `json:"page"`
+	// Exported symbols, Type.Method for methods.
+	Symbols []string `json:"symbols"`
+}
+
+// How long to wait for another build to release the registry, and the age after
+// which a lock is considered left behind by a crashed build.
+const (
+	registryLockTimeout = 30 * time.Second
+	registryLockStale   = 10 * time.Minute
+)
+
+// Takes the lock file of the registry at filePath, returning the function releasing
+// it.
+func lockSymbolRegistry(filePath string) (func(), error) {
+	lockPath := filePath + ".lock"
+	deadline := time.Now().Add(registryLockTimeout)
+	for {
+		lock, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			_ = lock.Close()
+			return func() { _ = os.Remove(lockPath) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, xerrors.Errorf("error locking symbol registry: %w", err)
+		}
+		if info, err := os.Stat(lockPath); err == nil && time.Since(info.ModTime()) > registryLockStale {
+			_ = os.Remove(lockPath)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, xerrors.Errorf("symbol registry is locked by '%v'", lockPath)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// Reads the registry at filePath. A missing registry is empty.
+func readSymbolRegistry(filePath string) (*SymbolRegistry, error) {
+	registry := &SymbolRegistry{Modules: make(map[string]*RegistryModule)}
+	data, err := ioutil.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return registry, nil
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("error reading symbol registry: %w", err)
+	}
+	if err := json.Unmarshal(data, registry); err != nil {
+		return nil, xerrors.Errorf("error parsing symbol registry '%v': %w", filePath, err)
+	}
+	if registry.Modules == nil {
+		registry.Modules = make(map[string]*RegistryModule)
+	}
+	return registry, nil
+}
+
+// Writes the registry to filePath through a temporary file, so builds reading it
+// never see a partial registry.
+func writeSymbolRegistry(filePath string, registry *SymbolRegistry) error {
+	data, err := json.MarshalIndent(registry, "", "  ")
+	if err != nil {
+		return xerrors.Errorf("error encoding symbol registry: %w", err)
+	}
+	tempPath := filePath + ".tmp"
+	if err := ioutil.WriteFile(tempPath, data, 0644); err != nil {
+		return xerrors.Errorf("error writing symbol registry: %w", err)
+	}
+	if err := os.Rename(tempPath, filePath); err != nil {
+		return xerrors.Errorf("error writing symbol registry: %w", err)
+	}
+	return nil
+}
+
+// Records the packages and symbols of the build in the --symbol-registry.
+func registerModuleSymbols(runInfo *RunInfo) {
+	settings := runInfo.Settings
+	if settings.SymbolRegistry == "" {
+		return
+	}
+
+	model, err := loadBuildDocModel(settings)
+	if err != nil {
+		log.Panicf("error loading doc model: %v", err)
+	}
+	pages := packagePages(runInfo)
+	module := &RegistryModule{
+		BaseURL:  strings.TrimSuffix(settings.BaseURL, "/"),
+		BuiltAt:  time.Now().UTC(),
+		Packages: make(map[string]*RegistryPackage),
+	}
+	for _, pkg := range model.Packages {
+		page, ok := pages[pkg.ImportPath]
+		if !ok {
+			continue
+		}
+		registryPackage := &RegistryPackage{Page: page, Symbols: make([]string, 0, len(pkg.Symbols))}
+		for _, symbol := range pkg.Symbols {
+			registryPackage.Symbols = append(registryPackage.Symbols, symbol.Name)
+		}
+		module.Packages[pkg.ImportPath] = registryPackage
+	}
+	module.Interfaces, module.Types = moduleMethodSets(settings)
+
+	unlock, err := lockSymbolRegistry(settings.SymbolRegistry)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer unlock()
+	registry, err := readSymbolRegistry(settings.SymbolRegistry)
+	if err != nil {
+		log.Panic(err)
+	}
+	registry.Modules[settings.ModName] = module
+	if err := writeSymbolRegistry(settings.SymbolRegistry, registry); err != nil {
+		log.Panic(err)
+	}
+	log.Printf("registered %v packages in %v.", len(module.Packages), settings.SymbolRegistry)
+
+	if settings.ImplementersIndex != "" {
+		if err := writeImplementersIndex(settings.ImplementersIndex, registry); err != nil {
+			log.Panic(err)
+		}
+	}
+}
+
+// Regexes of references to the symbols of other modules: doc links left as text,
+// like [example.com/other/pkg.Type], and links to pkg.go.dev or the doc server.
+var (
+	registryRefRegex  = regexp.MustCompile(`\[((?:[a-z0-9\-]+\.)+[a-z]+(?:/[A-Za-z0-9_.\-~]+)+)\]`)
+	registryHrefRegex = regexp.MustCompile(`href="(?:https://pkg\.go\.dev)?/([^"#?]+)(?:#([^"]*))?"`)
+	codeBlockRegex    = regexp.MustCompile(`(?s)<pre[ >].*?</pre>`)
+)
+
+// Returns the processor linking references to the packages and symbols of the other
+// modules of the --symbol-registry to their pages. Modules built later are only
+// linked to once this one is rebuilt.
+func newSymbolLinksProcessor(ctx context.Context, runInfo *RunInfo) Processor {
+	settings := runInfo.Settings
+	if settings.SymbolRegistry == "" {
+		return nil
+	}
+	registry, err := readSymbolRegistry(settings.SymbolRegistry)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	pages := make(map[string]string)
+	symbols := make(map[string]bool)
+	for modName, module := range registry.Modules {
+		if modName == settings.ModName {
+			continue
+		}
+		for importPath, pkg := range module.Packages {
+			pages[importPath] = module.BaseURL + "/" + pkg.Page
+			for _, symbol := range pkg.Symbols {
+				symbols[importPath+"."+symbol] = true
+			}
+		}
+	}
+	if len(pages) == 0 {
+		return nil
+	}
+
+	// Links the base-url stage moved under our base path still point at the doc
+	// server's path of the package.
+	basePath := ""
+	if parsed, err := url.Parse(strings.TrimSuffix(settings.BaseURL, "/")); err == nil {
+		basePath = parsed.Path
+	}
+
+	// Returns the link of a reference, or an empty string if the registry doesn't
+	// know the package or symbol.
+	resolve := func(ref string) string {
+		link := resolveSymbolLink(pages, ref)
+		if link == "" || (strings.Contains(link, "#") && !symbols[ref]) {
+			return ""
+		}
+		return link
+	}
+
+	return ProcessorFunc(func(page *Page) error {
+		data := registryHrefRegex.ReplaceAllFunc(page.Data, func(match []byte) []byte {
+			groups := registryHrefRegex.FindSubmatch(match)
+			importPath := strings.TrimPrefix(string(groups[1]), strings.TrimPrefix(basePath, "/")+"/")
+			link, ok := pages[importPath]
+			if !ok {
+				return match
+			}
+			if len(groups[2]) > 0 {
+				link += "#" + string(groups[2])
+			}
+			return []byte(`href="` + link + `"`)
+		})
+
+		// Doc links rendered as text, except in code blocks.
+		blocks := codeBlockRegex.FindAllIndex(data, -1)
+		output := new(bytes.Buffer)
+		last := 0
+		for _, block := range append(blocks, []int{len(data), len(data)}) {
+			text := registryRefRegex.ReplaceAllFunc(data[last:block[0]], func(match []byte) []byte {
+				ref := string(match[1 : len(match)-1])
+				if link := resolve(ref); link != "" {
+					return []byte(`<a href="` + link + `">` + ref + `</a>`)
+				}
+				return match
+			})
+			output.Write(text)
+			output.Write(data[block[0]:block[1]])
+			last = block[1]
+		}
+		page.Data = output.Bytes()
+		return nil
+	})
+}
+
+// Returns the registry shared by the tenants of a content root.
+func contentRootRegistry(contentRoot string) string {
+	return filepath.Join(contentRoot, ".docmodule-registry.json")
+}
+
+// Returns the directory of the implementers index of the tenants of a content root.
+func contentRootImplementers(contentRoot string) string {
+	return filepath.Join(contentRoot, ".docmodule-implementers")
+}
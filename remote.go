@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"golang.org/x/xerrors"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Environment variables holding the credentials of --remote-server, kept off the
+// command line.
+const (
+	remotePasswordEnv = "DOCMODULE_REMOTE_PASSWORD"
+	remoteTokenEnv    = "DOCMODULE_REMOTE_TOKEN"
+)
+
+// headerFlag is a flag given once per HTTP header, like "X-Team: docs".
+type headerFlag []string
+
+func (headers *headerFlag) String() string {
+	if headers == nil {
+		return ""
+	}
+	return strings.Join(*headers, ", ")
+}
+
+func (headers *headerFlag) Set(value string) error {
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		return xerrors.Errorf("header %q is not of the form 'Name: value'", value)
+	}
+	*headers = append(*headers, value)
+	return nil
+}
+
+func (headers *headerFlag) Get() interface{} {
+	return append([]string{}, *headers...)
+}
+
+// Parses the url of --remote-server.
+func parseRemoteServer(remoteServer string) (*url.URL, error) {
+	remoteURL, err := url.Parse(remoteServer)
+	if err != nil {
+		return nil, xerrors.Errorf("invalid --remote-server: %w", err)
+	}
+	if (remoteURL.Scheme != "http" && remoteURL.Scheme != "https") || remoteURL.Host == "" {
+		return nil, xerrors.Errorf("--remote-server %q is not an http or https url", remoteServer)
+	}
+	return remoteURL, nil
+}
+
+// Returns the headers added to the requests to the remote doc server: the ones of
+// --remote-header, and the basic auth of --remote-user or the bearer token of
+// $DOCMODULE_REMOTE_TOKEN.
+func remoteRequestHeaders(settings *Settings) http.Header {
+	headers := make(http.Header)
+	for _, header := range settings.RemoteHeaders {
+		parts := strings.SplitN(header, ":", 2)
+		headers.Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	}
+	if settings.RemoteUser != "" {
+		credentials := settings.RemoteUser + ":" + os.Getenv(remotePasswordEnv)
+		headers.Set(
+			"Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(credentials)),
+		)
+	} else if token := os.Getenv(remoteTokenEnv); token != "" {
+		headers.Set("Authorization", "Bearer "+token)
+	}
+	return headers
+}
+
+// Scrapes an already running doc server at --remote-server instead of starting one.
+// wget scrapes the scrape proxy on settings.ServerHost, which forwards the requests
+// to the remote server with its headers and credentials, so they stay off wget's
+// command line and the pages link to the local host like a local server's.
+func scrapeRemoteServer(ctx context.Context, settings *Settings) []*BuildProblem {
+	remoteURL, err := parseRemoteServer(settings.RemoteServer)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	var recorder *SessionRecorder
+	if settings.Record != "" {
+		recorder = NewSessionRecorder()
+	}
+	proxyCtx, stopProxy := context.WithCancel(ctx)
+	defer stopProxy()
+	log.Println("scraping remote doc server", settings.RemoteServer, "at", settings.ServerHost+".")
+	startScrapeProxy(proxyCtx, settings, remoteURL, recorder)
+
+	if err := waitForServer(ctx, settings); err != nil {
+		log.Panic(err)
+	}
+	problems := scrapeModulePages(ctx, settings)
+	writeRecordedSession(settings, recorder)
+	return problems
+}
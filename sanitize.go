@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"html"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// Elements allowed in the content of doc comments and prose. Like the user generated
+// content policy of bluemonday, it allows formatting, links, images and tables.
+var sanitizedElements = wordSet(
+	"a abbr b blockquote br code dd del details div dl dt em h1 h2 h3 h4 h5 h6 hr i " +
+		"img ins kbd li mark ol p pre q s samp small span strong sub summary sup " +
+		"table tbody td tfoot th thead tr u ul var",
+)
+
+// Attributes allowed on some elements of sanitizedElements besides
+// sanitizedGlobalAttributes.
+var sanitizedElementAttributes = map[string]map[string]bool{
+	"a":          wordSet("href name rel"),
+	"blockquote": wordSet("cite"),
+	"img":        wordSet("src alt width height"),
+	"ol":         wordSet("start"),
+	"q":          wordSet("cite"),
+	"td":         wordSet("colspan rowspan align"),
+	"th":         wordSet("colspan rowspan align scope"),
+}
+
+// Attributes allowed on every element of sanitizedElements.
+var sanitizedGlobalAttributes = wordSet("id class title")
+
+// Attributes holding urls, which may only link to the schemes of sanitizedURLSchemes.
+var sanitizedURLAttributes = wordSet("href src cite")
+
+// Schemes of the urls allowed in links and images, besides relative urls.
+var sanitizedURLSchemes = wordSet("http https mailto")
+
+// Elements removed along with their content, which is not text to show.
+var droppedContentElements = wordSet(
+	"script style iframe object embed noscript template textarea select svg math title",
+)
+
+// Elements which have no content or closing tag.
+var voidElements = wordSet("br hr img")
+
+// Returns the set of the space-separated words.
+func wordSet(words string) map[string]bool {
+	set := make(map[string]bool)
+	for _, word := range strings.Fields(words) {
+		set[word] = true
+	}
+	return set
+}
+
+// Regexes for the tags, attributes and comments of markup being sanitized.
+var (
+	startTagRegex = regexp.MustCompile(
+		`^<([a-zA-Z][a-zA-Z0-9-]*)((?:\s+[a-zA-Z_:][-a-zA-Z0-9_:.]*` +
+			`(?:\s*=\s*(?:"[^"]*"|'[^']*'|[^\s"'=<>` + "`" + `]+))?)*)\s*/?>`,
+	)
+	endTagRegex    = regexp.MustCompile(`^</([a-zA-Z][a-zA-Z0-9-]*)\s*>`)
+	attributeRegex = regexp.MustCompile(
+		`([a-zA-Z_:][-a-zA-Z0-9_:.]*)(?:\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'=<>` + "`" + `]+)))?`,
+	)
+	markupCommentRegex = regexp.MustCompile(`^(?s)<!--.*?-->`)
+)
+
+// Regex for the regions of the pages holding the content of doc comments and prose:
+// paragraphs and preformatted blocks.
+var sanitizedRegionRegex = regexp.MustCompile(
+	`(?is)<p(?:\s[^>]*)?>.*?</p>|<pre(?:\s[^>]*)?>.*?</pre>`,
+)
+
+// Reports whether a url may be linked to: a relative url, or one of the schemes of
+// sanitizedURLSchemes. Browsers ignore whitespace and control characters in schemes.
+func sanitizedURL(value string) bool {
+	cleaned := strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, strings.ToLower(value))
+	colon := strings.IndexByte(cleaned, ':')
+	if colon < 0 || strings.ContainsAny(cleaned[:colon], "/?#") {
+		return true
+	}
+	return sanitizedURLSchemes[cleaned[:colon]]
+}
+
+// Returns the start tag of an allowed element with only its allowed attributes, and
+// whether it is unchanged, in which case the original is kept as is.
+func sanitizeStartTag(name string, attributes string) (string, bool) {
+	allowed := sanitizedElementAttributes[name]
+	tag := "<" + name
+	unchanged := true
+	for _, match := range attributeRegex.FindAllStringSubmatch(attributes, -1) {
+		attribute := strings.ToLower(match[1])
+		value := html.UnescapeString(match[2] + match[3] + match[4])
+		if (!allowed[attribute] && !sanitizedGlobalAttributes[attribute]) ||
+			(sanitizedURLAttributes[attribute] && !sanitizedURL(value)) {
+			unchanged = false
+			continue
+		}
+		tag += " " + attribute + `="` + html.EscapeString(value) + `"`
+	}
+	return tag + ">", unchanged
+}
+
+// Sanitizes a fragment of markup: elements outside of sanitizedElements are removed,
+// keeping their text unless they are in droppedContentElements, as are attributes not
+// allowed on an element and urls with other schemes, like javascript:. Stray closing
+// tags are dropped and unclosed elements closed, so the fragment can't break the
+// markup around it, and a "<" not starting a tag is escaped.
+func sanitizeHTML(data []byte) []byte {
+	output := new(bytes.Buffer)
+	open := make([]string, 0)
+	for len(data) > 0 {
+		next := bytes.IndexByte(data, '<')
+		if next < 0 {
+			output.Write(data)
+			break
+		}
+		output.Write(data[:next])
+		data = data[next:]
+
+		if location := markupCommentRegex.FindIndex(data); location != nil {
+			data = data[location[1]:]
+			continue
+		}
+		if match := endTagRegex.FindSubmatch(data); match != nil {
+			data = data[len(match[0]):]
+			name := strings.ToLower(string(match[1]))
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i] != name {
+					continue
+				}
+				// Elements left open inside it are closed first.
+				for _, unclosed := range reverseStrings(open[i+1:]) {
+					output.WriteString("</" + unclosed + ">")
+				}
+				output.Write(match[0])
+				open = open[:i]
+				break
+			}
+			continue
+		}
+		match := startTagRegex.FindSubmatch(data)
+		if match == nil {
+			output.WriteString("&lt;")
+			data = data[1:]
+			continue
+		}
+		data = data[len(match[0]):]
+		name := strings.ToLower(string(match[1]))
+		if droppedContentElements[name] {
+			end := bytes.Index(bytes.ToLower(data), []byte("</"+name))
+			if end < 0 {
+				break
+			}
+			data = data[end:]
+			if closing := bytes.IndexByte(data, '>'); closing >= 0 {
+				data = data[closing+1:]
+			}
+			continue
+		}
+		if !sanitizedElements[name] {
+			continue
+		}
+		if tag, unchanged := sanitizeStartTag(name, string(match[2])); unchanged {
+			output.Write(match[0])
+		} else {
+			output.WriteString(tag)
+		}
+		if !voidElements[name] {
+			open = append(open, name)
+		}
+	}
+	for _, unclosed := range reverseStrings(open) {
+		output.WriteString("</" + unclosed + ">")
+	}
+	return output.Bytes()
+}
+
+// Returns a copy of values in reverse order.
+func reverseStrings(values []string) []string {
+	reversed := make([]string, len(values))
+	for i, value := range values {
+		reversed[len(values)-1-i] = value
+	}
+	return reversed
+}
+
+// Returns the processor sanitizing the paragraphs and preformatted blocks of every
+// page, where the content of doc comments and prose ends up, so markup slipping
+// through them or through the rewrites of the pipeline can't break or script the
+// published pages.
+func newSanitizeProcessor(ctx context.Context, runInfo *RunInfo) Processor {
+	if !runInfo.Settings.Sanitize {
+		return nil
+	}
+
+	return ProcessorFunc(func(page *Page) error {
+		changed := 0
+		page.Data = sanitizedRegionRegex.ReplaceAllFunc(page.Data, func(region []byte) []byte {
+			sanitized := sanitizeHTML(region)
+			if !bytes.Equal(sanitized, region) {
+				changed++
+			}
+			return sanitized
+		})
+		if changed > 0 {
+			log.Printf("sanitized %v regions of %v.", changed, page.RelPath)
+		}
+		return nil
+	})
+}
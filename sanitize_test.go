@@ -0,0 +1,106 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSanitizedURL(t *testing.T) {
+	cases := map[string]bool{
+		"":                           true,
+		"../pkg/index.html":          true,
+		"#anchor":                    true,
+		"/docs/a:b":                  true,
+		"?q=a:b":                     true,
+		"https://example.com":        true,
+		"HTTP://example.com":         true,
+		"mailto:team@example.com":    true,
+		"javascript:alert(1)":        false,
+		"JavaScript:alert(1)":        false,
+		" java\tscript:alert(1)":     false,
+		"java\x00script:alert(1)":    false,
+		"data:text/html;base64,PHA+": false,
+		"vbscript:msgbox(1)":         false,
+		"ftp://example.com/file.txt": false,
+	}
+	for value, want := range cases {
+		if got := sanitizedURL(value); got != want {
+			t.Errorf("sanitizedURL(%q) = %v, want %v", value, got, want)
+		}
+	}
+}
+
+func TestSanitizeHTML(t *testing.T) {
+	cases := []struct {
+		name   string
+		input  string
+		output string
+	}{
+		{
+			name:   "allowed markup",
+			input:  `<p>See <a href="../pkg/" class="x">pkg</a> and <code>f()</code>.</p>`,
+			output: `<p>See <a href="../pkg/" class="x">pkg</a> and <code>f()</code>.</p>`,
+		},
+		{
+			name:   "script",
+			input:  `<p>a<script>alert(1)</script>b</p>`,
+			output: `<p>ab</p>`,
+		},
+		{
+			name:   "unclosed script",
+			input:  `<p>a<script>alert(1)`,
+			output: `<p>a</p>`,
+		},
+		{
+			name:   "unknown element keeps its text",
+			input:  `<p><font color="red">red</font></p>`,
+			output: `<p>red</p>`,
+		},
+		{
+			name:   "event handler",
+			input:  `<p><img src="a.png" onerror="alert(1)"></p>`,
+			output: `<p><img src="a.png"></p>`,
+		},
+		{
+			name:   "javascript url",
+			input:  `<p><a href="javascript:alert(1)" title="t">x</a></p>`,
+			output: `<p><a title="t">x</a></p>`,
+		},
+		{
+			name:   "entity encoded javascript url",
+			input:  `<p><a href="&#106;avascript:alert(1)">x</a></p>`,
+			output: `<p><a>x</a></p>`,
+		},
+		{
+			name:   "comment",
+			input:  `<p>a<!-- <script>alert(1)</script> -->b</p>`,
+			output: `<p>ab</p>`,
+		},
+		{
+			name:   "stray closing tag",
+			input:  `<p>a</div></p>`,
+			output: `<p>a</p>`,
+		},
+		{
+			name:   "unclosed elements",
+			input:  `<p><b><i>a</p>`,
+			output: `<p><b><i>a</i></b></p>`,
+		},
+		{
+			name:   "less-than sign",
+			input:  `<pre>if a < b {}</pre>`,
+			output: `<pre>if a &lt; b {}</pre>`,
+		},
+		{
+			name:   "void elements",
+			input:  `<p>a<br>b<hr/></p>`,
+			output: `<p>a<br>b<hr/></p>`,
+		},
+	}
+	for _, testCase := range cases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if got := string(sanitizeHTML([]byte(testCase.input))); got != testCase.output {
+				t.Errorf("sanitizeHTML(%q) = %q, want %q", testCase.input, got, testCase.output)
+			}
+		})
+	}
+}
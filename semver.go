@@ -0,0 +1,263 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"golang.org/x/xerrors"
+	"log"
+	"regexp"
+	"strconv"
+)
+
+// Kinds of releases, from least to most significant.
+const (
+	releasePatch = iota
+	releaseMinor
+	releaseMajor
+)
+
+var releaseNames = []string{"patch", "minor", "major"}
+
+// Regex for semantic versions as used by Go modules.
+var semverRegex = regexp.MustCompile(`^v(\d+)\.(\d+)\.(\d+)(?:[-+].*)?$`)
+
+// A semantic version.
+type semver struct {
+	Major int
+	Minor int
+	Patch int
+}
+
+func parseSemver(version string) (*semver, error) {
+	match := semverRegex.FindStringSubmatch(version)
+	if match == nil {
+		return nil, xerrors.Errorf("%q is not a semantic version like v1.2.3", version)
+	}
+	major, _ := strconv.Atoi(match[1])
+	minor, _ := strconv.Atoi(match[2])
+	patch, _ := strconv.Atoi(match[3])
+	return &semver{Major: major, Minor: minor, Patch: patch}, nil
+}
+
+func (version *semver) String() string {
+	return fmt.Sprintf("v%d.%d.%d", version.Major, version.Minor, version.Patch)
+}
+
+// Returns whether version sorts before other.
+func (version *semver) less(other *semver) bool {
+	if version.Major != other.Major {
+		return version.Major < other.Major
+	}
+	if version.Minor != other.Minor {
+		return version.Minor < other.Minor
+	}
+	return version.Patch < other.Patch
+}
+
+// Returns the next version for a release of kind. Before v1 incompatible changes
+// only bump the minor version.
+func (version *semver) next(kind int) *semver {
+	switch {
+	case kind == releaseMajor && version.Major > 0:
+		return &semver{Major: version.Major + 1}
+	case kind >= releaseMinor:
+		return &semver{Major: version.Major, Minor: version.Minor + 1}
+	default:
+		return &semver{Major: version.Major, Minor: version.Minor, Patch: version.Patch + 1}
+	}
+}
+
+// Parses a declaration printed in a ModelSymbol's signature.
+func parseSignature(signature string) (ast.Decl, *token.FileSet, error) {
+	fileSet := token.NewFileSet()
+	file, err := parser.ParseFile(fileSet, "", "package p\n"+signature, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(file.Decls) != 1 {
+		return nil, nil, xerrors.Errorf("expected one declaration in %q", signature)
+	}
+	return file.Decls[0], fileSet, nil
+}
+
+// Regex for the last identifier of a possibly qualified name, e.g. Reader in io.Reader.
+var lastIdentRegex = regexp.MustCompile(`[^.]+$`)
+
+// Returns the exported fields of a struct type declaration by name, with their type.
+// Returns nil if the declaration is not a struct.
+func structFields(signature string) map[string]string {
+	decl, fileSet, err := parseSignature(signature)
+	if err != nil {
+		return nil
+	}
+	genDecl, ok := decl.(*ast.GenDecl)
+	if !ok || len(genDecl.Specs) != 1 {
+		return nil
+	}
+	typeSpec := genDecl.Specs[0].(*ast.TypeSpec)
+	structType, ok := typeSpec.Type.(*ast.StructType)
+	if !ok || typeSpec.Assign.IsValid() {
+		return nil
+	}
+
+	fields := make(map[string]string)
+	for _, field := range structType.Fields.List {
+		fieldType := formatNode(fileSet, field.Type)
+		if len(field.Names) == 0 {
+			// Embedded fields are named after their type.
+			name := fieldType
+			if star, ok := field.Type.(*ast.StarExpr); ok {
+				name = formatNode(fileSet, star.X)
+			}
+			if selector := lastIdentRegex.FindString(name); ast.IsExported(selector) {
+				fields[selector] = fieldType
+			}
+			continue
+		}
+		for _, name := range field.Names {
+			if name.IsExported() {
+				fields[name.Name] = fieldType
+			}
+		}
+	}
+	return fields
+}
+
+// Returns the type and value of name in a const or var declaration.
+func valueOf(signature string, name string) (string, bool) {
+	decl, fileSet, err := parseSignature(signature)
+	if err != nil {
+		return "", false
+	}
+	genDecl, ok := decl.(*ast.GenDecl)
+	if !ok || len(genDecl.Specs) != 1 {
+		return "", false
+	}
+	valueSpec := genDecl.Specs[0].(*ast.ValueSpec)
+	for i, valueName := range valueSpec.Names {
+		if valueName.Name != name {
+			continue
+		}
+		value := ""
+		if valueSpec.Type != nil {
+			value = formatNode(fileSet, valueSpec.Type)
+		}
+		if i < len(valueSpec.Values) {
+			value += " = " + formatNode(fileSet, valueSpec.Values[i])
+		}
+		return value, true
+	}
+	return "", false
+}
+
+// Classifies a changed symbol as a patch, minor or major change, with the reason.
+// Like apidiff, adding fields to a struct is compatible while any change to a
+// function's signature or an interface's method set is not.
+func classifyChange(change *apiChange) (int, string) {
+	if change.Old.Kind != change.New.Kind {
+		return releaseMajor, "changed from " + change.Old.Kind + " to " + change.New.Kind
+	}
+
+	switch change.New.Kind {
+	case "type":
+		oldFields := structFields(change.Old.Signature)
+		newFields := structFields(change.New.Signature)
+		if oldFields == nil || newFields == nil {
+			return releaseMajor, "type definition changed"
+		}
+		for name, fieldType := range oldFields {
+			if newFields[name] != fieldType {
+				return releaseMajor, "field " + name + " removed or changed"
+			}
+		}
+		if len(newFields) > len(oldFields) {
+			return releaseMinor, "fields added"
+		}
+		return releasePatch, "unexported fields changed"
+	case "const", "var":
+		name := change.New.Name
+		oldValue, oldOK := valueOf(change.Old.Signature, name)
+		newValue, newOK := valueOf(change.New.Signature, name)
+		if oldOK && newOK && oldValue == newValue {
+			// Only the rest of the declaration group changed.
+			return releasePatch, "declaration group changed"
+		}
+		return releaseMajor, change.New.Kind + " type or value changed"
+	default:
+		return releaseMajor, "signature changed"
+	}
+}
+
+// Prints the minimum next version of the module given the API changes since a
+// release, and fails if --release is lower than that.
+func runSemverCommand(args []string) {
+	flags := newCommandFlagSet("semver")
+	from := flags.String(
+		"from",
+		"",
+		"Git tag of the last release, e.g. v1.2.0.",
+	)
+	to := flags.String(
+		"to",
+		"HEAD",
+		"Git ref or model.json of the upcoming release.",
+	)
+	release := flags.String(
+		"release",
+		"",
+		"Version planned for the upcoming release. Fails if it is lower than needed.",
+	)
+	_ = flags.Parse(args)
+
+	if *from == "" {
+		log.Fatal("--from is required")
+	}
+	current, err := parseSemver(*from)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	diff := diffRefs(*from, *to)
+	kind := releasePatch
+	report := func(changeKind int, symbol *ModelSymbol, reason string) {
+		fmt.Printf(
+			"%-5v  %v.%v: %v\n",
+			releaseNames[changeKind],
+			symbol.ImportPath,
+			symbol.Name,
+			reason,
+		)
+		if changeKind > kind {
+			kind = changeKind
+		}
+	}
+
+	for _, symbol := range diff.Removed {
+		report(releaseMajor, symbol, "removed")
+	}
+	for _, change := range diff.Changed {
+		changeKind, reason := classifyChange(change)
+		report(changeKind, change.New, reason)
+	}
+	for _, symbol := range diff.Added {
+		report(releaseMinor, symbol, "added")
+	}
+
+	minimum := current.next(kind)
+	fmt.Printf("\n%v release, next version: %v\n", releaseNames[kind], minimum)
+	if minimum.Major > current.Major && minimum.Major > 1 {
+		fmt.Printf("the module path needs a /v%v suffix for this release\n", minimum.Major)
+	}
+
+	if *release != "" {
+		planned, err := parseSemver(*release)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if planned.less(minimum) {
+			log.Fatalf("%v is too low for these changes, use %v or later", planned, minimum)
+		}
+	}
+}
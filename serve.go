@@ -0,0 +1,355 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Prefix of the endpoints serve mode adds next to the static docs.
+const serveAPIPrefix = "/_docmodule/"
+
+// Settings for the serve command.
+type ServeSettings struct {
+	// Directory of a finished build to serve
+	BuildDir string
+	// Directory holding builds of multiple modules and versions to serve instead
+	// of BuildDir
+	ContentRoot string
+	// Where to write access logs: a file path, "-" for stderr or "syslog"
+	AccessLog string
+	// Where to write the audit log of rebuilds and of the publishes of rebuilt
+	// tenants: a file path, "-" or "syslog"
+	AuditLog string
+	// Hides packages from viewers without the required role, nil to show all
+	Visibility *VisibilityPolicy
+	// Header set by the reverse proxy carrying the viewer's roles
+	RolesHeader string
+	// Claim holding the roles when RolesHeader carries a JWT, or in OIDC tokens
+	RolesClaim string
+	// Max age of the Cache-Control header for assets
+	AssetMaxAge time.Duration
+	// Serve compressed responses
+	Compress bool
+	// TLS certificate and key. Serving over TLS enables HTTP/2.
+	TLSCertFile string
+	TLSKeyFile  string
+	// Authenticator protecting the docs, empty for none
+	Auth string
+	// OIDC issuer url
+	OIDCIssuer string
+	// OIDC client id
+	OIDCClientID string
+	// External url of the OIDC login callback
+	OIDCRedirectURL string
+	// Host and port to listen on
+	ListenHost string
+	// Share a symbol registry between the rebuilds of the tenants, linking their
+	// docs to each other
+	SymbolRegistry bool
+	// Shared secret authorizing tenant rebuilds, read from the environment
+	RebuildSecret string
+	// Role of authenticated viewers allowed to trigger tenant rebuilds
+	RebuildRole string
+}
+
+func parseServeArgs(args []string) *ServeSettings {
+	flags := newCommandFlagSet("serve")
+	buildDir := flags.String(
+		"build-path",
+		"zdocs/source/_static",
+		"path of the build directory to serve",
+	)
+	listenHost := flags.String(
+		"listen",
+		"localhost:8080",
+		"Host and port to serve the docs on.",
+	)
+
+	contentRoot := flags.String(
+		"content-root",
+		"",
+		"Serve multiple modules and versions laid out as <root>/<tenant>/<version>.",
+	)
+
+	symbolRegistry := flags.Bool(
+		"symbol-registry",
+		false,
+		"Record the symbols of every tenant rebuild in a registry in the content "+
+			"root, link references to other tenants' packages to their docs and "+
+			"serve the interfaces they implement across tenants at "+
+			serveAPIPrefix+"implementers/.",
+	)
+
+	rebuildRole := flags.String(
+		"rebuild-role",
+		"",
+		"Role allowing viewers authenticated with --auth to trigger tenant rebuilds. "+
+			"Rebuilds are otherwise only accepted with the secret in $"+rebuildSecretEnv+
+			", as a bearer token or the key of an X-Hub-Signature-256 webhook signature.",
+	)
+
+	accessLog := flags.String(
+		"access-log",
+		"",
+		"Write JSON access logs to a file, '-' for stderr or 'syslog'.",
+	)
+	auditLog := flags.String(
+		"audit-log",
+		"",
+		"Write a JSON audit log of rebuilds and the publishes of rebuilt tenants to a file, "+
+			"'-' for stderr or 'syslog'.",
+	)
+
+	visibilityFile := flags.String(
+		"visibility-policy",
+		"",
+		"JSON file restricting packages to viewers with given roles.",
+	)
+	rolesHeader := flags.String(
+		"roles-header",
+		"X-Forwarded-Groups",
+		"Header set by the reverse proxy carrying the viewer's comma separated "+
+			"roles, or a JWT when --roles-claim is set.",
+	)
+	rolesClaim := flags.String(
+		"roles-claim",
+		"",
+		"JWT claim holding the viewer's roles.",
+	)
+
+	assetMaxAge := flags.Duration(
+		"asset-max-age",
+		time.Hour,
+		"Cache-Control max age for css, js and images. HTML is always revalidated.",
+	)
+	compress := flags.Bool(
+		"compress",
+		true,
+		"Serve precompressed .br/.gz files when present and gzip text on the fly.",
+	)
+	tlsCertFile := flags.String(
+		"tls-cert",
+		"",
+		"TLS certificate file. Serving over TLS enables HTTP/2. HTTP/3 is not supported, "+
+			"it needs a QUIC implementation outside of the standard library; put a proxy "+
+			"speaking it in front of serve mode instead.",
+	)
+	tlsKeyFile := flags.String("tls-key", "", "TLS private key file.")
+	auth := flags.String(
+		"auth",
+		"",
+		"Require authentication with a built-in authenticator: oidc.",
+	)
+	oidcIssuer := flags.String("oidc-issuer", "", "OIDC issuer url.")
+	oidcClientID := flags.String(
+		"oidc-client-id",
+		"",
+		"OIDC client id. The secret is read from $"+oidcClientSecretEnv+".",
+	)
+	oidcRedirectURL := flags.String(
+		"oidc-redirect-url",
+		"",
+		"External url of "+oidcCallbackPath+", registered with the provider. "+
+			"Browsers are only sent to the login page when this is set.",
+	)
+
+	if err := flags.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+
+	var visibility *VisibilityPolicy
+	if *visibilityFile != "" {
+		policy, err := loadVisibilityPolicy(*visibilityFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		visibility = policy
+	}
+
+	return &ServeSettings{
+		BuildDir:        *buildDir,
+		ListenHost:      *listenHost,
+		ContentRoot:     *contentRoot,
+		AccessLog:       *accessLog,
+		AuditLog:        *auditLog,
+		Visibility:      visibility,
+		RolesHeader:     *rolesHeader,
+		RolesClaim:      *rolesClaim,
+		AssetMaxAge:     *assetMaxAge,
+		Compress:        *compress,
+		TLSCertFile:     *tlsCertFile,
+		TLSKeyFile:      *tlsKeyFile,
+		Auth:            *auth,
+		OIDCIssuer:      *oidcIssuer,
+		OIDCClientID:    *oidcClientID,
+		OIDCRedirectURL: *oidcRedirectURL,
+		SymbolRegistry:  *symbolRegistry,
+		RebuildSecret:   os.Getenv(rebuildSecretEnv),
+		RebuildRole:     *rebuildRole,
+	}
+}
+
+// Reports the version and build time of the served docs as JSON.
+func badgeJSONHandler(settings *ServeSettings) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		info, err := readBuildInfo(settings.BuildDir)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		writer.Header().Set("Content-Type", "application/json")
+		writer.Header().Set("Cache-Control", "no-cache")
+		_ = json.NewEncoder(writer).Encode(map[string]interface{}{
+			// shields.io endpoint badge schema
+			"schemaVersion": 1,
+			"label":         "docs",
+			"message":       info.Version,
+			"color":         "blue",
+			// raw build info for dashboards
+			"module":  info.Module,
+			"version": info.Version,
+			"builtAt": info.BuiltAt,
+		})
+	}
+}
+
+// Renders a "docs: <version>" badge for the served docs.
+func badgeSVGHandler(settings *ServeSettings) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		message, color := "unknown", badgeColorGrey
+		if info, err := readBuildInfo(settings.BuildDir); err == nil {
+			message, color = info.Version, badgeColorBlue
+		}
+
+		writer.Header().Set("Content-Type", "image/svg+xml")
+		writer.Header().Set("Cache-Control", "no-cache")
+		_, _ = writer.Write(renderBadgeSVG("docs", message, color))
+	}
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	ID      string `xml:"id"`
+	Title   string `xml:"title"`
+	Updated string `xml:"updated"`
+}
+
+// Publishes the latest doc build as an Atom feed so feed readers and dashboards can
+// follow doc updates. The badge endpoints report the same entry.
+func feedHandler(settings *ServeSettings) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		info, err := readBuildInfo(settings.BuildDir)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		updated := info.BuiltAt.Format(time.RFC3339)
+		feed := atomFeed{
+			ID:      "urn:docmodule:" + info.Module,
+			Title:   info.Module + " documentation",
+			Updated: updated,
+			Entries: []atomEntry{{
+				ID:      "urn:docmodule:" + info.Module + ":" + info.Version,
+				Title:   info.Module + " " + info.Version,
+				Updated: updated,
+			}},
+		}
+
+		writer.Header().Set("Content-Type", "application/atom+xml")
+		_, _ = writer.Write([]byte(xml.Header))
+		_ = xml.NewEncoder(writer).Encode(feed)
+	}
+}
+
+// Builds the handler serving a build directory and the serve mode endpoints.
+func newServeMux(settings *ServeSettings) *http.ServeMux {
+	var files http.Handler
+	files = NewStaticFileHandler(
+		settings.BuildDir, settings.AssetMaxAge, settings.Compress,
+	)
+	if settings.Visibility != nil {
+		files = visibilityMiddleware(settings, files)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", files)
+	mux.Handle(serveAPIPrefix+"badge.json", badgeJSONHandler(settings))
+	mux.Handle(serveAPIPrefix+"badge.svg", badgeSVGHandler(settings))
+	mux.Handle(serveAPIPrefix+"feed.atom", feedHandler(settings))
+	return mux
+}
+
+// Runs the serve command, serving a finished build until the process is killed.
+func runServeCommand(args []string) {
+	settings := parseServeArgs(args)
+
+	accessWriter, err := openLogWriter(settings.AccessLog, "docmodule-access")
+	if err != nil {
+		log.Fatal(err)
+	}
+	auditWriter, err := openLogWriter(settings.AuditLog, "docmodule-audit")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	servedDir := settings.BuildDir
+	var handler http.Handler
+	// Rebuilds carrying the shared secret bypass --auth, see carriesRebuildSecret.
+	var unauthenticated func(request *http.Request) bool
+	if settings.RebuildRole != "" && settings.Auth == "" {
+		log.Fatal("--rebuild-role requires --auth")
+	}
+	if settings.ContentRoot != "" {
+		if settings.RebuildSecret == "" && settings.RebuildRole == "" {
+			log.Println("tenant rebuilds are disabled, set $" + rebuildSecretEnv + " or --rebuild-role.")
+		}
+		servedDir = settings.ContentRoot
+		tenantServer := NewTenantServer(settings)
+		tenantServer.AuditLog = NewJSONLogger(auditWriter)
+		handler = tenantServer
+		unauthenticated = tenantServer.carriesRebuildSecret
+	} else {
+		handler = newServeMux(settings)
+	}
+	if settings.Auth != "" {
+		authenticator, err := newAuthenticator(settings)
+		if err != nil {
+			log.Fatal(err)
+		}
+		handler = authMiddleware(authenticator, handler, unauthenticated)
+	}
+	if accessWriter != nil {
+		handler = accessLogMiddleware(handler, NewJSONLogger(accessWriter))
+	}
+
+	if exists, err := fileExists(servedDir); !exists || err != nil {
+		log.Fatalf("directory %q does not exist", servedDir)
+	}
+
+	log.Println("serving", servedDir, "at", settings.ListenHost+".")
+	if settings.TLSCertFile != "" {
+		// net/http negotiates HTTP/2 over TLS on its own. HTTP/3 would need a QUIC
+		// dependency and a newer Go, so it is left to a proxy in front.
+		err = http.ListenAndServeTLS(
+			settings.ListenHost, settings.TLSCertFile, settings.TLSKeyFile, handler,
+		)
+	} else {
+		err = http.ListenAndServe(settings.ListenHost, handler)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
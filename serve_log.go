@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"golang.org/x/xerrors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Opens the destination of a serve mode log: "-" for stderr, "syslog" for the system
+// log, or a file path which is appended to. Returns nil for an empty target.
+func openLogWriter(target string, tag string) (io.Writer, error) {
+	switch target {
+	case "":
+		return nil, nil
+	case "-":
+		return os.Stderr, nil
+	case "syslog":
+		return openSyslog(tag)
+	}
+
+	file, err := os.OpenFile(target, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, xerrors.Errorf("error opening log file %q: %w", target, err)
+	}
+	return file, nil
+}
+
+// JSONLogger writes one JSON object per line. It is safe for concurrent use.
+type JSONLogger struct {
+	writer io.Writer
+	lock   sync.Mutex
+}
+
+func NewJSONLogger(writer io.Writer) *JSONLogger {
+	return &JSONLogger{writer: writer}
+}
+
+// Writes entry as a single line. Loggers without a writer discard entries.
+func (logger *JSONLogger) Log(entry interface{}) {
+	if logger == nil || logger.writer == nil {
+		return
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+
+	logger.lock.Lock()
+	defer logger.lock.Unlock()
+	_, _ = logger.writer.Write(append(data, '\n'))
+}
+
+// AccessLogEntry is a single served request.
+type AccessLogEntry struct {
+	Time       time.Time `json:"time"`
+	RemoteAddr string    `json:"remoteAddr"`
+	// Subject of the authenticated viewer, empty without --auth or when the request
+	// was not authenticated.
+	Subject    string  `json:"subject,omitempty"`
+	Host       string  `json:"host"`
+	Method     string  `json:"method"`
+	Path       string  `json:"path"`
+	Status     int     `json:"status"`
+	Bytes      int64   `json:"bytes"`
+	DurationMs float64 `json:"durationMs"`
+	UserAgent  string  `json:"userAgent"`
+	Referer    string  `json:"referer"`
+}
+
+// AuditLogEntry records a state-changing action: a rebuild taken through serve mode or
+// a publish of a build.
+type AuditLogEntry struct {
+	Time       time.Time `json:"time"`
+	Action     string    `json:"action"`
+	Tenant     string    `json:"tenant,omitempty"`
+	Version    string    `json:"version,omitempty"`
+	RemoteAddr string    `json:"remoteAddr,omitempty"`
+	// Subject of the authenticated viewer who requested the action, if any.
+	Subject string `json:"subject,omitempty"`
+	// Name of the publisher a publish went to.
+	Target string `json:"target,omitempty"`
+	// "requested", "rejected", "succeeded" or "failed".
+	Outcome string `json:"outcome"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Records the status and size of a response for the access log.
+type loggingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (writer *loggingResponseWriter) WriteHeader(status int) {
+	writer.status = status
+	writer.ResponseWriter.WriteHeader(status)
+}
+
+func (writer *loggingResponseWriter) Write(data []byte) (int, error) {
+	if writer.status == 0 {
+		writer.status = http.StatusOK
+	}
+	written, err := writer.ResponseWriter.Write(data)
+	writer.bytes += int64(written)
+	return written, err
+}
+
+// Returns the client address of a request without the port.
+func remoteHost(request *http.Request) string {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return request.RemoteAddr
+	}
+	return host
+}
+
+// Wraps handler to write an access log entry for every request, with the subject
+// authMiddleware authenticated inside it.
+func accessLogMiddleware(handler http.Handler, logger *JSONLogger) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		started := time.Now()
+		logWriter := &loggingResponseWriter{ResponseWriter: writer}
+		request, slot := withPrincipalSlot(request)
+
+		handler.ServeHTTP(logWriter, request)
+
+		subject := ""
+		if slot.principal != nil {
+			subject = slot.principal.Subject
+		}
+		// Like net/http, a handler which writes nothing responds 200.
+		status := logWriter.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		// The query is left out, it can carry secrets like the login codes sent to
+		// the OIDC callback.
+		logger.Log(&AccessLogEntry{
+			Time:       started.UTC(),
+			RemoteAddr: remoteHost(request),
+			Subject:    subject,
+			Host:       request.Host,
+			Method:     request.Method,
+			Path:       request.URL.Path,
+			Status:     status,
+			Bytes:      logWriter.bytes,
+			DurationMs: float64(time.Since(started)) / float64(time.Millisecond),
+			UserAgent:  request.UserAgent(),
+			Referer:    request.Referer(),
+		})
+	})
+}
@@ -0,0 +1,13 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package main
+
+import (
+	"golang.org/x/xerrors"
+	"io"
+)
+
+func openSyslog(tag string) (io.Writer, error) {
+	return nil, xerrors.New("syslog is not supported on this platform")
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StaticFileHandler serves a build directory like a production static host: strong
+// ETags, cache headers, and conditional and range requests via http.ServeContent.
+// Directories serve their index.html and are never listed.
+//
+// Files are compressed for clients accepting it: precompressed .br and .gz siblings
+// are preferred, otherwise compressible files are gzipped on the fly.
+type StaticFileHandler struct {
+	Root string
+	// Whether to serve compressed responses.
+	Compress bool
+	// Max age for assets. HTML pages are always revalidated, since their names do
+	// not change between builds.
+	AssetMaxAge time.Duration
+}
+
+// Cached ETag of a file, valid while its size and modification time are unchanged.
+type etagEntry struct {
+	size    int64
+	modTime time.Time
+	etag    string
+}
+
+// ETags are shared by all handlers, since the tenant server creates one per request.
+var etagCache = struct {
+	entries map[string]etagEntry
+	lock    sync.Mutex
+}{entries: make(map[string]etagEntry)}
+
+func NewStaticFileHandler(
+	root string, assetMaxAge time.Duration, compress bool,
+) *StaticFileHandler {
+	return &StaticFileHandler{Root: root, AssetMaxAge: assetMaxAge, Compress: compress}
+}
+
+// Precompressed variants we look for next to a file, in order of preference.
+var precompressedEncodings = []struct {
+	Encoding  string
+	Extension string
+}{
+	{Encoding: "br", Extension: ".br"},
+	{Encoding: "gzip", Extension: ".gz"},
+}
+
+// File extensions worth compressing on the fly.
+var compressibleExtensions = map[string]bool{
+	".html": true,
+	".css":  true,
+	".js":   true,
+	".json": true,
+	".svg":  true,
+	".xml":  true,
+	".txt":  true,
+}
+
+// Reports whether the request's Accept-Encoding header accepts encoding.
+func acceptsEncoding(request *http.Request, encoding string) bool {
+	for _, accepted := range strings.Split(request.Header.Get("Accept-Encoding"), ",") {
+		accepted = strings.TrimSpace(strings.SplitN(accepted, ";", 2)[0])
+		if accepted == encoding || accepted == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Gzips everything written to it. The gzip stream is only started on the first
+// write, so bodiless responses like 304s stay empty.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gzipWriter *gzip.Writer
+}
+
+func (writer *gzipResponseWriter) Write(data []byte) (int, error) {
+	if writer.gzipWriter == nil {
+		writer.gzipWriter = gzip.NewWriter(writer.ResponseWriter)
+	}
+	return writer.gzipWriter.Write(data)
+}
+
+func (writer *gzipResponseWriter) Close() error {
+	if writer.gzipWriter == nil {
+		return nil
+	}
+	return writer.gzipWriter.Close()
+}
+
+// Returns the ETag of the file at filePath, hashing it if it changed since we last
+// saw it.
+func fileETag(filePath string, info os.FileInfo, file io.ReadSeeker) (string, error) {
+	etagCache.lock.Lock()
+	entry, ok := etagCache.entries[filePath]
+	etagCache.lock.Unlock()
+	if ok && entry.size == info.Size() && entry.modTime.Equal(info.ModTime()) {
+		return entry.etag, nil
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	etag := `"` + hex.EncodeToString(hash.Sum(nil))[:32] + `"`
+
+	etagCache.lock.Lock()
+	etagCache.entries[filePath] = etagEntry{
+		size: info.Size(), modTime: info.ModTime(), etag: etag,
+	}
+	etagCache.lock.Unlock()
+	return etag, nil
+}
+
+func (handler *StaticFileHandler) ServeHTTP(
+	writer http.ResponseWriter, request *http.Request,
+) {
+	if request.Method != http.MethodGet && request.Method != http.MethodHead {
+		writer.Header().Set("Allow", "GET, HEAD")
+		http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	urlPath := path.Clean("/" + request.URL.Path)
+	filePath := filepath.Join(handler.Root, filepath.FromSlash(urlPath))
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		http.NotFound(writer, request)
+		return
+	}
+	if info.IsDir() {
+		if !strings.HasSuffix(request.URL.Path, "/") {
+			redirectToDir(writer, request)
+			return
+		}
+		filePath = filepath.Join(filePath, "index.html")
+		if info, err = os.Stat(filePath); err != nil || info.IsDir() {
+			http.NotFound(writer, request)
+			return
+		}
+	}
+
+	header := writer.Header()
+	name := info.Name()
+	servedPath, servedInfo, encoding := filePath, info, ""
+
+	if handler.Compress {
+		header.Add("Vary", "Accept-Encoding")
+		for _, variant := range precompressedEncodings {
+			if !acceptsEncoding(request, variant.Encoding) {
+				continue
+			}
+			variantInfo, err := os.Stat(filePath + variant.Extension)
+			if err == nil && !variantInfo.IsDir() {
+				servedPath, servedInfo = filePath+variant.Extension, variantInfo
+				encoding = variant.Encoding
+				break
+			}
+		}
+	}
+
+	file, err := os.Open(servedPath)
+	if err != nil {
+		http.NotFound(writer, request)
+		return
+	}
+	defer file.Close()
+
+	etag, err := fileETag(servedPath, servedInfo, file)
+	if err != nil {
+		http.Error(writer, "error reading file", http.StatusInternalServerError)
+		return
+	}
+
+	// Compress on the fly when there is no precompressed file. Ranges refer to the
+	// uncompressed bytes, so range requests are served uncompressed.
+	if handler.Compress &&
+		encoding == "" &&
+		compressibleExtensions[path.Ext(name)] &&
+		request.Header.Get("Range") == "" &&
+		acceptsEncoding(request, "gzip") {
+
+		gzipWriter := &gzipResponseWriter{ResponseWriter: writer}
+		defer gzipWriter.Close()
+		writer = gzipWriter
+		encoding = "gzip"
+	}
+
+	if encoding != "" {
+		// Also stops ServeContent from setting the uncompressed Content-Length.
+		header.Set("Content-Encoding", encoding)
+		etag = strings.TrimSuffix(etag, `"`) + "-" + encoding + `"`
+	}
+
+	header.Set("ETag", etag)
+	if strings.HasSuffix(filePath, ".html") || handler.AssetMaxAge <= 0 {
+		header.Set("Cache-Control", "no-cache")
+	} else {
+		maxAge := strconv.Itoa(int(handler.AssetMaxAge / time.Second))
+		header.Set("Cache-Control", "public, max-age="+maxAge)
+	}
+
+	// Handles If-None-Match, If-Modified-Since, Range and content types.
+	// Content types are detected from the uncompressed name.
+	http.ServeContent(writer, request, name, servedInfo.ModTime(), file)
+}
+
+// Redirects a request for a directory to the directory with a trailing slash. The
+// Location is relative to the last segment of the request URI as the client sent it,
+// since handlers behind http.StripPrefix only see the stripped path, which
+// http.Redirect would resolve the target against.
+func redirectToDir(writer http.ResponseWriter, request *http.Request) {
+	requestPath := request.URL.EscapedPath()
+	if requestURI, err := url.ParseRequestURI(request.RequestURI); err == nil {
+		requestPath = requestURI.EscapedPath()
+	}
+	target := "./" + path.Base(requestPath) + "/"
+	if query := request.URL.RawQuery; query != "" {
+		target += "?" + query
+	}
+	writer.Header().Set("Location", target)
+	writer.WriteHeader(http.StatusMovedPermanently)
+}
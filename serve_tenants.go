@@ -0,0 +1,411 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Name of the optional per-tenant file describing how to rebuild its docs.
+const tenantConfigName = "docmodule-tenant.json"
+
+// Environment variable holding the shared secret rebuild requests must carry, either
+// as a bearer token or as the key of a webhook signature of the request body.
+const rebuildSecretEnv = "DOCMODULE_REBUILD_SECRET"
+
+// Largest rebuild request body read to check its webhook signature.
+const rebuildBodyLimit = 1 << 20
+
+// Version alias which resolves to the most recently built version of a tenant.
+const latestVersion = "latest"
+
+// TenantConfig tells multi-tenant serve mode how to rebuild a tenant's docs.
+type TenantConfig struct {
+	// Root of the module to document.
+	ModuleRoot string `json:"moduleRoot"`
+	// Extra arguments passed to the build.
+	Args []string `json:"args"`
+}
+
+// TenantServer hosts multiple modules and versions from a content root laid out as
+//
+//	<content root>/<tenant>/<version>/...
+//
+// where each version directory is a finished build. Requests are routed by host
+// when a tenant is named after the request's host name, otherwise by the first path
+// segment. Each tenant can be rebuilt independently of the others.
+type TenantServer struct {
+	ContentRoot string
+	// Settings applied to every tenant's build directory.
+	Settings *ServeSettings
+	// Receives an entry for every rebuild requested, succeeded or failed.
+	AuditLog *JSONLogger
+	// Tenants with a rebuild in progress.
+	rebuilding map[string]bool
+	lock       sync.Mutex
+}
+
+func NewTenantServer(settings *ServeSettings) *TenantServer {
+	return &TenantServer{
+		ContentRoot: settings.ContentRoot,
+		Settings:    settings,
+		rebuilding:  make(map[string]bool),
+	}
+}
+
+// Returns whether name is a tenant directory in the content root.
+func (server *TenantServer) isTenant(name string) bool {
+	if name == "" || strings.HasPrefix(name, ".") || strings.ContainsAny(name, `/\`) {
+		return false
+	}
+	info, err := os.Stat(filepath.Join(server.ContentRoot, name))
+	return err == nil && info.IsDir()
+}
+
+// Returns the version of tenant whose build finished last. Hidden directories, like
+// the ones rebuildTenant builds into and swaps out, are not versions.
+func (server *TenantServer) latestVersion(tenant string) (string, bool) {
+	tenantDir := filepath.Join(server.ContentRoot, tenant)
+	entries, err := ioutil.ReadDir(tenantDir)
+	if err != nil {
+		return "", false
+	}
+
+	var latest string
+	var latestInfo *BuildInfo
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		info, err := readBuildInfo(filepath.Join(tenantDir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		if latestInfo == nil || info.BuiltAt.After(latestInfo.BuiltAt) {
+			latest, latestInfo = entry.Name(), info
+		}
+	}
+	return latest, latestInfo != nil
+}
+
+// Resolves the tenant of request, from its host or else the first segment of its
+// path, and returns it with the path prefix naming it and the rest of the path.
+func (server *TenantServer) resolveTenant(request *http.Request) (tenant, prefix, rest string, ok bool) {
+	host, _, err := net.SplitHostPort(request.Host)
+	if err != nil {
+		host = request.Host
+	}
+
+	rest = strings.TrimPrefix(request.URL.Path, "/")
+	if server.isTenant(host) {
+		return host, "", rest, true
+	}
+	parts := strings.SplitN(rest, "/", 2)
+	if !server.isTenant(parts[0]) {
+		return "", "", "", false
+	}
+	rest = ""
+	if len(parts) > 1 {
+		rest = parts[1]
+	}
+	return parts[0], "/" + parts[0], rest, true
+}
+
+// Reports whether rest, as returned by resolveTenant, is the tenant rebuild route.
+func isRebuildRoute(rest string) bool {
+	return rest == strings.TrimPrefix(serveAPIPrefix, "/")+"rebuild"
+}
+
+func (server *TenantServer) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	// The implementers index spans all tenants.
+	implementersPrefix := serveAPIPrefix + "implementers/"
+	if server.Settings.SymbolRegistry && strings.HasPrefix(request.URL.Path, implementersPrefix) {
+		files := NewStaticFileHandler(
+			contentRootImplementers(server.ContentRoot),
+			server.Settings.AssetMaxAge,
+			server.Settings.Compress,
+		)
+		http.StripPrefix(strings.TrimSuffix(implementersPrefix, "/"), files).ServeHTTP(writer, request)
+		return
+	}
+
+	tenant, prefix, rest, ok := server.resolveTenant(request)
+	if !ok {
+		http.NotFound(writer, request)
+		return
+	}
+
+	if isRebuildRoute(rest) {
+		server.handleRebuild(writer, request, tenant)
+		return
+	}
+
+	parts := strings.SplitN(rest, "/", 2)
+	version := parts[0]
+	if version == "" || version == latestVersion {
+		latest, ok := server.latestVersion(tenant)
+		if !ok {
+			http.NotFound(writer, request)
+			return
+		}
+		if version == "" {
+			http.Redirect(writer, request, prefix+"/"+latestVersion+"/", http.StatusFound)
+			return
+		}
+		version = latest
+	}
+	if strings.HasPrefix(version, ".") {
+		http.NotFound(writer, request)
+		return
+	}
+
+	buildDir := filepath.Join(server.ContentRoot, tenant, version)
+	if info, err := os.Stat(buildDir); err != nil || !info.IsDir() {
+		http.NotFound(writer, request)
+		return
+	}
+
+	// The mux would redirect the empty path left after stripping the prefix to the
+	// root of the server rather than of the version.
+	if len(parts) == 1 {
+		redirectToDir(writer, request)
+		return
+	}
+
+	// Serve the version directory exactly like single-tenant serve mode.
+	versionSettings := *server.Settings
+	versionSettings.BuildDir = buildDir
+	mux := newServeMux(&versionSettings)
+	http.StripPrefix(prefix+"/"+parts[0], mux).ServeHTTP(writer, request)
+}
+
+// Reports whether a rebuild request carries the shared secret, as a bearer token or
+// as an X-Hub-Signature-256 HMAC of body, or comes from a viewer authenticated with
+// the rebuild role. Without a secret or role, rebuilds are never authorized.
+func (server *TenantServer) rebuildAuthorized(request *http.Request, body []byte) bool {
+	if role := server.Settings.RebuildRole; role != "" {
+		principal := requestPrincipal(request)
+		if principal != nil && hasAnyRole(principal.Roles, []string{role}) {
+			return true
+		}
+	}
+
+	secret := server.Settings.RebuildSecret
+	if secret == "" {
+		return false
+	}
+	if token, ok := bearerToken(request); ok {
+		return subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1
+	}
+	signature := request.Header.Get("X-Hub-Signature-256")
+	if digest := strings.TrimPrefix(signature, "sha256="); digest != signature {
+		received, err := hex.DecodeString(digest)
+		if err != nil {
+			return false
+		}
+		mac := hmac.New(sha256.New, []byte(secret))
+		_, _ = mac.Write(body)
+		return hmac.Equal(received, mac.Sum(nil))
+	}
+	return false
+}
+
+// Reports whether request is a rebuild request carrying the shared secret or a webhook
+// signature instead of the viewer credentials of --auth. authMiddleware lets these
+// through unauthenticated, handleRebuild still checks them with rebuildAuthorized.
+func (server *TenantServer) carriesRebuildSecret(request *http.Request) bool {
+	secret := server.Settings.RebuildSecret
+	if secret == "" {
+		return false
+	}
+	if _, _, rest, ok := server.resolveTenant(request); !ok || !isRebuildRoute(rest) {
+		return false
+	}
+	if token, ok := bearerToken(request); ok {
+		return subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1
+	}
+	return request.Header.Get("X-Hub-Signature-256") != ""
+}
+
+// Returns the bearer token of the request's Authorization header, if it has one.
+func bearerToken(request *http.Request) (string, bool) {
+	header := request.Header.Get("Authorization")
+	token := strings.TrimPrefix(header, "Bearer ")
+	return token, token != header
+}
+
+// Triggers an asynchronous rebuild of a tenant version, given by the `version` query
+// parameter. Only one rebuild per tenant runs at a time. The request must be
+// authorized by rebuildAuthorized.
+func (server *TenantServer) handleRebuild(
+	writer http.ResponseWriter, request *http.Request, tenant string,
+) {
+	if request.Method != http.MethodPost {
+		http.Error(writer, "rebuild must be a POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(request.Body, rebuildBodyLimit))
+	if err != nil {
+		http.Error(writer, "error reading request", http.StatusBadRequest)
+		return
+	}
+	version := request.URL.Query().Get("version")
+	audit := AuditLogEntry{
+		Action:     "rebuild",
+		Tenant:     tenant,
+		Version:    version,
+		RemoteAddr: remoteHost(request),
+		Subject:    requestSubject(request),
+	}
+	if !server.rebuildAuthorized(request, body) {
+		server.audit(audit, "rejected", nil)
+		http.Error(writer, "rebuild not authorized", http.StatusForbidden)
+		return
+	}
+
+	if version == "" ||
+		version == latestVersion ||
+		strings.HasPrefix(version, ".") ||
+		strings.ContainsAny(version, `/\`) {
+		http.Error(writer, "a concrete version is required", http.StatusBadRequest)
+		return
+	}
+
+	tenantDir := filepath.Join(server.ContentRoot, tenant)
+	data, err := ioutil.ReadFile(filepath.Join(tenantDir, tenantConfigName))
+	if err != nil {
+		http.Error(writer, "tenant has no rebuild config", http.StatusNotFound)
+		return
+	}
+	config := new(TenantConfig)
+	if err := json.Unmarshal(data, config); err != nil {
+		http.Error(writer, "invalid tenant rebuild config", http.StatusInternalServerError)
+		return
+	}
+
+	server.lock.Lock()
+	if server.rebuilding[tenant] {
+		server.lock.Unlock()
+		http.Error(writer, "rebuild already in progress", http.StatusConflict)
+		return
+	}
+	server.rebuilding[tenant] = true
+	server.lock.Unlock()
+
+	server.audit(audit, "requested", nil)
+
+	go func() {
+		defer func() {
+			server.lock.Lock()
+			delete(server.rebuilding, tenant)
+			server.lock.Unlock()
+		}()
+		registry := ""
+		if server.Settings.SymbolRegistry {
+			registry = contentRootRegistry(server.ContentRoot)
+		}
+		err := rebuildTenant(tenantDir, version, config, registry, server.Settings.AuditLog)
+		if err != nil {
+			log.Printf("error rebuilding %v %v: %v", tenant, version, err)
+			server.audit(audit, "failed", err)
+		} else {
+			server.audit(audit, "succeeded", nil)
+		}
+	}()
+
+	writer.WriteHeader(http.StatusAccepted)
+}
+
+// Writes entry to the audit log with the given outcome.
+func (server *TenantServer) audit(entry AuditLogEntry, outcome string, err error) {
+	entry.Time = time.Now().UTC()
+	entry.Outcome = outcome
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	server.AuditLog.Log(&entry)
+}
+
+// Builds a tenant version into a staging directory and swaps it into place, so the
+// old build keeps being served until the new one is complete. With a registry, the
+// build records its symbols there, hosted under /<tenant>/<version> unless its
+// args set another base url. Publishes of the build go to auditLog, the audit log of
+// serve mode, unless it is stderr, which the build's output is captured from.
+func rebuildTenant(
+	tenantDir string, version string, config *TenantConfig, registry string, auditLog string,
+) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	versionDir := filepath.Join(tenantDir, version)
+	stagingDir := filepath.Join(tenantDir, "."+version+".building")
+	oldDir := filepath.Join(tenantDir, "."+version+".old")
+
+	args := append([]string{"-build-path", stagingDir}, config.Args...)
+	if registry != "" {
+		args = append(args,
+			"-symbol-registry", registry,
+			"-implementers-index", contentRootImplementers(filepath.Dir(tenantDir)),
+		)
+		if !hasFlagArg(config.Args, "base-url") {
+			baseURL := "/" + filepath.Base(tenantDir) + "/" + version
+			args = append(args, "-base-url", baseURL)
+		}
+	}
+	if auditLog != "" && auditLog != "-" && !hasFlagArg(config.Args, "audit-log") {
+		if auditLog != "syslog" {
+			// The build runs in the module root.
+			if auditLog, err = filepath.Abs(auditLog); err != nil {
+				return err
+			}
+		}
+		args = append(args, "-audit-log", auditLog)
+	}
+	command := exec.Command(executable, args...)
+	command.Dir = config.ModuleRoot
+
+	log.Println("rebuilding", versionDir+".")
+	if output, err := command.CombinedOutput(); err != nil {
+		_ = os.RemoveAll(stagingDir)
+		log.Print(string(output))
+		return err
+	}
+
+	_ = os.RemoveAll(oldDir)
+	if err := os.Rename(versionDir, oldDir); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Rename(stagingDir, versionDir); err != nil {
+		return err
+	}
+	log.Println("rebuilt", versionDir+".")
+	return os.RemoveAll(oldDir)
+}
+
+// Reports whether args set the flag called name, with one or two dashes or by its
+// short name.
+func hasFlagArg(args []string, name string) bool {
+	for _, arg := range args {
+		arg = strings.SplitN(strings.TrimLeft(arg, "-"), "=", 2)[0]
+		if arg == name || shortFlagNames[arg] == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Writes the build info of a version directory of a tenant, built at builtAt.
+func writeTestBuild(t *testing.T, contentRoot string, tenant string, version string, builtAt time.Time) {
+	t.Helper()
+	data, err := json.Marshal(&BuildInfo{Module: "example.com/" + tenant, BuiltAt: builtAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	versionDir := filepath.Join(contentRoot, tenant, version)
+	if err := os.MkdirAll(versionDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(versionDir, buildInfoName), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Returns a content root with the builds v1 and v2 of the tenant acme, and the
+// staging directory of a rebuild of v1 finished after both.
+func newTestContentRoot(t *testing.T) string {
+	t.Helper()
+	contentRoot := writeTestTree(t, map[string]string{
+		"acme/v1/index.html":         "acme v1",
+		"acme/v1/pkg/sub/index.html": "acme v1 sub",
+		"acme/v2/index.html":         "acme v2",
+		"acme/.v1.building/x.html":   "staging",
+		"acme/notes.txt":             "not a version",
+	})
+	now := time.Now().UTC()
+	writeTestBuild(t, contentRoot, "acme", "v1", now.Add(-2*time.Hour))
+	writeTestBuild(t, contentRoot, "acme", "v2", now.Add(-time.Hour))
+	writeTestBuild(t, contentRoot, "acme", ".v1.building", now)
+	return contentRoot
+}
+
+func TestLatestVersion(t *testing.T) {
+	contentRoot := newTestContentRoot(t)
+	defer os.RemoveAll(contentRoot)
+	if err := os.MkdirAll(filepath.Join(contentRoot, "empty"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	server := NewTenantServer(&ServeSettings{ContentRoot: contentRoot})
+
+	cases := []struct {
+		tenant  string
+		version string
+		ok      bool
+	}{
+		{tenant: "acme", version: "v2", ok: true},
+		{tenant: "empty"},
+		{tenant: "missing"},
+	}
+	for _, testCase := range cases {
+		version, ok := server.latestVersion(testCase.tenant)
+		if version != testCase.version || ok != testCase.ok {
+			t.Errorf(
+				"latestVersion(%q) = %q, %v, want %q, %v",
+				testCase.tenant, version, ok, testCase.version, testCase.ok,
+			)
+		}
+	}
+}
+
+func TestTenantServerRouting(t *testing.T) {
+	contentRoot := newTestContentRoot(t)
+	defer os.RemoveAll(contentRoot)
+	server := httptest.NewServer(NewTenantServer(&ServeSettings{ContentRoot: contentRoot}))
+	defer server.Close()
+	client := server.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	cases := []struct {
+		path     string
+		status   int
+		location string
+		body     string
+	}{
+		{path: "/acme", status: http.StatusFound, location: "/acme/latest/"},
+		{path: "/acme/", status: http.StatusFound, location: "/acme/latest/"},
+		{path: "/acme/latest/", status: http.StatusOK, body: "acme v2"},
+		{path: "/acme/v1/", status: http.StatusOK, body: "acme v1"},
+		{path: "/acme/v1/pkg/sub/", status: http.StatusOK, body: "acme v1 sub"},
+		{path: "/acme/v1", status: http.StatusMovedPermanently, location: "./v1/"},
+		{path: "/acme/latest", status: http.StatusMovedPermanently, location: "./latest/"},
+		{path: "/acme/v1/pkg/sub", status: http.StatusMovedPermanently, location: "./sub/"},
+		{path: "/acme/.v1.building/x.html", status: http.StatusNotFound},
+		{path: "/acme/v3/", status: http.StatusNotFound},
+		{path: "/other/", status: http.StatusNotFound},
+	}
+	for _, testCase := range cases {
+		t.Run(testCase.path, func(t *testing.T) {
+			resp, err := client.Get(server.URL + testCase.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, _ := ioutil.ReadAll(resp.Body)
+
+			if resp.StatusCode != testCase.status {
+				t.Fatalf("status %v, want %v", resp.StatusCode, testCase.status)
+			}
+			if location := resp.Header.Get("Location"); location != testCase.location {
+				t.Errorf("Location %q, want %q", location, testCase.location)
+			}
+			if testCase.body != "" && string(body) != testCase.body {
+				t.Errorf("body %q, want %q", body, testCase.body)
+			}
+		})
+	}
+}
+
+// Authenticator rejecting every request, like OIDC does for tokens it didn't issue.
+type rejectingAuthenticator struct{}
+
+func (rejectingAuthenticator) Routes() map[string]http.Handler {
+	return nil
+}
+
+func (rejectingAuthenticator) Authenticate(
+	writer http.ResponseWriter, request *http.Request,
+) (*Principal, error) {
+	return nil, http.ErrNoCookie
+}
+
+func TestTenantRebuildAuthorization(t *testing.T) {
+	contentRoot := newTestContentRoot(t)
+	defer os.RemoveAll(contentRoot)
+	const secret = "rebuild-secret"
+	tenantServer := NewTenantServer(&ServeSettings{
+		ContentRoot:   contentRoot,
+		RebuildSecret: secret,
+	})
+	// As with --auth, only rebuilds carrying the secret get past the authenticator.
+	handler := authMiddleware(
+		rejectingAuthenticator{}, tenantServer, tenantServer.carriesRebuildSecret,
+	)
+
+	body := `{"ref":"refs/tags/v3"}`
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write([]byte(body))
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	cases := []struct {
+		name    string
+		path    string
+		headers map[string]string
+		status  int
+	}{
+		{
+			// Authorized, but acme has no rebuild config.
+			name:    "bearer secret",
+			path:    "/acme/_docmodule/rebuild?version=v3",
+			headers: map[string]string{"Authorization": "Bearer " + secret},
+			status:  http.StatusNotFound,
+		},
+		{
+			name:    "webhook signature",
+			path:    "/acme/_docmodule/rebuild?version=v3",
+			headers: map[string]string{"X-Hub-Signature-256": signature},
+			status:  http.StatusNotFound,
+		},
+		{
+			// Let through by the middleware, rejected by the tenant server.
+			name:    "wrong webhook signature",
+			path:    "/acme/_docmodule/rebuild?version=v3",
+			headers: map[string]string{"X-Hub-Signature-256": "sha256=00"},
+			status:  http.StatusForbidden,
+		},
+		{
+			name:    "wrong bearer token",
+			path:    "/acme/_docmodule/rebuild?version=v3",
+			headers: map[string]string{"Authorization": "Bearer other"},
+			status:  http.StatusUnauthorized,
+		},
+		{
+			name:   "no credentials",
+			path:   "/acme/_docmodule/rebuild?version=v3",
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:    "signature outside of the rebuild route",
+			path:    "/acme/v1/_docmodule/rebuild",
+			headers: map[string]string{"X-Hub-Signature-256": signature},
+			status:  http.StatusUnauthorized,
+		},
+		{
+			name:    "signature for an unknown tenant",
+			path:    "/other/_docmodule/rebuild",
+			headers: map[string]string{"X-Hub-Signature-256": signature},
+			status:  http.StatusUnauthorized,
+		},
+	}
+	for _, testCase := range cases {
+		t.Run(testCase.name, func(t *testing.T) {
+			request := httptest.NewRequest(
+				http.MethodPost, testCase.path, strings.NewReader(body),
+			)
+			for name, value := range testCase.headers {
+				request.Header.Set(name, value)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+			if recorder.Code != testCase.status {
+				t.Errorf("status %v, want %v: %s", recorder.Code, testCase.status, recorder.Body)
+			}
+		})
+	}
+}
+
+func TestHasFlagArg(t *testing.T) {
+	cases := []struct {
+		args []string
+		name string
+		want bool
+	}{
+		{args: []string{"-base-url", "/docs"}, name: "base-url", want: true},
+		{args: []string{"--base-url=/docs"}, name: "base-url", want: true},
+		{args: []string{"-o", "out"}, name: "build-path", want: true},
+		{args: []string{"-build-path=out"}, name: "base-url"},
+		{args: []string{"/base-url"}, name: "base-url"},
+		{args: nil, name: "base-url"},
+	}
+	for _, testCase := range cases {
+		if got := hasFlagArg(testCase.args, testCase.name); got != testCase.want {
+			t.Errorf("hasFlagArg(%q, %q) = %v, want %v", testCase.args, testCase.name, got, testCase.want)
+		}
+	}
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"golang.org/x/xerrors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// VisibilityRule restricts a set of packages to viewers holding one of Roles.
+type VisibilityRule struct {
+	// Package pattern, e.g. example.com/mod/internal/...
+	Packages string `json:"packages"`
+	// Roles allowed to view the packages.
+	Roles []string `json:"roles"`
+}
+
+// VisibilityPolicy hides internal-audience packages from viewers without the
+// required role. Packages matching no rule are public.
+type VisibilityPolicy struct {
+	Rules []*VisibilityRule `json:"rules"`
+}
+
+func loadVisibilityPolicy(filePath string) (*VisibilityPolicy, error) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, xerrors.Errorf("error reading visibility policy: %w", err)
+	}
+
+	policy := new(VisibilityPolicy)
+	if err := json.Unmarshal(data, policy); err != nil {
+		return nil, xerrors.Errorf("error parsing visibility policy: %w", err)
+	}
+	return policy, nil
+}
+
+// Reports whether a viewer with roles may see the package importPath.
+func (policy *VisibilityPolicy) Allows(importPath string, roles []string) bool {
+	for _, rule := range policy.Rules {
+		if !matchPackagePattern(rule.Packages, importPath) {
+			continue
+		}
+		if !hasAnyRole(roles, rule.Roles) {
+			return false
+		}
+	}
+	return true
+}
+
+func hasAnyRole(held []string, allowed []string) bool {
+	for _, role := range held {
+		for _, allowedRole := range allowed {
+			if role == allowedRole {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Extracts the viewer's roles from the request. Without serve mode authentication,
+// the reverse proxy in front of us is trusted to set settings.RolesHeader, either to
+// a comma separated list of roles or, when settings.RolesClaim is set, to a JWT
+// carrying the roles in that claim. The proxy is responsible for verifying the token.
+func requestRoles(settings *ServeSettings, request *http.Request) []string {
+	// Roles of viewers we authenticated ourselves take precedence.
+	if principal := requestPrincipal(request); principal != nil {
+		return principal.Roles
+	}
+
+	value := request.Header.Get(settings.RolesHeader)
+	if value == "" {
+		return nil
+	}
+
+	if settings.RolesClaim == "" {
+		roles := make([]string, 0)
+		for _, role := range strings.Split(value, ",") {
+			if role = strings.TrimSpace(role); role != "" {
+				roles = append(roles, role)
+			}
+		}
+		return roles
+	}
+
+	claims := jwtClaims(strings.TrimPrefix(value, "Bearer "))
+	switch claim := claims[settings.RolesClaim].(type) {
+	case string:
+		return strings.Fields(claim)
+	case []interface{}:
+		roles := make([]string, 0, len(claim))
+		for _, role := range claim {
+			if roleString, ok := role.(string); ok {
+				roles = append(roles, roleString)
+			}
+		}
+		return roles
+	}
+	return nil
+}
+
+// Decodes the payload of a JWT without verifying it.
+func jwtClaims(token string) map[string]interface{} {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil
+	}
+	claims := make(map[string]interface{})
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil
+	}
+	return claims
+}
+
+// Extensions of the assets of the pages, which every viewer may fetch.
+var pageAssetExtensions = map[string]bool{
+	".css":   true,
+	".js":    true,
+	".png":   true,
+	".jpg":   true,
+	".jpeg":  true,
+	".gif":   true,
+	".svg":   true,
+	".ico":   true,
+	".webp":  true,
+	".woff":  true,
+	".woff2": true,
+	".ttf":   true,
+}
+
+// Pages and directories of the build holding the docs of every package, which
+// viewers with hidden packages may not fetch. The notes pages of every marker are
+// aggregate pages too, see isAggregateFile.
+var (
+	aggregatePages = map[string]bool{graphPageName: true, allNotesPageName: true}
+	aggregateDirs  = []string{
+		examplePagesDir, markdownDir, hugoDir, docusaurusDir, jekyllDir, manDir,
+	}
+)
+
+// Regexes for the markup of a page linking to other packages: rows of the package
+// index and links, like the entries of the sidebar.
+var (
+	pageTableRowRegex = regexp.MustCompile(`(?is)<tr[\s>].*?</tr>`)
+	pageAnchorRegex   = regexp.MustCompile(`(?is)<a\s[^>]*>.*?</a>`)
+)
+
+// Returns the path of the build file a request path addresses, where directories
+// address their index.html.
+func requestRelPath(urlPath string) string {
+	relPath := strings.TrimPrefix(path.Clean("/"+urlPath), "/")
+	if relPath == "" || strings.HasSuffix(urlPath, "/") {
+		relPath = path.Join(relPath, "index.html")
+	}
+	return relPath
+}
+
+// Returns the path of the file a request path addresses as in the page index, where
+// precompressed and text-only variants and type definition sidecars address the page
+// they are made of.
+func visibilityRelPath(urlPath string) string {
+	relPath := requestRelPath(urlPath)
+	relPath = strings.TrimSuffix(strings.TrimSuffix(relPath, ".gz"), ".br")
+	relPath = strings.TrimPrefix(relPath, textSiteDir+"/")
+	if strings.HasSuffix(relPath, symbolSidecarSuffix) {
+		relPath = strings.TrimSuffix(relPath, symbolSidecarSuffix) + ".html"
+	}
+	return relPath
+}
+
+// Reports whether a file of the build other than a package page is hidden from
+// viewers with hidden packages: everything but the other HTML pages, whose links to
+// hidden pages are removed, and the assets of the pages.
+func isAggregateFile(relPath string) bool {
+	if aggregatePages[relPath] {
+		return true
+	}
+	// The pages of the notes of a marker, which serve mode doesn't know the markers of.
+	if strings.HasPrefix(relPath, notesPagePrefix) && path.Ext(relPath) == ".html" &&
+		!strings.Contains(relPath, "/") {
+		return true
+	}
+	for _, dir := range aggregateDirs {
+		if strings.HasPrefix(relPath, dir+"/") {
+			return true
+		}
+	}
+	return path.Ext(relPath) != ".html" && !pageAssetExtensions[path.Ext(relPath)]
+}
+
+// Removes the rows and links of a page pointing to hidden pages, like the entries of
+// the package index and the sidebar. relPath is the path of the page.
+func removeHiddenLinks(data []byte, relPath string, hidden map[string]bool) []byte {
+	linksHidden := func(markup []byte) bool {
+		for _, match := range localLinkRegex.FindAllSubmatch(markup, -1) {
+			link := string(match[2])
+			if strings.HasPrefix(link, "/") {
+				continue
+			}
+			target := path.Join(path.Dir(relPath), link)
+			if strings.HasSuffix(link, "/") {
+				target += "/"
+			}
+			if hidden[visibilityRelPath("/"+target)] {
+				return true
+			}
+		}
+		return false
+	}
+	remove := func(markup []byte) []byte {
+		if linksHidden(markup) {
+			return nil
+		}
+		return markup
+	}
+	data = pageTableRowRegex.ReplaceAllFunc(data, remove)
+	return pageAnchorRegex.ReplaceAllFunc(data, remove)
+}
+
+// Holds a response so its body can be rewritten before it is sent.
+type bufferedResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (writer *bufferedResponseWriter) Header() http.Header {
+	return writer.header
+}
+
+func (writer *bufferedResponseWriter) WriteHeader(status int) {
+	writer.status = status
+}
+
+func (writer *bufferedResponseWriter) Write(data []byte) (int, error) {
+	if writer.status == 0 {
+		writer.status = http.StatusOK
+	}
+	return writer.body.Write(data)
+}
+
+// Wraps the file server of a build directory so package pages hidden from the viewer
+// return 404, as if they were not part of the build, in every variant: precompressed,
+// text-only and sidecars. Viewers with hidden packages get the other pages without
+// their links to hidden pages, and can't fetch the files listing every package, like
+// model.json, the anchor index or the pages of other formats.
+func visibilityMiddleware(settings *ServeSettings, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		pages, err := readPageIndex(settings.BuildDir)
+		if err != nil {
+			// Without a page index we cannot tell packages apart, so fail closed.
+			http.Error(writer, "page index missing", http.StatusInternalServerError)
+			return
+		}
+
+		// The page index names every package, including hidden ones.
+		requestPath := requestRelPath(request.URL.Path)
+		relPath := visibilityRelPath(request.URL.Path)
+		if relPath == pageIndexName {
+			http.NotFound(writer, request)
+			return
+		}
+
+		roles := requestRoles(settings, request)
+		hidden := make(map[string]bool)
+		for pagePath, importPath := range pages {
+			if !settings.Visibility.Allows(importPath, roles) {
+				hidden[pagePath] = true
+			}
+		}
+		if len(hidden) == 0 {
+			handler.ServeHTTP(writer, request)
+			return
+		}
+		// Directories requested without a trailing slash are redirected to their page.
+		info, err := os.Stat(filepath.Join(settings.BuildDir, filepath.FromSlash(relPath)))
+		if err == nil && info.IsDir() {
+			relPath = path.Join(relPath, "index.html")
+		}
+		_, isPage := pages[relPath]
+		if hidden[relPath] || (!isPage && isAggregateFile(relPath)) {
+			http.NotFound(writer, request)
+			return
+		}
+		switch path.Ext(requestPath) {
+		case ".html":
+		case ".gz", ".br":
+			// Precompressed pages can't have their links removed.
+			if path.Ext(relPath) == ".html" {
+				http.NotFound(writer, request)
+				return
+			}
+			fallthrough
+		default:
+			handler.ServeHTTP(writer, request)
+			return
+		}
+
+		// The page is served uncompressed and whole, so its links can be removed, and
+		// without the ETag, which is the same for every viewer.
+		request = request.Clone(request.Context())
+		for _, name := range []string{"Accept-Encoding", "Range", "If-None-Match", "If-Range"} {
+			request.Header.Del(name)
+		}
+		buffered := &bufferedResponseWriter{header: writer.Header()}
+		handler.ServeHTTP(buffered, request)
+
+		body := buffered.body.Bytes()
+		if buffered.status == http.StatusOK {
+			body = removeHiddenLinks(body, requestPath, hidden)
+		}
+		writer.Header().Del("ETag")
+		writer.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		writer.WriteHeader(buffered.status)
+		_, _ = writer.Write(body)
+	})
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestIsAggregateFile(t *testing.T) {
+	cases := map[string]bool{
+		graphPageName:               true,
+		allNotesPageName:            true,
+		notesPageName("BUG"):        true,
+		"notes-todo.html":           true,
+		"model.json":                true,
+		"anchors.json":              true,
+		examplePagesDir + "/x.html": true,
+		"index.html":                false,
+		"notes-pkg/index.html":      false,
+		"style.css":                 false,
+		"pkg/index.html":            false,
+	}
+	for relPath, want := range cases {
+		if got := isAggregateFile(relPath); got != want {
+			t.Errorf("isAggregateFile(%q) = %v, want %v", relPath, got, want)
+		}
+	}
+}
+
+func TestVisibilityRelPath(t *testing.T) {
+	cases := map[string]string{
+		"/":                                   "index.html",
+		"/pkg/":                               "pkg/index.html",
+		"/pkg/index.html.gz":                  "pkg/index.html",
+		"/pkg/index.html.br":                  "pkg/index.html",
+		"/" + textSiteDir + "/pkg/index.html": "pkg/index.html",
+		"/pkg/../other/index.html":            "other/index.html",
+	}
+	for urlPath, want := range cases {
+		if got := visibilityRelPath(urlPath); got != want {
+			t.Errorf("visibilityRelPath(%q) = %q, want %q", urlPath, got, want)
+		}
+	}
+}
+
+func TestVisibilityMiddleware(t *testing.T) {
+	buildDir := writeTestTree(t, map[string]string{
+		pageIndexName: `[` +
+			`{"path":"pub/index.html","importPath":"example.com/mod/pub"},` +
+			`{"path":"internal/index.html","importPath":"example.com/mod/internal"}` +
+			`]`,
+		"index.html": `<table><tr><td><a href="pub/">pub</a></td></tr>` +
+			`<tr><td><a href="internal/">internal</a></td></tr></table>`,
+		"pub/index.html":         "pub docs",
+		"internal/index.html":    "internal docs",
+		"internal/index.html.gz": "compressed internal docs",
+		"notes-bug.html":         "internal BUG notes",
+		graphPageName:            "graph of every package",
+		"model.json":             "{}",
+		"style.css":              "body {}",
+	})
+	defer os.RemoveAll(buildDir)
+
+	settings := &ServeSettings{
+		BuildDir:    buildDir,
+		RolesHeader: "X-Forwarded-Groups",
+		Visibility: &VisibilityPolicy{Rules: []*VisibilityRule{
+			{Packages: "example.com/mod/internal/...", Roles: []string{"staff"}},
+		}},
+	}
+	handler := newServeMux(settings)
+
+	cases := []struct {
+		name   string
+		roles  string
+		path   string
+		status int
+		// Substrings the body must and must not contain.
+		contains    string
+		notContains string
+	}{
+		{name: "public page", path: "/pub/", status: http.StatusOK, contains: "pub docs"},
+		{name: "hidden page", path: "/internal/", status: http.StatusNotFound},
+		{name: "hidden precompressed page", path: "/internal/index.html.gz", status: http.StatusNotFound},
+		{name: "hidden text-only page", path: "/" + textSiteDir + "/internal/index.html", status: http.StatusNotFound},
+		{name: "notes page", path: "/notes-bug.html", status: http.StatusNotFound},
+		{name: "graph page", path: "/" + graphPageName, status: http.StatusNotFound},
+		{name: "model", path: "/model.json", status: http.StatusNotFound},
+		{name: "page index", path: "/" + pageIndexName, status: http.StatusNotFound},
+		{name: "asset", path: "/style.css", status: http.StatusOK},
+		{
+			name:        "index without hidden links",
+			path:        "/",
+			status:      http.StatusOK,
+			contains:    `href="pub/"`,
+			notContains: `href="internal/"`,
+		},
+		{
+			name:     "index for staff",
+			roles:    "staff",
+			path:     "/",
+			status:   http.StatusOK,
+			contains: `href="internal/"`,
+		},
+		{name: "hidden page for staff", roles: "staff", path: "/internal/", status: http.StatusOK},
+		{name: "notes page for staff", roles: "docs, staff", path: "/notes-bug.html", status: http.StatusOK},
+		{name: "page index for staff", roles: "staff", path: "/" + pageIndexName, status: http.StatusNotFound},
+	}
+	for _, testCase := range cases {
+		t.Run(testCase.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, testCase.path, nil)
+			if testCase.roles != "" {
+				request.Header.Set(settings.RolesHeader, testCase.roles)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			if recorder.Code != testCase.status {
+				t.Fatalf("status %v, want %v", recorder.Code, testCase.status)
+			}
+			body := recorder.Body.String()
+			if testCase.contains != "" && !strings.Contains(body, testCase.contains) {
+				t.Errorf("body %q does not contain %q", body, testCase.contains)
+			}
+			if testCase.notContains != "" && strings.Contains(body, testCase.notContains) {
+				t.Errorf("body %q contains %q", body, testCase.notContains)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"golang.org/x/xerrors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SessionRecorder records the pages served during a scrape so the scrape can be
+// replayed later without the doc server. Pages are stored by url path, in the same
+// layout --fixtures reads.
+type SessionRecorder struct {
+	pages map[string][]byte
+	lock  sync.Mutex
+}
+
+func NewSessionRecorder() *SessionRecorder {
+	return &SessionRecorder{pages: make(map[string][]byte)}
+}
+
+// Returns the path a response to urlPath is stored under.
+func sessionPagePath(urlPath string) string {
+	pagePath := strings.TrimPrefix(path.Clean("/"+urlPath), "/")
+	if pagePath == "" || strings.HasSuffix(urlPath, "/") {
+		pagePath = path.Join(pagePath, "index.html")
+	}
+	return pagePath
+}
+
+// Records a successful response. Used as a reverse proxy's ModifyResponse hook.
+func (recorder *SessionRecorder) Record(response *http.Response) error {
+	if response.StatusCode != http.StatusOK {
+		return nil
+	}
+
+	body, err := ioutil.ReadAll(response.Body)
+	_ = response.Body.Close()
+	if err != nil {
+		return xerrors.Errorf("error recording response: %w", err)
+	}
+	response.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+	recorder.pages[sessionPagePath(response.Request.URL.Path)] = body
+	return nil
+}
+
+// Writes the recorded pages to a tar archive at destPath.
+func (recorder *SessionRecorder) WriteTar(destPath string) error {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+
+	names := make([]string, 0, len(recorder.pages))
+	for name := range recorder.pages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	file, err := os.Create(destPath)
+	if err != nil {
+		return xerrors.Errorf("error creating session archive: %w", err)
+	}
+	defer file.Close()
+
+	archive := tar.NewWriter(file)
+	modTime := time.Now()
+	for _, name := range names {
+		header := &tar.Header{
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(recorder.pages[name])),
+			ModTime:  modTime,
+			Typeflag: tar.TypeReg,
+		}
+		if err := archive.WriteHeader(header); err != nil {
+			return xerrors.Errorf("error writing session archive: %w", err)
+		}
+		if _, err := archive.Write(recorder.pages[name]); err != nil {
+			return xerrors.Errorf("error writing session archive: %w", err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return xerrors.Errorf("error writing session archive: %w", err)
+	}
+	return file.Close()
+}
+
+// Writes the session recorded by recorder to --record, if it is set.
+func writeRecordedSession(settings *Settings, recorder *SessionRecorder) {
+	if recorder == nil {
+		return
+	}
+	if err := recorder.WriteTar(settings.Record); err != nil {
+		log.Panic(err)
+	}
+	log.Println("recorded session to", settings.Record+".")
+}
+
+// Scrapes a session recorded with --record instead of the doc server.
+func replaySession(ctx context.Context, settings *Settings) []*BuildProblem {
+	dir, err := ioutil.TempDir("", "docmodule-replay-")
+	if err != nil {
+		log.Panicf("error extracting session: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	file, err := os.Open(settings.Replay)
+	if err != nil {
+		log.Panicf("error opening session: %v", err)
+	}
+	defer file.Close()
+	if err := extractTar(file, dir); err != nil {
+		log.Panicf("error extracting session: %v", err)
+	}
+
+	log.Println("replaying session", settings.Replay+".")
+	replaySettings := *settings
+	replaySettings.Fixtures = dir
+	return scrapeFixtures(ctx, &replaySettings)
+}
@@ -3,18 +3,27 @@ package main
 import (
 	"encoding/json"
 	"flag"
+	"fmt"
 	"golang.org/x/xerrors"
 	"io/ioutil"
 	"log"
+	"net"
+	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
 	"regexp"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type RunInfo struct {
 	Settings    *Settings
 	HtmlFiles   []string
 	DocFileInfo []*DocFileInfo
+	Summary     *BuildSummary
+	Submodules  []*Submodule
 }
 
 // Call to initialize a blank object without nil pointers.
@@ -22,35 +31,63 @@ func NewRunInfo() *RunInfo {
 	return &RunInfo{
 		Settings:    new(Settings),
 		DocFileInfo: make([]*DocFileInfo, 0),
+		Summary:     NewBuildSummary(),
 	}
 }
 
 type DocFileInfo struct {
-	OldName           string
-	NewName           string
-	HtmlReplaceRegex1 *regexp.Regexp
-	HtmlReplaceRegex2 *regexp.Regexp
-	HtmlReplaceWith1  []byte
-	HtmlReplaceWith2  []byte
+	OldName string
+	NewName string
+	// Path of the renamed file relative to the build directory, slash-separated.
+	NewRelPath string
+	// Import path of the package documented by the file, empty for other pages.
+	ImportPath string
 }
 
-func NewDocFileInfo(oldPath string, newPath string) *DocFileInfo {
-	oldName := filepath.Base(oldPath)
-	newName := filepath.Base(newPath)
+func NewDocFileInfo(oldPath string, newRelPath string) *DocFileInfo {
+	return &DocFileInfo{
+		OldName:    filepath.Base(oldPath),
+		NewName:    path.Base(newRelPath),
+		NewRelPath: newRelPath,
+	}
+}
+
+// Regex for links to files at the root of the build directory, the way wget leaves
+// the links between the pages it saved: a file name, then a fragment or the end of
+// the link.
+var fileLinkRegex = regexp.MustCompile(`href="([^"#/:?]+)([#"])`)
 
-	regex1, _ := regexp.Compile("href=\"" + oldName + "#")
-	regex2, _ := regexp.Compile("href=\"" + oldName + "\"")
+// Rewrites the links of data to the old names of renamed files to point at the new
+// files, relative to fromDir, in a single pass over data. fromDir is the
+// slash-separated directory of the page being rewritten, and newPaths maps the old
+// file names to the new paths, both relative to the build directory.
+func replaceRenamedLinks(data []byte, fromDir string, newPaths map[string]string) []byte {
+	matches := fileLinkRegex.FindAllSubmatchIndex(data, -1)
+	if len(matches) == 0 {
+		return data
+	}
 
-	docFileInfo := DocFileInfo{
-		OldName:           oldName,
-		NewName:           newName,
-		HtmlReplaceRegex1: regex1,
-		HtmlReplaceRegex2: regex2,
-		HtmlReplaceWith1:  []byte("href=\"" + newName + "#"),
-		HtmlReplaceWith2:  []byte("href=\"" + newName + "\""),
+	result := make([]byte, 0, len(data))
+	last := 0
+	for _, match := range matches {
+		newPath, ok := newPaths[string(data[match[2]:match[3]])]
+		if !ok {
+			continue
+		}
+		result = append(result, data[last:match[2]]...)
+		result = append(result, relativeLink(fromDir, newPath)...)
+		last = match[3]
 	}
+	return append(result, data[last:]...)
+}
 
-	return &docFileInfo
+// Returns a relative link from the directory fromDir to the file target. Both are
+// slash-separated and relative to the build directory.
+func relativeLink(fromDir string, target string) string {
+	if fromDir == "." || fromDir == "" {
+		return target
+	}
+	return strings.Repeat("../", strings.Count(fromDir, "/")+1) + target
 }
 
 type CliArgs struct {
@@ -60,8 +97,198 @@ type CliArgs struct {
 	BuildDir *string
 	// Base name to use for html files
 	HTMLBaseName *string
+	// Output layout, flat or nested
+	Layout *string
+	// URL the docs will be hosted at
+	BaseURL *string
+	// Documentation server to scrape
+	Backend *string
+	// Install the backend binary if it is missing
+	AutoInstall *bool
+	// How long to wait for the doc server to come up
+	ServerTimeout *time.Duration
+	// Bound on the whole run
+	Timeout *time.Duration
+	// Initial interval between doc server readiness checks
+	PollInterval *time.Duration
+	// WebDAV collection to publish to
+	WebDAVURL *string
+	// WebDAV user name
+	WebDAVUser *string
+	// Remove published files which are no longer part of the build
+	DeleteExtraneous *bool
+	// Artifactory / Nexus generic repository URL
+	ArtifactRepoURL *string
+	// Artifact group, e.g. com.example.docs
+	ArtifactGroup *string
+	// Artifact name, defaults to the last element of the module name
+	ArtifactName *string
+	// Artifact version
+	ArtifactVersion *string
+	// Artifact repository user name
+	ArtifactUser *string
+	// Upload the unpacked site alongside the archive
+	ArtifactUnpacked *bool
+	// Add the build directory to IPFS
+	IPFS *bool
+	// Confluence instance to publish the package pages to
+	ConfluenceURL *string
+	// Key of the Confluence space
+	ConfluenceSpace *string
+	// Id of the Confluence page the package pages go under
+	ConfluenceParent *string
+	// Confluence user name
+	ConfluenceUser *string
+	// Name of the search index of the elasticsearch output
+	SearchIndex *string
+	// Elasticsearch or OpenSearch cluster to index the docs in
+	SearchEndpoint *string
+	// User name of the search cluster
+	SearchUser *string
+	// Algolia application to send the docsearch records to
+	AlgoliaAppID *string
+	// Algolia index of the docsearch records
+	AlgoliaIndex *string
+	// Destination of the audit log of publishes
+	AuditLog *string
+	// Path to write the build summary to
+	SummaryPath *string
+	// Version of the module being documented
+	DocVersion *string
+	// Inject a navigation sidebar into every page
+	Sidebar *bool
+	// Directory of godoc template overrides
+	TemplatesDir *string
+	// Inject a table of contents and next/previous links into package pages
+	TOC *bool
+	// File of HTML to inject into the head of every page
+	InjectHeadFile *string
+	// Comma separated note markers to render and aggregate
+	NoteMarkers *string
+	// Group the examples of a symbol into tabs
+	ExampleTabs *bool
+	// Order examples are listed in, alpha or declared
+	ExampleOrder *string
+	// Add description and Open Graph tags to every page
+	MetaTags *bool
+	// Name of the site used in meta tags
+	SiteName *string
+	// Document unexported identifiers too
+	IncludeUnexported *bool
+	// Show type definitions when hovering types in signatures
+	TypePopovers *bool
+	// Add expand all / collapse all controls to package pages
+	ExpandControls *bool
+	// Show notes and deprecations as callouts and aggregate them into notes.html
+	NoteCallouts *bool
+	// Render aligned comment blocks as tables: off, auto or directive
+	CommentTables *string
+	// Write a doc-coverage badge into the build directory
+	CoverageBadge *bool
+	// HAR archive or directory of responses to scrape instead of a live server
+	Fixtures *string
+	// Archive to record the scrape session to
+	Record *string
+	// Archive of a recorded scrape session to replay
+	Replay *string
+	// Write the doc model into the build directory
+	DocModel *bool
+	// Render every example on a page of its own
+	ExamplePages *bool
+	// Link runnable example pages to the Go Playground
+	PlaygroundLinks *bool
+	// Comma separated output formats
+	Formats *string
+	// Run the testable examples and mark them as verified
+	VerifyExamples *bool
+	// List the implementations of interfaces on the package pages
+	Implementations *bool
+	// Name of the pinned theme to use as templates directory
+	Theme *string
+	// Path of the config file
+	Config *string
+	// Archive to package the build directory into
+	Archive *string
+	// Draw the import graph of the module's packages
+	Graph *bool
+	// Include packages outside the module in the import graph
+	GraphExternal *bool
+	// Go toolchain to analyze and render the module with
+	GoToolchain *string
+	// Write the changes since the previous build into the build directory
+	Delta *bool
+	// Comma separated audiences whose doc comment blocks are kept
+	Audience *string
+	// Write .gz and .br variants of the compressible files
+	Precompress *bool
+	// Comma separated editions whose doc comment blocks are kept
+	Edition *string
+	// Give stylesheets and scripts content-hashed names
+	AssetHashes *bool
+	// Registry of the symbols of modules linking to each other
+	SymbolRegistry *string
+	// Directory to write the implementers index of the registry's modules to
+	ImplementersIndex *string
+	// Fail the build on any scrape or rewrite problem
+	Strict *bool
+	// Write an archive index of the versions published to the targets
+	VersionArchive *bool
+	// Write a text-only variant of the site
+	TextOnly *bool
+	// Build the modules nested in the module too
+	Submodules *bool
+	// Import path of a project without go.mod
+	ModuleName *string
+	// Write per-package doc-quality metrics
+	Metrics *bool
+	// Directory of the module to document instead of the working directory's
+	ModuleRoot *string
+	// Don't draw a progress bar
+	NoProgress *bool
+	// Write the build stats
+	BuildStats *bool
+	// Path of the Prometheus textfile of the build stats
+	StatsTextfile *string
+	// Rate limit of the scrape
+	MaxRequestsPerSecond *float64
+	// Concurrency cap of the scrape
+	MaxConcurrentFetches *int
+	// Running doc server to scrape
+	RemoteServer *string
+	// Headers of the requests to the remote doc server
+	RemoteHeaders *headerFlag
+	// Basic auth user of the remote doc server
+	RemoteUser *string
+	// Standard library packages to document
+	IncludeStdlib *string
+	// Check the build references no file outside of it
+	VerifyOffline *bool
+	// Move inline scripts and styles to files and add a CSP meta tag
+	CSP *bool
+	// Strip comments and indentation from the pages
+	Minify *bool
+	// Sanitize the markup of doc comments and prose
+	Sanitize *bool
+	// Write anchors.json
+	Anchors *bool
+	// Write the Sphinx inventory
+	SphinxInventory *bool
+	// Front matter format of the hugo output
+	HugoFrontMatter *string
+	// Layout of the pages of the jekyll output
+	JekyllLayout *string
+	// Write a man page per symbol
+	ManPerSymbol *bool
 }
 
+// Output layouts.
+const (
+	// All pages are written into the build directory as numbered files.
+	layoutFlat = "flat"
+	// Pages are written to <package dir>/index.html, mirroring the import path.
+	layoutNested = "nested"
+)
+
 type Settings struct {
 	// $GOROOT value
 	GoRootPath string `json:"GOROOT"`
@@ -79,6 +306,237 @@ type Settings struct {
 	BuildDir string
 	// Base name to use for html files
 	HTMLBaseName string
+	// Output layout, flat or nested
+	Layout string
+	// URL the docs will be hosted at, used to rewrite absolute links
+	BaseURL string
+	// Documentation server to scrape, godoc or pkgsite
+	Backend string
+	// Install the backend binary if it is missing
+	AutoInstall bool
+	// Resolved path of the backend binary
+	BackendBinary string
+	// How long to wait for the doc server to come up
+	ServerTimeout time.Duration
+	// Bound on the whole run, 0 for none
+	Timeout time.Duration
+	// Initial interval between doc server readiness checks, doubled after every
+	// failed check
+	PollInterval time.Duration
+	// WebDAV collection to publish to
+	WebDAVURL string
+	// WebDAV user name
+	WebDAVUser string
+	// Remove published files which are no longer part of the build
+	DeleteExtraneous bool
+	// Artifactory / Nexus generic repository URL
+	ArtifactRepoURL string
+	// Artifact group, e.g. com.example.docs
+	ArtifactGroup string
+	// Artifact name, defaults to the last element of the module name
+	ArtifactName string
+	// Artifact version
+	ArtifactVersion string
+	// Artifact repository user name
+	ArtifactUser string
+	// Upload the unpacked site alongside the archive
+	ArtifactUnpacked bool
+	// Add the build directory to IPFS
+	IPFS bool
+	// Base URL of the Confluence instance to publish a page per package to
+	ConfluenceURL string
+	// Key of the Confluence space the pages are published to
+	ConfluenceSpace string
+	// Id of the Confluence page the package pages are created under
+	ConfluenceParent string
+	// Confluence user name, for Confluence Cloud
+	ConfluenceUser string
+	// Name of the index of the documents of the elasticsearch output, by default the
+	// last element of the module path
+	SearchIndex string
+	// URL of the Elasticsearch or OpenSearch cluster the elasticsearch output is sent
+	// to
+	SearchEndpoint string
+	// User name of the search cluster
+	SearchUser string
+	// Id of the Algolia application the docsearch output is sent to
+	AlgoliaAppID string
+	// Algolia index the docsearch records replace the records of
+	AlgoliaIndex string
+	// Destination of the audit log of publishes, as for the --audit-log of serve
+	AuditLog string
+	// Path to write the build summary to
+	SummaryPath string
+	// Version of the module being documented
+	DocVersion string
+	// Inject a navigation sidebar into every page
+	Sidebar bool
+	// Directory of godoc template overrides
+	TemplatesDir string
+	// Inject a table of contents and next/previous links into package pages
+	TOC bool
+	// File of HTML to inject into the head of every page
+	InjectHeadFile string
+	// Note markers to render and aggregate, like BUG
+	NoteMarkers []string
+	// Group the examples of a symbol into tabs
+	ExampleTabs bool
+	// Order examples are listed in, alpha or declared
+	ExampleOrder string
+	// Add description and Open Graph tags to every page
+	MetaTags bool
+	// Name of the site used in meta tags
+	SiteName string
+	// Document unexported identifiers too
+	IncludeUnexported bool
+	// Show type definitions when hovering types in signatures
+	TypePopovers bool
+	// Add expand all / collapse all controls to package pages
+	ExpandControls bool
+	// Show notes and deprecations as callouts and aggregate them into notes.html
+	NoteCallouts bool
+	// Render aligned comment blocks as tables: off, auto or directive
+	CommentTables string
+	// Write a doc-coverage badge into the build directory
+	CoverageBadge bool
+	// HAR archive or directory of responses to scrape instead of a live server
+	Fixtures string
+	// Archive to record the scrape session to
+	Record string
+	// Archive of a recorded scrape session to replay
+	Replay string
+	// Write the doc model into the build directory
+	DocModel bool
+	// Render every example on a page of its own
+	ExamplePages bool
+	// Link runnable example pages to the Go Playground
+	PlaygroundLinks bool
+	// Output formats, like html and markdown
+	Formats []string
+	// Run the testable examples and mark them as verified
+	VerifyExamples bool
+	// List the implementations of interfaces on the package pages
+	Implementations bool
+	// Draw the import graph of the module's packages
+	Graph bool
+	// Include packages outside the module in the import graph
+	GraphExternal bool
+	// Directory of markdown prose pages, from the config
+	ProseDir string
+	// File ordering the prose pages, from the config
+	NavFile string
+	// Package patterns left out of the docs, from the config's ignore file
+	IgnorePatterns []string
+	// Archive to package the build directory into, .tar.gz, .tgz or .zip
+	Archive string
+	// Shell commands run around the build, from the config
+	Hooks *ConfigHooks
+	// Go toolchain selected with --go-toolchain, empty for the one on PATH
+	GoToolchain string
+	// Page processors added to the pipeline, from the config
+	Processors []*ConfigProcessor
+	// Stages of the pipeline of the build, the built-in ones and Processors
+	ProcessorStages []*processorStage
+	// Write the changes since the previous build into the build directory
+	Delta bool
+	// Doc comment preprocessors, from the config
+	Preprocessors []*ConfigPreprocessor
+	// Audiences whose doc comment blocks the audience preprocessor keeps
+	Audiences []string
+	// Copy of the module with preprocessed doc comments the docs are rendered from,
+	// empty to render the module itself
+	DocSourcePath string
+	// Write .gz and .br variants next to the compressible files of the build
+	Precompress bool
+	// Editions whose //docmodule:if blocks of doc comments are kept
+	Editions []string
+	// Give stylesheets and scripts content-hashed names for long-lived caching
+	AssetHashes bool
+	// Registry recording the module's symbols and resolving references to the
+	// symbols of other modules, empty for none
+	SymbolRegistry string
+	// Directory to write the cross-module implementers index of the registry to,
+	// empty for none
+	ImplementersIndex string
+	// Fail on failed downloads, missing package pages and broken links
+	Strict bool
+	// Write versions.html, listing the versions published to a target with their
+	// API changes, before publishing to it
+	VersionArchive bool
+	// Write a text-only variant of every page, without scripts, stylesheets or media,
+	// and link the pages to it
+	TextOnly bool
+	// Build the modules nested in the module's directory tree into modules/ and list
+	// them on the index page
+	Submodules bool
+	// Temporary GOPATH a project without go.mod is documented from, empty for modules
+	TempGoPath string
+	// Write the per-package doc-quality metrics in the OpenMetrics format
+	Metrics bool
+	// Only log the time of each stage, without drawing a progress bar
+	NoProgress bool
+	// Progress of the build, reporting the time and pages of its stages
+	Progress *Progress
+	// Write build-stats.json, the size of the build and the time of its stages
+	BuildStats bool
+	// Path to write the build stats to in the Prometheus textfile format
+	StatsTextfile string
+	// Most requests per second the scrape sends to the doc server, 0 for no limit
+	MaxRequestsPerSecond float64
+	// Most requests the doc server handles at once during the scrape, 0 for no limit
+	MaxConcurrentFetches int
+	// URL of a running doc server to scrape instead of starting one
+	RemoteServer string
+	// Headers of the requests to the remote doc server, like "X-Team: docs"
+	RemoteHeaders []string
+	// Basic auth user of the remote doc server
+	RemoteUser string
+	// Standard library packages documented along with the module: "referenced",
+	// "all" or none if empty
+	IncludeStdlib string
+	// Standard library packages scraped along with the module, listed before the
+	// scrape
+	StdlibPackages []string
+	// Fail the build if a page or stylesheet references a file outside of the build
+	// directory, like the doc server or a CDN
+	VerifyOffline bool
+	// Move the inline scripts and styles of the pages to files and add the
+	// Content-Security-Policy they comply with
+	CSP bool
+	// Strip the comments and indentation of the pages, outside of preformatted text,
+	// scripts and styles
+	Minify bool
+	// Sanitize the paragraphs and preformatted blocks of the pages, where the content
+	// of doc comments and prose ends up, with an allowlist of elements and attributes
+	Sanitize bool
+	// Write anchors.json, mapping the symbols of the module to their page and anchor,
+	// and give constants and variables an anchor where the backend has none
+	Anchors bool
+	// Write objects.inv, the Sphinx inventory of the packages and symbols, for the
+	// :go: roles of a Sphinx project to link to the pages
+	SphinxInventory bool
+	// Front matter format of the pages of the hugo output, yaml or toml
+	HugoFrontMatter string
+	// Layout in the front matter of the pages of the jekyll output
+	JekyllLayout string
+	// Write a man page per symbol of the man output, besides the package pages
+	ManPerSymbol bool
+}
+
+// Reports whether the requests of the scrape to the doc server are throttled.
+func (settings *Settings) throttlesScrape() bool {
+	return settings.MaxRequestsPerSecond > 0 || settings.MaxConcurrentFetches > 0
+}
+
+// Reports whether importPath is a standard library package scraped along with the
+// module.
+func (settings *Settings) includesStdlibPackage(importPath string) bool {
+	for _, stdlibPath := range settings.StdlibPackages {
+		if stdlibPath == importPath {
+			return true
+		}
+	}
+	return false
 }
 
 // Path to root module page on godoc server.
@@ -89,10 +547,13 @@ func (settings *Settings) serverModulePath() string {
 // Regex for extracting module name from go.mod file
 var modNameRegex = regexp.MustCompile(`module\s+(?P<modName>\S+)`)
 
-// Extracts information we are interested in via the go env command
+// Extracts information we are interested in via the go env command, run in
+// settings.ModuleRootPath if it is already set and in the working directory otherwise
 func getEnvSettings(settings *Settings) {
 	// Run the command
-	envJsonBytes, err := exec.Command("go", "env", "-json").Output()
+	command := exec.Command("go", "env", "-json")
+	command.Dir = settings.ModuleRootPath
+	envJsonBytes, err := command.Output()
 	if err != nil {
 		log.Fatal(xerrors.Errorf("error inspecting go environment: %w", err))
 	}
@@ -108,15 +569,383 @@ func getEnvSettings(settings *Settings) {
 	settings.ServerHost = "localhost:6161"
 }
 
+// settingsErrors collects every problem found with the flags and config so they can
+// be reported together before any work begins, rather than one per run.
+type settingsErrors []string
+
+func (errs *settingsErrors) addf(format string, args ...interface{}) {
+	*errs = append(*errs, fmt.Sprintf(format, args...))
+}
+
+func (errs *settingsErrors) add(err error) {
+	*errs = append(*errs, err.Error())
+}
+
+// Exits listing the problems found, if any.
+func (errs *settingsErrors) fatal() {
+	if len(*errs) == 0 {
+		return
+	}
+	log.Fatalf("invalid settings:\n  - %v", strings.Join(*errs, "\n  - "))
+}
+
+// Checks that the doc server host is a host:port pair with a valid port.
+func validateServerHost(host string) error {
+	_, port, err := net.SplitHostPort(host)
+	if err != nil {
+		return xerrors.Errorf("invalid --godoc-host %q: %w", host, err)
+	}
+	if number, err := strconv.Atoi(port); err != nil || number < 1 || number > 65535 {
+		return xerrors.Errorf("invalid --godoc-host %q: bad port %q", host, port)
+	}
+	return nil
+}
+
+// Checks that the build directory, or else the closest of its parents which exists,
+// is a directory we can write to.
+func validateBuildDir(buildDir string) error {
+	if buildDir == "" {
+		return xerrors.New("--build-path must not be empty")
+	}
+	dir, err := filepath.Abs(buildDir)
+	if err != nil {
+		return xerrors.Errorf("error resolving build directory: %w", err)
+	}
+	for {
+		info, err := os.Stat(dir)
+		if err == nil {
+			if !info.IsDir() {
+				return xerrors.Errorf("build directory: '%v' is not a directory", dir)
+			}
+			break
+		}
+		if !os.IsNotExist(err) || filepath.Dir(dir) == dir {
+			return xerrors.Errorf("build directory: %w", err)
+		}
+		dir = filepath.Dir(dir)
+	}
+
+	probe, err := ioutil.TempFile(dir, ".docmodule-")
+	if err != nil {
+		return xerrors.Errorf("build directory '%v' is not writable: %w", buildDir, err)
+	}
+	_ = probe.Close()
+	return os.Remove(probe.Name())
+}
+
 func applyCliArgs(settings *Settings, args *CliArgs) {
+	errs := new(settingsErrors)
 
 	settings.BuildDir = *args.BuildDir
 	settings.ServerHost = *args.ServerHost
 	settings.HTMLBaseName = *args.HTMLBaseName
+	settings.Layout = *args.Layout
+	settings.BaseURL = *args.BaseURL
+	settings.Backend = *args.Backend
+	settings.AutoInstall = *args.AutoInstall
+	settings.ServerTimeout = *args.ServerTimeout
+	settings.Timeout = *args.Timeout
+	settings.PollInterval = *args.PollInterval
+	if settings.PollInterval <= 0 {
+		errs.addf("poll interval must be positive")
+	}
+	if _, ok := backends[settings.Backend]; !ok {
+		errs.addf("unknown backend %q, expected godoc or pkgsite", settings.Backend)
+	}
+	if settings.Layout != layoutFlat && settings.Layout != layoutNested {
+		errs.addf("unknown layout %q, expected flat or nested", settings.Layout)
+	}
+	settings.WebDAVURL = *args.WebDAVURL
+	settings.WebDAVUser = *args.WebDAVUser
+	settings.DeleteExtraneous = *args.DeleteExtraneous
+	settings.ArtifactRepoURL = *args.ArtifactRepoURL
+	settings.ArtifactGroup = *args.ArtifactGroup
+	settings.ArtifactName = *args.ArtifactName
+	settings.ArtifactVersion = *args.ArtifactVersion
+	settings.ArtifactUser = *args.ArtifactUser
+	settings.ArtifactUnpacked = *args.ArtifactUnpacked
+	settings.IPFS = *args.IPFS
+	settings.ConfluenceURL = *args.ConfluenceURL
+	settings.ConfluenceSpace = *args.ConfluenceSpace
+	settings.ConfluenceParent = *args.ConfluenceParent
+	settings.ConfluenceUser = *args.ConfluenceUser
+	settings.SearchIndex = *args.SearchIndex
+	settings.SearchEndpoint = *args.SearchEndpoint
+	settings.SearchUser = *args.SearchUser
+	settings.AlgoliaAppID = *args.AlgoliaAppID
+	settings.AlgoliaIndex = *args.AlgoliaIndex
+	settings.AuditLog = *args.AuditLog
+	settings.SummaryPath = *args.SummaryPath
+	settings.DocVersion = *args.DocVersion
+	settings.Sidebar = *args.Sidebar
+	settings.TOC = *args.TOC
+	settings.InjectHeadFile = *args.InjectHeadFile
+	settings.IncludeUnexported = *args.IncludeUnexported
+	if settings.IncludeUnexported && settings.Backend != "godoc" {
+		errs.addf("--include-unexported is only supported by the godoc backend")
+	}
+	settings.TypePopovers = *args.TypePopovers
+	if settings.TypePopovers && settings.Backend != "godoc" {
+		errs.addf("--type-popovers is only supported by the godoc backend")
+	}
+	settings.ExpandControls = *args.ExpandControls
+	settings.NoteCallouts = *args.NoteCallouts
+	settings.CommentTables = *args.CommentTables
+	settings.CoverageBadge = *args.CoverageBadge
+	settings.Delta = *args.Delta
+	settings.Precompress = *args.Precompress
+	settings.AssetHashes = *args.AssetHashes
+	settings.SymbolRegistry = *args.SymbolRegistry
+	if settings.SymbolRegistry != "" && settings.BaseURL == "" {
+		errs.addf("--symbol-registry requires --base-url, where other modules link to")
+	}
+	settings.ImplementersIndex = *args.ImplementersIndex
+	settings.Strict = *args.Strict
+	settings.VerifyOffline = *args.VerifyOffline
+	settings.CSP = *args.CSP
+	settings.Minify = *args.Minify
+	settings.Sanitize = *args.Sanitize
+	settings.Anchors = *args.Anchors
+	settings.SphinxInventory = *args.SphinxInventory
+	settings.HugoFrontMatter = *args.HugoFrontMatter
+	if settings.HugoFrontMatter != frontMatterYAML && settings.HugoFrontMatter != frontMatterTOML {
+		errs.addf(
+			"--hugo-front-matter must be %q or %q, got %q",
+			frontMatterYAML, frontMatterTOML, settings.HugoFrontMatter,
+		)
+	}
+	settings.JekyllLayout = *args.JekyllLayout
+	settings.ManPerSymbol = *args.ManPerSymbol
+	settings.IncludeStdlib = *args.IncludeStdlib
+	switch settings.IncludeStdlib {
+	case "", stdlibReferenced, stdlibAll:
+	default:
+		errs.addf(
+			"--include-stdlib must be %q or %q, got %q",
+			stdlibReferenced, stdlibAll, settings.IncludeStdlib,
+		)
+	}
+	if settings.IncludeStdlib != "" && settings.Backend != "godoc" {
+		errs.addf("--include-stdlib is only supported by the godoc backend")
+	}
+	settings.RemoteServer = *args.RemoteServer
+	settings.RemoteHeaders = *args.RemoteHeaders
+	settings.RemoteUser = *args.RemoteUser
+	if settings.RemoteServer != "" {
+		if _, err := parseRemoteServer(settings.RemoteServer); err != nil {
+			errs.add(err)
+		}
+		if settings.RemoteUser != "" && os.Getenv(remoteTokenEnv) != "" {
+			errs.addf("--remote-user and $%v are mutually exclusive", remoteTokenEnv)
+		}
+	} else if settings.RemoteUser != "" || len(settings.RemoteHeaders) > 0 {
+		errs.addf("--remote-user and --remote-header require --remote-server")
+	}
+	settings.MaxRequestsPerSecond = *args.MaxRequestsPerSecond
+	if settings.MaxRequestsPerSecond < 0 {
+		errs.addf("--max-requests-per-second must not be negative")
+	}
+	settings.MaxConcurrentFetches = *args.MaxConcurrentFetches
+	if settings.MaxConcurrentFetches < 0 {
+		errs.addf("--max-concurrent-fetches must not be negative")
+	}
+	settings.BuildStats = *args.BuildStats
+	settings.StatsTextfile = *args.StatsTextfile
+	settings.NoProgress = *args.NoProgress
+	settings.VersionArchive = *args.VersionArchive
+	settings.TextOnly = *args.TextOnly
+	settings.Submodules = *args.Submodules
+	settings.Metrics = *args.Metrics
+	if settings.ImplementersIndex != "" && settings.SymbolRegistry == "" {
+		errs.addf("--implementers-index requires --symbol-registry")
+	}
+	settings.Fixtures = *args.Fixtures
+	settings.DocModel = *args.DocModel
+	for _, format := range strings.Split(*args.Formats, ",") {
+		format = strings.TrimSpace(format)
+		if _, ok := modelFormats[format]; !ok && format != formatHTML {
+			errs.addf("unknown format %q, expected one of %v", format, formatNames())
+		}
+		settings.Formats = append(settings.Formats, format)
+	}
+	if settings.DocModel && !settings.hasFormat(formatJSON) {
+		settings.Formats = append(settings.Formats, formatJSON)
+	}
+	if settings.SearchEndpoint != "" && !settings.hasFormat(formatElasticsearch) {
+		settings.Formats = append(settings.Formats, formatElasticsearch)
+	}
+	if settings.AlgoliaAppID != "" && !settings.hasFormat(formatDocSearch) {
+		settings.Formats = append(settings.Formats, formatDocSearch)
+	}
+	settings.ExamplePages = *args.ExamplePages
+	settings.PlaygroundLinks = *args.PlaygroundLinks
+	if settings.PlaygroundLinks && !settings.ExamplePages {
+		errs.addf("--playground-links requires --example-pages")
+	}
+	settings.Record = *args.Record
+	settings.Replay = *args.Replay
+	if (settings.Fixtures != "" || settings.Replay != "") && settings.IncludeUnexported {
+		errs.addf("--include-unexported has no effect on --fixtures or --replay")
+	}
+	if settings.Fixtures != "" && settings.Replay != "" {
+		errs.addf("--fixtures and --replay are mutually exclusive")
+	}
+	if settings.RemoteServer != "" && (settings.Fixtures != "" || settings.Replay != "") {
+		errs.addf("--remote-server is a live doc server, not --fixtures or --replay")
+	}
+	if settings.Record != "" && (settings.Fixtures != "" || settings.Replay != "") {
+		errs.addf("--record needs a live doc server, not --fixtures or --replay")
+	}
+	switch settings.CommentTables {
+	case commentTablesOff, commentTablesAuto, commentTablesDirective:
+	default:
+		errs.addf(
+			"unknown comment tables mode %q, expected off, auto or directive",
+			settings.CommentTables,
+		)
+	}
+	settings.MetaTags = *args.MetaTags
+	settings.SiteName = *args.SiteName
+	settings.ExampleTabs = *args.ExampleTabs
+	settings.ExampleOrder = *args.ExampleOrder
+	if settings.ExampleOrder != exampleOrderAlpha && settings.ExampleOrder != exampleOrderDeclared {
+		errs.addf("unknown example order %q, expected alpha or declared", settings.ExampleOrder)
+	}
+	if (settings.ExampleTabs || settings.ExampleOrder != exampleOrderAlpha) &&
+		settings.Backend != "godoc" {
+		errs.addf("--example-tabs and --example-order are only supported by the godoc backend")
+	}
+	settings.VerifyExamples = *args.VerifyExamples
+	if settings.VerifyExamples && settings.Backend != "godoc" {
+		errs.addf("--verify-examples is only supported by the godoc backend")
+	}
+	settings.Archive = *args.Archive
+	if settings.Archive != "" {
+		if !isArchivePath(settings.Archive) {
+			errs.addf("--archive must end in .tar.gz, .tgz or .zip, got %q", settings.Archive)
+		}
+		archivePath, err := filepath.Abs(settings.Archive)
+		if err != nil {
+			errs.add(xerrors.Errorf("error resolving archive path: %w", err))
+		}
+		buildDir, err := filepath.Abs(settings.BuildDir)
+		if err != nil {
+			errs.add(xerrors.Errorf("error resolving build directory: %w", err))
+		}
+		if strings.HasPrefix(archivePath, buildDir+string(filepath.Separator)) {
+			errs.addf("--archive must be outside the build directory")
+		}
+		settings.Archive = archivePath
+	}
+	settings.Graph = *args.Graph
+	settings.GraphExternal = *args.GraphExternal
+	if settings.GraphExternal && !settings.Graph {
+		errs.addf("--graph-external requires --graph")
+	}
+	settings.Implementations = *args.Implementations
+	if settings.Implementations && settings.Backend != "godoc" {
+		errs.addf("--implementations is only supported by the godoc backend")
+	}
+	if *args.NoteMarkers != "" {
+		if settings.Backend != "godoc" {
+			errs.addf("--notes is only supported by the godoc backend")
+		}
+		for _, marker := range strings.Split(*args.NoteMarkers, ",") {
+			marker = strings.TrimSpace(marker)
+			if !noteMarkerRegex.MatchString(marker) {
+				errs.addf("invalid note marker %q, expected two or more capitals", marker)
+			}
+			settings.NoteMarkers = append(settings.NoteMarkers, marker)
+		}
+	}
+	if settings.InjectHeadFile != "" {
+		if _, err := os.Stat(settings.InjectHeadFile); err != nil {
+			errs.add(xerrors.Errorf("error reading head snippet: %w", err))
+		}
+	}
+	settings.TemplatesDir = *args.TemplatesDir
+	if *args.Theme != "" {
+		if settings.TemplatesDir != "" {
+			errs.addf("--theme and --templates cannot be used together")
+		} else if themeDir, err := resolveTheme(settings.ModuleRootPath, *args.Theme); err != nil {
+			errs.add(err)
+		} else {
+			settings.TemplatesDir = themeDir
+		}
+	}
+	if settings.TemplatesDir != "" {
+		if settings.Backend != "godoc" {
+			errs.addf("--templates is only supported by the godoc backend")
+		}
+		templatesDir, err := filepath.Abs(settings.TemplatesDir)
+		if err != nil {
+			errs.add(xerrors.Errorf("error resolving templates directory: %w", err))
+		} else if err := validateTemplatesDir(templatesDir); err != nil {
+			errs.add(err)
+		}
+		settings.TemplatesDir = templatesDir
+	}
+
+	if stages, err := buildProcessorStages(settings.Processors); err != nil {
+		errs.add(err)
+	} else {
+		settings.ProcessorStages = stages
+	}
+	if *args.Audience != "" {
+		for _, audience := range strings.Split(*args.Audience, ",") {
+			settings.Audiences = append(settings.Audiences, strings.TrimSpace(audience))
+		}
+	}
+	if *args.Edition != "" {
+		for _, edition := range strings.Split(*args.Edition, ",") {
+			settings.Editions = append(settings.Editions, strings.TrimSpace(edition))
+		}
+	}
+	if _, err := preprocessorChain(settings); err != nil {
+		errs.add(err)
+	}
+	settings.GoToolchain = *args.GoToolchain
+	if settings.GoToolchain != "" {
+		if root, err := resolveGoToolchain(settings.GoToolchain, settings.GoModPath); err != nil {
+			errs.add(err)
+		} else {
+			useGoToolchain(settings, root)
+		}
+	}
+
+	if settings.ModName == "" {
+		errs.addf("no module name, run docmodule from within a Go module")
+	}
+	if err := validateServerHost(settings.ServerHost); err != nil {
+		errs.add(err)
+	}
+	if err := validateBuildDir(settings.BuildDir); err != nil {
+		errs.add(err)
+	}
+	if settings.WebDAVUser != "" && settings.WebDAVURL == "" {
+		errs.addf("--webdav-user requires --webdav-url")
+	}
+	if settings.ArtifactUnpacked && settings.ArtifactRepoURL == "" {
+		errs.addf("--artifact-unpacked requires --artifact-repo")
+	}
+	if settings.ConfluenceURL != "" &&
+		(settings.ConfluenceSpace == "" || settings.ConfluenceParent == "") {
+		errs.addf("--confluence-url requires --confluence-space and --confluence-parent")
+	}
+	if settings.SearchUser != "" && settings.SearchEndpoint == "" {
+		errs.addf("--search-user requires --search-endpoint")
+	}
+	if settings.AlgoliaAppID != "" && (settings.AlgoliaIndex == "" || settings.BaseURL == "") {
+		errs.addf("--algolia-app-id requires --algolia-index and --base-url")
+	}
+	errs.fatal()
 }
 
 // Gets the package name from go mod
 func getGoModName(settings *Settings) {
+	if settings.GoModPath == "" || settings.GoModPath == os.DevNull {
+		log.Fatal("go.mod not found, run docmodule from within a Go module")
+	}
 	goModContent, err := ioutil.ReadFile(settings.GoModPath)
 	if err != nil {
 		log.Fatal("error reading go mod")
@@ -131,35 +960,602 @@ func getGoModName(settings *Settings) {
 	settings.ModName = string(match[1])
 }
 
-func parseCmdArgs() *CliArgs {
+// Registers the flags of the build command on flags.
+func registerBuildFlags(flags *flag.FlagSet) *CliArgs {
 	cliArgs := new(CliArgs)
-	cliArgs.BuildDir = flag.String(
-		"--build-path",
+	cliArgs.BuildDir = flags.String(
+		"build-path",
 		"zdocs/source/_static",
 
 		"path to place extracted html files",
 	)
-	cliArgs.ServerHost = flag.String(
-		"--godoc-host",
+	cliArgs.ServerHost = flags.String(
+		"godoc-host",
 		"localhost:6161",
 		"Host and port to use for temporarily running godoc server.",
 	)
-	cliArgs.HTMLBaseName = flag.String(
-		"--html-file-name",
+	cliArgs.HTMLBaseName = flags.String(
+		"html-file-name",
 		"godoc",
 		"Base name to use for extracted html files.",
 	)
+	cliArgs.Backend = flags.String(
+		"backend",
+		"godoc",
+		"Documentation server to scrape: 'godoc' or 'pkgsite'.",
+	)
+	cliArgs.ServerTimeout = flags.Duration(
+		"server-timeout",
+		10*time.Second,
+		"How long to wait for the doc server to start. Large GOPATHs take longer "+
+			"to index.",
+	)
+	cliArgs.Timeout = flags.Duration(
+		"timeout",
+		0,
+		"Bound on the whole build, e.g. 10m. The doc server is shut down when it is "+
+			"exceeded. 0 means no limit.",
+	)
+	cliArgs.PollInterval = flags.Duration(
+		"poll-interval",
+		time.Second,
+		"Initial interval between doc server readiness checks. Backs off "+
+			"exponentially.",
+	)
+	cliArgs.AutoInstall = flags.Bool(
+		"auto-install",
+		false,
+		"Install the backend server binary into the tool cache if it is missing.",
+	)
+	cliArgs.BaseURL = flags.String(
+		"base-url",
+		"",
+		"URL the docs will be hosted at, e.g. https://example.com/docs/go/. "+
+			"Absolute links are rewritten to work under its path.",
+	)
+	cliArgs.Layout = flags.String(
+		"layout",
+		layoutFlat,
+		"Output layout. 'flat' writes numbered html files into the build directory, "+
+			"'nested' writes pkg/sub/index.html mirroring the import path.",
+	)
+	cliArgs.WebDAVURL = flags.String(
+		"webdav-url",
+		"",
+		"WebDAV collection URL to publish the build directory to.",
+	)
+	cliArgs.WebDAVUser = flags.String(
+		"webdav-user",
+		"",
+		"WebDAV user name. The password is read from $"+webDAVPasswordEnv+".",
+	)
+	cliArgs.DeleteExtraneous = flags.Bool(
+		"delete-extraneous",
+		false,
+		"Remove files from publish targets which are no longer part of the build.",
+	)
+	cliArgs.ArtifactRepoURL = flags.String(
+		"artifact-repo",
+		"",
+		"Artifactory / Nexus generic repository URL to upload the docs archive to.",
+	)
+	cliArgs.ArtifactGroup = flags.String(
+		"artifact-group",
+		"",
+		"Group coordinate of the docs artifact, e.g. com.example.docs.",
+	)
+	cliArgs.ArtifactName = flags.String(
+		"artifact-name",
+		"",
+		"Name coordinate of the docs artifact. Defaults to the module base name.",
+	)
+	cliArgs.ArtifactVersion = flags.String(
+		"artifact-version",
+		"",
+		"Version coordinate of the docs artifact.",
+	)
+	cliArgs.ArtifactUser = flags.String(
+		"artifact-user",
+		"",
+		"Artifact repository user name. The password is read from $"+
+			artifactPasswordEnv+".",
+	)
+	cliArgs.ArtifactUnpacked = flags.Bool(
+		"artifact-unpacked",
+		false,
+		"Also upload the unpacked site next to the docs archive.",
+	)
+	cliArgs.IPFS = flags.Bool(
+		"ipfs",
+		false,
+		"EXPERIMENTAL: add the build directory to IPFS with the local ipfs CLI.",
+	)
+	cliArgs.ConfluenceURL = flags.String(
+		"confluence-url",
+		"",
+		"Base URL of a Confluence instance, e.g. https://example.atlassian.net/wiki, to "+
+			"create or update a page per package in.",
+	)
+	cliArgs.ConfluenceSpace = flags.String(
+		"confluence-space",
+		"",
+		"Key of the Confluence space to publish the package pages to.",
+	)
+	cliArgs.ConfluenceParent = flags.String(
+		"confluence-parent",
+		"",
+		"Id of the Confluence page to create the package pages under.",
+	)
+	cliArgs.ConfluenceUser = flags.String(
+		"confluence-user",
+		"",
+		"Confluence user name. The API token, or the personal access token when no user "+
+			"is set, is read from $"+confluenceTokenEnv+".",
+	)
+	cliArgs.SearchIndex = flags.String(
+		"search-index",
+		"",
+		"Index of the documents of the elasticsearch format. Defaults to the module base "+
+			"name in lower case.",
+	)
+	cliArgs.SearchEndpoint = flags.String(
+		"search-endpoint",
+		"",
+		"URL of an Elasticsearch or OpenSearch cluster to send the elasticsearch format "+
+			"to with the _bulk API. Adds elasticsearch to --formats.",
+	)
+	cliArgs.SearchUser = flags.String(
+		"search-user",
+		"",
+		"User name of the search cluster. The password, or the API key when no user is "+
+			"set, is read from $"+searchTokenEnv+".",
+	)
+	cliArgs.AlgoliaAppID = flags.String(
+		"algolia-app-id",
+		"",
+		"Id of an Algolia application to replace the records of --algolia-index in with "+
+			"the docsearch format. The API key is read from $"+algoliaAPIKeyEnv+". Adds "+
+			"docsearch to --formats.",
+	)
+	cliArgs.AlgoliaIndex = flags.String(
+		"algolia-index",
+		"",
+		"Algolia DocSearch index to replace the records of.",
+	)
+	cliArgs.AuditLog = flags.String(
+		"audit-log",
+		"",
+		"Write a JSON audit log of publishes to a file, '-' for stderr or 'syslog'.",
+	)
+	cliArgs.DocVersion = flags.String(
+		"doc-version",
+		"",
+		"Version of the module being documented. Defaults to 'git describe --tags'.",
+	)
+	cliArgs.SummaryPath = flags.String(
+		"summary-file",
+		"",
+		"Path to write a JSON summary of the build to.",
+	)
+	cliArgs.Sidebar = flags.Bool(
+		"sidebar",
+		false,
+		"Inject a sidebar with the package tree and the page's symbols into every page.",
+	)
+	cliArgs.TOC = flags.Bool(
+		"toc",
+		false,
+		"Inject a table of contents and next/previous package links into package pages.",
+	)
+	cliArgs.InjectHeadFile = flags.String(
+		"inject-head-file",
+		"",
+		"File of HTML, like analytics tags or font links, to add to the head of every page.",
+	)
+	cliArgs.NoteMarkers = flags.String(
+		"notes",
+		"",
+		"Comma separated note markers, like BUG,SECURITY, to render. "+
+			"Each marker's notes are collected into a notes-<marker>.html page.",
+	)
+	cliArgs.ExampleTabs = flags.Bool(
+		"example-tabs",
+		false,
+		"Group the examples of each symbol into tabs.",
+	)
+	cliArgs.ExampleOrder = flags.String(
+		"example-order",
+		exampleOrderAlpha,
+		"Order to list examples in: 'alpha' or 'declared', the order of the test files.",
+	)
+	cliArgs.MetaTags = flags.Bool(
+		"meta-tags",
+		false,
+		"Add description, Open Graph and Twitter card tags to every page. "+
+			"Pages get an og:url when --base-url is absolute.",
+	)
+	cliArgs.SiteName = flags.String(
+		"site-name",
+		"",
+		"Site name used in meta tags. Defaults to the module name.",
+	)
+	cliArgs.IncludeUnexported = flags.Bool(
+		"include-unexported",
+		false,
+		"Also document unexported identifiers, like godoc's m=all mode. "+
+			"By default only exported identifiers are documented.",
+	)
+	cliArgs.TypePopovers = flags.Bool(
+		"type-popovers",
+		false,
+		"Show a type's definition when hovering it in a signature.",
+	)
+	cliArgs.ExpandControls = flags.Bool(
+		"expand-controls",
+		false,
+		"Add controls expanding or collapsing all examples and sections of a page. "+
+			"The reader's choice is remembered across pages.",
+	)
+	cliArgs.NoteCallouts = flags.Bool(
+		"note-callouts",
+		false,
+		"Show notes and 'Deprecated:' paragraphs as callout boxes and collect them, "+
+			"across the module, into notes.html.",
+	)
+	cliArgs.CommentTables = flags.String(
+		"comment-tables",
+		commentTablesOff,
+		"Render aligned column layouts in doc comments as tables: 'off', 'auto' for "+
+			"every aligned block, or 'directive' for blocks whose first line is "+
+			"'docmodule:table'. A first line of 'docmodule:no-table' opts a block out.",
+	)
+	cliArgs.CoverageBadge = flags.Bool(
+		"coverage-badge",
+		false,
+		"Write a badge of the share of documented exported identifiers to "+
+			coverageBadgeName+".",
+	)
+	cliArgs.Fixtures = flags.String(
+		"fixtures",
+		"",
+		"Scrape a HAR archive or a directory of saved responses, laid out by url path, "+
+			"instead of running the doc server.",
+	)
+	cliArgs.Record = flags.String(
+		"record",
+		"",
+		"Record the pages scraped from the doc server to a tar archive for --replay.",
+	)
+	cliArgs.Replay = flags.String(
+		"replay",
+		"",
+		"Scrape a session recorded with --record instead of running the doc server.",
+	)
+	cliArgs.DocModel = flags.Bool(
+		"model",
+		false,
+		"Write the extracted doc model to "+docModelName+" for commands like "+
+			"'docmodule diff' to reuse. Same as adding json to --formats.",
+	)
+	cliArgs.Formats = flags.String(
+		"formats",
+		formatHTML,
+		"Comma separated output formats: html, markdown, json, pdf, epub, sqlite, "+
+			"elasticsearch, docsearch, hugo, docusaurus, jekyll or man. Formats other than "+
+			"html are rendered concurrently from one extraction of the docs.",
+	)
+	cliArgs.VerifyExamples = flags.Bool(
+		"verify-examples",
+		false,
+		"Run the module's testable examples, badge each example as verified or "+
+			"unverified, and fail the build if an example's output no longer matches.",
+	)
+	cliArgs.Implementations = flags.Bool(
+		"implementations",
+		false,
+		"List the module's types implementing each interface, and the interfaces "+
+			"each type implements, on the package pages.",
+	)
+	cliArgs.Archive = flags.String(
+		"archive",
+		"",
+		"Package the finished build directory into this .tar.gz, .tgz or .zip file, "+
+			"with stable file order and timestamps, e.g. for release assets.",
+	)
+	cliArgs.Config = flags.String(
+		"config",
+		"",
+		"Config file holding flag values and the prose, nav and ignore files. "+
+			"Defaults to "+configFileName+" in the module root, if it exists.",
+	)
+	cliArgs.Theme = flags.String(
+		"theme",
+		"",
+		"Name of a theme pinned in "+themesConfigName+" with 'docmodule theme add' "+
+			"to use as --templates. It is installed at its pinned version if needed.",
+	)
+	cliArgs.Graph = flags.Bool(
+		"graph",
+		false,
+		"Write "+graphPageName+", drawing the import graph of the module's packages, "+
+			"and link it from the index page.",
+	)
+	cliArgs.GraphExternal = flags.Bool(
+		"graph-external",
+		false,
+		"Include the packages outside the module, except the standard library, in "+
+			"the import graph.",
+	)
+	cliArgs.Delta = flags.Bool(
+		"delta",
+		false,
+		"Write "+deltaName+", listing the pages and symbols added, removed and "+
+			"modified since the previous build in the build directory, for search "+
+			"indexes and CDNs to update incrementally.",
+	)
+	cliArgs.Precompress = flags.Bool(
+		"precompress",
+		false,
+		"Write .gz and, with the brotli command on PATH, .br variants next to every "+
+			"HTML, CSS, JS and other text file of the build, for hosts and CDNs "+
+			"serving precompressed files.",
+	)
+	cliArgs.SymbolRegistry = flags.String(
+		"symbol-registry",
+		"",
+		"JSON registry shared by the builds of several modules: the build records "+
+			"its packages and symbols there and links references like "+
+			"[example.com/other/pkg.Type] to the other modules' docs. Requires --base-url.",
+	)
+	cliArgs.ManPerSymbol = flags.Bool(
+		"man-per-symbol",
+		false,
+		"Write a man page per symbol with the man format, referred to by the package pages.",
+	)
+	cliArgs.JekyllLayout = flags.String(
+		"jekyll-layout",
+		"default",
+		"Layout of the pages of the jekyll format, set in their front matter.",
+	)
+	cliArgs.HugoFrontMatter = flags.String(
+		"hugo-front-matter",
+		frontMatterYAML,
+		"Front matter format of the pages of the hugo format: yaml or toml.",
+	)
+	cliArgs.SphinxInventory = flags.Bool(
+		"sphinx-inventory",
+		false,
+		"Write "+sphinxInventoryName+", the Sphinx inventory of the packages and "+
+			"symbols in the '"+sphinxDomain+"' domain, so roles like :go:func:`mypkg.Foo` "+
+			"of a Sphinx project listing the docs in intersphinx_mapping link to them.",
+	)
+	cliArgs.Anchors = flags.Bool(
+		"anchors",
+		false,
+		"Write "+anchorIndexName+", mapping every exported symbol, like "+
+			"pkg.Type.Method, to its page and anchor, for other docs to deep link to.",
+	)
+	cliArgs.Sanitize = flags.Bool(
+		"sanitize",
+		false,
+		"Sanitize the markup of doc comments and prose with an allowlist of elements, "+
+			"attributes and url schemes, removing scripts and event handlers and closing "+
+			"unclosed tags.",
+	)
+	cliArgs.CSP = flags.Bool(
+		"csp",
+		false,
+		"Move the inline scripts and styles of the pages to files and add a "+
+			"Content-Security-Policy meta tag allowing only the files of the site, for "+
+			"hosting on sites with a strict policy. The policy is logged to set as a header.",
+	)
+	cliArgs.Minify = flags.Bool(
+		"minify",
+		false,
+		"Strip the comments and indentation of the pages, leaving preformatted text, "+
+			"scripts and styles alone.",
+	)
+	cliArgs.VerifyOffline = flags.Bool(
+		"verify-offline",
+		false,
+		"Fail the build if a page or stylesheet references anything outside of the "+
+			"build directory, like the doc server or the internet, listing the references.",
+	)
+	cliArgs.IncludeStdlib = flags.String(
+		"include-stdlib",
+		"",
+		"Also document the standard library packages imported by the module, with "+
+			"'referenced', or the whole standard library, with 'all', linking the "+
+			"module's pages to them locally for offline docs. Requires the godoc backend.",
+	)
+	cliArgs.RemoteServer = flags.String(
+		"remote-server",
+		"",
+		"URL of an already running godoc or pkgsite server to scrape, like "+
+			"https://godoc.internal.example.com, instead of starting one. It is scraped "+
+			"through a proxy on --godoc-host. A bearer token is read from $"+
+			remoteTokenEnv+".",
+	)
+	cliArgs.RemoteHeaders = new(headerFlag)
+	flags.Var(
+		cliArgs.RemoteHeaders,
+		"remote-header",
+		"Header added to the requests to --remote-server, like 'X-Team: docs'. May be "+
+			"given more than once.",
+	)
+	cliArgs.RemoteUser = flags.String(
+		"remote-user",
+		"",
+		"Basic auth user of --remote-server. The password is read from $"+
+			remotePasswordEnv+".",
+	)
+	cliArgs.MaxRequestsPerSecond = flags.Float64(
+		"max-requests-per-second",
+		0,
+		"Most requests per second the scrape sends to the doc server, e.g. 20 or 0.5, "+
+			"so documenting huge modules doesn't saturate it. 0 means no limit.",
+	)
+	cliArgs.MaxConcurrentFetches = flags.Int(
+		"max-concurrent-fetches",
+		0,
+		"Most requests the doc server handles at once during the scrape; the rest "+
+			"wait their turn. 0 means no limit.",
+	)
+	cliArgs.BuildStats = flags.Bool(
+		"build-stats",
+		false,
+		"Write "+buildStatsName+", the packages, symbols, pages and bytes of the build "+
+			"and the time each stage took, to track the performance of doc builds.",
+	)
+	cliArgs.StatsTextfile = flags.String(
+		"stats-textfile",
+		"",
+		"Also write the build stats to this path in the Prometheus text format, like "+
+			"a .prom file in the directory of node_exporter's textfile collector.",
+	)
+	cliArgs.NoProgress = flags.Bool(
+		"no-progress",
+		false,
+		"Don't draw the progress bar of the pages and bytes of the running stage, only "+
+			"log the time each stage took. The bar is only drawn when stderr is a "+
+			"terminal, so CI logs never get it.",
+	)
+	cliArgs.ModuleRoot = flags.String(
+		"module-root",
+		".",
+		"Directory of the module to document. go env and go list run there instead "+
+			"of the working directory, relative paths like --build-path stay relative "+
+			"to the working directory.",
+	)
+	cliArgs.Metrics = flags.Bool(
+		"metrics",
+		false,
+		"Write "+docMetricsName+", the doc coverage, examples, page size and broken "+
+			"links of every package in the OpenMetrics format, for dashboards trending "+
+			"documentation quality.",
+	)
+	cliArgs.ModuleName = flags.String(
+		"module-name",
+		"",
+		"Import path of a project without go.mod, like a plain directory of Go files. "+
+			"Packages in GOPATH/src default to the import path of their directory.",
+	)
+	cliArgs.Submodules = flags.Bool(
+		"submodules",
+		false,
+		"Also build the modules nested in the module's directory tree, which go list "+
+			"and the doc server leave out, into "+submodulesDir+"/<dir>/, and list them "+
+			"on the index page.",
+	)
+	cliArgs.TextOnly = flags.Bool(
+		"text-only",
+		false,
+		"Write a low-bandwidth variant of every page under "+textSiteDir+"/, without "+
+			"scripts, stylesheets or media, and link each page to its variant.",
+	)
+	cliArgs.VersionArchive = flags.Bool(
+		"version-archive",
+		false,
+		"Write "+versionArchiveName+" to the build before publishing it to a webdav, "+
+			"s3 or sftp target, listing every version published there with its date "+
+			"and API changes, from the history of the target's manifest.",
+	)
+	cliArgs.Strict = flags.Bool(
+		"strict",
+		false,
+		"Fail the build if wget fails to download any page, a package of the module "+
+			"has no page or a page links to a missing page, listing the problems by "+
+			"page. Meant for CI.",
+	)
+	cliArgs.ImplementersIndex = flags.String(
+		"implementers-index",
+		"",
+		"Directory to write pages listing, for the interfaces of every module of "+
+			"the --symbol-registry, the types of the other modules implementing them.",
+	)
+	cliArgs.AssetHashes = flags.Bool(
+		"asset-hashes",
+		true,
+		"Rename stylesheets and scripts to content-hashed names, like "+
+			"style.3fa9c2e1.css, and point the pages to them, so hosts can cache "+
+			"them for good. Disable with -asset-hashes=false.",
+	)
+	cliArgs.Edition = flags.String(
+		"edition",
+		"",
+		"Comma separated editions, like enterprise, to document. Doc comment lines "+
+			"between '//docmodule:if <edition>' and '//docmodule:endif' are kept only "+
+			"for the editions given, those after '//docmodule:else' only for others.",
+	)
+	cliArgs.Audience = flags.String(
+		"audience",
+		"",
+		"Comma separated audiences, like internal,partner, whose '@audience' blocks "+
+			"the audience preprocessor of the config keeps in doc comments. Other "+
+			"blocks are left out.",
+	)
+	cliArgs.GoToolchain = flags.String(
+		"go-toolchain",
+		"",
+		"Go toolchain to analyze and render the module with instead of the go on "+
+			"PATH: 'mod' for the toolchain or go directive of go.mod, a version like "+
+			"go1.20.14 installed with golang.org/dl, or the path of a Go SDK.",
+	)
+	cliArgs.ExamplePages = flags.Bool(
+		"example-pages",
+		false,
+		"Render every example on a page of its own under "+examplePagesDir+"/.",
+	)
+	cliArgs.PlaygroundLinks = flags.Bool(
+		"playground-links",
+		false,
+		"Share runnable examples on the Go Playground and link them from their page.",
+	)
 
-	flag.Parse()
+	cliArgs.TemplatesDir = flags.String(
+		"templates",
+		"",
+		"Directory of template overrides passed to godoc's -templates flag. Its "+
+			pageTemplatesDir+" subdirectory overrides the pages docmodule generates "+
+			"itself; see 'docmodule template-funcs' for the functions they may call.",
+	)
+
+	return cliArgs
+}
 
+func parseCmdArgs() *CliArgs {
+	cliArgs := registerBuildFlags(flag.CommandLine)
+	flag.Usage = printBuildUsage
+	// The command line exits on errors itself.
+	_ = flag.CommandLine.Parse(expandShortFlags(flag.CommandLine, os.Args[1:]))
+	if err := applyEnvFlags(flag.CommandLine); err != nil {
+		log.Fatal(err)
+	}
 	return cliArgs
 }
 
 func setupRunInfo() *RunInfo {
 	cliArgs := parseCmdArgs()
 	runInfo := NewRunInfo()
+	projectDir, err := filepath.Abs(*cliArgs.ModuleRoot)
+	if err != nil {
+		log.Fatal(xerrors.Errorf("error resolving --module-root: %w", err))
+	}
+	if info, err := os.Stat(projectDir); err != nil || !info.IsDir() {
+		log.Fatalf("--module-root %q is not a directory", *cliArgs.ModuleRoot)
+	}
+	runInfo.Settings.ModuleRootPath = projectDir
 	getEnvSettings(runInfo.Settings)
-	getGoModName(runInfo.Settings)
+	if runInfo.Settings.GoModPath == "" || runInfo.Settings.GoModPath == os.DevNull {
+		setupGopathProject(runInfo.Settings, projectDir, *cliArgs.ModuleName, *cliArgs.BuildDir)
+	} else if *cliArgs.ModuleName != "" {
+		log.Fatal("--module-name is only used for projects without go.mod")
+	} else {
+		getGoModName(runInfo.Settings)
+	}
+	if err := loadConfig(runInfo.Settings, flag.CommandLine, *cliArgs.Config); err != nil {
+		log.Fatal(err)
+	}
 	applyCliArgs(runInfo.Settings, cliArgs)
 	return runInfo
 }
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"html"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Name of the stylesheet holding the styles of the pages' injected navigation.
+const navStylesheetName = "docmodule.css"
+
+const navStylesheet = `.docmodule-sidebar {
+  position: fixed; top: 0; left: 0; bottom: 0; width: 16rem;
+  overflow-y: auto; padding: 1rem; box-sizing: border-box;
+  border-right: 1px solid #ddd; background: #f8f8f8; font-size: 0.875rem;
+}
+.docmodule-sidebar ul { list-style: none; margin: 0; padding-left: 0.75rem; }
+.docmodule-sidebar > ul { padding-left: 0; }
+.docmodule-sidebar h3 { margin: 1rem 0 0.5rem; font-size: 0.875rem; }
+.docmodule-sidebar .current > a { font-weight: bold; }
+body.docmodule-has-sidebar { margin-left: 16rem; }
+.docmodule-toc { margin: 1rem 0; padding: 0.5rem 1rem; border: 1px solid #ddd; }
+.docmodule-toc ul { margin: 0; padding-left: 1rem; }
+.docmodule-toc .level-3 { margin-left: 1rem; }
+.docmodule-pager { display: flex; justify-content: space-between; margin: 2rem 0; }
+.docmodule-tab-buttons { display: none; gap: 0.25rem; margin-bottom: 0.5rem; }
+.docmodule-tabs-ready > .docmodule-tab-buttons { display: flex; }
+.docmodule-tab-buttons button { border: 1px solid #ddd; background: #f8f8f8; cursor: pointer; }
+.docmodule-tab-buttons button.active { background: #fff; font-weight: bold; }
+.docmodule-tabs-ready > .docmodule-tab-panel { display: none; }
+.docmodule-tabs-ready > .docmodule-tab-panel.active { display: block; }
+.docmodule-popover {
+  position: absolute; z-index: 100; max-width: 40rem; max-height: 20rem; overflow: auto;
+  padding: 0.5rem; border: 1px solid #ccc; background: #fff;
+  box-shadow: 0 2px 6px rgba(0, 0, 0, 0.15); font-size: 0.875rem;
+}
+.docmodule-popover pre { margin: 0; }
+.docmodule-popover p { margin: 0.5rem 0 0; }
+.docmodule-callout {
+  margin: 1rem 0; padding: 0.5rem 1rem; border-left: 4px solid #3b82f6; background: #eff6ff;
+}
+.docmodule-callout-bug { border-color: #dc2626; background: #fef2f2; }
+.docmodule-callout-deprecated { border-color: #d97706; background: #fffbeb; }
+.docmodule-callout h2, .docmodule-callout h3 { margin-top: 0; }
+.docmodule-comment-table { border-collapse: collapse; margin: 1rem 0; }
+.docmodule-comment-table th, .docmodule-comment-table td {
+  padding: 0.25rem 0.75rem; border: 1px solid #ddd; text-align: left; vertical-align: top;
+}
+.docmodule-example-actions { display: flex; gap: 1rem; align-items: center; }
+.docmodule-example-badge {
+  margin-left: 0.5rem; padding: 0 0.375rem; border-radius: 0.25rem; font-size: 0.75rem;
+}
+.docmodule-example-verified { background: #dcfce7; color: #166534; }
+.docmodule-example-unverified { background: #f3f4f6; color: #4b5563; }
+.docmodule-implements h4 { margin: 0.5rem 0 0.25rem; }
+.docmodule-implements ul { margin: 0; }
+.docmodule-expand-controls { display: flex; gap: 0.5rem; justify-content: flex-end; }
+.docmodule-notes { list-style: none; padding: 0; }
+@media (max-width: 50rem) {
+  .docmodule-sidebar { position: static; width: auto; border-right: none; }
+  body.docmodule-has-sidebar { margin-left: 0; }
+}
+`
+
+// Name of the script driving the injected navigation, like example tabs.
+const navScriptName = "docmodule.js"
+
+const navScript = `document.querySelectorAll(".docmodule-copy").forEach(function (button) {
+  button.addEventListener("click", function () {
+    var target = document.getElementById(button.getAttribute("data-copy"));
+    if (target) {
+      navigator.clipboard.writeText(target.textContent);
+    }
+  });
+});
+
+document.querySelectorAll(".docmodule-example-tabs").forEach(function (tabs) {
+  var buttons = tabs.querySelectorAll(":scope > .docmodule-tab-buttons > button");
+  var panels = tabs.querySelectorAll(":scope > .docmodule-tab-panel");
+  function select(index) {
+    for (var i = 0; i < panels.length; i++) {
+      buttons[i].classList.toggle("active", i === index);
+      panels[i].classList.toggle("active", i === index);
+    }
+  }
+  buttons.forEach(function (button, index) {
+    button.addEventListener("click", function () { select(index); });
+  });
+  var selected = 0;
+  panels.forEach(function (panel, index) {
+    if (location.hash && panel.querySelector(location.hash.replace(/[^#\w-]/g, "\\$&"))) {
+      selected = index;
+    }
+  });
+  tabs.classList.add("docmodule-tabs-ready");
+  select(selected);
+});
+
+(function () {
+  var sidecars = {};
+  var popover = null;
+  var hovered = null;
+  function hide() {
+    hovered = null;
+    if (popover) {
+      popover.remove();
+      popover = null;
+    }
+  }
+  function sidecar(link) {
+    var url = new URL(link.getAttribute("href"), location.href);
+    var page = url.pathname.replace(/\/$/, "/index.html").replace(/\.html$/, "");
+    var sidecarURL = page + ".symbols.json";
+    if (!sidecars[sidecarURL]) {
+      sidecars[sidecarURL] = fetch(sidecarURL).then(function (response) {
+        return response.ok ? response.json() : {};
+      }).catch(function () { return {}; });
+    }
+    return sidecars[sidecarURL].then(function (symbols) {
+      return symbols[decodeURIComponent(url.hash.slice(1))];
+    });
+  }
+  document.querySelectorAll("pre a[href*='#']").forEach(function (link) {
+    link.addEventListener("mouseenter", function () {
+      hovered = link;
+      sidecar(link).then(function (symbol) {
+        if (!symbol || hovered !== link) {
+          return;
+        }
+        popover = document.createElement("div");
+        popover.className = "docmodule-popover";
+        var decl = document.createElement("pre");
+        decl.textContent = symbol.decl;
+        popover.appendChild(decl);
+        if (symbol.synopsis) {
+          var synopsis = document.createElement("p");
+          synopsis.textContent = symbol.synopsis;
+          popover.appendChild(synopsis);
+        }
+        var rect = link.getBoundingClientRect();
+        popover.style.left = rect.left + window.scrollX + "px";
+        popover.style.top = rect.bottom + window.scrollY + 4 + "px";
+        document.body.appendChild(popover);
+      });
+    });
+    link.addEventListener("mouseleave", hide);
+  });
+})();
+(function () {
+  var key = "docmodule-expand";
+  var buttons = document.querySelectorAll(".docmodule-expand-controls button");
+  if (!buttons.length) {
+    return;
+  }
+  function apply(state) {
+    var expand = state === "all";
+    document.querySelectorAll(".toggle, .toggleVisible").forEach(function (element) {
+      element.classList.toggle("toggle", !expand);
+      element.classList.toggle("toggleVisible", expand);
+    });
+    document.querySelectorAll("details").forEach(function (element) {
+      element.open = expand;
+    });
+  }
+  buttons.forEach(function (button) {
+    button.addEventListener("click", function () {
+      try {
+        localStorage.setItem(key, button.dataset.expand);
+      } catch (e) {}
+      apply(button.dataset.expand);
+    });
+  });
+  var stored = null;
+  try {
+    stored = localStorage.getItem(key);
+  } catch (e) {}
+  if (stored) {
+    apply(stored);
+  }
+})();
+`
+
+// Node of the package tree shown in the sidebar.
+type navNode struct {
+	Name     string
+	Page     string
+	Children map[string]*navNode
+}
+
+// Builds the module's package tree from the renamed pages.
+func buildNavTree(runInfo *RunInfo) *navNode {
+	settings := runInfo.Settings
+	root := &navNode{Name: path.Base(settings.ModName), Children: map[string]*navNode{}}
+
+	for _, info := range runInfo.DocFileInfo {
+		if !strings.HasPrefix(info.ImportPath, settings.ModName) {
+			continue
+		}
+		relPath := strings.TrimPrefix(info.ImportPath, settings.ModName)
+		relPath = strings.TrimPrefix(relPath, "/")
+
+		node := root
+		if relPath != "" {
+			for _, part := range strings.Split(relPath, "/") {
+				child, ok := node.Children[part]
+				if !ok {
+					child = &navNode{Name: part, Children: map[string]*navNode{}}
+					node.Children[part] = child
+				}
+				node = child
+			}
+		}
+		node.Page = info.NewRelPath
+	}
+
+	return root
+}
+
+// Renders node and its children as nested lists with links relative to fromDir.
+func renderNavTree(node *navNode, fromDir string, currentPage string) string {
+	class := ""
+	if node.Page != "" && node.Page == currentPage {
+		class = ` class="current"`
+	}
+
+	label := html.EscapeString(node.Name)
+	if node.Page != "" {
+		label = `<a href="` + html.EscapeString(relativeLink(fromDir, node.Page)) + `">` +
+			label + `</a>`
+	}
+
+	rendered := "<li" + class + ">" + label
+	if len(node.Children) > 0 {
+		names := make([]string, 0, len(node.Children))
+		for name := range node.Children {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		rendered += "<ul>"
+		for _, name := range names {
+			rendered += renderNavTree(node.Children[name], fromDir, currentPage)
+		}
+		rendered += "</ul>"
+	}
+	return rendered + "</li>"
+}
+
+// Regex for the headings godoc gives an id to, i.e. the page's symbols and sections.
+var symbolHeadingRegex = regexp.MustCompile(`(?s)<h([23]) id="([^"]+)"[^>]*>(.*?)</h[23]>`)
+
+// A heading of a page, used for outlines and tables of contents.
+type pageHeading struct {
+	Level int
+	ID    string
+	Text  string
+}
+
+// Returns the headings of a page in document order. The permalink marker godoc adds
+// to each heading is dropped from its text.
+func pageHeadings(data []byte) []*pageHeading {
+	headings := make([]*pageHeading, 0)
+	for _, match := range symbolHeadingRegex.FindAllSubmatch(data, -1) {
+		level := 2
+		if string(match[1]) == "3" {
+			level = 3
+		}
+		text := strings.TrimSpace(strings.TrimSuffix(htmlText(match[3]), "¶"))
+		headings = append(headings, &pageHeading{
+			Level: level,
+			ID:    string(match[2]),
+			Text:  html.UnescapeString(text),
+		})
+	}
+	return headings
+}
+
+// Renders the outline of a page's symbols. godoc's own sections have ids starting
+// with "pkg-" and are left out.
+func renderSymbolOutline(data []byte) string {
+	rendered := ""
+	for _, heading := range pageHeadings(data) {
+		if strings.HasPrefix(heading.ID, "pkg-") {
+			continue
+		}
+		rendered += `<li><a href="#` + html.EscapeString(heading.ID) + `">` +
+			html.EscapeString(heading.Text) + "</a></li>"
+	}
+	if rendered == "" {
+		return ""
+	}
+	return "<h3>On this page</h3><ul>" + rendered + "</ul>"
+}
+
+// Writes the stylesheet of the injected navigation into the build directory.
+func writeNavStylesheet(settings *Settings) {
+	stylesheetPath := settings.BuildDir + "/" + navStylesheetName
+	if exists, _ := fileExists(stylesheetPath); exists {
+		return
+	}
+	err := ioutil.WriteFile(stylesheetPath, []byte(navStylesheet), os.ModePerm)
+	if err != nil {
+		log.Panicf("error writing navigation stylesheet: %v", err)
+	}
+}
+
+// Writes the script of the injected navigation into the build directory.
+func writeNavScript(settings *Settings) {
+	scriptPath := settings.BuildDir + "/" + navScriptName
+	if exists, _ := fileExists(scriptPath); exists {
+		return
+	}
+	err := ioutil.WriteFile(scriptPath, []byte(navScript), os.ModePerm)
+	if err != nil {
+		log.Panicf("error writing navigation script: %v", err)
+	}
+}
+
+// Adds the navigation script to the end of a page, unless it already has it.
+func linkNavScript(data []byte, fromDir string) []byte {
+	link := relativeLink(fromDir, navScriptName)
+	if bytes.Contains(data, []byte(`src="`+link+`"`)) {
+		return data
+	}
+	return injectIntoBody(data, []byte(`<script src="`+link+`"></script>`))
+}
+
+// Returns the path of a page relative to the build directory, slash-separated.
+func pageRelPath(settings *Settings, filePath string) string {
+	relPath, err := filepath.Rel(settings.BuildDir, filePath)
+	if err != nil {
+		log.Panicf("error resolving '%v': %v", filePath, err)
+	}
+	return filepath.ToSlash(relPath)
+}
+
+// Adds a link to the navigation stylesheet to a page, unless it already has one.
+func linkNavStylesheet(data []byte, fromDir string) []byte {
+	link := relativeLink(fromDir, navStylesheetName)
+	if bytes.Contains(data, []byte(`href="`+link+`"`)) {
+		return data
+	}
+	stylesheet := `<link type="text/css" rel="stylesheet" href="` + link + `">`
+	return injectIntoHead(data, []byte(stylesheet))
+}
+
+// Returns the processor injecting a sidebar with the module's package tree and the
+// page's symbol outline into every generated page.
+func newSidebarProcessor(ctx context.Context, runInfo *RunInfo) Processor {
+	settings := runInfo.Settings
+	if !settings.Sidebar {
+		return nil
+	}
+
+	writeNavStylesheet(settings)
+	tree := buildNavTree(runInfo)
+
+	return ProcessorFunc(func(page *Page) error {
+		fromDir := path.Dir(page.RelPath)
+
+		sidebar := `<nav class="docmodule-sidebar"><h3>Packages</h3><ul>` +
+			renderNavTree(tree, fromDir, page.RelPath) + "</ul>" +
+			renderSymbolOutline(page.Data) + "</nav>"
+
+		data := linkNavStylesheet(page.Data, fromDir)
+		data = bytes.Replace(data, []byte("<body>"), []byte(`<body class="docmodule-has-sidebar">`), 1)
+		page.Data = injectIntoBody(data, []byte(sidebar))
+		return nil
+	})
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"golang.org/x/xerrors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Name of the Sphinx inventory written to the root of the build directory, the name
+// intersphinx fetches from the url of a project.
+const sphinxInventoryName = "objects.inv"
+
+// Domain of the objects of the inventory, whose roles, like :go:func:, resolve to
+// the pages of the build.
+const sphinxDomain = "go"
+
+// Kinds of symbols listed in the inventory, which are also the roles of their
+// objects, besides "package".
+var sphinxRoles = wordSet("func method type const var")
+
+// An object of the inventory.
+type sphinxObject struct {
+	Name string
+	Role string
+	// Page and anchor of the object, relative to the build directory.
+	URI string
+}
+
+// Returns the objects of the inventory: every package, by import path, and every
+// symbol, by importPath.Name. Symbols are also listed by packageName.Name, like
+// mypkg.Foo, when no other package of the module has the same name.
+func sphinxObjects(index *AnchorIndex) []*sphinxObject {
+	packagesByName := make(map[string]int)
+	for _, pkg := range index.Packages {
+		packagesByName[pkg.Name]++
+	}
+
+	objects := make([]*sphinxObject, 0, len(index.Packages)+2*len(index.Symbols))
+	for importPath, pkg := range index.Packages {
+		objects = append(objects, &sphinxObject{Name: importPath, Role: "package", URI: pkg.Page})
+	}
+	for key, symbol := range index.Symbols {
+		if !sphinxRoles[symbol.Kind] {
+			continue
+		}
+		pkg := index.Packages[symbol.Package]
+		uri := pkg.Page + "#" + symbol.Fragment
+		objects = append(objects, &sphinxObject{Name: key, Role: symbol.Kind, URI: uri})
+		if packagesByName[pkg.Name] == 1 {
+			objects = append(objects, &sphinxObject{
+				Name: pkg.Name + "." + symbol.Name, Role: symbol.Kind, URI: uri,
+			})
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		if objects[i].Name != objects[j].Name {
+			return objects[i].Name < objects[j].Name
+		}
+		return objects[i].Role < objects[j].Role
+	})
+	return objects
+}
+
+// Renders the objects in the version 2 inventory format of Sphinx: a plain text
+// header naming the project, then zlib compressed lines of
+// "name domain:role priority uri display-name", where "-" displays the name.
+func renderSphinxInventory(project string, version string, objects []*sphinxObject) ([]byte, error) {
+	output := new(bytes.Buffer)
+	fmt.Fprintf(output, "# Sphinx inventory version 2\n")
+	fmt.Fprintf(output, "# Project: %v\n", project)
+	fmt.Fprintf(output, "# Version: %v\n", version)
+	fmt.Fprintf(output, "# The remainder of this file is compressed using zlib.\n")
+
+	compressor := zlib.NewWriter(output)
+	for _, object := range objects {
+		// Names can't hold spaces, but no Go import path or identifier does.
+		line := fmt.Sprintf(
+			"%v %v:%v 1 %v -\n", object.Name, sphinxDomain, object.Role, object.URI,
+		)
+		if _, err := compressor.Write([]byte(line)); err != nil {
+			return nil, xerrors.Errorf("error compressing inventory: %w", err)
+		}
+	}
+	if err := compressor.Close(); err != nil {
+		return nil, xerrors.Errorf("error compressing inventory: %w", err)
+	}
+	return output.Bytes(), nil
+}
+
+// Writes objects.inv, the inventory of the packages and symbols of the module in the
+// format of Sphinx, if --sphinx-inventory is set. A Sphinx project lists the url of
+// the docs in intersphinx_mapping, and a go domain resolves roles like
+// :go:func:`mypkg.Foo` or :go:type:`example.com/mod/mypkg.Bar` through it.
+func writeSphinxInventory(runInfo *RunInfo) {
+	settings := runInfo.Settings
+	if !settings.SphinxInventory {
+		return
+	}
+	index, err := buildAnchorIndex(runInfo)
+	if err != nil {
+		log.Panic(err)
+	}
+	objects := sphinxObjects(index)
+	data, err := renderSphinxInventory(settings.ModName, detectDocVersion(settings), objects)
+	if err != nil {
+		log.Panic(err)
+	}
+	inventoryPath := filepath.Join(settings.BuildDir, sphinxInventoryName)
+	if err := ioutil.WriteFile(inventoryPath, data, os.ModePerm); err != nil {
+		log.Panicf("error writing sphinx inventory: %v", err)
+	}
+	log.Printf("wrote %v objects to the sphinx inventory %v.", len(objects), sphinxInventoryName)
+}
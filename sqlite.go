@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"golang.org/x/xerrors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Name of the SQLite bundle written to the root of the build directory.
+const sqliteBundleName = "docs.sqlite"
+
+// Schema of the SQLite bundle. search is an FTS5 index of the doc text of the
+// packages and symbols, by key: the import path of a package or importPath.Name of a
+// symbol.
+const sqliteSchema = `CREATE TABLE module (path TEXT NOT NULL, version TEXT);
+CREATE TABLE packages (
+  import_path TEXT PRIMARY KEY,
+  name TEXT NOT NULL,
+  synopsis TEXT,
+  doc TEXT,
+  page TEXT
+);
+CREATE TABLE symbols (
+  key TEXT PRIMARY KEY,
+  import_path TEXT NOT NULL REFERENCES packages (import_path),
+  name TEXT NOT NULL,
+  kind TEXT NOT NULL,
+  signature TEXT NOT NULL,
+  doc TEXT,
+  file TEXT,
+  line INTEGER,
+  anchor TEXT,
+  link TEXT
+);
+CREATE INDEX symbols_import_path ON symbols (import_path);
+CREATE TABLE links (
+  source TEXT NOT NULL,
+  target TEXT NOT NULL,
+  PRIMARY KEY (source, target)
+);
+CREATE INDEX links_target ON links (target);
+CREATE VIRTUAL TABLE search USING fts5 (key UNINDEXED, kind UNINDEXED, name, doc);
+`
+
+// Regex for the doc links of doc comments, like [Type], [Type.Method], [pkg.Func] or
+// [example.com/pkg.Func].
+var docLinkRegex = regexp.MustCompile(`\[\*?((?:[\w.\-~]+/)*[A-Za-z_]\w*(?:\.[A-Za-z_]\w*){0,2})\]`)
+
+// Returns the keys of the symbols and packages the doc links of a doc comment of the
+// package at importPath refer to. References to packages by name are resolved
+// against the module's packages.
+func docLinkTargets(
+	doc string, importPath string, packagesByName map[string]string, known map[string]bool,
+) []string {
+	targets := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, match := range docLinkRegex.FindAllStringSubmatch(doc, -1) {
+		ref := match[1]
+		candidates := []string{importPath + "." + ref}
+		if strings.Contains(ref, "/") {
+			candidates = []string{ref}
+		} else if parts := strings.SplitN(ref, ".", 2); len(parts) == 2 {
+			if pkg, ok := packagesByName[parts[0]]; ok {
+				candidates = append(candidates, pkg+"."+parts[1])
+			}
+		} else if pkg, ok := packagesByName[ref]; ok {
+			candidates = append(candidates, pkg)
+		}
+		for _, candidate := range candidates {
+			if known[candidate] && !seen[candidate] {
+				seen[candidate] = true
+				targets = append(targets, candidate)
+				break
+			}
+		}
+	}
+	return targets
+}
+
+// Quotes a value as an SQL string literal, or NULL if it is empty.
+func sqlString(value string) string {
+	if value == "" {
+		return "NULL"
+	}
+	return "'" + strings.Replace(value, "'", "''", -1) + "'"
+}
+
+// Writes an SQL insert of values into table.
+func writeSQLInsert(script *bytes.Buffer, table string, values ...string) {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = sqlString(value)
+	}
+	script.WriteString("INSERT INTO " + table + " VALUES (" + strings.Join(quoted, ", ") + ");\n")
+}
+
+// Returns the SQL script creating the bundle of the model. pages maps the import
+// paths of the packages to their pages in the build, if it has any, and anchors the
+// keys of the symbols of those pages to the ids of the elements documenting them.
+func sqliteScript(model *DocModel, pages map[string]string, anchors map[string]string) []byte {
+	packagesByName := make(map[string]string)
+	known := make(map[string]bool)
+	for _, pkg := range model.Packages {
+		packagesByName[pkg.Name] = pkg.ImportPath
+		known[pkg.ImportPath] = true
+		for _, symbol := range pkg.Symbols {
+			known[symbol.key()] = true
+		}
+	}
+
+	script := new(bytes.Buffer)
+	script.WriteString("BEGIN;\n")
+	script.WriteString(sqliteSchema)
+	writeSQLInsert(script, "module", model.Module, model.Version)
+
+	links := make(map[string][]string)
+	for _, pkg := range model.Packages {
+		writeSQLInsert(
+			script, "packages", pkg.ImportPath, pkg.Name, pkg.Synopsis, pkg.Doc, pages[pkg.ImportPath],
+		)
+		writeSQLInsert(script, "search", pkg.ImportPath, "package", pkg.Name, pkg.Doc)
+		links[pkg.ImportPath] = docLinkTargets(pkg.Doc, pkg.ImportPath, packagesByName, known)
+
+		for _, symbol := range pkg.Symbols {
+			key := symbol.key()
+			line, link := "", resolveSymbolLink(pages, key)
+			if symbol.Line > 0 {
+				line = strconv.Itoa(symbol.Line)
+			}
+			if anchor, ok := anchors[key]; ok {
+				link = pages[pkg.ImportPath] + "#" + anchor
+			}
+			writeSQLInsert(
+				script, "symbols", key, pkg.ImportPath, symbol.Name, symbol.Kind, symbol.Signature,
+				symbol.Doc, symbol.File, line, anchors[key], link,
+			)
+			writeSQLInsert(script, "search", key, symbol.Kind, symbol.Name, symbol.Doc)
+			links[key] = docLinkTargets(symbol.Doc, pkg.ImportPath, packagesByName, known)
+		}
+	}
+
+	sources := make([]string, 0, len(links))
+	for source := range links {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	for _, source := range sources {
+		for _, target := range links[source] {
+			writeSQLInsert(script, "links", source, target)
+		}
+	}
+	script.WriteString("COMMIT;\n")
+	return script.Bytes()
+}
+
+// SQLiteFormat writes the packages, symbols, doc text and doc links of the module into
+// a single SQLite database with a full-text index, for tools to query. Symbols record
+// where they are declared and, when the build has an HTML site, their anchor on the
+// page of their package. It runs the sqlite3 shell, which must be on PATH and have
+// FTS5.
+type SQLiteFormat struct{}
+
+func (format *SQLiteFormat) Name() string {
+	return formatSQLite
+}
+
+func (format *SQLiteFormat) Generate(
+	ctx context.Context, settings *Settings, model *DocModel,
+) error {
+	sqlite, err := exec.LookPath("sqlite3")
+	if err != nil {
+		return xerrors.New("sqlite output needs sqlite3 on PATH")
+	}
+
+	pages, anchors, err := modelPageAnchors(settings, model)
+	if err != nil {
+		return err
+	}
+
+	destPath := filepath.Join(settings.BuildDir, sqliteBundleName)
+	if err := os.Remove(destPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	command := exec.CommandContext(ctx, sqlite, "-bail", destPath)
+	command.Stdin = bytes.NewReader(sqliteScript(model, pages, anchors))
+	if output, err := command.CombinedOutput(); err != nil {
+		return xerrors.Errorf("error running sqlite3: %w, output: %v", err, string(output))
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Name of the build stats written to the root of the build directory.
+const buildStatsName = "build-stats.json"
+
+// BuildStats describes the size of a build and how long its stages took, for
+// tracking the performance of doc builds over time.
+type BuildStats struct {
+	Module  string    `json:"module"`
+	Version string    `json:"version"`
+	BuiltAt time.Time `json:"builtAt"`
+	// Packages and exported symbols of the module
+	Packages int `json:"packages"`
+	Symbols  int `json:"symbols"`
+	// HTML pages of the build
+	Pages int `json:"pages"`
+	// Size of the files in the build directory
+	BytesWritten int64 `json:"bytesWritten"`
+	// Stages of the build up to the stats, in the order they ran
+	Stages []*StageTiming `json:"stages"`
+}
+
+// Returns the total size of the files in dir.
+func directorySize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// Renders the stats in the Prometheus text format read by the textfile collector of
+// node_exporter, labelled with the module.
+func renderStatsTextfile(stats *BuildStats) []byte {
+	output := new(strings.Builder)
+	module := metricLabelEscaper.Replace(stats.Module)
+	gauge := func(name string, help string, value interface{}) {
+		fmt.Fprintf(output, "# HELP %v %v\n", name, help)
+		fmt.Fprintf(output, "# TYPE %v gauge\n", name)
+		fmt.Fprintf(output, "%v{module=\"%v\"} %v\n", name, module, value)
+	}
+	gauge("docmodule_build_packages", "Packages of the module.", stats.Packages)
+	gauge("docmodule_build_symbols", "Exported symbols of the module.", stats.Symbols)
+	gauge("docmodule_build_pages", "HTML pages of the build.", stats.Pages)
+	gauge("docmodule_build_bytes_written", "Size of the build directory.", stats.BytesWritten)
+	gauge(
+		"docmodule_build_timestamp_seconds", "Time the build finished.",
+		fmt.Sprintf("%.3f", float64(stats.BuiltAt.UnixNano())/float64(time.Second)),
+	)
+
+	name := "docmodule_build_stage_duration_seconds"
+	fmt.Fprintf(output, "# HELP %v Time taken by a stage of the build.\n", name)
+	fmt.Fprintf(output, "# TYPE %v gauge\n", name)
+	for _, stage := range stats.Stages {
+		fmt.Fprintf(
+			output, "%v{module=\"%v\",stage=\"%v\"} %v\n",
+			name, module, metricLabelEscaper.Replace(stage.Name), stage.Seconds,
+		)
+	}
+	return []byte(output.String())
+}
+
+// Writes build-stats.json into the build directory and the Prometheus textfile of
+// the stats, as enabled. Stages which run later, like publishing, are left out.
+func writeBuildStats(runInfo *RunInfo) {
+	settings := runInfo.Settings
+	if !settings.BuildStats && settings.StatsTextfile == "" {
+		return
+	}
+
+	stats := &BuildStats{
+		Module:  settings.ModName,
+		Version: detectDocVersion(settings),
+		BuiltAt: time.Now().UTC(),
+		Pages:   len(runInfo.HtmlFiles),
+		Stages:  settings.Progress.Stages(),
+	}
+	model, err := loadBuildDocModel(settings)
+	if err != nil {
+		log.Panicf("error loading doc model: %v", err)
+	}
+	stats.Packages = len(model.Packages)
+	for _, pkg := range model.Packages {
+		stats.Symbols += len(pkg.Symbols)
+	}
+	if stats.BytesWritten, err = directorySize(settings.BuildDir); err != nil {
+		log.Panicf("error measuring build directory: %v", err)
+	}
+
+	if settings.BuildStats {
+		data, err := json.MarshalIndent(stats, "", "  ")
+		if err != nil {
+			log.Panicf("error encoding build stats: %v", err)
+		}
+		statsPath := filepath.Join(settings.BuildDir, buildStatsName)
+		if err := ioutil.WriteFile(statsPath, data, os.ModePerm); err != nil {
+			log.Panicf("error writing build stats: %v", err)
+		}
+	}
+
+	if settings.StatsTextfile != "" {
+		// The collector may read the file at any time, so it is replaced at once.
+		tempPath := settings.StatsTextfile + ".tmp"
+		if err := ioutil.WriteFile(tempPath, renderStatsTextfile(stats), 0644); err != nil {
+			log.Panicf("error writing stats textfile: %v", err)
+		}
+		if err := os.Rename(tempPath, settings.StatsTextfile); err != nil {
+			log.Panicf("error writing stats textfile: %v", err)
+		}
+	}
+}
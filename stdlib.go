@@ -0,0 +1,118 @@
+package main
+
+import (
+	"golang.org/x/xerrors"
+	"log"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Values of --include-stdlib.
+const (
+	// The standard library packages imported by the module's packages.
+	stdlibReferenced = "referenced"
+	// The whole standard library.
+	stdlibAll = "all"
+)
+
+// Runs go list in the module with args, returning the lines it printed.
+func goListLines(settings *Settings, args ...string) ([]string, error) {
+	command := exec.Command("go", append([]string{"list", "-e"}, args...)...)
+	command.Dir = settings.docSourceRoot()
+	output, err := command.Output()
+	if err != nil {
+		return nil, xerrors.Errorf("error listing packages: %w", err)
+	}
+	return strings.Fields(string(output)), nil
+}
+
+// Reports whether a standard library package is documented: internal and vendored
+// packages can't be imported by the module, so no page links to them.
+func documentedStdlibPackage(importPath string) bool {
+	elements := strings.Split(importPath, "/")
+	if elements[0] == "vendor" || importPath == "C" {
+		return false
+	}
+	for _, element := range elements {
+		if element == "internal" {
+			return false
+		}
+	}
+	return true
+}
+
+// Lists the standard library packages --include-stdlib adds to the build, sorted.
+func listStdlibPackages(settings *Settings) ([]string, error) {
+	var packages []string
+	if settings.IncludeStdlib == stdlibAll {
+		all, err := goListLines(settings, "std")
+		if err != nil {
+			return nil, err
+		}
+		packages = all
+	} else {
+		imports, err := goListLines(settings, "-f", `{{join .Imports "\n"}}`, "./...")
+		if err != nil {
+			return nil, err
+		}
+		if len(imports) == 0 {
+			return nil, nil
+		}
+		args := append([]string{"-f", "{{if .Standard}}{{.ImportPath}}{{end}}"}, imports...)
+		if packages, err = goListLines(settings, args...); err != nil {
+			return nil, err
+		}
+	}
+
+	documented := make(map[string]bool)
+	for _, importPath := range packages {
+		if documentedStdlibPackage(importPath) {
+			documented[importPath] = true
+		}
+	}
+	sorted := make([]string, 0, len(documented))
+	for importPath := range documented {
+		sorted = append(sorted, importPath)
+	}
+	sort.Strings(sorted)
+	return sorted, nil
+}
+
+// Lists the standard library packages scraped along with the module into
+// settings.StdlibPackages, if --include-stdlib is set.
+func resolveStdlibPackages(settings *Settings) {
+	if settings.IncludeStdlib == "" {
+		return
+	}
+	packages, err := listStdlibPackages(settings)
+	if err != nil {
+		log.Panic(err)
+	}
+	settings.StdlibPackages = packages
+	log.Printf("including %v standard library packages.", len(packages))
+}
+
+// Returns the alternative of the accept regex of wget matching the pages of the
+// standard library packages to scrape, but not the ones of their sub directories.
+func stdlibAcceptRegex(settings *Settings) string {
+	if len(settings.StdlibPackages) == 0 {
+		return ""
+	}
+	quoted := make([]string, len(settings.StdlibPackages))
+	for i, importPath := range settings.StdlibPackages {
+		quoted[i] = regexp.QuoteMeta(importPath)
+	}
+	return `|/pkg/(` + strings.Join(quoted, "|") + `)/$`
+}
+
+// Returns the urls of the standard library pages wget starts crawling from besides
+// the module's, as the module's pages are crawled without ascending to /pkg/.
+func stdlibPageURLs(settings *Settings) []string {
+	urls := make([]string, len(settings.StdlibPackages))
+	for i, importPath := range settings.StdlibPackages {
+		urls[i] = settings.ServerHost + "/pkg/" + importPath + "/"
+	}
+	return urls
+}
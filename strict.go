@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// BuildProblem is something a --strict build fails on, like a page wget could not
+// download.
+type BuildProblem struct {
+	// Page, url or package the problem is about.
+	Page    string `json:"page"`
+	Message string `json:"message"`
+}
+
+func (summary *BuildSummary) AddProblem(page string, message string) {
+	summary.Problems = append(summary.Problems, &BuildProblem{Page: page, Message: message})
+}
+
+// Regexes for the lines of wget's output naming the url being downloaded and the
+// error it got.
+var (
+	wgetURLRegex   = regexp.MustCompile(`^--\d{4}-\d\d-\d\d \d\d:\d\d:\d\d--\s+(\S+)`)
+	wgetErrorRegex = regexp.MustCompile(`ERROR (\d+: .*?)\.?$`)
+)
+
+// Returns the downloads of wget's output which failed, and why.
+func wgetProblems(output string) []*BuildProblem {
+	problems := make([]*BuildProblem, 0)
+	url := ""
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if match := wgetURLRegex.FindStringSubmatch(line); match != nil {
+			url = match[1]
+			continue
+		}
+		if match := wgetErrorRegex.FindStringSubmatch(line); match != nil {
+			problems = append(problems, &BuildProblem{Page: url, Message: "wget: " + match[1]})
+		}
+	}
+	return problems
+}
+
+// Compares the package pages of the build against `go list ./...`, reporting the
+// packages not left out by the ignore file which have no page, e.g. because the
+// crawl didn't reach them. They are problems of --strict builds.
+func checkPackagePages(runInfo *RunInfo) {
+	settings := runInfo.Settings
+	command := exec.Command(
+		"go", "list", "-e", "-f", "{{if or .GoFiles .CgoFiles}}{{.ImportPath}}{{end}}", "./...",
+	)
+	command.Dir = settings.docSourceRoot()
+	output, err := command.Output()
+	if err != nil {
+		log.Printf("error listing packages, skipping the check for missing pages: %v", err)
+		return
+	}
+
+	pages := packagePages(runInfo)
+	for _, importPath := range strings.Fields(string(output)) {
+		if _, ok := pages[importPath]; ok || settings.ignored(importPath) {
+			continue
+		}
+		runInfo.Summary.MissingPackages = append(runInfo.Summary.MissingPackages, importPath)
+		if settings.Strict {
+			runInfo.Summary.AddProblem(importPath, "no package page was scraped")
+		}
+	}
+	if missing := runInfo.Summary.MissingPackages; len(missing) > 0 {
+		log.Printf(
+			"%v packages have no page, check the crawl and the backend's accepted paths: %v",
+			len(missing), strings.Join(missing, ", "),
+		)
+	}
+}
+
+// Returns the links of a page to local HTML files missing from the build, left
+// behind by a failed rewrite.
+func brokenPageLinks(filePath string) []string {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panicf("error opening file '%v': %v", filePath, err)
+	}
+	broken := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, match := range localLinkRegex.FindAllSubmatch(data, -1) {
+		link := string(match[2])
+		if path.Ext(link) != ".html" || strings.HasPrefix(link, "/") || seen[link] {
+			continue
+		}
+		seen[link] = true
+		target := filepath.Join(filepath.Dir(filePath), filepath.FromSlash(link))
+		if _, err := os.Stat(target); err != nil {
+			broken = append(broken, link)
+		}
+	}
+	return broken
+}
+
+// Records the broken links of the pages to local HTML files.
+func checkLocalLinks(runInfo *RunInfo) {
+	settings := runInfo.Settings
+	for _, filePath := range runInfo.HtmlFiles {
+		settings.Progress.Add(1, 0)
+		relPath := pageRelPath(settings, filePath)
+		for _, link := range brokenPageLinks(filePath) {
+			runInfo.Summary.AddProblem(relPath, "link to missing page "+link)
+		}
+	}
+}
+
+// Fails a --strict or --verify-offline build if it recorded problems, listing them
+// by page. Builds without --strict only record the problems of --verify-offline.
+func failOnProblems(runInfo *RunInfo) {
+	problems := runInfo.Summary.Problems
+	settings := runInfo.Settings
+	if (!settings.Strict && !settings.VerifyOffline) || len(problems) == 0 {
+		return
+	}
+
+	byPage := make(map[string][]string)
+	pages := make([]string, 0)
+	for _, problem := range problems {
+		if _, ok := byPage[problem.Page]; !ok {
+			pages = append(pages, problem.Page)
+		}
+		byPage[problem.Page] = append(byPage[problem.Page], problem.Message)
+	}
+	sort.Strings(pages)
+
+	report := new(strings.Builder)
+	for _, page := range pages {
+		report.WriteString("\n" + page + ":")
+		for _, message := range byPage[page] {
+			report.WriteString("\n  - " + message)
+		}
+	}
+	writeBuildSummary(runInfo)
+	log.Panicf("build failed with %v problems:%v", len(problems), report.String())
+}
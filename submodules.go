@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"html"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Directory of the build the nested modules are built into, by their directory in
+// the module.
+const submodulesDir = "modules"
+
+// Submodule is a module nested in the directory tree of the module being built. Its
+// packages are left out by go list and the doc server of the outer module.
+type Submodule struct {
+	ModName string
+	// Directory of the module relative to the outer module, slash-separated.
+	Dir string
+	// Entry page of the module's docs, relative to the build directory.
+	Link string
+}
+
+// Returns the modules nested in the module's directory tree, skipping hidden,
+// vendor and testdata directories and the build directory. The modules nested in
+// those are left to their builds.
+func findSubmodules(settings *Settings) []*Submodule {
+	buildDir, err := filepath.Abs(settings.BuildDir)
+	if err != nil {
+		log.Panicf("error resolving build directory: %v", err)
+	}
+	root := settings.ModuleRootPath
+
+	submodules := make([]*Submodule, 0)
+	err = filepath.Walk(root, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() || filePath == root {
+			return nil
+		}
+		name := info.Name()
+		if strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") ||
+			name == "vendor" || name == "testdata" || filePath == buildDir {
+			return filepath.SkipDir
+		}
+		goMod, err := ioutil.ReadFile(filepath.Join(filePath, "go.mod"))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		match := modNameRegex.FindSubmatch(goMod)
+		if match == nil {
+			return nil
+		}
+		relDir, err := filepath.Rel(root, filePath)
+		if err != nil {
+			return err
+		}
+		dir := filepath.ToSlash(relDir)
+		submodules = append(submodules, &Submodule{
+			ModName: string(match[1]),
+			Dir:     dir,
+			Link:    submodulesDir + "/" + dir + "/index.html",
+		})
+		// Modules nested in this one are built along with it.
+		return filepath.SkipDir
+	})
+	if err != nil {
+		log.Panicf("error looking for nested modules: %v", err)
+	}
+	return submodules
+}
+
+// Records the modules nested in the module, which --submodules builds along with it
+// and the index page lists. Without --submodules their packages are missing from the
+// docs, which is only logged.
+func detectSubmodules(runInfo *RunInfo) {
+	settings := runInfo.Settings
+	submodules := findSubmodules(settings)
+	if len(submodules) == 0 {
+		return
+	}
+	names := make([]string, len(submodules))
+	for i, submodule := range submodules {
+		names[i] = submodule.ModName
+	}
+	if !settings.Submodules {
+		log.Printf(
+			"skipping %v nested modules, build them with --submodules: %v",
+			len(submodules), strings.Join(names, ", "),
+		)
+		return
+	}
+	if settings.Fixtures != "" || settings.Replay != "" {
+		log.Printf(
+			"skipping %v nested modules, --fixtures and --replay only hold the pages "+
+				"of the outer module: %v",
+			len(submodules), strings.Join(names, ", "),
+		)
+		return
+	}
+	runInfo.Submodules = submodules
+}
+
+// Builds the HTML docs of the nested modules of runInfo.Submodules into
+// submodulesDir, with the settings of the outer module, and marks the boundary of
+// each on its pages with a link back to the outer module. Their problems are
+// recorded in the outer build's summary.
+func buildSubmodules(ctx context.Context, runInfo *RunInfo) {
+	settings := runInfo.Settings
+	for _, submodule := range runInfo.Submodules {
+		log.Printf("building nested module %v.", submodule.ModName)
+
+		subSettings := new(Settings)
+		*subSettings = *settings
+		subSettings.ModName = submodule.ModName
+		dir := filepath.FromSlash(submodule.Dir)
+		subSettings.ModuleRootPath = filepath.Join(settings.ModuleRootPath, dir)
+		subSettings.GoModPath = filepath.Join(subSettings.ModuleRootPath, "go.mod")
+		// The doc server serves the module of its working directory.
+		subSettings.DocSourcePath = filepath.Join(settings.docSourceRoot(), dir)
+		subSettings.BuildDir = filepath.Join(settings.BuildDir, submodulesDir, dir)
+		if settings.BaseURL != "" {
+			subSettings.BaseURL = strings.TrimSuffix(settings.BaseURL, "/") + "/" +
+				submodulesDir + "/" + submodule.Dir
+		}
+
+		subRunInfo := NewRunInfo()
+		subRunInfo.Settings = subSettings
+		subRunInfo.Summary = runInfo.Summary
+		setupBuildDir(subSettings)
+		buildHTMLSite(ctx, subRunInfo)
+
+		for _, filePath := range subRunInfo.HtmlFiles {
+			if err := markModuleBoundary(runInfo, submodule, filePath); err != nil {
+				log.Panicf("error marking module boundary: %v", err)
+			}
+		}
+	}
+}
+
+// Adds a banner naming the nested module to one of its pages, linking back to the
+// index page of the outer module.
+func markModuleBoundary(runInfo *RunInfo, submodule *Submodule, filePath string) error {
+	settings := runInfo.Settings
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	relPath := pageRelPath(settings, filePath)
+	outerLink := relativeLink(path.Dir(relPath), indexPageName(settings))
+	banner := `<p class="docmodule-module-boundary">Module <code>` +
+		html.EscapeString(submodule.ModName) + `</code>, nested in <a href="` + outerLink +
+		`">` + html.EscapeString(settings.ModName) + `</a></p>` + "\n"
+	return ioutil.WriteFile(filePath, injectIntoBodyStart(data, []byte(banner)), os.ModePerm)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+// BuildSummary collects the results of a run which are useful to callers, such as
+// where the docs were published to.
+type BuildSummary struct {
+	Published []*PublishedLocation `json:"published"`
+	// Packages listed by go list without a page in the build.
+	MissingPackages []string `json:"missingPackages,omitempty"`
+	// Problems found by a --strict or --verify-offline build.
+	Problems []*BuildProblem `json:"problems,omitempty"`
+	// Time taken by the stages of the build, in the order they ran.
+	Stages []*StageTiming `json:"stages,omitempty"`
+}
+
+// PublishedLocation records a single place the docs were published to.
+type PublishedLocation struct {
+	// Kind of publish target, e.g. webdav or ipfs.
+	Target string `json:"target"`
+	// URL, path or content id of the published docs.
+	Location string `json:"location"`
+}
+
+func NewBuildSummary() *BuildSummary {
+	return &BuildSummary{Published: make([]*PublishedLocation, 0)}
+}
+
+func (summary *BuildSummary) AddPublished(target string, location string) {
+	summary.Published = append(
+		summary.Published, &PublishedLocation{Target: target, Location: location},
+	)
+}
+
+// Logs the build summary and writes it to the summary file if one was requested.
+func writeBuildSummary(runInfo *RunInfo) {
+	data, err := json.MarshalIndent(runInfo.Summary, "", "  ")
+	if err != nil {
+		log.Panicf("error encoding build summary: %v", err)
+	}
+
+	log.Print(
+		"\n\n##### BUILD SUMMARY #####\n\n",
+		string(data),
+		"\n\n##### END SUMMARY #####\n\n",
+	)
+
+	if runInfo.Settings.SummaryPath == "" {
+		return
+	}
+	err = ioutil.WriteFile(runInfo.Settings.SummaryPath, data, os.ModePerm)
+	if err != nil {
+		log.Panicf("error writing build summary: %v", err)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"html"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Modes of converting aligned comment blocks to tables.
+const (
+	// Leave every block preformatted.
+	commentTablesOff = "off"
+	// Convert every aligned block, except those opting out.
+	commentTablesAuto = "auto"
+	// Only convert blocks opting in.
+	commentTablesDirective = "directive"
+)
+
+// Directives which, as the first line of an indented comment block, opt the block in
+// to or out of being rendered as a table. The directive line itself is dropped.
+const (
+	tableDirective   = "docmodule:table"
+	noTableDirective = "docmodule:no-table"
+)
+
+// Regex for preformatted blocks. Blocks with markup, like declarations with links,
+// never match.
+var preBlockRegex = regexp.MustCompile(`(?s)<pre>([^<]*)</pre>`)
+
+// Regex for the gaps between columns of an aligned block.
+var columnGapRegex = regexp.MustCompile(`\S(\s{2,})\S`)
+
+// Regex for a header separator line, e.g. "----  -----".
+var separatorLineRegex = regexp.MustCompile(`^[-=]+(\s{2,}[-=]+)*$`)
+
+// Regex for blocks holding Go declarations rather than a layout.
+var declarationRegex = regexp.MustCompile(`^\s*(package|import|const|var|type|func)\b`)
+
+// Returns the start offsets of the columns of line.
+func columnStarts(line string) []int {
+	starts := []int{len(line) - len(strings.TrimLeft(line, " \t"))}
+	offset := 0
+	for {
+		location := columnGapRegex.FindStringSubmatchIndex(line[offset:])
+		if location == nil {
+			return starts
+		}
+		starts = append(starts, offset+location[3])
+		offset += location[3]
+	}
+}
+
+// Splits line into cells at the column starts.
+func splitColumns(line string, starts []int) []string {
+	cells := make([]string, len(starts))
+	for i, start := range starts {
+		end := len(line)
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+		if start < len(line) {
+			if end > len(line) {
+				end = len(line)
+			}
+			cells[i] = strings.TrimSpace(line[start:end])
+		}
+	}
+	return cells
+}
+
+// Returns the rows of lines if they are all laid out in the same, aligned
+// columns, and whether the first row is a header marked by a separator line.
+func alignedRows(lines []string) ([][]string, bool, bool) {
+	var starts []int
+	header := false
+	rows := make([][]string, 0, len(lines))
+
+	for i, line := range lines {
+		if i == 1 && separatorLineRegex.MatchString(strings.TrimSpace(line)) {
+			header = true
+			continue
+		}
+		lineStarts := columnStarts(line)
+		if starts == nil {
+			starts = lineStarts
+			if len(starts) < 2 {
+				return nil, false, false
+			}
+		} else if !equalInts(starts, lineStarts) {
+			return nil, false, false
+		}
+		rows = append(rows, splitColumns(line, starts))
+	}
+	return rows, header, len(rows) >= 2
+}
+
+func equalInts(a []int, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Renders rows as an HTML table.
+func renderTable(rows [][]string, header bool) string {
+	rendered := `<table class="docmodule-comment-table">`
+	for i, row := range rows {
+		cellTag := "td"
+		if i == 0 && header {
+			cellTag = "th"
+		}
+		rendered += "<tr>"
+		for _, cell := range row {
+			rendered += "<" + cellTag + ">" + html.EscapeString(cell) + "</" + cellTag + ">"
+		}
+		rendered += "</tr>"
+	}
+	return rendered + "</table>"
+}
+
+// Returns the rendering of a preformatted comment block under mode, converting it to
+// a table when its lines are aligned in columns.
+func rewriteCommentBlock(block []byte, mode string) []byte {
+	text := html.UnescapeString(string(preBlockRegex.FindSubmatch(block)[1]))
+	if declarationRegex.MatchString(text) {
+		return block
+	}
+
+	lines := strings.Split(strings.Trim(text, "\n"), "\n")
+	directive := strings.TrimSpace(lines[0])
+	if directive == tableDirective || directive == noTableDirective {
+		lines = lines[1:]
+	} else {
+		directive = ""
+	}
+
+	convert := (mode == commentTablesAuto && directive != noTableDirective) ||
+		directive == tableDirective
+	if convert && len(lines) > 0 {
+		if rows, header, ok := alignedRows(lines); ok {
+			return []byte(renderTable(rows, header))
+		}
+	}
+	if directive == "" {
+		return block
+	}
+	return []byte("<pre>" + html.EscapeString(strings.Join(lines, "\n")) + "</pre>")
+}
+
+// Returns the processor rendering the aligned column layouts of doc comments, like
+// option matrices, as HTML tables rather than preformatted text.
+func newCommentTablesProcessor(ctx context.Context, runInfo *RunInfo) Processor {
+	settings := runInfo.Settings
+	if settings.CommentTables == commentTablesOff {
+		return nil
+	}
+
+	writeNavStylesheet(settings)
+
+	return ProcessorFunc(func(page *Page) error {
+		data := preBlockRegex.ReplaceAllFunc(page.Data, func(block []byte) []byte {
+			return rewriteCommentBlock(block, settings.CommentTables)
+		})
+		page.Data = linkNavStylesheet(data, path.Dir(page.RelPath))
+		return nil
+	})
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"golang.org/x/xerrors"
+	"html"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// A function available to user templates.
+type templateFunc struct {
+	Name string
+	// How the function is called, e.g. `slugify "Some Title"`.
+	Usage string
+	Doc   string
+	Func  interface{}
+}
+
+var slugSeparatorRegex = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Returns a lower case, dash separated version of text usable in ids and file names.
+func slugify(text string) string {
+	return strings.Trim(slugSeparatorRegex.ReplaceAllString(strings.ToLower(text), "-"), "-")
+}
+
+// Returns import path -> page path relative to the build directory.
+func packagePages(runInfo *RunInfo) map[string]string {
+	pages := make(map[string]string)
+	for _, info := range runInfo.DocFileInfo {
+		if info.ImportPath != "" {
+			pages[info.ImportPath] = info.NewRelPath
+		}
+	}
+	return pages
+}
+
+// Resolves a reference like example.com/mod/pkg.Type.Method to the link of the
+// symbol, relative to the build directory, picking the longest documented package
+// the reference starts with. Returns an empty string for unknown packages.
+func resolveSymbolLink(pages map[string]string, ref string) string {
+	if page, ok := pages[ref]; ok {
+		return page
+	}
+
+	importPath, symbol := "", ""
+	for candidate := range pages {
+		if strings.HasPrefix(ref, candidate+".") && len(candidate) > len(importPath) {
+			importPath = candidate
+			symbol = strings.TrimPrefix(ref, candidate+".")
+		}
+	}
+	if importPath == "" {
+		return ""
+	}
+	return pages[importPath] + "#" + symbol
+}
+
+var (
+	markdownHeadingRegex = regexp.MustCompile(`^(#{1,6})\s+(.*)$`)
+	markdownListRegex    = regexp.MustCompile(`^[-*]\s+(.*)$`)
+	markdownCodeRegex    = regexp.MustCompile("`([^`]+)`")
+	markdownStrongRegex  = regexp.MustCompile(`\*\*([^*]+)\*\*`)
+	markdownLinkRegex    = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+)
+
+// Renders the inline markup of an already escaped line of markdown.
+func renderMarkdownInline(line string) string {
+	line = markdownCodeRegex.ReplaceAllString(line, "<code>$1</code>")
+	line = markdownStrongRegex.ReplaceAllString(line, "<strong>$1</strong>")
+	return markdownLinkRegex.ReplaceAllStringFunc(line, func(link string) string {
+		parts := markdownLinkRegex.FindStringSubmatch(link)
+		if strings.HasPrefix(strings.ToLower(parts[2]), "javascript:") {
+			return parts[1]
+		}
+		return `<a href="` + parts[2] + `">` + parts[1] + "</a>"
+	})
+}
+
+// Renders the common subset of markdown used in doc strings: headings, paragraphs,
+// lists, fenced code, inline code, strong text and links. Raw HTML is escaped.
+func renderMarkdown(text string) template.HTML {
+	rendered := new(bytes.Buffer)
+	var paragraph, list []string
+	inCode := false
+
+	flush := func() {
+		if len(paragraph) > 0 {
+			rendered.WriteString("<p>" + renderMarkdownInline(strings.Join(paragraph, " ")) + "</p>\n")
+			paragraph = nil
+		}
+		if len(list) > 0 {
+			rendered.WriteString("<ul>\n")
+			for _, item := range list {
+				rendered.WriteString("<li>" + renderMarkdownInline(item) + "</li>\n")
+			}
+			rendered.WriteString("</ul>\n")
+			list = nil
+		}
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			if inCode {
+				rendered.WriteString("</code></pre>\n")
+			} else {
+				flush()
+				rendered.WriteString("<pre><code>")
+			}
+			inCode = !inCode
+			continue
+		}
+		if inCode {
+			rendered.WriteString(html.EscapeString(line) + "\n")
+			continue
+		}
+
+		line = html.EscapeString(strings.TrimSpace(line))
+		if match := markdownHeadingRegex.FindStringSubmatch(line); match != nil {
+			flush()
+			level := string(rune('0' + len(match[1])))
+			rendered.WriteString("<h" + level + ` id="` + slugify(match[2]) + `">` +
+				renderMarkdownInline(match[2]) + "</h" + level + ">\n")
+		} else if match := markdownListRegex.FindStringSubmatch(line); match != nil {
+			if len(paragraph) > 0 {
+				flush()
+			}
+			list = append(list, match[1])
+		} else if line == "" {
+			flush()
+		} else if len(list) > 0 {
+			// A continuation of the last list item.
+			list[len(list)-1] += " " + line
+		} else {
+			paragraph = append(paragraph, line)
+		}
+	}
+	if inCode {
+		rendered.WriteString("</code></pre>\n")
+	}
+	flush()
+	return template.HTML(rendered.String())
+}
+
+// Returns the major version of a semantic version.
+func semverMajor(version string) (int, error) {
+	parsed, err := parseSemver(version)
+	if err != nil {
+		return 0, err
+	}
+	return parsed.Major, nil
+}
+
+// Compares two semantic versions, returning -1, 0 or 1.
+func semverCompare(a string, b string) (int, error) {
+	versionA, err := parseSemver(a)
+	if err != nil {
+		return 0, err
+	}
+	versionB, err := parseSemver(b)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case versionA.less(versionB):
+		return -1, nil
+	case versionB.less(versionA):
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// Returns the functions available to user templates of a build.
+func templateFunctions(runInfo *RunInfo) []*templateFunc {
+	pages := packagePages(runInfo)
+	return []*templateFunc{
+		{
+			Name:  "slugify",
+			Usage: `slugify "Some Title"`,
+			Doc:   "Lower case, dash separated version of a string, for ids and file names.",
+			Func:  slugify,
+		},
+		{
+			Name:  "anchor",
+			Usage: `anchor "Type.Method"`,
+			Doc:   "Fragment linking to a symbol on its package's page, e.g. #Type.Method.",
+			Func: func(symbol string) string {
+				return "#" + symbol
+			},
+		},
+		{
+			Name:  "packageLink",
+			Usage: `packageLink "example.com/mod/pkg"`,
+			Doc: "Page of a package, relative to the build directory. Empty if the " +
+				"package is not documented.",
+			Func: func(importPath string) string {
+				return pages[importPath]
+			},
+		},
+		{
+			Name:  "symbolLink",
+			Usage: `symbolLink "example.com/mod/pkg.Type.Method"`,
+			Doc: "Resolves a cross reference to the symbol's link, relative to the build " +
+				"directory. Empty if the package is not documented.",
+			Func: func(ref string) string {
+				return resolveSymbolLink(pages, ref)
+			},
+		},
+		{
+			Name:  "relLink",
+			Usage: `relLink "pkg/index.html" "other/index.html#Type"`,
+			Doc: "Makes a link relative to the build directory relative to the page the " +
+				"first argument names.",
+			Func: func(fromPage string, target string) string {
+				return relativeLink(path.Dir(fromPage), target)
+			},
+		},
+		{
+			Name:  "markdown",
+			Usage: `markdown .Text`,
+			Doc: "Renders headings, paragraphs, lists, fenced and inline code, strong " +
+				"text and links. Raw HTML is escaped.",
+			Func: renderMarkdown,
+		},
+		{
+			Name:  "calloutClass",
+			Usage: `calloutClass "BUG"`,
+			Doc:   "Class of the callout box --note-callouts wraps notes of a marker in.",
+			Func:  calloutClass,
+		},
+		{
+			Name:  "version",
+			Usage: "version",
+			Doc:   "Version of the docs, as set by --doc-version or detected from git.",
+			Func: func() string {
+				return detectDocVersion(runInfo.Settings)
+			},
+		},
+		{
+			Name:  "semverMajor",
+			Usage: `semverMajor "v1.2.3"`,
+			Doc:   "Major version of a semantic version. Fails on invalid versions.",
+			Func:  semverMajor,
+		},
+		{
+			Name:  "semverCompare",
+			Usage: `semverCompare "v1.2.3" "v1.10.0"`,
+			Doc:   "Compares two semantic versions, returning -1, 0 or 1.",
+			Func:  semverCompare,
+		},
+	}
+}
+
+// Returns the function map of user templates of a build.
+func templateFuncMap(runInfo *RunInfo) template.FuncMap {
+	funcs := make(template.FuncMap)
+	for _, function := range templateFunctions(runInfo) {
+		funcs[function.Name] = function.Func
+	}
+	return funcs
+}
+
+var templateFuncsTemplate = template.Must(template.New("template-funcs").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta http-equiv="Content-Type" content="text/html; charset=utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>docmodule template functions</title>
+</head>
+<body>
+<h1>docmodule template functions</h1>
+<p>
+Templates in the <code>{{.Dir}}</code> directory of --templates override the pages
+docmodule generates itself: {{range $i, $name := .Pages}}{{if $i}}, {{end}}<code>{{$name}}</code>{{end}}.
+They may call these functions in addition to Go's built-in template functions.
+</p>
+{{range .Functions}}
+<h2 id="{{.Name}}">{{.Name}}</h2>
+<pre>{{"{{"}}{{.Usage}}{{"}}"}}</pre>
+<p>{{.Doc}}</p>
+{{end}}
+</body>
+</html>
+`))
+
+// Writes the reference page of the functions available to user templates.
+func runTemplateFuncsCommand(args []string) {
+	flags := newCommandFlagSet("template-funcs")
+	output := flags.String(
+		"output",
+		"-",
+		"File to write the reference page to, '-' for stdout.",
+	)
+	_ = flags.Parse(args)
+
+	buffer := new(bytes.Buffer)
+	err := templateFuncsTemplate.Execute(buffer, map[string]interface{}{
+		"Dir":       pageTemplatesDir,
+		"Pages":     pageTemplateFiles(),
+		"Functions": templateFunctions(&RunInfo{Settings: new(Settings)}),
+	})
+	if err != nil {
+		log.Fatal(xerrors.Errorf("error rendering template functions: %w", err))
+	}
+
+	if *output == "-" {
+		_, _ = os.Stdout.Write(buffer.Bytes())
+		return
+	}
+	if err := ioutil.WriteFile(*output, buffer.Bytes(), os.ModePerm); err != nil {
+		log.Fatal(xerrors.Errorf("error writing template functions: %w", err))
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"golang.org/x/xerrors"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Files godoc loads from its -templates directory. Anything else in the directory
+// would be silently ignored by godoc, which usually means a misspelled override.
+var godocTemplateNames = map[string]bool{
+	"callgraph.html":     true,
+	"codewalk.html":      true,
+	"codewalkdir.html":   true,
+	"dirlist.html":       true,
+	"error.html":         true,
+	"example.html":       true,
+	"godoc.html":         true,
+	"godocs.js":          true,
+	"implements.html":    true,
+	"methodset.html":     true,
+	"opensearch.xml":     true,
+	"package.html":       true,
+	"packageroot.html":   true,
+	"play.js":            true,
+	"playground.js":      true,
+	"search.html":        true,
+	"searchcode.html":    true,
+	"searchdoc.html":     true,
+	"searchtxt.html":     true,
+	"style.css":          true,
+	"jquery.js":          true,
+	"analysis/help.html": true,
+}
+
+// Directory of the templates directory holding overrides of the pages docmodule
+// generates itself, which godoc does not read.
+const pageTemplatesDir = "docmodule"
+
+// Templates of the pages docmodule generates itself, by name. An override is named
+// after its template, e.g. docmodule/index.html.
+var pageTemplates = map[string]*template.Template{
+	indexTemplate.Name():        indexTemplate,
+	notesTemplate.Name():        notesTemplate,
+	allNotesTemplate.Name():     allNotesTemplate,
+	examplePageTemplate.Name():  examplePageTemplate,
+	exampleIndexTemplate.Name(): exampleIndexTemplate,
+}
+
+// Returns the file names of the page template overrides, in lexical order.
+func pageTemplateFiles() []string {
+	files := make([]string, 0, len(pageTemplates))
+	for name := range pageTemplates {
+		files = append(files, pageTemplatesDir+"/"+name+".html")
+	}
+	sort.Strings(files)
+	return files
+}
+
+// Returns the user's override of a page template, parsed with the template function
+// library, or builtin when there is none.
+func pageTemplate(runInfo *RunInfo, builtin *template.Template) *template.Template {
+	dir := runInfo.Settings.TemplatesDir
+	if dir == "" {
+		return builtin
+	}
+
+	filePath := filepath.Join(dir, pageTemplatesDir, builtin.Name()+".html")
+	data, err := ioutil.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return builtin
+	}
+	if err != nil {
+		log.Panicf("error reading template '%v': %v", filePath, err)
+	}
+
+	override, err := parsePageTemplate(runInfo, builtin.Name(), data)
+	if err != nil {
+		log.Panicf("error parsing template '%v': %v", filePath, err)
+	}
+	return override
+}
+
+// Parses the override of the page template name with the template function library.
+func parsePageTemplate(runInfo *RunInfo, name string, data []byte) (*template.Template, error) {
+	return template.New(name).Funcs(templateFuncMap(runInfo)).Parse(string(data))
+}
+
+// Checks that dir only contains files godoc knows how to override, and overrides of
+// docmodule's own pages.
+func validateTemplatesDir(dir string) error {
+	files, err := templateFiles(dir)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return xerrors.Errorf("templates directory '%v' is empty", dir)
+	}
+
+	for _, name := range files {
+		if strings.HasPrefix(name, pageTemplatesDir+"/") {
+			if _, ok := pageTemplates[strings.TrimSuffix(
+				strings.TrimPrefix(name, pageTemplatesDir+"/"), ".html",
+			)]; !ok {
+				return xerrors.Errorf(
+					"templates directory '%v' contains '%v', which is not one of %v",
+					dir,
+					name,
+					pageTemplateFiles(),
+				)
+			}
+			continue
+		}
+		if !godocTemplateNames[name] {
+			return xerrors.Errorf(
+				"templates directory '%v' contains '%v', which godoc does not use",
+				dir,
+				name,
+			)
+		}
+	}
+	return nil
+}
+
+// Returns the files in dir, relative to it and slash-separated, in lexical order.
+func templateFiles(dir string) ([]string, error) {
+	files := make([]string, 0)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(relPath))
+		return nil
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("error reading templates directory: %w", err)
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+// Returns a digest of the names and contents of the files in dir, recorded in the
+// build info so a build can be traced back to the templates it was rendered with.
+func templatesDigest(dir string) (string, error) {
+	files, err := templateFiles(dir)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	for _, name := range files {
+		data, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			return "", xerrors.Errorf("error reading template: %w", err)
+		}
+		fileHash := sha256.Sum256(data)
+		_, _ = hash.Write([]byte(name + "\x00" + hex.EncodeToString(fileHash[:]) + "\n"))
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"html"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Directory of the text-only variant of the site, mirroring the pages of the build.
+// The go tool ignores directories starting with an underscore, so the pages of no
+// package are written there in the nested layout.
+const textSiteDir = "_text"
+
+// Styles of the text-only pages, the only ones they load.
+const textSiteStyle = `<style>
+body { max-width: 50rem; margin: 0 auto; padding: 1rem; font-family: sans-serif; line-height: 1.5; }
+pre { overflow-x: auto; }
+</style>
+`
+
+// Regexes for the markup the text-only pages leave out: scripts, stylesheets, media
+// and the buttons only scripts give a use.
+var (
+	textStripRegex = regexp.MustCompile(
+		`(?is)<script[\s>].*?</script>|<style[\s>].*?</style>|<button[\s>].*?</button>|` +
+			`<(?:iframe|svg|video|audio|canvas)[\s>].*?</(?:iframe|svg|video|audio|canvas)>|` +
+			`<link[^>]*>|</?noscript[^>]*>`,
+	)
+	textAttributeRegex = regexp.MustCompile(`(?i)\s(?:on[a-z]+|style)="[^"]*"`)
+	imageTagRegex      = regexp.MustCompile(`(?i)<img[^>]*>`)
+	imageAltRegex      = regexp.MustCompile(`(?i)\salt="([^"]*)"`)
+)
+
+// Returns the text-only variant of a page: its markup without scripts, styles and
+// media, with links to other pages pointing to their text-only variant and links to
+// other files to the files of the site. basePath is the path of --base-url, which
+// root-relative links start with. The styles are inlined, or linked from the file at
+// stylePath of the build directory if it is set, as under --csp.
+func textOnlyPage(data []byte, relPath string, basePath string, stylePath string) []byte {
+	data = textStripRegex.ReplaceAll(data, nil)
+	data = textAttributeRegex.ReplaceAll(data, nil)
+	data = imageTagRegex.ReplaceAllFunc(data, func(match []byte) []byte {
+		if alt := imageAltRegex.FindSubmatch(match); alt != nil && len(alt[1]) > 0 {
+			return []byte("[" + string(alt[1]) + "]")
+		}
+		return nil
+	})
+
+	pageDir := path.Dir(relPath)
+	textDir := path.Dir(textSiteDir + "/" + relPath)
+	isPage := func(link string) bool {
+		return path.Ext(link) == ".html" || strings.HasSuffix(link, "/")
+	}
+	data = localLinkRegex.ReplaceAllFunc(data, func(match []byte) []byte {
+		groups := localLinkRegex.FindSubmatch(match)
+		link := string(groups[2])
+		if strings.HasPrefix(link, "/") {
+			relLink := strings.TrimPrefix(link, basePath+"/")
+			if relLink == link || !isPage(link) {
+				return match
+			}
+			return []byte(string(groups[1]) + `="` + basePath + "/" + textSiteDir + "/" + relLink)
+		}
+		if isPage(link) {
+			return match
+		}
+		target := path.Join(pageDir, link)
+		return []byte(string(groups[1]) + `="` + relativeLink(textDir, target))
+	})
+
+	fullLink := relativeLink(textDir, relPath)
+	if stylePath != "" {
+		data = injectIntoHead(data, []byte(
+			`<link rel="stylesheet" href="`+relativeLink(textDir, stylePath)+`">`+"\n",
+		))
+	} else {
+		data = injectIntoHead(data, []byte(textSiteStyle))
+	}
+	return injectIntoBodyStart(data, []byte(
+		`<p><a href="`+html.EscapeString(fullLink)+`">Full version of this page</a></p>`+"\n",
+	))
+}
+
+// Returns the processor writing the text-only variant of every page under
+// textSiteDir and linking each page to its variant.
+func newTextOnlyProcessor(ctx context.Context, runInfo *RunInfo) Processor {
+	settings := runInfo.Settings
+	if !settings.TextOnly {
+		return nil
+	}
+
+	basePath := ""
+	if parsed, err := url.Parse(strings.TrimSuffix(settings.BaseURL, "/")); err == nil {
+		basePath = parsed.Path
+	}
+
+	// A strict policy doesn't allow the inline styles.
+	stylePath := ""
+	if settings.CSP {
+		style := inlineStyleRegex.FindSubmatch([]byte(textSiteStyle))[2]
+		name, err := writeInlineAsset(settings, style, ".css")
+		if err != nil {
+			log.Panicf("error writing text-only styles: %v", err)
+		}
+		stylePath = name
+	}
+
+	log.Printf("writing text-only variants of the pages to %v/.", textSiteDir)
+
+	return ProcessorFunc(func(page *Page) error {
+		textPath := filepath.Join(
+			settings.BuildDir, textSiteDir, filepath.FromSlash(page.RelPath),
+		)
+		if err := os.MkdirAll(filepath.Dir(textPath), os.ModePerm); err != nil {
+			return err
+		}
+		textData := textOnlyPage(page.Data, page.RelPath, basePath, stylePath)
+		if err := ioutil.WriteFile(textPath, textData, os.ModePerm); err != nil {
+			return err
+		}
+
+		textLink := relativeLink(path.Dir(page.RelPath), textSiteDir+"/"+page.RelPath)
+		page.Data = injectIntoHead(page.Data, []byte(
+			`<link rel="alternate" title="Text-only version" href="`+textLink+`">`+"\n",
+		))
+		page.Data = injectIntoBodyStart(page.Data, []byte(
+			`<p class="docmodule-text-only"><a href="`+textLink+`">Text-only version</a></p>`+"\n",
+		))
+		return nil
+	})
+}
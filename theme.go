@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"golang.org/x/xerrors"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Doc model of the made up module theme previews are rendered with.
+func sampleDocModel() *DocModel {
+	return &DocModel{
+		Module:  "example.com/sample",
+		Version: "v1.2.3",
+		Packages: []*ModelPackage{
+			{
+				ImportPath: "example.com/sample",
+				Name:       "sample",
+				Synopsis:   "Package sample shows how a theme renders a package.",
+				Doc:        "Package sample shows how a theme renders a package.\n",
+				Symbols: []*ModelSymbol{
+					{
+						ImportPath: "example.com/sample",
+						Name:       "Shape",
+						Kind:       "type",
+						Signature:  "type Shape interface {\n\tArea() float64\n}",
+						Doc:        "Shape is anything with an area.\n",
+					},
+					{
+						ImportPath: "example.com/sample",
+						Name:       "Total",
+						Kind:       "func",
+						Signature:  "func Total(shapes ...Shape) float64",
+						Doc:        "Total sums the areas of shapes.\n",
+					},
+				},
+			},
+			{
+				ImportPath: "example.com/sample/square",
+				Name:       "square",
+				Synopsis:   "Package square implements square shapes.",
+				Doc:        "Package square implements square shapes.\n",
+				Symbols: []*ModelSymbol{
+					{
+						ImportPath: "example.com/sample/square",
+						Name:       "Square",
+						Kind:       "type",
+						Signature:  "type Square struct {\n\tSide float64\n}",
+						Doc:        "Square is a shape with four equal sides.\n",
+					},
+				},
+			},
+		},
+	}
+}
+
+// Returns a run of the sample module, with a page per package.
+func sampleRunInfo(model *DocModel, templatesDir string) *RunInfo {
+	runInfo := NewRunInfo()
+	runInfo.Settings.ModName = model.Module
+	runInfo.Settings.DocVersion = model.Version
+	runInfo.Settings.TemplatesDir = templatesDir
+	for _, pkg := range model.Packages {
+		relDir := filepath.ToSlash(pkg.ImportPath[len(model.Module):])
+		runInfo.DocFileInfo = append(runInfo.DocFileInfo, &DocFileInfo{
+			NewRelPath: "pkg" + relDir + "/index.html",
+			ImportPath: pkg.ImportPath,
+		})
+	}
+	return runInfo
+}
+
+// Returns the data each page template is executed with for the sample module, like
+// the build passes it.
+func samplePageData(model *DocModel, runInfo *RunInfo) map[string]interface{} {
+	pages := packagePages(runInfo)
+	root, square := model.Packages[0], model.Packages[1]
+
+	groups := []*indexGroup{{Dir: ".", Packages: []*indexPackage{
+		{
+			ImportPath: root.ImportPath,
+			RelPath:    ".",
+			Name:       root.Name,
+			Synopsis:   root.Synopsis,
+			Link:       pages[root.ImportPath],
+		},
+		{
+			ImportPath: square.ImportPath,
+			RelPath:    "square",
+			Name:       square.Name,
+			Synopsis:   square.Synopsis,
+			Link:       pages[square.ImportPath],
+		},
+	}}}
+	bugs := []*notePackage{{
+		ImportPath: square.ImportPath,
+		Link:       pages[square.ImportPath],
+		Notes:      []template.HTML{"Sides are not checked for being positive."},
+	}}
+	notes := &moduleNotes{
+		Markers: map[string][]*notePackage{"BUG": bugs},
+		Deprecated: []*deprecatedSymbol{{
+			ImportPath: root.ImportPath,
+			Symbol:     "Total",
+			Link:       pages[root.ImportPath] + "#Total",
+			Message:    "Use Sum instead.",
+		}},
+	}
+	example := &examplePage{
+		ImportPath:    root.ImportPath,
+		Name:          "Total",
+		Title:         "Total",
+		Doc:           "Sums the areas of two squares.",
+		Code:          "fmt.Println(sample.Total(square.Square{Side: 1}, square.Square{Side: 2}))",
+		Output:        "5\n",
+		RelPath:       examplePagesDir + "/sample/Total.html",
+		PackageLink:   relativeLink(examplePagesDir+"/sample", pages[root.ImportPath]),
+		Stylesheet:    "../../style.css",
+		NavStylesheet: "../../" + navStylesheetName,
+		IndexLink:     "../index.html",
+	}
+
+	return map[string]interface{}{
+		indexTemplate.Name(): map[string]interface{}{
+			"Module":     model.Module,
+			"Stylesheet": "style.css",
+			"Groups":     groups,
+			"GraphLink":  graphPageName,
+			"GuideLink":  proseDir + "/index.html",
+		},
+		notesTemplate.Name(): map[string]interface{}{
+			"Module":     model.Module,
+			"Marker":     "BUG",
+			"Stylesheet": "style.css",
+			"Packages":   bugs,
+		},
+		allNotesTemplate.Name(): map[string]interface{}{
+			"Module":        model.Module,
+			"Stylesheet":    "style.css",
+			"NavStylesheet": navStylesheetName,
+			"Markers":       []string{"BUG"},
+			"Notes":         notes,
+		},
+		examplePageTemplate.Name(): example,
+		exampleIndexTemplate.Name(): map[string]interface{}{
+			"Module":     model.Module,
+			"Stylesheet": "../style.css",
+			"Packages": []*exampleIndexPackage{{
+				ImportPath: root.ImportPath,
+				Examples:   []*exampleIndexEntry{{Title: example.Title, Link: "sample/Total.html"}},
+			}},
+		},
+	}
+}
+
+// Renders the page template name for the sample module, failing on fields the data
+// does not have. Uses the override in templatesDir when there is one.
+func renderSamplePage(
+	runInfo *RunInfo, name string, data interface{},
+) (rendered []byte, overridden bool, err error) {
+	page := pageTemplates[name]
+	filePath := filepath.Join(runInfo.Settings.TemplatesDir, pageTemplatesDir, name+".html")
+	source, err := ioutil.ReadFile(filePath)
+	if err == nil {
+		overridden = true
+		page, err = parsePageTemplate(runInfo, name, source)
+		if err != nil {
+			return nil, overridden, err
+		}
+		page = page.Option("missingkey=error")
+	} else if !os.IsNotExist(err) {
+		return nil, false, err
+	}
+
+	buffer := new(bytes.Buffer)
+	if err := page.Execute(buffer, data); err != nil {
+		return nil, overridden, err
+	}
+	return buffer.Bytes(), overridden, nil
+}
+
+// Validates the template overrides of a theme and renders every page docmodule
+// generates itself for a sample module, so template errors show before a build.
+func runThemeCheckCommand(args []string) {
+	flags := newCommandFlagSet("theme check")
+	templatesDir := flags.String(
+		"templates",
+		"",
+		"Directory of template overrides, as passed to --templates.",
+	)
+	output := flags.String(
+		"output",
+		"theme-preview",
+		"Directory to render the preview of the sample module into, '' for none.",
+	)
+	_ = flags.Parse(args)
+
+	if *templatesDir == "" {
+		log.Fatal("--templates is required")
+	}
+	if err := validateTemplatesDir(*templatesDir); err != nil {
+		log.Fatal(err)
+	}
+
+	model := sampleDocModel()
+	runInfo := sampleRunInfo(model, *templatesDir)
+	data := samplePageData(model, runInfo)
+
+	names := make([]string, 0, len(pageTemplates))
+	for name := range pageTemplates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	failed := 0
+	for _, name := range names {
+		fileName := pageTemplatesDir + "/" + name + ".html"
+		rendered, overridden, err := renderSamplePage(runInfo, name, data[name])
+		if err != nil {
+			failed++
+			fmt.Printf("FAIL %v: %v\n", fileName, err)
+			continue
+		}
+		if overridden {
+			fmt.Printf("ok   %v\n", fileName)
+		}
+
+		if *output == "" {
+			continue
+		}
+		previewPath := filepath.Join(*output, name+".html")
+		if err := os.MkdirAll(*output, os.ModePerm); err != nil {
+			log.Fatal(xerrors.Errorf("error creating preview directory: %w", err))
+		}
+		if err := ioutil.WriteFile(previewPath, rendered, os.ModePerm); err != nil {
+			log.Fatal(xerrors.Errorf("error writing preview: %w", err))
+		}
+	}
+
+	if failed > 0 {
+		log.Fatalf("%v templates failed", failed)
+	}
+	if *output != "" {
+		fmt.Printf("preview of the sample module written to %v\n", *output)
+	}
+}
+
+// Subcommands of `docmodule theme`.
+var themeCommands = map[string]func(args []string){
+	"add":   runThemeAddCommand,
+	"check": runThemeCheckCommand,
+}
+
+func runThemeCommand(args []string) {
+	if len(args) == 0 {
+		log.Fatal("usage: docmodule theme add|check")
+	}
+	command, ok := themeCommands[args[0]]
+	if !ok {
+		log.Fatalf("unknown theme command %q", args[0])
+	}
+	command(args[1:])
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/xerrors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Name of the file in the module root pinning the themes the module's docs use.
+const themesConfigName = "docmodule-themes.json"
+
+// Directory of the module root themes are installed into.
+const themesDir = ".docmodule/themes"
+
+// ThemeSpec pins a theme: a directory of template overrides, as passed to
+// --templates, fetched from a git repository or an archive.
+type ThemeSpec struct {
+	Name string `json:"name"`
+	// Git URL, or URL or path of a .tar.gz, .tgz or .zip archive.
+	Source string `json:"source"`
+	// Git ref the theme was added from, HEAD when empty.
+	Ref string `json:"ref,omitempty"`
+	// Commit of git themes, or "sha256:" digest of archive themes, the theme is
+	// pinned to.
+	Version string `json:"version"`
+}
+
+// ThemesConfig is the content of docmodule-themes.json.
+type ThemesConfig struct {
+	Themes []*ThemeSpec `json:"themes"`
+}
+
+func readThemesConfig(moduleRoot string) (*ThemesConfig, error) {
+	config := new(ThemesConfig)
+	data, err := ioutil.ReadFile(filepath.Join(moduleRoot, themesConfigName))
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("error reading themes config: %w", err)
+	}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, xerrors.Errorf("error parsing themes config: %w", err)
+	}
+	return config, nil
+}
+
+func writeThemesConfig(moduleRoot string, config *ThemesConfig) error {
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return xerrors.Errorf("error encoding themes config: %w", err)
+	}
+	data = append(data, '\n')
+	if err := ioutil.WriteFile(filepath.Join(moduleRoot, themesConfigName), data, 0644); err != nil {
+		return xerrors.Errorf("error writing themes config: %w", err)
+	}
+	return nil
+}
+
+// Returns the pinned theme called name, or nil.
+func (config *ThemesConfig) theme(name string) *ThemeSpec {
+	for _, spec := range config.Themes {
+		if spec.Name == name {
+			return spec
+		}
+	}
+	return nil
+}
+
+// Reports whether source is a git repository rather than an archive.
+func isGitSource(source string) bool {
+	return strings.HasPrefix(source, "git@") ||
+		strings.HasPrefix(source, "git+") ||
+		strings.HasPrefix(source, "ssh://") ||
+		strings.HasSuffix(source, ".git")
+}
+
+// Returns the name a theme gets by default, the base name of its source without
+// extension.
+func themeName(source string) string {
+	source = strings.TrimSuffix(filepath.ToSlash(source), "/")
+	source = strings.TrimSuffix(source, "/.git")
+	name := path.Base(source)
+	for _, suffix := range []string{".git", ".tar.gz", ".tgz", ".zip"} {
+		name = strings.TrimSuffix(name, suffix)
+	}
+	return name
+}
+
+// Clones a git theme and extracts its tree at the pinned commit, or else at its ref,
+// into dir. Returns the commit.
+func fetchGitTheme(spec *ThemeSpec, dir string) (string, error) {
+	cloneDir, err := ioutil.TempDir("", "docmodule-theme-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(cloneDir)
+
+	source := strings.TrimPrefix(spec.Source, "git+")
+	command := exec.Command("git", "clone", "--quiet", source, cloneDir)
+	if output, err := command.CombinedOutput(); err != nil {
+		return "", xerrors.Errorf("error cloning theme: %w, output: %v", err, string(output))
+	}
+
+	ref := spec.Version
+	if ref == "" {
+		ref = spec.Ref
+	}
+	if ref == "" {
+		ref = "HEAD"
+	}
+	command = exec.Command("git", "rev-parse", "--verify", ref+"^{commit}")
+	command.Dir = cloneDir
+	output, err := command.Output()
+	if err != nil {
+		return "", xerrors.Errorf("error resolving theme ref %v: %w", ref, err)
+	}
+	commit := strings.TrimSpace(string(output))
+
+	if err := extractGitRef(cloneDir, commit, dir); err != nil {
+		return "", err
+	}
+	return commit, nil
+}
+
+// Reads an archive from a URL or a local path.
+func readArchive(source string) ([]byte, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		return ioutil.ReadFile(source)
+	}
+
+	client := &http.Client{Timeout: time.Minute}
+	response, err := client.Get(source)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, xerrors.Errorf("error downloading %v: %v", source, response.Status)
+	}
+	return ioutil.ReadAll(response.Body)
+}
+
+// Extracts the regular files of a zip archive into dir.
+func extractZip(data []byte, dir string) error {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return xerrors.Errorf("error reading archive: %w", err)
+	}
+	for _, file := range archive.File {
+		if !file.Mode().IsRegular() {
+			continue
+		}
+		target := filepath.Join(dir, filepath.FromSlash(path.Clean("/"+file.Name)))
+		if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+			return err
+		}
+		reader, err := file.Open()
+		if err != nil {
+			return xerrors.Errorf("error reading archive: %w", err)
+		}
+		content, err := ioutil.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			return xerrors.Errorf("error reading archive: %w", err)
+		}
+		if err := ioutil.WriteFile(target, content, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Downloads an archive theme and extracts it into dir, checking it against the
+// pinned digest. Returns the digest.
+func fetchArchiveTheme(spec *ThemeSpec, dir string) (string, error) {
+	data, err := readArchive(spec.Source)
+	if err != nil {
+		return "", xerrors.Errorf("error reading theme archive: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	if spec.Version != "" && spec.Version != digest {
+		return "", xerrors.Errorf(
+			"theme archive %v has digest %v, but %v is pinned", spec.Source, digest, spec.Version,
+		)
+	}
+
+	switch {
+	case strings.HasSuffix(spec.Source, ".zip"):
+		err = extractZip(data, dir)
+	case strings.HasSuffix(spec.Source, ".tar.gz"), strings.HasSuffix(spec.Source, ".tgz"):
+		var reader *gzip.Reader
+		reader, err = gzip.NewReader(bytes.NewReader(data))
+		if err == nil {
+			err = extractTar(reader, dir)
+		}
+	default:
+		err = xerrors.Errorf(
+			"unknown theme source %v, expected a git URL or a .tar.gz, .tgz or .zip archive",
+			spec.Source,
+		)
+	}
+	if err != nil {
+		return "", err
+	}
+	return digest, nil
+}
+
+// Fetches a theme into the themes directory of the module, replacing any earlier
+// install, and returns its directory and pinned version. Archives holding a single
+// directory are unwrapped.
+func installTheme(moduleRoot string, spec *ThemeSpec) (string, string, error) {
+	root := filepath.Join(moduleRoot, filepath.FromSlash(themesDir))
+	if err := os.MkdirAll(root, os.ModePerm); err != nil {
+		return "", "", xerrors.Errorf("error creating themes directory: %w", err)
+	}
+	staging, err := ioutil.TempDir(root, ".fetch-")
+	if err != nil {
+		return "", "", err
+	}
+	defer os.RemoveAll(staging)
+
+	var version string
+	if isGitSource(spec.Source) {
+		version, err = fetchGitTheme(spec, staging)
+	} else {
+		version, err = fetchArchiveTheme(spec, staging)
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	themeRoot := staging
+	entries, err := ioutil.ReadDir(staging)
+	if err != nil {
+		return "", "", err
+	}
+	if len(entries) == 1 && entries[0].IsDir() && entries[0].Name() != pageTemplatesDir {
+		themeRoot = filepath.Join(staging, entries[0].Name())
+	}
+	if err := validateTemplatesDir(themeRoot); err != nil {
+		return "", "", xerrors.Errorf("invalid theme %v: %w", spec.Name, err)
+	}
+
+	dir := filepath.Join(root, spec.Name)
+	if err := os.RemoveAll(dir); err != nil {
+		return "", "", err
+	}
+	if err := os.Rename(themeRoot, dir); err != nil {
+		return "", "", xerrors.Errorf("error installing theme: %w", err)
+	}
+	return dir, version, nil
+}
+
+// Returns the templates directory of the pinned theme called name, installing it at
+// its pinned version if it is not installed yet.
+func resolveTheme(moduleRoot string, name string) (string, error) {
+	config, err := readThemesConfig(moduleRoot)
+	if err != nil {
+		return "", err
+	}
+	spec := config.theme(name)
+	if spec == nil {
+		return "", xerrors.Errorf(
+			"theme %q is not in %v, add it with 'docmodule theme add'", name, themesConfigName,
+		)
+	}
+
+	dir := filepath.Join(moduleRoot, filepath.FromSlash(themesDir), spec.Name)
+	if exists, _ := fileExists(dir); exists {
+		return dir, nil
+	}
+	log.Printf("installing theme %v at %v.", spec.Name, spec.Version)
+	dir, _, err = installTheme(moduleRoot, spec)
+	return dir, err
+}
+
+// Installs a theme from a git repository or an archive and pins it in
+// docmodule-themes.json, so every build of the module uses the same version.
+func runThemeAddCommand(args []string) {
+	flags := newCommandFlagSet("theme add")
+	name := flags.String(
+		"name",
+		"",
+		"Name to refer to the theme by with --theme. Defaults to the source's base name.",
+	)
+	ref := flags.String(
+		"ref",
+		"",
+		"Branch, tag or commit of a git theme to pin. Defaults to HEAD.",
+	)
+	_ = flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		log.Fatal("usage: docmodule theme add [--name NAME] [--ref REF] SOURCE")
+	}
+	spec := &ThemeSpec{Name: *name, Source: flags.Arg(0), Ref: *ref}
+	if spec.Name == "" {
+		spec.Name = themeName(spec.Source)
+	}
+	if spec.Name == "" || spec.Name != path.Base(spec.Name) || strings.HasPrefix(spec.Name, ".") {
+		log.Fatalf("invalid theme name %q, set one with --name", spec.Name)
+	}
+	if spec.Ref != "" && !isGitSource(spec.Source) {
+		log.Fatal("--ref is only supported for git themes")
+	}
+
+	settings := new(Settings)
+	getEnvSettings(settings)
+
+	config, err := readThemesConfig(settings.ModuleRootPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	dir, version, err := installTheme(settings.ModuleRootPath, spec)
+	if err != nil {
+		log.Fatal(err)
+	}
+	spec.Version = version
+
+	if existing := config.theme(spec.Name); existing != nil {
+		*existing = *spec
+	} else {
+		config.Themes = append(config.Themes, spec)
+	}
+	if err := writeThemesConfig(settings.ModuleRootPath, config); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("installed theme %v at %v into %v\n", spec.Name, version, dir)
+}
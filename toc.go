@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"html"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// Regex for the first section heading of a page, where the table of contents goes.
+var firstHeadingRegex = regexp.MustCompile(`<h2 id="`)
+
+// Renders a table of contents linking to every heading of a page.
+func renderTOC(data []byte) string {
+	headings := pageHeadings(data)
+	if len(headings) == 0 {
+		return ""
+	}
+
+	rendered := `<nav class="docmodule-toc"><strong>Contents</strong><ul>`
+	for _, heading := range headings {
+		rendered += `<li class="level-` + strconv.Itoa(heading.Level) + `"><a href="#` +
+			html.EscapeString(heading.ID) + `">` + html.EscapeString(heading.Text) +
+			"</a></li>"
+	}
+	return rendered + "</ul></nav>"
+}
+
+// Returns the module's package pages in reading order, which is by import path.
+func readingOrder(runInfo *RunInfo) []*DocFileInfo {
+	pages := make([]*DocFileInfo, 0, len(runInfo.DocFileInfo))
+	for _, info := range runInfo.DocFileInfo {
+		if info.ImportPath != "" {
+			pages = append(pages, info)
+		}
+	}
+	sort.Slice(pages, func(i, j int) bool {
+		return pages[i].ImportPath < pages[j].ImportPath
+	})
+	return pages
+}
+
+// Renders links to the package pages before and after the page at index.
+func renderPager(pages []*DocFileInfo, index int, fromDir string) string {
+	rendered := `<nav class="docmodule-pager">`
+	if index > 0 {
+		previous := pages[index-1]
+		rendered += `<a rel="prev" href="` +
+			html.EscapeString(relativeLink(fromDir, previous.NewRelPath)) + `">&larr; ` +
+			html.EscapeString(previous.ImportPath) + "</a>"
+	} else {
+		rendered += "<span></span>"
+	}
+	if index < len(pages)-1 {
+		next := pages[index+1]
+		rendered += `<a rel="next" href="` +
+			html.EscapeString(relativeLink(fromDir, next.NewRelPath)) + `">` +
+			html.EscapeString(next.ImportPath) + " &rarr;</a>"
+	}
+	return rendered + "</nav>"
+}
+
+// Returns the processor injecting a table of contents at the top of every package
+// page and links to the previous and next package at the bottom, so the docs can be
+// read front to back.
+func newTOCProcessor(ctx context.Context, runInfo *RunInfo) Processor {
+	settings := runInfo.Settings
+	if !settings.TOC {
+		return nil
+	}
+
+	writeNavStylesheet(settings)
+	pages := readingOrder(runInfo)
+	pageIndexes := make(map[string]int, len(pages))
+	for i, info := range pages {
+		pageIndexes[info.NewRelPath] = i
+	}
+
+	return ProcessorFunc(func(page *Page) error {
+		index, ok := pageIndexes[page.RelPath]
+		if !ok {
+			return nil
+		}
+
+		fromDir := path.Dir(page.RelPath)
+		data := linkNavStylesheet(page.Data, fromDir)
+		if toc := renderTOC(data); toc != "" {
+			data = injectBefore(data, firstHeadingRegex, []byte(toc))
+		}
+		page.Data = injectIntoBody(data, []byte(renderPager(pages, index, fromDir)))
+		return nil
+	})
+}
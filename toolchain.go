@@ -0,0 +1,116 @@
+package main
+
+import (
+	"go/build"
+	"golang.org/x/xerrors"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Value of --go-toolchain selecting the toolchain go.mod asks for.
+const goToolchainMod = "mod"
+
+// Regexes for the toolchain and go directives of go.mod.
+var (
+	goModToolchainRegex = regexp.MustCompile(`(?m)^toolchain\s+(go\S+)`)
+	goModGoRegex        = regexp.MustCompile(`(?m)^go\s+(\d+\.\d+(?:\.\d+)?)`)
+)
+
+// Regex for a toolchain version, e.g. go1.20.14.
+var goVersionRegex = regexp.MustCompile(`^go\d+\.\d+(\.\d+)?((rc|beta)\d+)?$`)
+
+// Returns the toolchain version go.mod asks for: its toolchain directive, or else
+// its go directive.
+func goModToolchain(goModPath string) (string, error) {
+	data, err := ioutil.ReadFile(goModPath)
+	if err != nil {
+		return "", xerrors.Errorf("error reading go.mod: %w", err)
+	}
+	if match := goModToolchainRegex.FindSubmatch(data); match != nil {
+		return string(match[1]), nil
+	}
+	if match := goModGoRegex.FindSubmatch(data); match != nil {
+		return "go" + string(match[1]), nil
+	}
+	return "", xerrors.New("go.mod has neither a toolchain nor a go directive")
+}
+
+// Returns the root of the SDK of a toolchain version, as installed by the
+// golang.org/dl wrapper of that version.
+func goVersionRoot(version string) (string, error) {
+	if wrapper, err := exec.LookPath(version); err == nil {
+		output, err := exec.Command(wrapper, "env", "GOROOT").Output()
+		if err == nil && strings.TrimSpace(string(output)) != "" {
+			return strings.TrimSpace(string(output)), nil
+		}
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		root := filepath.Join(home, "sdk", version)
+		if exists, _ := fileExists(filepath.Join(root, "bin", "go")); exists {
+			return root, nil
+		}
+	}
+	return "", xerrors.Errorf(
+		"toolchain %v is not installed, install it with "+
+			"`go install golang.org/dl/%v@latest && %v download`",
+		version, version, version,
+	)
+}
+
+// Resolves --go-toolchain to the root of a Go SDK. The value is "mod" for the
+// toolchain go.mod asks for, a version like go1.20.14, or the path of an SDK or of
+// its go binary.
+func resolveGoToolchain(value string, goModPath string) (string, error) {
+	if value == goToolchainMod {
+		version, err := goModToolchain(goModPath)
+		if err != nil {
+			return "", err
+		}
+		value = version
+	}
+	if goVersionRegex.MatchString(value) {
+		return goVersionRoot(value)
+	}
+
+	root, err := filepath.Abs(value)
+	if err != nil {
+		return "", err
+	}
+	if info, err := os.Stat(root); err == nil && !info.IsDir() {
+		// The go binary, in <root>/bin.
+		root = filepath.Dir(filepath.Dir(root))
+	}
+	if exists, _ := fileExists(filepath.Join(root, "bin", "go")); !exists {
+		return "", xerrors.Errorf("'%v' is not a Go SDK or go binary", value)
+	}
+	return root, nil
+}
+
+// Makes the go commands we run, the source importer and the doc server use the SDK
+// at root rather than whatever go is on PATH. GOTOOLCHAIN=local keeps the go command
+// from switching to another toolchain itself.
+func useGoToolchain(settings *Settings, root string) {
+	environment := map[string]string{
+		"GOROOT":      root,
+		"GOTOOLCHAIN": "local",
+		"PATH":        filepath.Join(root, "bin") + string(os.PathListSeparator) + os.Getenv("PATH"),
+	}
+	for name, value := range environment {
+		if err := os.Setenv(name, value); err != nil {
+			log.Fatal(xerrors.Errorf("error setting %v: %w", name, err))
+		}
+	}
+	build.Default.GOROOT = root
+	settings.GoRootPath = root
+
+	output, err := exec.Command("go", "version").Output()
+	if err != nil {
+		log.Fatal(xerrors.Errorf("error running go of toolchain '%v': %w", root, err))
+	}
+	log.Printf("using %v", strings.TrimSpace(string(output)))
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Results of running an example with `go test`.
+const (
+	// The example ran and its output matched.
+	exampleVerified = "verified"
+	// The example has no output comment, so `go test` only compiles it.
+	exampleUnverified = "unverified"
+	exampleFailed     = "failed"
+)
+
+// Event of `go test -json`.
+type testEvent struct {
+	Action  string
+	Package string
+	Test    string
+	Output  string
+}
+
+// Returns the name godoc gives an example in its page ids, e.g. Foo_bar for
+// ExampleFoo_bar and package for Example.
+func exampleID(testName string) string {
+	name := strings.TrimPrefix(testName, "Example")
+	if name == "" {
+		return "package"
+	}
+	return name
+}
+
+// Runs the testable examples of the module, returning import path -> example name ->
+// result of every example that ran, and the output of the ones that failed.
+func runExampleTests(
+	ctx context.Context, settings *Settings,
+) (map[string]map[string]string, map[string]string) {
+	command := exec.CommandContext(ctx, "go", "test", "-json", "-run", "^Example", "./...")
+	command.Dir = settings.ModuleRootPath
+	// Failing tests exit non-zero; the events tell which ones failed.
+	output, _ := command.Output()
+
+	results := make(map[string]map[string]string)
+	outputs := make(map[string]*bytes.Buffer)
+	failures := make(map[string]string)
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		event := new(testEvent)
+		if err := json.Unmarshal(scanner.Bytes(), event); err != nil {
+			continue
+		}
+		if !strings.HasPrefix(event.Test, "Example") {
+			if event.Action == "fail" && event.Test == "" && results[event.Package] == nil {
+				// The package did not build, so none of its examples ran.
+				failures[event.Package] = "package failed to build"
+			}
+			continue
+		}
+
+		key := event.Package + "." + event.Test
+		switch event.Action {
+		case "output":
+			if outputs[key] == nil {
+				outputs[key] = new(bytes.Buffer)
+			}
+			outputs[key].WriteString(event.Output)
+		case "pass", "fail":
+			if results[event.Package] == nil {
+				results[event.Package] = make(map[string]string)
+			}
+			result := exampleVerified
+			if event.Action == "fail" {
+				result = exampleFailed
+				failures[key] = outputs[key].String()
+			}
+			results[event.Package][exampleID(event.Test)] = result
+		}
+	}
+	return results, failures
+}
+
+// Returns the badge marking an example with its result.
+func exampleBadge(result string) string {
+	return `<span class="docmodule-example-badge docmodule-example-` + result + `">` +
+		result + "</span>"
+}
+
+// Runs the module's testable examples and returns the processor marking each example
+// on the package pages as verified or unverified. Fails the build if any example's
+// output no longer matches.
+func newVerifyExamplesProcessor(ctx context.Context, runInfo *RunInfo) Processor {
+	settings := runInfo.Settings
+	if !settings.VerifyExamples {
+		return nil
+	}
+
+	log.Println("running examples.")
+	results, failures := runExampleTests(ctx, settings)
+	if len(failures) > 0 {
+		names := make([]string, 0, len(failures))
+		for name := range failures {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			log.Printf("example %v failed:\n%v", name, failures[name])
+		}
+		log.Panicf("%v examples failed: %v", len(names), strings.Join(names, ", "))
+	}
+
+	writeNavStylesheet(settings)
+
+	return ProcessorFunc(func(page *Page) error {
+		data := page.Data
+		matches := exampleStartRegex.FindAllSubmatchIndex(data, -1)
+		if len(matches) == 0 {
+			return nil
+		}
+
+		annotated := new(bytes.Buffer)
+		last := 0
+		for _, match := range matches {
+			if match[0] < last {
+				continue
+			}
+			end := divEnd(data, match[0])
+			result, ok := results[page.ImportPath()][string(data[match[2]:match[3]])]
+			if !ok {
+				result = exampleUnverified
+			}
+
+			annotated.Write(data[last:match[0]])
+			annotated.Write(exampleTitleRegex.ReplaceAll(
+				data[match[0]:end], []byte("$0 "+exampleBadge(result)),
+			))
+			last = end
+		}
+		annotated.Write(data[last:])
+		page.Data = linkNavStylesheet(annotated.Bytes(), path.Dir(page.RelPath))
+		return nil
+	})
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// Version, commit and build date of docmodule, set by the makefile with
+// -ldflags "-X main.version=...". Binaries installed with `go install` only know
+// their module version, read from their build info.
+var (
+	version   = ""
+	commit    = ""
+	buildDate = ""
+)
+
+// Returns the version of docmodule: the one set at link time, or else the version of
+// its module in the build info, "(devel)" for builds from a checkout.
+func docmoduleVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "unknown"
+}
+
+// Returns value, or "unknown" if it is empty.
+func orUnknown(value string) string {
+	if value == "" {
+		return "unknown"
+	}
+	return value
+}
+
+// Prints the version, commit and build date of docmodule and the Go version it was
+// built with.
+func runVersionCommand(args []string) {
+	fmt.Printf("docmodule %v\n", docmoduleVersion())
+	fmt.Printf("commit:     %v\n", orUnknown(commit))
+	fmt.Printf("built:      %v\n", orUnknown(buildDate))
+	fmt.Printf("go version: %v %v/%v\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
+
+// Returns the processor marking every page with the version of docmodule which
+// generated it, in an HTML comment, to trace pages back to the build that made them.
+func newGeneratorProcessor(ctx context.Context, runInfo *RunInfo) Processor {
+	generator := "docmodule " + docmoduleVersion()
+	if commit != "" {
+		generator += " (" + commit + ")"
+	}
+	// "--" ends a comment early.
+	comment := []byte("<!-- Generated by " + strings.Replace(generator, "--", "- -", -1) + " -->\n")
+	return ProcessorFunc(func(page *Page) error {
+		page.Data = injectIntoHead(page.Data, comment)
+		return nil
+	})
+}